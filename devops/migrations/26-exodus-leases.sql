@@ -0,0 +1,17 @@
+-- 26-exodus-leases.sql: leader election for the long-running exodus scheduler.
+--
+-- `exodus --mode=scheduler` runs as a Deployment with more than one replica,
+-- and exactly one of them may execute bails at a time -- two executors would
+-- send every bailout twice. CockroachDB does not implement pg_advisory_lock,
+-- so the lock is a row: whoever holds an unexpired lease for `name` is the
+-- leader, and renews it well inside its TTL. A holder that dies simply stops
+-- renewing, and the lease becomes takeable once expires_at passes.
+CREATE TABLE IF NOT EXISTS chatroach.exodus_leases (
+  name STRING PRIMARY KEY,
+  holder STRING NOT NULL,
+  expires_at TIMESTAMPTZ NOT NULL,
+  acquired_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+GRANT INSERT, SELECT, UPDATE, DELETE ON TABLE chatroach.exodus_leases TO chatroach;
+GRANT SELECT ON TABLE chatroach.exodus_leases TO chatreader;
@@ -0,0 +1,38 @@
+-- 27-bail-user-lists.sql: uploaded user lists for user_list-type bails.
+--
+-- A user_list bail used to embed its users in bails.definition, capped at 1000
+-- so the JSONB stayed reasonable to read on every executor tick. Lists are now
+-- uploaded as CSV into a side table and the definition carries only
+-- user_list.list_id, which lets a researcher bail 100k+ respondents without the
+-- definition growing with them. Embedded lists keep working unchanged.
+--
+-- report holds the validation report produced at upload time (duplicate rows,
+-- rows whose user or page was never seen in states, unknown shortcodes) so the
+-- dashboard can show it again without re-running the checks.
+CREATE TABLE IF NOT EXISTS chatroach.bail_user_lists (
+  id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  user_id UUID NOT NULL REFERENCES chatroach.users(id) ON DELETE CASCADE,
+  name STRING NOT NULL,
+  row_count INT NOT NULL DEFAULT 0,
+  report JSONB NOT NULL DEFAULT '{}',
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+
+  INDEX idx_bail_user_lists_user (user_id, created_at DESC) STORING (name, row_count)
+);
+
+-- One row per (userid, pageid) per list. Duplicates in the upload are reported
+-- and dropped rather than stored, because a user can only be bailed once per
+-- execution anyway.
+CREATE TABLE IF NOT EXISTS chatroach.bail_user_list_entries (
+  list_id UUID NOT NULL REFERENCES chatroach.bail_user_lists(id) ON DELETE CASCADE,
+  userid VARCHAR NOT NULL,
+  pageid VARCHAR NOT NULL,
+  shortcode VARCHAR NOT NULL,
+  platform VARCHAR,
+  PRIMARY KEY (list_id, userid, pageid)
+);
+
+GRANT INSERT, SELECT, UPDATE, DELETE ON TABLE chatroach.bail_user_lists TO chatroach;
+GRANT INSERT, SELECT, DELETE ON TABLE chatroach.bail_user_list_entries TO chatroach;
+GRANT SELECT ON TABLE chatroach.bail_user_lists TO chatreader;
+GRANT SELECT ON TABLE chatroach.bail_user_list_entries TO chatreader;
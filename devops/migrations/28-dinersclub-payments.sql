@@ -0,0 +1,35 @@
+-- 28-dinersclub-payments.sql: dinersclub's local payments ledger.
+--
+-- dean re-drives a payment for up to 14 days while the respondent waits in
+-- WAIT_EXTERNAL_EVENT. A payment that succeeded at the provider but whose
+-- Result never reached botserver used to be paid again on every re-drive.
+-- dinersclub now records each payment under a stable idempotency key derived
+-- from the event (userid, pageid, provider, details id -- never the event
+-- timestamp, which changes on every re-drive) and answers a re-drive of a
+-- recorded success from this table instead of calling the provider.
+--
+-- status is 'attempting' while a worker holds the payment, then 'success' or
+-- 'failed'. A failed payment may be claimed again; an 'attempting' row is only
+-- reclaimed once it is older than DINERSCLUB_LEDGER_CLAIM_TTL, which covers a
+-- worker that died mid-payout.
+CREATE TABLE IF NOT EXISTS chatroach.payments (
+  idempotency_key STRING PRIMARY KEY,
+  userid STRING NOT NULL,
+  pageid STRING NOT NULL,
+  provider STRING NOT NULL,
+  payment_id STRING,
+  owner STRING,
+  status STRING NOT NULL CHECK (status IN ('attempting', 'success', 'failed')),
+  attempts INT NOT NULL DEFAULT 1,
+  details JSONB,
+  result JSONB,
+  error_code STRING,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+
+  INDEX idx_payments_user (userid, pageid),
+  INDEX idx_payments_status (status, updated_at)
+);
+
+GRANT INSERT, SELECT, UPDATE ON TABLE chatroach.payments TO chatroach;
+GRANT SELECT ON TABLE chatroach.payments TO chatreader;
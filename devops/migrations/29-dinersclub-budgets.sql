@@ -0,0 +1,30 @@
+-- 29-dinersclub-budgets.sql: payment budgets and spend caps for dinersclub.
+--
+-- Nothing used to stop a misconfigured survey or a bot farm from draining a
+-- researcher's provider wallet. A budget belongs to a researcher and
+-- optionally to one survey (shortcode NULL means every survey), and caps the
+-- amount paid per UTC day, in total, and per respondent. A NULL cap is no cap.
+--
+-- Spend is read from chatroach.payments, which therefore now records the
+-- amount and the survey of every payment. 'attempting' rows count towards
+-- spend as well as 'success' ones, so concurrent payouts cannot each see the
+-- budget as unspent.
+ALTER TABLE chatroach.payments ADD COLUMN IF NOT EXISTS shortcode STRING;
+ALTER TABLE chatroach.payments ADD COLUMN IF NOT EXISTS amount DECIMAL NOT NULL DEFAULT 0;
+CREATE INDEX IF NOT EXISTS idx_payments_owner ON chatroach.payments (owner, status, created_at) STORING (shortcode, amount, userid, pageid);
+
+CREATE TABLE IF NOT EXISTS chatroach.payment_budgets (
+  id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  userid UUID NOT NULL REFERENCES chatroach.users(id) ON DELETE CASCADE,
+  shortcode STRING,
+  daily_cap DECIMAL CHECK (daily_cap >= 0),
+  total_cap DECIMAL CHECK (total_cap >= 0),
+  per_respondent_cap DECIMAL CHECK (per_respondent_cap >= 0),
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+
+  UNIQUE INDEX idx_payment_budgets_scope (userid, shortcode)
+);
+
+GRANT INSERT, SELECT, UPDATE, DELETE ON TABLE chatroach.payment_budgets TO chatroach;
+GRANT SELECT ON TABLE chatroach.payment_budgets TO chatreader;
@@ -0,0 +1,16 @@
+-- 30-dinersclub-pending-payments.sql: pending payments in the dinersclub ledger.
+--
+-- A provider may accept a payment without settling it -- a Reloadly gift card
+-- order can come back PENDING. Such a payment is recorded as 'pending' and
+-- dinersclub's status poller re-queries the provider until it settles; only
+-- then does the Result go to botserver.
+--
+-- The poller has no Kafka event to work from, so the ledger now keeps what it
+-- needs to rebuild one: the platform (for the credential lookup) and the
+-- credential key named by the survey's payment block.
+ALTER TABLE chatroach.payments ADD COLUMN IF NOT EXISTS platform STRING;
+ALTER TABLE chatroach.payments ADD COLUMN IF NOT EXISTS credential_key STRING;
+
+ALTER TABLE chatroach.payments DROP CONSTRAINT IF EXISTS check_status;
+ALTER TABLE chatroach.payments ADD CONSTRAINT check_status
+  CHECK (status IN ('attempting', 'pending', 'success', 'failed'));
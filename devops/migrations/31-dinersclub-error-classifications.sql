@@ -0,0 +1,37 @@
+-- 31-dinersclub-error-classifications.sql: editable recovery classes for
+-- payment error codes.
+--
+-- dinersclub decides whether a failed payment is sent to the respondent or
+-- withheld for dean to re-drive from the code's recovery class. Those classes
+-- were a compiled-in map (dinersclub/classify.go), so a code nobody had seen
+-- was permanent -- the respondent was told their payment failed -- until a
+-- code change shipped. Rows here override the map, optionally for one
+-- provider (provider NULL: any) and one researcher (userid NULL: all), and
+-- dinersclub reloads them periodically.
+--
+-- dinersclub also records every code it could not classify here, with
+-- recovery NULL and first/last-seen timestamps. Triage is then setting
+-- recovery on that row.
+CREATE TABLE IF NOT EXISTS chatroach.payment_error_classifications (
+  id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  code STRING NOT NULL,
+  provider STRING,
+  userid UUID REFERENCES chatroach.users(id) ON DELETE CASCADE,
+  recovery STRING CHECK (recovery IN ('transient', 'precondition', 'permanent')),
+  seen_count INT NOT NULL DEFAULT 0,
+  first_seen_at TIMESTAMPTZ,
+  last_seen_at TIMESTAMPTZ,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+-- One researcher-wide row per provider and code, which is what recording an
+-- unclassified code upserts into. Researcher-scoped rows are unique on their
+-- own index.
+CREATE UNIQUE INDEX IF NOT EXISTS idx_payment_error_classifications_provider
+  ON chatroach.payment_error_classifications (provider, code) WHERE userid IS NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_payment_error_classifications_user
+  ON chatroach.payment_error_classifications (userid, provider, code) WHERE userid IS NOT NULL;
+
+GRANT INSERT, SELECT, UPDATE, DELETE ON TABLE chatroach.payment_error_classifications TO chatroach;
+GRANT SELECT ON TABLE chatroach.payment_error_classifications TO chatreader;
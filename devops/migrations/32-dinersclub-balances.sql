@@ -0,0 +1,30 @@
+-- 32-dinersclub-balances.sql: provider wallet balances for dinersclub.
+--
+-- INSUFFICIENT_BALANCE is the largest failure class dinersclub sees, and it
+-- used to be learned one parked respondent at a time. dinersclub now asks each
+-- researcher's provider for its balance on a schedule, for every credential
+-- that has paid something in the last 14 days, and records the answer here
+-- (and as a gauge).
+--
+-- floor is set by hand, per researcher, provider and credential. While the
+-- last fresh balance is below it, dinersclub refuses new payouts on that
+-- credential as BALANCE_BELOW_FLOOR -- a precondition, so respondents wait
+-- for the top-up exactly as they would for an empty wallet, but before the
+-- wallet is actually empty. NULL is no floor.
+CREATE TABLE IF NOT EXISTS chatroach.payment_balances (
+  userid UUID NOT NULL REFERENCES chatroach.users(id) ON DELETE CASCADE,
+  provider STRING NOT NULL,
+  credential_key STRING NOT NULL,
+  balance DECIMAL,
+  currency STRING,
+  floor DECIMAL CHECK (floor >= 0),
+  error STRING,
+  checked_at TIMESTAMPTZ,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+
+  PRIMARY KEY (userid, provider, credential_key)
+);
+
+GRANT INSERT, SELECT, UPDATE, DELETE ON TABLE chatroach.payment_balances TO chatroach;
+GRANT SELECT ON TABLE chatroach.payment_balances TO chatreader;
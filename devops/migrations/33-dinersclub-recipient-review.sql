@@ -0,0 +1,26 @@
+-- 33-dinersclub-recipient-review.sql: duplicate-recipient holds in the ledger.
+--
+-- Incentivised surveys attract people who take a survey many times under
+-- different accounts and have every payment sent to the same phone. The
+-- ledger now records who each payment went to (recipient: the phone digits
+-- or lowercased email from the payment details), and before paying,
+-- dinersclub looks for the same recipient paid for other respondents in the
+-- same study within DINERSCLUB_DUPLICATE_WINDOW.
+--
+-- A payment that matches is recorded 'held' and nothing is sent: the
+-- respondent waits, as for an empty wallet, while someone reviews it through
+-- dinersclub's admin API. review records the decision and outlives later
+-- status changes, so an approved payment is not held again when a re-drive
+-- retries it. 'approved' may then be claimed and paid; 'rejected' is answered
+-- PAYMENT_REJECTED on the next re-drive.
+ALTER TABLE chatroach.payments ADD COLUMN IF NOT EXISTS recipient STRING;
+ALTER TABLE chatroach.payments ADD COLUMN IF NOT EXISTS review STRING CHECK (review IN ('approved', 'rejected'));
+ALTER TABLE chatroach.payments ADD COLUMN IF NOT EXISTS review_reason STRING;
+ALTER TABLE chatroach.payments ADD COLUMN IF NOT EXISTS reviewed_by STRING;
+ALTER TABLE chatroach.payments ADD COLUMN IF NOT EXISTS reviewed_at TIMESTAMPTZ;
+
+CREATE INDEX IF NOT EXISTS idx_payments_recipient ON chatroach.payments (owner, recipient, created_at);
+
+ALTER TABLE chatroach.payments DROP CONSTRAINT IF EXISTS check_status;
+ALTER TABLE chatroach.payments ADD CONSTRAINT check_status
+  CHECK (status IN ('attempting', 'pending', 'success', 'failed', 'held', 'approved', 'rejected'));
@@ -0,0 +1,26 @@
+-- 34-dinersclub-payment-attempts.sql: every attempt at a payment, kept.
+--
+-- The payments ledger holds only a payment's latest verdict, so seeing what a
+-- provider said the first three times meant grepping logs. Each verdict
+-- dinersclub records now also lands here: one row per attempt and per
+-- settlement of a pending payment, with the provider's Result as it was
+-- stored. A claim released with no verdict (every try a system fault) is a
+-- 'failed' row with no result.
+--
+-- recorded_by is NULL for what dinersclub recorded itself, and names the
+-- person when a payment was marked paid through the admin API.
+CREATE TABLE IF NOT EXISTS chatroach.payment_attempts (
+  id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  idempotency_key STRING NOT NULL REFERENCES chatroach.payments (idempotency_key) ON DELETE CASCADE,
+  attempt INT NOT NULL,
+  status STRING NOT NULL,
+  result JSONB,
+  error_code STRING,
+  recorded_by STRING,
+  recorded_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+
+  INDEX idx_payment_attempts_key (idempotency_key, recorded_at)
+);
+
+GRANT INSERT, SELECT ON TABLE chatroach.payment_attempts TO chatroach;
+GRANT SELECT ON TABLE chatroach.payment_attempts TO chatreader;
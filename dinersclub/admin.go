@@ -0,0 +1,547 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// Admin API.
+//
+// For the people who decide what dinersclub could not. Every request carries
+// DINERSCLUB_ADMIN_TOKEN as a bearer token, and without one set the server is
+// not started at all. It is meant for the cluster, not the internet.
+//
+// Payments held for review (review.go) are listed and approved or rejected
+// here. A decision only changes the ledger. The payment itself moves on the
+// next re-drive from dean: an approved one is paid, a rejected one is
+// answered PAYMENT_REJECTED.
+//
+// Any payment in the ledger can be looked up with every attempt at it
+// (payment_attempts, devops/migrations/34), and two things can be done to one
+// by hand:
+//
+//   - retry: a withheld failure is paid now rather than on dean's next
+//     re-drive. It goes through Job exactly as a re-drive would, budgets,
+//     review and all.
+//   - mark-paid: a payment settled outside dinersclub is recorded as a
+//     success and the success Result is sent, which releases the respondent.
+//     Later re-drives replay it like any recorded success.
+//
+// Neither touches a payment whose failure was already sent: the respondent
+// was released by it and is no longer waiting for a Result.
+//
+// Only payments that reached the ledger are here. One withheld before it was
+// claimed -- an auth failure, an empty wallet, an exhausted budget -- has no
+// row until a re-drive gets further.
+
+// serveAdmin serves the admin API. Like serveCallbacks, failures are logged
+// and swallowed. Run it in a goroutine.
+func (dc *DC) serveAdmin(port int) {
+	addr := fmt.Sprintf(":%d", port)
+	log.Printf("DinersClub serving admin API on %s", addr)
+
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           dc.adminHandler(),
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+	if err := srv.ListenAndServe(); err != nil {
+		log.Printf("DinersClub admin server stopped: %v", err)
+	}
+}
+
+// HeldPayment is a payment waiting for review, as the admin API lists it.
+type HeldPayment struct {
+	IdempotencyKey string           `json:"idempotency_key"`
+	Userid         string           `json:"userid"`
+	Pageid         string           `json:"pageid"`
+	Provider       string           `json:"provider"`
+	Owner          string           `json:"owner"`
+	Shortcode      *string          `json:"shortcode"`
+	Recipient      *string          `json:"recipient"`
+	Amount         *float64         `json:"amount"`
+	Reason         *string          `json:"reason"`
+	Details        *json.RawMessage `json:"details"`
+	CreatedAt      time.Time        `json:"created_at"`
+}
+
+func listHeldPayments(ctx context.Context, dc *DC) ([]HeldPayment, error) {
+	query := `
+		SELECT idempotency_key, userid, pageid, provider, owner, shortcode, recipient, amount, review_reason, details, created_at
+		FROM payments
+		WHERE status = $1
+		ORDER BY created_at`
+
+	rows, err := dc.pool.Query(ctx, query, paymentHeld)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	held := []HeldPayment{}
+	for rows.Next() {
+		h := HeldPayment{}
+		err := rows.Scan(&h.IdempotencyKey, &h.Userid, &h.Pageid, &h.Provider, &h.Owner, &h.Shortcode,
+			&h.Recipient, &h.Amount, &h.Reason, &h.Details, &h.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		held = append(held, h)
+	}
+	return held, rows.Err()
+}
+
+func (dc *DC) adminHandler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /admin/reviews", func(w http.ResponseWriter, r *http.Request) {
+		held, err := listHeldPayments(r.Context(), dc)
+		if err != nil {
+			log.Printf("DinersClub admin: listing held payments: %v", err)
+			http.Error(w, "ledger unavailable", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(held)
+	})
+
+	decide := func(decision string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			body := struct {
+				Reviewer string `json:"reviewer"`
+			}{}
+			if r.ContentLength != 0 {
+				if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+					http.Error(w, "body must be {\"reviewer\": ...}", http.StatusBadRequest)
+					return
+				}
+			}
+
+			key := r.PathValue("key")
+			ok, err := decideReview(dc.pool, key, decision, body.Reviewer)
+			if err != nil {
+				log.Printf("DinersClub admin: %s %s: %v", decision, key, err)
+				http.Error(w, "ledger unavailable", http.StatusInternalServerError)
+				return
+			}
+			if !ok {
+				http.Error(w, "no held payment with that key", http.StatusNotFound)
+				return
+			}
+			log.Printf("DinersClub admin: payment %s %s by %q.", key, decision, body.Reviewer)
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}
+	mux.HandleFunc("POST /admin/reviews/{key}/approve", decide(paymentApproved))
+	mux.HandleFunc("POST /admin/reviews/{key}/reject", decide(paymentRejected))
+
+	mux.HandleFunc("GET /admin/payments", dc.adminListPayments)
+	mux.HandleFunc("GET /admin/payments/{key}", dc.adminShowPayment)
+	mux.HandleFunc("POST /admin/payments/{key}/retry", dc.adminRetryPayment)
+	mux.HandleFunc("POST /admin/payments/{key}/mark-paid", dc.adminMarkPaid)
+
+	return dc.adminAuth(mux)
+}
+
+// adminAuth refuses any request without the admin token.
+func (dc *DC) adminAuth(next http.Handler) http.Handler {
+	want := []byte("Bearer " + dc.cfg.AdminToken)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got := []byte(r.Header.Get("Authorization"))
+		if dc.cfg.AdminToken == "" || subtle.ConstantTimeCompare(got, want) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// PaymentRecord is a payment in the ledger, as the admin API shows it.
+// History, every attempt at it oldest first, is filled in only when a single
+// payment is asked for.
+type PaymentRecord struct {
+	IdempotencyKey string           `json:"idempotency_key"`
+	Userid         string           `json:"userid"`
+	Pageid         string           `json:"pageid"`
+	Platform       *string          `json:"platform"`
+	Provider       string           `json:"provider"`
+	CredentialKey  *string          `json:"credential_key"`
+	Owner          *string          `json:"owner"`
+	Shortcode      *string          `json:"shortcode"`
+	Status         string           `json:"status"`
+	Attempts       int              `json:"attempts"`
+	Amount         *float64         `json:"amount"`
+	ErrorCode      *string          `json:"error_code"`
+	Recipient      *string          `json:"recipient"`
+	Review         *string          `json:"review"`
+	Details        *json.RawMessage `json:"details"`
+	Result         *json.RawMessage `json:"result"`
+	CreatedAt      time.Time        `json:"created_at"`
+	UpdatedAt      time.Time        `json:"updated_at"`
+
+	History []AttemptRecord `json:"history,omitempty"`
+}
+
+// AttemptRecord is one verdict recorded for a payment.
+type AttemptRecord struct {
+	Attempt    int              `json:"attempt"`
+	Status     string           `json:"status"`
+	Result     *json.RawMessage `json:"result"`
+	ErrorCode  *string          `json:"error_code"`
+	RecordedBy *string          `json:"recorded_by"`
+	RecordedAt time.Time        `json:"recorded_at"`
+}
+
+const paymentRecordColumns = `idempotency_key, userid, pageid, platform, provider, credential_key, owner,
+			shortcode, status, attempts, amount, error_code, recipient, review, details, result, created_at, updated_at`
+
+func scanPaymentRecord(row pgx.Row) (*PaymentRecord, error) {
+	p := new(PaymentRecord)
+	err := row.Scan(&p.IdempotencyKey, &p.Userid, &p.Pageid, &p.Platform, &p.Provider, &p.CredentialKey, &p.Owner,
+		&p.Shortcode, &p.Status, &p.Attempts, &p.Amount, &p.ErrorCode, &p.Recipient, &p.Review, &p.Details, &p.Result,
+		&p.CreatedAt, &p.UpdatedAt)
+	return p, err
+}
+
+// event rebuilds the payment event a re-drive would carry.
+func (p *PaymentRecord) event() *PaymentEvent {
+	deref := func(s *string) string {
+		if s == nil {
+			return ""
+		}
+		return *s
+	}
+	ts := JSTimestamp(time.Now().UTC())
+	return &PaymentEvent{
+		Userid:         p.Userid,
+		Pageid:         p.Pageid,
+		Platform:       deref(p.Platform),
+		Shortcode:      deref(p.Shortcode),
+		Timestamp:      &ts,
+		Provider:       p.Provider,
+		Key:            deref(p.CredentialKey),
+		Details:        p.Details,
+		IdempotencyKey: p.IdempotencyKey,
+		Owner:          deref(p.Owner),
+	}
+}
+
+// storedResult is the Result last recorded for the payment, if any.
+func (p *PaymentRecord) storedResult() (*Result, error) {
+	if p.Result == nil || string(*p.Result) == "null" {
+		return nil, nil
+	}
+	res := new(Result)
+	if err := json.Unmarshal(*p.Result, res); err != nil {
+		return nil, fmt.Errorf("stored result for payment %s is not a Result: %w", p.IdempotencyKey, err)
+	}
+	return res, nil
+}
+
+// paymentFilter selects payments to list. An empty field matches anything.
+type paymentFilter struct {
+	userid, pageid, owner, status string
+	limit                         int
+}
+
+const (
+	defaultPaymentLimit = 100
+	maxPaymentLimit     = 1000
+)
+
+var paymentStatuses = []string{paymentAttempting, paymentPending, paymentSuccess, paymentFailed,
+	paymentHeld, paymentApproved, paymentRejected}
+
+func parsePaymentFilter(q url.Values) (paymentFilter, error) {
+	f := paymentFilter{
+		userid: q.Get("userid"),
+		pageid: q.Get("pageid"),
+		owner:  q.Get("researcher"),
+		status: q.Get("status"),
+		limit:  defaultPaymentLimit,
+	}
+	if f.status != "" && !contains(paymentStatuses, f.status) {
+		return f, fmt.Errorf("status must be one of %v", paymentStatuses)
+	}
+	if l := q.Get("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n < 1 || n > maxPaymentLimit {
+			return f, fmt.Errorf("limit must be between 1 and %d", maxPaymentLimit)
+		}
+		f.limit = n
+	}
+	return f, nil
+}
+
+// listPayments returns the payments matching f, most recently updated first.
+func listPayments(ctx context.Context, pool *pgxpool.Pool, f paymentFilter) ([]PaymentRecord, error) {
+	query := `
+		SELECT ` + paymentRecordColumns + `
+		FROM payments
+		WHERE ($1::STRING = '' OR userid = $1)
+		  AND ($2::STRING = '' OR pageid = $2)
+		  AND ($3::STRING = '' OR owner = $3)
+		  AND ($4::STRING = '' OR status = $4)
+		ORDER BY updated_at DESC
+		LIMIT $5`
+
+	rows, err := pool.Query(ctx, query, f.userid, f.pageid, f.owner, f.status, f.limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	payments := []PaymentRecord{}
+	for rows.Next() {
+		p, err := scanPaymentRecord(rows)
+		if err != nil {
+			return nil, err
+		}
+		payments = append(payments, *p)
+	}
+	return payments, rows.Err()
+}
+
+// loadPayment returns one payment with its history, or nil if there is no
+// payment with that key.
+func loadPayment(ctx context.Context, pool *pgxpool.Pool, key string) (*PaymentRecord, error) {
+	query := `SELECT ` + paymentRecordColumns + ` FROM payments WHERE idempotency_key = $1`
+	p, err := scanPaymentRecord(pool.QueryRow(ctx, query, key))
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	query = `
+		SELECT attempt, status, result, error_code, recorded_by, recorded_at
+		FROM payment_attempts
+		WHERE idempotency_key = $1
+		ORDER BY recorded_at, attempt`
+
+	rows, err := pool.Query(ctx, query, key)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	p.History = []AttemptRecord{}
+	for rows.Next() {
+		a := AttemptRecord{}
+		if err := rows.Scan(&a.Attempt, &a.Status, &a.Result, &a.ErrorCode, &a.RecordedBy, &a.RecordedAt); err != nil {
+			return nil, err
+		}
+		p.History = append(p.History, a)
+	}
+	return p, rows.Err()
+}
+
+// outOfBandResult is the success Result for a payment settled outside
+// dinersclub. A fallback hop's Result carries the chain's type, as settle's
+// does, or no wait matches it.
+func outOfBandResult(pe *PaymentEvent, prior *Result, by, note string) *Result {
+	b, _ := json.Marshal(map[string]interface{}{"out_of_band": true, "marked_by": by, "note": note})
+	response := json.RawMessage(b)
+	t := fmt.Sprintf("payment:%v", pe.Provider)
+	res := &Result{Type: t, ID: paymentID(pe), Success: true, Timestamp: time.Now().UTC(), Response: &response}
+	if prior != nil && prior.Provider != "" {
+		res.Type, res.Provider, res.Attempts = prior.Type, prior.Provider, prior.Attempts
+	}
+	return res
+}
+
+// markPaid records res as the payment's verdict, but only if the payment is
+// still in status from. ok is false when it has moved on since it was read.
+func markPaid(pool *pgxpool.Pool, key, from string, res *Result, by string) (bool, error) {
+	b, err := json.Marshal(res)
+	if err != nil {
+		return false, err
+	}
+
+	query := `
+		WITH p AS (
+			UPDATE payments
+			SET status = $2, result = $3, error_code = NULL, updated_at = now()
+			WHERE idempotency_key = $1 AND status = $4
+			RETURNING idempotency_key, attempts
+		)
+		INSERT INTO payment_attempts (idempotency_key, attempt, status, result, recorded_by)
+		SELECT idempotency_key, attempts, $2, $3, NULLIF($5, '') FROM p`
+
+	tag, err := pool.Exec(context.Background(), query, key, paymentSuccess, b, from, by)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func (dc *DC) adminListPayments(w http.ResponseWriter, r *http.Request) {
+	f, err := parsePaymentFilter(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	payments, err := listPayments(r.Context(), dc.pool, f)
+	if err != nil {
+		log.Printf("DinersClub admin: listing payments: %v", err)
+		http.Error(w, "ledger unavailable", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, payments)
+}
+
+// adminPayment loads the payment named in the path. When there is none to act
+// on it has answered the request itself, and ok is false.
+func (dc *DC) adminPayment(w http.ResponseWriter, r *http.Request) (p *PaymentRecord, ok bool) {
+	key := r.PathValue("key")
+	p, err := loadPayment(r.Context(), dc.pool, key)
+	if err != nil {
+		log.Printf("DinersClub admin: loading payment %s: %v", key, err)
+		http.Error(w, "ledger unavailable", http.StatusInternalServerError)
+		return nil, false
+	}
+	if p == nil {
+		http.Error(w, "no payment with that key", http.StatusNotFound)
+		return nil, false
+	}
+	return p, true
+}
+
+func (dc *DC) adminShowPayment(w http.ResponseWriter, r *http.Request) {
+	if p, ok := dc.adminPayment(w, r); ok {
+		writeJSON(w, p)
+	}
+}
+
+// sentFailure reports whether prior is a failure that was sent to the
+// respondent rather than withheld, and its recovery class.
+func sentFailure(pe *PaymentEvent, prior *Result) (Recovery, bool) {
+	if prior == nil || prior.Success || prior.Pending {
+		return "", false
+	}
+	recovery, _ := classifyPayment(pe, prior)
+	return recovery, !recovery.Silent()
+}
+
+// adminRetryPayment pays a withheld failure now. Only a failure that was
+// withheld can be retried: one that was sent released the respondent, who
+// is no longer waiting for a Result.
+func (dc *DC) adminRetryPayment(w http.ResponseWriter, r *http.Request) {
+	p, ok := dc.adminPayment(w, r)
+	if !ok {
+		return
+	}
+	if p.Status != paymentFailed {
+		http.Error(w, fmt.Sprintf("payment is %s; only a failed payment can be retried", p.Status), http.StatusConflict)
+		return
+	}
+
+	pe := p.event()
+	prior, err := p.storedResult()
+	if err != nil {
+		log.Printf("DinersClub admin: %v", err)
+		http.Error(w, "stored result unreadable", http.StatusInternalServerError)
+		return
+	}
+	if recovery, sent := sentFailure(pe, prior); sent {
+		http.Error(w, fmt.Sprintf("its %s failure was sent to the respondent, who is no longer waiting for it", recovery),
+			http.StatusConflict)
+		return
+	}
+
+	// Job derives the key again. A fallback hop's key includes its place in
+	// the chain, which the ledger does not keep, so a hop is retried by
+	// retrying the payment that fell back to it.
+	if idempotencyKey(pe) != p.IdempotencyKey {
+		http.Error(w, "payment is a fallback hop; retry the payment that fell back to it", http.StatusConflict)
+		return
+	}
+
+	log.Printf("DinersClub admin: retrying %s payment %s for user %s.", pe.Provider, p.IdempotencyKey, pe.Userid)
+	if err := dc.Job(pe); err != nil {
+		log.Printf("DinersClub admin: retrying payment %s: %v", p.IdempotencyKey, err)
+		http.Error(w, fmt.Sprintf("retry failed: %v", err), http.StatusBadGateway)
+		return
+	}
+	dc.adminShowPayment(w, r)
+}
+
+// adminMarkPaid records a payment settled outside dinersclub as a success
+// and sends the Result, releasing the respondent. Like a retry, it refuses
+// a payment whose failure was already sent: a success sent after it would
+// reach a respondent who has moved on.
+func (dc *DC) adminMarkPaid(w http.ResponseWriter, r *http.Request) {
+	body := struct {
+		By   string `json:"by"`
+		Note string `json:"note"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.By == "" {
+		http.Error(w, "body must be {\"by\": ..., \"note\": ...}, naming who paid it", http.StatusBadRequest)
+		return
+	}
+
+	p, ok := dc.adminPayment(w, r)
+	if !ok {
+		return
+	}
+	switch p.Status {
+	case paymentSuccess:
+		http.Error(w, "payment is already paid", http.StatusConflict)
+		return
+	case paymentAttempting:
+		http.Error(w, "payment is being attempted right now; look again once it has a verdict", http.StatusConflict)
+		return
+	}
+
+	pe := p.event()
+	prior, err := p.storedResult()
+	if err != nil {
+		log.Printf("DinersClub admin: %v", err)
+		http.Error(w, "stored result unreadable", http.StatusInternalServerError)
+		return
+	}
+	if recovery, sent := sentFailure(pe, prior); sent {
+		http.Error(w, fmt.Sprintf("its %s failure was sent to the respondent, who is no longer waiting for it", recovery),
+			http.StatusConflict)
+		return
+	}
+	res := outOfBandResult(pe, prior, body.By, body.Note)
+
+	marked, err := markPaid(dc.pool, p.IdempotencyKey, p.Status, res, body.By)
+	if err != nil {
+		log.Printf("DinersClub admin: marking payment %s paid: %v", p.IdempotencyKey, err)
+		http.Error(w, "ledger unavailable", http.StatusInternalServerError)
+		return
+	}
+	if !marked {
+		http.Error(w, "payment changed while it was being marked; look again", http.StatusConflict)
+		return
+	}
+	log.Printf("DinersClub admin: %s payment %s for user %s marked paid by %q (was %s).",
+		pe.Provider, p.IdempotencyKey, pe.Userid, body.By, p.Status)
+
+	// Recorded first, so a failed send is replayed by the next re-drive
+	// rather than lost.
+	if err := dc.sendResult(pe, res); err != nil {
+		log.Printf("DinersClub admin: sending out-of-band result for %s: %v", p.IdempotencyKey, err)
+		http.Error(w, "recorded as paid, but the Result could not be sent; the next re-drive replays it", http.StatusBadGateway)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
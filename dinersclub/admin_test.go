@@ -0,0 +1,194 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/stretchr/testify/assert"
+)
+
+func adminCall(t *testing.T, dc *DC, method, path, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+dc.cfg.AdminToken)
+	rec := httptest.NewRecorder()
+	dc.adminHandler().ServeHTTP(rec, req)
+	return rec
+}
+
+func adminPayments(t *testing.T, dc *DC, query string) []PaymentRecord {
+	t.Helper()
+	rec := adminCall(t, dc, "GET", "/admin/payments?"+query, "")
+	assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	payments := []PaymentRecord{}
+	assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), &payments))
+	return payments
+}
+
+func adminShow(t *testing.T, dc *DC, key string) PaymentRecord {
+	t.Helper()
+	rec := adminCall(t, dc, "GET", "/admin/payments/"+key, "")
+	assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	p := PaymentRecord{}
+	assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), &p))
+	return p
+}
+
+// walletProvider answers INSUFFICIENT_BALANCE until its wallet is topped up,
+// then pays.
+type walletProvider struct {
+	countingProvider
+	funded *int32
+}
+
+func (p *walletProvider) Payout(event *PaymentEvent) (*Result, error) {
+	atomic.AddInt32(p.attempts, 1)
+	res := &Result{Type: "payment:fake", ID: paymentID(event), Success: true}
+	if atomic.LoadInt32(p.funded) == 0 {
+		res.Success = false
+		res.Error = &PaymentError{"wallet is empty", "INSUFFICIENT_BALANCE", nil}
+	}
+	return res, nil
+}
+
+func TestParsePaymentFilter(t *testing.T) {
+	f, err := parsePaymentFilter(url.Values{"researcher": {"r1"}, "status": {"failed"}})
+	assert.Nil(t, err)
+	assert.Equal(t, paymentFilter{owner: "r1", status: "failed", limit: defaultPaymentLimit}, f)
+
+	for _, q := range []string{"status=paid", "limit=0", "limit=5000", "limit=ten"} {
+		v, _ := url.ParseQuery(q)
+		_, err := parsePaymentFilter(v)
+		assert.NotNil(t, err, q)
+	}
+}
+
+func TestOutOfBandResultKeepsTheChainsType(t *testing.T) {
+	d := json.RawMessage(`{"id": "p-1"}`)
+	pe := &PaymentEvent{Provider: "dingconnect", Details: &d}
+
+	res := outOfBandResult(pe, nil, "ops", "paid by bank transfer")
+	assert.True(t, res.Success)
+	assert.Equal(t, "payment:dingconnect", res.Type)
+	assert.Equal(t, "p-1", res.ID)
+	assert.Contains(t, string(*res.Response), `"marked_by":"ops"`)
+
+	hop := &Result{Type: "payment:reloadly", Provider: "dingconnect", Attempts: []PaymentAttempt{{Provider: "reloadly"}}}
+	res = outOfBandResult(pe, hop, "ops", "")
+	assert.Equal(t, "payment:reloadly", res.Type, "a hop answers the wait for the first provider")
+	assert.Equal(t, hop.Attempts, res.Attempts)
+}
+
+func TestMarkPaidNamesWhoPaid(t *testing.T) {
+	dc := offlineDC()
+	dc.cfg.AdminToken = "secret"
+
+	for _, body := range []string{"", `{"note": "paid"}`, `nope`} {
+		rec := adminCall(t, dc, "POST", "/admin/payments/abc/mark-paid", body)
+		assert.Equal(t, http.StatusBadRequest, rec.Code, body)
+	}
+}
+
+func TestWithheldPaymentIsRetriedByHand(t *testing.T) {
+	var received int32
+	var last string
+	ts := countingBotserver(&received, &last)
+	defer ts.Close()
+
+	var attempts, funded int32
+	provider := &walletProvider{countingProvider{attempts: &attempts, owner: "r1"}, &funded}
+	dc := getDC(ts)
+	dc.cfg.AdminToken = "secret"
+	dc.getProvider = func(pool *pgxpool.Pool, event *PaymentEvent) (Provider, error) { return provider, nil }
+
+	assert.Nil(t, dc.Process(makeMessages([]string{paymentMessage("p-1", 1600558963867, true)})))
+	assert.Equal(t, int32(0), atomic.LoadInt32(&received), "an empty wallet is withheld")
+
+	payments := adminPayments(t, dc, "userid=foo&status=failed")
+	assert.Equal(t, 1, len(payments))
+	assert.Equal(t, 0, len(adminPayments(t, dc, "researcher=someone-else")))
+	key := payments[0].IdempotencyKey
+
+	atomic.StoreInt32(&funded, 1)
+	rec := adminCall(t, dc, "POST", "/admin/payments/"+key+"/retry", "")
+	assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	assert.Equal(t, int32(2), atomic.LoadInt32(&attempts))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&received))
+	assert.Contains(t, last, `"success":true`)
+
+	p := adminShow(t, dc, key)
+	assert.Equal(t, paymentSuccess, p.Status)
+	assert.Equal(t, 2, len(p.History))
+	assert.Equal(t, paymentFailed, p.History[0].Status)
+	assert.Equal(t, "INSUFFICIENT_BALANCE", *p.History[0].ErrorCode)
+	assert.Equal(t, paymentSuccess, p.History[1].Status)
+
+	rec = adminCall(t, dc, "POST", "/admin/payments/"+key+"/retry", "")
+	assert.Equal(t, http.StatusConflict, rec.Code, "a paid payment is not retried")
+}
+
+func TestSentFailureIsNotRetriedOrMarkedPaid(t *testing.T) {
+	var received int32
+	var last string
+	ts := countingBotserver(&received, &last)
+	defer ts.Close()
+
+	dc := getDC(ts)
+	dc.cfg.AdminToken = "secret"
+	assert.Nil(t, dc.Process(makeMessages([]string{failingPaymentMessage("IMPOSSIBLE_AMOUNT")})))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&received))
+
+	key := adminPayments(t, dc, "")[0].IdempotencyKey
+	rec := adminCall(t, dc, "POST", "/admin/payments/"+key+"/retry", "")
+	assert.Equal(t, http.StatusConflict, rec.Code)
+	assert.Contains(t, rec.Body.String(), "no longer waiting")
+
+	rec = adminCall(t, dc, "POST", "/admin/payments/"+key+"/mark-paid", `{"by": "ops"}`)
+	assert.Equal(t, http.StatusConflict, rec.Code)
+	assert.Contains(t, rec.Body.String(), "no longer waiting")
+	assert.Equal(t, int32(1), atomic.LoadInt32(&received), "nothing more was sent")
+	assert.Equal(t, paymentFailed, adminShow(t, dc, key).Status)
+}
+
+func TestMarkPaidReleasesTheRespondent(t *testing.T) {
+	var received int32
+	var last string
+	ts := countingBotserver(&received, &last)
+	defer ts.Close()
+
+	var attempts, funded int32
+	provider := &walletProvider{countingProvider{attempts: &attempts, owner: "r1"}, &funded}
+	dc := getDC(ts)
+	dc.cfg.AdminToken = "secret"
+	dc.getProvider = func(pool *pgxpool.Pool, event *PaymentEvent) (Provider, error) { return provider, nil }
+
+	msg := paymentMessage("p-1", 1600558963867, true)
+	assert.Nil(t, dc.Process(makeMessages([]string{msg})))
+	key := adminPayments(t, dc, "")[0].IdempotencyKey
+
+	rec := adminCall(t, dc, "POST", "/admin/payments/"+key+"/mark-paid", `{"by": "ops", "note": "paid by bank transfer"}`)
+	assert.Equal(t, http.StatusNoContent, rec.Code, rec.Body.String())
+	assert.Equal(t, int32(1), atomic.LoadInt32(&received))
+	assert.Contains(t, last, `"success":true`)
+	assert.Contains(t, last, `"out_of_band":true`)
+
+	p := adminShow(t, dc, key)
+	assert.Equal(t, paymentSuccess, p.Status)
+	assert.Equal(t, "ops", *p.History[len(p.History)-1].RecordedBy)
+
+	// A re-drive replays the recorded success rather than paying.
+	assert.Nil(t, dc.Process(makeMessages([]string{msg})))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&attempts))
+	assert.Equal(t, int32(2), atomic.LoadInt32(&received))
+
+	rec = adminCall(t, dc, "POST", "/admin/payments/"+key+"/mark-paid", `{"by": "ops"}`)
+	assert.Equal(t, http.StatusConflict, rec.Code)
+	rec = adminCall(t, dc, "GET", "/admin/payments/nope", "")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
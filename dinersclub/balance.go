@@ -0,0 +1,239 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// Wallet balances (chatroach.payment_balances, devops/migrations/32).
+//
+// INSUFFICIENT_BALANCE is the largest failure class in classify.go, and until
+// this existed it was learned from the respondents it parked. Every
+// DINERSCLUB_BALANCE_POLL_INTERVAL, dinersclub asks the provider for the
+// balance behind every researcher credential that has paid something in the
+// last 14 days, using that credential, and exports it as
+// dinersclub_provider_balance. The alert can then fire while there is still
+// money in the wallet.
+//
+// A researcher can also set a floor on a credential. While its last fresh
+// balance is below the floor, new payouts on it are refused as
+// BALANCE_BELOW_FLOOR before the provider is called. That is a precondition,
+// like INSUFFICIENT_BALANCE itself: nothing is sent, the respondent waits, and
+// dean pays them after the top-up. A balance older than three polls is not
+// fresh and refuses nothing -- a monitor that has stopped must not become a
+// way to stop every payment.
+
+// BalanceBelowFloor is the code for a payout refused because the credential's
+// balance is below the researcher's floor.
+const BalanceBelowFloor = "BALANCE_BELOW_FLOOR"
+
+// Balance is a provider account balance in its own currency.
+type Balance struct {
+	Amount   float64
+	Currency string
+}
+
+// BalanceProvider is implemented by providers that can report the balance of
+// the account they were authorised against. Optional, like StatusProvider.
+type BalanceProvider interface {
+	Balance() (*Balance, error)
+}
+
+// balanceTarget is one researcher credential to check.
+type balanceTarget struct {
+	owner    string
+	provider string
+	key      string
+}
+
+// monitorBalances checks balances now and then every interval, forever. Run
+// it in a goroutine.
+func (dc *DC) monitorBalances() {
+	check := func() {
+		if err := dc.checkBalances(); err != nil {
+			log.Printf("DinersClub balance check error: %v", err)
+		}
+	}
+
+	check()
+	ticker := time.NewTicker(dc.cfg.BalancePollInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		check()
+	}
+}
+
+// checkBalances runs one round over every target.
+func (dc *DC) checkBalances() error {
+	targets, err := balanceTargets(dc.pool)
+	if err != nil {
+		recordFault("balance")
+		return err
+	}
+
+	failed := 0
+	for _, t := range targets {
+		bal, err := dc.fetchBalance(t)
+		if bal == nil && err == nil {
+			continue // the provider has no balance endpoint
+		}
+		if err != nil {
+			recordFault("balance")
+			failed++
+		} else {
+			recordBalance(t, bal)
+		}
+		if e := saveBalance(dc.pool, t, bal, err); e != nil {
+			recordFault("balance")
+			return e
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d balances could not be checked", failed, len(targets))
+	}
+	return nil
+}
+
+// fetchBalance authorises the target's provider with its credential and asks
+// for the balance. It returns (nil, nil) for a provider with no balance
+// endpoint.
+func (dc *DC) fetchBalance(t balanceTarget) (*Balance, error) {
+	provider, err := dc.getProvider(dc.pool, &PaymentEvent{Provider: t.provider, Key: t.key})
+	if err != nil {
+		return nil, err
+	}
+	bp, ok := provider.(BalanceProvider)
+	if !ok {
+		return nil, nil
+	}
+	if err := provider.Auth(&User{Id: t.owner}, t.key); err != nil {
+		return nil, err
+	}
+	return bp.Balance()
+}
+
+// balanceTargets is every credential that has paid in the last 14 days --
+// dean's re-drive window, so anything that could still be paid -- plus every
+// one a researcher has set a floor on.
+func balanceTargets(pool *pgxpool.Pool) ([]balanceTarget, error) {
+	query := `
+		SELECT DISTINCT owner, provider, credential_key
+		FROM payments
+		WHERE owner IS NOT NULL AND credential_key IS NOT NULL
+		  AND updated_at > now() - INTERVAL '14 days'
+		UNION
+		SELECT userid::STRING, provider, credential_key
+		FROM payment_balances
+		WHERE floor IS NOT NULL`
+
+	rows, err := pool.Query(context.Background(), query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	targets := []balanceTarget{}
+	for rows.Next() {
+		var t balanceTarget
+		if err := rows.Scan(&t.owner, &t.provider, &t.key); err != nil {
+			return nil, err
+		}
+		targets = append(targets, t)
+	}
+	return targets, rows.Err()
+}
+
+// saveBalance records a check. A failed check keeps the last balance and
+// records the error; the balance only stops counting once it goes stale.
+func saveBalance(pool *pgxpool.Pool, t balanceTarget, bal *Balance, checkErr error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if checkErr != nil {
+		_, err := pool.Exec(ctx, `
+			INSERT INTO payment_balances (userid, provider, credential_key, error)
+			VALUES ($1, $2, $3, $4)
+			ON CONFLICT (userid, provider, credential_key)
+			DO UPDATE SET error = excluded.error, updated_at = now()`,
+			t.owner, t.provider, t.key, checkErr.Error())
+		return err
+	}
+
+	_, err := pool.Exec(ctx, `
+		INSERT INTO payment_balances (userid, provider, credential_key, balance, currency, checked_at)
+		VALUES ($1, $2, $3, $4, $5, now())
+		ON CONFLICT (userid, provider, credential_key)
+		DO UPDATE SET balance = excluded.balance, currency = excluded.currency, error = NULL,
+			checked_at = now(), updated_at = now()`,
+		t.owner, t.provider, t.key, bal.Amount, bal.Currency)
+	return err
+}
+
+// checkBalanceFloor reports why a payment should be refused for its
+// credential's balance, or "" if it should not. Only a balance checked within
+// maxAge counts.
+func checkBalanceFloor(pool *pgxpool.Pool, pe *PaymentEvent, owner string, maxAge time.Duration) (string, error) {
+	if pe.Key == "" || maxAge <= 0 {
+		return "", nil
+	}
+
+	query := `
+		SELECT balance::FLOAT, floor::FLOAT, COALESCE(currency, '')
+		FROM payment_balances
+		WHERE userid::STRING = $1 AND provider = $2 AND credential_key = $3
+		  AND floor IS NOT NULL AND balance IS NOT NULL
+		  AND checked_at > $4`
+
+	var balance, floor float64
+	var currency string
+	err := pool.QueryRow(context.Background(), query, owner, pe.Provider, pe.Key, time.Now().UTC().Add(-maxAge)).
+		Scan(&balance, &floor, &currency)
+	if err == pgx.ErrNoRows {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if balance >= floor {
+		return "", nil
+	}
+	return fmt.Sprintf("The %s balance for %q is %.2f %s, below the floor of %.2f. Top the account up to resume payments.",
+		pe.Provider, pe.Key, balance, currency, floor), nil
+}
+
+func balanceBelowFloorResult(pe *PaymentEvent, message string) *Result {
+	err := &PaymentError{message, BalanceBelowFloor, pe.Details}
+	t := fmt.Sprintf("payment:%v", pe.Provider)
+	return &Result{Type: t, ID: paymentID(pe), Success: false, Timestamp: time.Now().UTC(), Error: err}
+}
+
+// Reloadly topups and gift cards share an account, and both APIs expose it at
+// /accounts/balance.
+func (p *ReloadlyProvider) Balance() (*Balance, error) {
+	resp := struct {
+		Balance      float64 `json:"balance"`
+		CurrencyCode string  `json:"currencyCode"`
+	}{}
+	if _, err := p.svc.Request("GET", "accounts/balance", nil, &resp); err != nil {
+		return nil, err
+	}
+	return &Balance{resp.Balance, resp.CurrencyCode}, nil
+}
+
+// DingConnect's GetBalance is not a call the dingconnect client makes; see
+// dingGet.
+func (p *DingConnectProvider) Balance() (*Balance, error) {
+	body := struct {
+		Balance     float64 `json:"Balance"`
+		CurrencyIso string  `json:"CurrencyIso"`
+	}{}
+	if err := p.dingGet("GetBalance", nil, &body); err != nil {
+		return nil, err
+	}
+	return &Balance{body.Balance, body.CurrencyIso}, nil
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vlab-research/go-reloadly/reloadly"
+)
+
+func TestDingConnectBalance(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "secret-key", r.Header.Get("api_key"))
+		w.Write([]byte(`{"Balance": 412.5, "CurrencyIso": "USD", "ResultCode": 1, "ErrorCodes": []}`))
+	}))
+	defer ts.Close()
+
+	p := &DingConnectProvider{apiKey: "secret-key", balanceURL: ts.URL}
+	bal, err := p.Balance()
+
+	assert.Nil(t, err)
+	assert.Equal(t, &Balance{412.5, "USD"}, bal)
+}
+
+func TestDingConnectBalanceReportsAFailedResult(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(401)
+		w.Write([]byte(`{"ResultCode": 3, "ErrorCodes": [{"Code": "AccountNotFound"}]}`))
+	}))
+	defer ts.Close()
+
+	p := &DingConnectProvider{apiKey: "bad-key", balanceURL: ts.URL}
+	bal, err := p.Balance()
+
+	assert.Nil(t, bal)
+	assert.Contains(t, err.Error(), "HTTP 401")
+}
+
+func TestDingConnectBalanceNeedsAuth(t *testing.T) {
+	_, err := (&DingConnectProvider{}).Balance()
+	assert.Contains(t, err.Error(), "before Auth")
+}
+
+func TestReloadlyBalance(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/accounts/balance", r.URL.Path)
+		w.Write([]byte(`{"balance": 87.3, "currencyCode": "EUR", "currencyName": "Euro"}`))
+	}))
+	defer ts.Close()
+
+	svc := reloadly.NewTopups()
+	svc.BaseUrl = ts.URL
+	bal, err := (&ReloadlyProvider{svc: svc}).Balance()
+
+	assert.Nil(t, err)
+	assert.Equal(t, &Balance{87.3, "EUR"}, bal)
+}
+
+func TestBalanceFloorIsOffWithoutAKeyOrAFreshnessWindow(t *testing.T) {
+	// Neither touches the database.
+	floor, err := checkBalanceFloor(nil, &PaymentEvent{Provider: "reloadly"}, "owner", time.Minute)
+	assert.Nil(t, err)
+	assert.Equal(t, "", floor)
+
+	floor, err = checkBalanceFloor(nil, &PaymentEvent{Provider: "reloadly", Key: "k"}, "owner", 0)
+	assert.Nil(t, err)
+	assert.Equal(t, "", floor)
+}
+
+func TestBalanceFloorOnlyRefusesOnAFreshBalance(t *testing.T) {
+	pool := getPool(getConfig())
+	defer pool.Close()
+	before(t, pool)
+	insertDingUser(t, pool)
+
+	owner := "00000000-0000-0000-0000-000000000000"
+	target := balanceTarget{owner, "reloadly", "main"}
+	pe := &PaymentEvent{Provider: "reloadly", Key: "main"}
+
+	assert.Nil(t, saveBalance(pool, target, &Balance{40, "USD"}, nil))
+	floor, err := checkBalanceFloor(pool, pe, owner, time.Hour)
+	assert.Nil(t, err)
+	assert.Equal(t, "", floor, "no floor set")
+
+	mustExec(t, pool, `UPDATE payment_balances SET floor = 50`)
+	floor, err = checkBalanceFloor(pool, pe, owner, time.Hour)
+	assert.Nil(t, err)
+	assert.Contains(t, floor, "below the floor of 50.00")
+
+	mustExec(t, pool, `UPDATE payment_balances SET checked_at = now() - INTERVAL '2 hours'`)
+	floor, err = checkBalanceFloor(pool, pe, owner, time.Hour)
+	assert.Nil(t, err)
+	assert.Equal(t, "", floor, "stale")
+
+	// A failed check keeps the balance but does not refresh it.
+	assert.Nil(t, saveBalance(pool, target, nil, assert.AnError))
+	floor, err = checkBalanceFloor(pool, pe, owner, time.Hour)
+	assert.Nil(t, err)
+	assert.Equal(t, "", floor)
+}
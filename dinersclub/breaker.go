@@ -0,0 +1,216 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Per-provider concurrency limits and circuit breakers.
+//
+// DINERSCLUB_POOL_SIZE bounds how many payments are in flight in total, but
+// says nothing about how they are spread: a batch of http payments can take
+// every worker and every one of them can be waiting on the same partner.
+// DINERSCLUB_PROVIDER_CONCURRENCY caps each provider's in-flight Payout calls
+// below that.
+//
+// The breaker is the other half. A provider that is down answers every
+// payment with a transient failure, and each of those spends its whole retry
+// budget finding that out -- which is how a batch outruns the Kafka poll
+// interval, and is a lot of traffic to point at a partner who is already
+// struggling. After DINERSCLUB_BREAKER_THRESHOLD transient failures in a row
+// the breaker opens: for DINERSCLUB_BREAKER_COOLDOWN every payment to that
+// provider is answered CIRCUIT_OPEN without calling it. CIRCUIT_OPEN is
+// transient, so deliver withholds it and dean re-drives the payment exactly
+// as it would a 503. Then one payment is let through as a probe; its outcome
+// closes the breaker or opens it for another cooldown.
+//
+// A breaker is per provider, except for http: every researcher there points
+// the same provider at their own partner, so one researcher's broken partner
+// must not withhold everyone else's payouts. Those breakers are per owner.
+// Concurrency limits stay per provider either way -- they are a provider
+// setting.
+//
+// The state lives here and not on DC (whose literal is positional in too many
+// places); it is per process, so each replica finds out for itself.
+
+// CircuitOpen is the code for a payment refused because its provider's
+// breaker is open.
+const CircuitOpen = "CIRCUIT_OPEN"
+
+type breakerState int
+
+// The values are what dinersclub_circuit_breaker_state exports.
+const (
+	breakerClosed breakerState = iota
+	breakerHalfOpen
+	breakerOpen
+)
+
+type circuitBreaker struct {
+	mu        sync.Mutex
+	provider  string
+	owner     string // "" unless the breaker is per owner
+	threshold int    // 0 never opens
+	cooldown  time.Duration
+	failures  int
+	state     breakerState
+	openedAt  time.Time
+	probing   bool
+	now       func() time.Time
+}
+
+func newCircuitBreaker(provider, owner string, threshold int, cooldown time.Duration) *circuitBreaker {
+	b := &circuitBreaker{provider: provider, owner: owner, threshold: threshold, cooldown: cooldown, now: time.Now}
+	recordBreakerState(provider, owner, breakerClosed)
+	return b
+}
+
+func (b *circuitBreaker) setState(s breakerState) {
+	if s == breakerOpen && b.state != breakerOpen {
+		recordBreakerOpen(b.provider, b.owner)
+	}
+	b.state = s
+	recordBreakerState(b.provider, b.owner, s)
+}
+
+// allow reports whether a payment may call the provider. Once the cooldown
+// has passed, exactly one caller is let through as the probe.
+func (b *circuitBreaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case breakerOpen:
+		if b.now().Sub(b.openedAt) < b.cooldown {
+			return false
+		}
+		b.setState(breakerHalfOpen)
+		b.probing = true
+		return true
+	case breakerHalfOpen:
+		if b.probing {
+			return false
+		}
+		b.probing = true
+		return true
+	}
+	return true
+}
+
+// open reports whether the breaker is open, for a payment already inside
+// its retries to stop early.
+func (b *circuitBreaker) open() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state == breakerOpen
+}
+
+// record files the outcome of one call. Only transient failures count
+// against the provider: a permanent or precondition failure is the provider
+// answering, which is what the breaker is waiting for.
+func (b *circuitBreaker) record(transient bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.probing = false
+	if !transient {
+		b.failures = 0
+		if b.state != breakerClosed {
+			b.setState(breakerClosed)
+		}
+		return
+	}
+
+	b.failures++
+	if b.state == breakerHalfOpen || (b.threshold > 0 && b.failures >= b.threshold) {
+		b.openedAt = b.now()
+		b.setState(breakerOpen)
+	}
+}
+
+// providerGuard is one payment's concurrency limit and breaker.
+type providerGuard struct {
+	slots   chan struct{} // nil: no limit beyond the pool
+	breaker *circuitBreaker
+}
+
+func (g *providerGuard) acquire() {
+	if g.slots != nil {
+		g.slots <- struct{}{}
+	}
+}
+
+func (g *providerGuard) release() {
+	if g.slots != nil {
+		<-g.slots
+	}
+}
+
+var providerGuards = struct {
+	mu       sync.Mutex
+	slots    map[string]chan struct{}
+	breakers map[string]*circuitBreaker
+}{slots: map[string]chan struct{}{}, breakers: map[string]*circuitBreaker{}}
+
+// breakerOwner is who, besides the provider, a payment's breaker belongs to.
+func breakerOwner(pe *PaymentEvent) string {
+	if pe.Provider == "http" {
+		return pe.Owner
+	}
+	return ""
+}
+
+// guardFor returns the payment's guard: its provider's concurrency slots and
+// its breaker, each made on first use from cfg.
+func guardFor(cfg *Config, pe *PaymentEvent) *providerGuard {
+	providerGuards.mu.Lock()
+	defer providerGuards.mu.Unlock()
+
+	slots, ok := providerGuards.slots[pe.Provider]
+	if !ok {
+		// main has already refused a malformed setting at startup.
+		limits, _ := parseProviderLimits(cfg.ProviderConcurrency)
+		if n := limits[pe.Provider]; n > 0 {
+			slots = make(chan struct{}, n)
+		}
+		providerGuards.slots[pe.Provider] = slots
+	}
+
+	owner := breakerOwner(pe)
+	key := pe.Provider + "\x00" + owner
+	b, ok := providerGuards.breakers[key]
+	if !ok {
+		b = newCircuitBreaker(pe.Provider, owner, cfg.BreakerThreshold, cfg.BreakerCooldown)
+		providerGuards.breakers[key] = b
+	}
+	return &providerGuard{slots: slots, breaker: b}
+}
+
+// parseProviderLimits reads DINERSCLUB_PROVIDER_CONCURRENCY, entries of the
+// form provider=limit.
+func parseProviderLimits(entries []string) (map[string]int, error) {
+	limits := map[string]int{}
+	for _, e := range entries {
+		e = strings.TrimSpace(e)
+		if e == "" {
+			continue
+		}
+		name, n, ok := strings.Cut(e, "=")
+		limit, err := strconv.Atoi(strings.TrimSpace(n))
+		if !ok || err != nil || limit < 1 {
+			return nil, fmt.Errorf("DINERSCLUB_PROVIDER_CONCURRENCY: %q is not provider=limit with a limit of at least 1", e)
+		}
+		limits[strings.TrimSpace(name)] = limit
+	}
+	return limits, nil
+}
+
+func circuitOpenResult(pe *PaymentEvent, cooldown time.Duration) *Result {
+	message := fmt.Sprintf("Payments to %s are paused after repeated failures; trying again within %s", pe.Provider, cooldown)
+	err := &PaymentError{message, CircuitOpen, pe.Details}
+	t := fmt.Sprintf("payment:%v", pe.Provider)
+	return &Result{Type: t, ID: paymentID(pe), Success: false, Timestamp: time.Now().UTC(), Error: err}
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"encoding/json"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// A breaker on a clock the test moves.
+func testBreaker(threshold int, cooldown time.Duration) (*circuitBreaker, *time.Time) {
+	now := time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC)
+	b := newCircuitBreaker("test", "", threshold, cooldown)
+	b.now = func() time.Time { return now }
+	return b, &now
+}
+
+func TestBreakerOpensAfterThresholdTransientFailuresInARow(t *testing.T) {
+	b, _ := testBreaker(3, time.Minute)
+
+	b.record(true)
+	b.record(true)
+	b.record(false) // the provider answered: the run is broken
+	b.record(true)
+	b.record(true)
+	assert.True(t, b.allow())
+
+	b.record(true)
+	assert.True(t, b.open())
+	assert.False(t, b.allow())
+}
+
+func TestBreakerLetsOneProbeThroughAfterCooldown(t *testing.T) {
+	b, now := testBreaker(1, time.Minute)
+	b.record(true)
+	assert.False(t, b.allow())
+
+	*now = now.Add(time.Minute)
+	assert.True(t, b.allow(), "the probe")
+	assert.False(t, b.allow(), "only one probe at a time")
+
+	b.record(false)
+	assert.False(t, b.open())
+	assert.True(t, b.allow())
+	assert.True(t, b.allow())
+}
+
+func TestBreakerReopensWhenTheProbeFails(t *testing.T) {
+	b, now := testBreaker(5, time.Minute)
+	for i := 0; i < 5; i++ {
+		b.record(true)
+	}
+
+	*now = now.Add(time.Minute)
+	assert.True(t, b.allow())
+	b.record(true)
+
+	assert.True(t, b.open(), "one failed probe reopens, whatever the threshold")
+	assert.False(t, b.allow())
+	*now = now.Add(59 * time.Second)
+	assert.False(t, b.allow(), "for a whole new cooldown")
+}
+
+func TestBreakerWithZeroThresholdNeverOpens(t *testing.T) {
+	b, _ := testBreaker(0, time.Minute)
+	for i := 0; i < 100; i++ {
+		b.record(true)
+	}
+	assert.True(t, b.allow())
+}
+
+func TestParseProviderLimits(t *testing.T) {
+	limits, err := parseProviderLimits([]string{"reloadly=4", " http = 8 ", ""})
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]int{"reloadly": 4, "http": 8}, limits)
+
+	for _, bad := range []string{"reloadly", "reloadly=0", "reloadly=many"} {
+		_, err := parseProviderLimits([]string{bad})
+		assert.NotNil(t, err, bad)
+	}
+}
+
+// transientProvider fails every payment with a 503.
+type transientProvider struct {
+	countingProvider
+}
+
+func (p *transientProvider) Payout(event *PaymentEvent) (*Result, error) {
+	atomic.AddInt32(p.attempts, 1)
+	return &Result{Error: &PaymentError{Message: "down", Code: "503"}}, nil
+}
+
+func breakerDC(threshold int) *DC {
+	cfg := getConfig()
+	cfg.RetryProvider = time.Minute
+	cfg.BreakerThreshold = threshold
+	cfg.BreakerCooldown = time.Hour
+	return &DC{cfg, nil, nil, nil, nil}
+}
+
+func breakerEvent(provider string) *PaymentEvent {
+	details := json.RawMessage(`{"id": "payment-1"}`)
+	return &PaymentEvent{Provider: provider, Details: &details}
+}
+
+func TestOpenBreakerWithholdsWithoutCallingTheProvider(t *testing.T) {
+	var attempts int32
+	provider := &transientProvider{countingProvider{attempts: &attempts}}
+	dc := breakerDC(2)
+	pe := breakerEvent("breaker-test-open")
+
+	// A minute of retry budget, but the breaker opening ends it.
+	res, err := dc.payout(provider, pe)
+	assert.Nil(t, err)
+	assert.Equal(t, "503", res.Error.Code)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&attempts))
+
+	res, err = dc.payout(provider, pe)
+
+	assert.Nil(t, err)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&attempts), "the next payment never reached the provider")
+	assert.Equal(t, CircuitOpen, res.Error.Code)
+	assert.Equal(t, "payment-1", res.ID)
+	recovery, known := ClassifyResult(res)
+	assert.True(t, known)
+	assert.Equal(t, RecoveryTransient, recovery, "withheld, so dean re-drives it")
+}
+
+// Every http researcher has their own partner, so one researcher's failing
+// partner opens only their breaker.
+func TestHTTPBreakerIsPerOwner(t *testing.T) {
+	var brokenAttempts, workingAttempts int32
+	broken := &transientProvider{countingProvider{attempts: &brokenAttempts}}
+	working := &countingProvider{attempts: &workingAttempts}
+	dc := breakerDC(2)
+
+	a := breakerEvent("http")
+	a.Owner = "breaker-test-researcher-a"
+	b := breakerEvent("http")
+	b.Owner = "breaker-test-researcher-b"
+	details := json.RawMessage(`{"result": {"type": "payment:http", "id": "payment-2", "success": true}}`)
+	b.Details = &details
+
+	_, _ = dc.payout(broken, a)
+	res, _ := dc.payout(broken, a)
+	assert.Equal(t, CircuitOpen, res.Error.Code, "researcher A's breaker is open")
+
+	res, err := dc.payout(working, b)
+	assert.Nil(t, err)
+	assert.True(t, res.Success, "researcher B's payment was attempted")
+	assert.Equal(t, int32(1), atomic.LoadInt32(&workingAttempts))
+	assert.False(t, guardFor(dc.cfg, b).breaker.open())
+}
+
+// Other providers are one API for everyone, so their breaker is shared.
+func TestBreakerIsSharedAcrossOwnersForOtherProviders(t *testing.T) {
+	a := breakerEvent("breaker-test-shared")
+	a.Owner = "researcher-a"
+	b := breakerEvent("breaker-test-shared")
+	b.Owner = "researcher-b"
+
+	dc := breakerDC(2)
+	assert.Same(t, guardFor(dc.cfg, a).breaker, guardFor(dc.cfg, b).breaker)
+}
+
+// slowProvider records the most Payout calls it ever saw at once.
+type slowProvider struct {
+	countingProvider
+	mu       sync.Mutex
+	inFlight int
+	peak     int
+}
+
+func (p *slowProvider) Payout(event *PaymentEvent) (*Result, error) {
+	p.mu.Lock()
+	p.inFlight++
+	if p.inFlight > p.peak {
+		p.peak = p.inFlight
+	}
+	p.mu.Unlock()
+
+	time.Sleep(20 * time.Millisecond)
+
+	p.mu.Lock()
+	p.inFlight--
+	p.mu.Unlock()
+	return &Result{Success: true}, nil
+}
+
+func TestProviderConcurrencyLimit(t *testing.T) {
+	var attempts int32
+	provider := &slowProvider{countingProvider: countingProvider{attempts: &attempts}}
+	dc := breakerDC(0)
+	dc.cfg.ProviderConcurrency = []string{"breaker-test-limit=2"}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, _ = dc.payout(provider, breakerEvent("breaker-test-limit"))
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, 2, provider.peak)
+}
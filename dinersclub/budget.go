@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// Payment budgets (chatroach.payment_budgets, devops/migrations/29).
+//
+// Nothing used to stop a misconfigured survey -- or a bot farm working through
+// one -- from draining a researcher's wallet. A budget caps what a researcher
+// pays per UTC day, in total, and per respondent, either across all their
+// surveys or for one shortcode. Every budget that applies is checked; the
+// first breached cap wins.
+//
+// An over-budget payment is not a fault and not a permanent failure. It is
+// BUDGET_EXCEEDED, a precondition like an empty wallet: nothing is sent, the
+// respondent stays parked, and dean re-drives it -- which pays them once the
+// day rolls over or the researcher raises the cap.
+
+// BudgetExceeded is the provider error code for a payment refused by a budget.
+const BudgetExceeded = "BUDGET_EXCEEDED"
+
+type budget struct {
+	ID               string
+	Shortcode        *string
+	DailyCap         *float64
+	TotalCap         *float64
+	PerRespondentCap *float64
+}
+
+// paymentAmount reads how much a payment spends from its details. Providers
+// name the amount differently: Reloadly topups and the fake and http
+// providers use `amount`, DingConnect `send_value`, and gift cards
+// `unitPrice` times `quantity`. A payment with no recognisable amount spends
+// nothing -- budgets are in the provider's currency and cannot price what
+// they cannot read.
+func paymentAmount(pe *PaymentEvent) float64 {
+	if pe.Details == nil {
+		return 0
+	}
+	d := struct {
+		Amount    json.Number `json:"amount"`
+		SendValue json.Number `json:"send_value"`
+		UnitPrice json.Number `json:"unitPrice"`
+		Quantity  json.Number `json:"quantity"`
+	}{}
+	if err := json.Unmarshal(*pe.Details, &d); err != nil {
+		return 0
+	}
+
+	num := func(n json.Number) float64 {
+		f, err := strconv.ParseFloat(string(n), 64)
+		if err != nil {
+			return 0
+		}
+		return f
+	}
+
+	switch {
+	case d.Amount != "":
+		return num(d.Amount)
+	case d.SendValue != "":
+		return num(d.SendValue)
+	case d.UnitPrice != "":
+		q := 1.0
+		if d.Quantity != "" {
+			q = num(d.Quantity)
+		}
+		return num(d.UnitPrice) * q
+	}
+	return 0
+}
+
+// checkBudgets reports the first cap this payment would breach, or "" if it
+// fits every budget that applies. It must run inside the transaction that
+// claims the payment: the budget rows are locked FOR UPDATE, so two workers
+// paying for the same researcher are serialised here and cannot both spend
+// the last of a cap.
+func checkBudgets(ctx context.Context, tx pgx.Tx, pe *PaymentEvent, owner string, amount float64) (string, error) {
+	budgets, err := applicableBudgets(ctx, tx, owner, pe.Shortcode)
+	if err != nil || len(budgets) == 0 {
+		return "", err
+	}
+
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+
+	// 'attempting' and 'pending' count as spent: it is money that may
+	// already have moved. The payment's own row is excluded, so reclaiming it
+	// is not counted against itself.
+	//
+	// A payment's day is the day it was first claimed. updated_at will not
+	// do: the poller's lease, a review decision and every recorded verdict
+	// bump it, so a payment from yesterday that is still pending would be
+	// counted again against today.
+	query := `
+		SELECT
+			COALESCE(SUM(amount) FILTER (WHERE created_at >= $3), 0)::FLOAT,
+			COALESCE(SUM(amount), 0)::FLOAT,
+			COALESCE(SUM(amount) FILTER (WHERE userid = $4 AND pageid = $5), 0)::FLOAT
+		FROM payments
+		WHERE owner = $1
+		  AND status IN ('attempting', 'pending', 'success')
+		  AND ($2::STRING IS NULL OR shortcode = $2)
+		  AND idempotency_key != $6`
+
+	for _, b := range budgets {
+		var daily, total, respondent float64
+		err := tx.QueryRow(ctx, query, owner, b.Shortcode, today, pe.Userid, pe.Pageid, pe.IdempotencyKey).
+			Scan(&daily, &total, &respondent)
+		if err != nil {
+			return "", err
+		}
+
+		scope := "all surveys"
+		if b.Shortcode != nil {
+			scope = fmt.Sprintf("survey %s", *b.Shortcode)
+		}
+
+		switch {
+		case b.DailyCap != nil && daily+amount > *b.DailyCap:
+			return fmt.Sprintf("Daily budget for %s exceeded: %.2f spent today of %.2f", scope, daily, *b.DailyCap), nil
+		case b.TotalCap != nil && total+amount > *b.TotalCap:
+			return fmt.Sprintf("Total budget for %s exceeded: %.2f spent of %.2f", scope, total, *b.TotalCap), nil
+		case b.PerRespondentCap != nil && respondent+amount > *b.PerRespondentCap:
+			return fmt.Sprintf("Per-respondent budget for %s exceeded: %.2f paid to this respondent of %.2f", scope, respondent, *b.PerRespondentCap), nil
+		}
+	}
+	return "", nil
+}
+
+// applicableBudgets loads, and locks, the researcher-wide budget and the
+// budget for this survey.
+func applicableBudgets(ctx context.Context, tx pgx.Tx, owner, shortcode string) ([]budget, error) {
+	query := `
+		SELECT id::STRING, shortcode, daily_cap::FLOAT, total_cap::FLOAT, per_respondent_cap::FLOAT
+		FROM payment_budgets
+		WHERE userid::STRING = $1
+		  AND (shortcode IS NULL OR shortcode = NULLIF($2, ''))
+		FOR UPDATE`
+
+	rows, err := tx.Query(ctx, query, owner, shortcode)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	budgets := []budget{}
+	for rows.Next() {
+		var b budget
+		if err := rows.Scan(&b.ID, &b.Shortcode, &b.DailyCap, &b.TotalCap, &b.PerRespondentCap); err != nil {
+			return nil, err
+		}
+		budgets = append(budgets, b)
+	}
+	return budgets, rows.Err()
+}
+
+func budgetExceededResult(pe *PaymentEvent, message string) *Result {
+	err := &PaymentError{message, BudgetExceeded, pe.Details}
+	t := fmt.Sprintf("payment:%v", pe.Provider)
+	return &Result{Type: t, ID: paymentID(pe), Success: false, Timestamp: time.Now().UTC(), Error: err}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync/atomic"
+	"testing"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/stretchr/testify/assert"
+)
+
+const budgetOwner = "00000000-0000-0000-0000-000000000000"
+
+// budgetMessage builds a successful fake-provider payment of amount to
+// userid, from survey shortcode.
+func budgetMessage(userid, id, shortcode string, amount float64) string {
+	return fmt.Sprintf(`{
+		"userid": %q,
+		"pageid": "page",
+		"shortcode": %q,
+		"timestamp": 1600558963867,
+		"provider": "fake",
+		"details": {
+			"id": %q,
+			"amount": %v,
+			"result": {"type": "payment:fake", "id": %q, "success": true}
+		}
+	}`, userid, shortcode, id, amount, id)
+}
+
+// budgetDC returns a DC whose payments belong to budgetOwner, after replacing
+// that researcher's budgets with the given (shortcode, daily, total,
+// per-respondent) row. A nil field is no cap.
+func budgetDC(t *testing.T, received, attempts *int32, shortcode *string, daily, total, perRespondent *float64) *DC {
+	var last string
+	ts := countingBotserver(received, &last)
+	t.Cleanup(ts.Close)
+
+	dc := getDC(ts)
+	before(t, dc.pool)
+	mustExec(t, dc.pool, `INSERT INTO users(id, email) VALUES ($1, 'budget@test.com')`, budgetOwner)
+	mustExec(t, dc.pool, `
+		INSERT INTO payment_budgets(userid, shortcode, daily_cap, total_cap, per_respondent_cap)
+		VALUES ($1, $2, $3, $4, $5)`, budgetOwner, shortcode, daily, total, perRespondent)
+
+	dc.getProvider = func(pool *pgxpool.Pool, event *PaymentEvent) (Provider, error) {
+		return &countingProvider{attempts: attempts, owner: budgetOwner}, nil
+	}
+	return dc
+}
+
+func capOf(f float64) *float64 { return &f }
+
+func TestPaymentAmount(t *testing.T) {
+	tests := []struct {
+		details string
+		want    float64
+	}{
+		{`{"number": "+123", "amount": 2.5}`, 2.5},
+		{`{"amount": "0.83"}`, 0.83},
+		{`{"sku_code": "S", "send_value": 25.0}`, 25},
+		{`{"productId": 1, "unitPrice": 5, "quantity": 2}`, 10},
+		{`{"productId": 1, "unitPrice": 5}`, 5},
+		{`{"url": "https://example.com"}`, 0},
+		{`not json`, 0},
+	}
+
+	for _, tt := range tests {
+		d := json.RawMessage(tt.details)
+		assert.Equal(t, tt.want, paymentAmount(&PaymentEvent{Details: &d}), tt.details)
+	}
+}
+
+func TestTotalBudgetWithholdsPaymentsOverTheCap(t *testing.T) {
+	var received, attempts int32
+	dc := budgetDC(t, &received, &attempts, nil, nil, capOf(5), nil)
+
+	err := dc.Process(makeMessages([]string{
+		budgetMessage("foo", "payment-1", "survey", 3),
+		budgetMessage("bar", "payment-1", "survey", 3),
+	}))
+
+	assert.Nil(t, err)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&attempts), "the second payment must never reach the provider")
+	assert.Equal(t, int32(1), atomic.LoadInt32(&received), "BUDGET_EXCEEDED is withheld like an empty wallet")
+}
+
+func TestPerRespondentBudget(t *testing.T) {
+	var received, attempts int32
+	dc := budgetDC(t, &received, &attempts, nil, nil, nil, capOf(3))
+
+	err := dc.Process(makeMessages([]string{
+		budgetMessage("foo", "payment-1", "survey", 2),
+		budgetMessage("foo", "payment-2", "survey", 2),
+		budgetMessage("bar", "payment-1", "survey", 2),
+	}))
+
+	assert.Nil(t, err)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&attempts), "foo's second payment is over their cap, bar's first is not")
+	assert.Equal(t, int32(2), atomic.LoadInt32(&received))
+}
+
+func TestSurveyBudgetOnlyAppliesToItsSurvey(t *testing.T) {
+	var received, attempts int32
+	capped := "capped"
+	dc := budgetDC(t, &received, &attempts, &capped, capOf(1), nil, nil)
+
+	err := dc.Process(makeMessages([]string{
+		budgetMessage("foo", "payment-1", "capped", 2),
+		budgetMessage("foo", "payment-2", "other", 2),
+	}))
+
+	assert.Nil(t, err)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&attempts))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&received))
+}
+
+func TestPendingPaymentCountsAgainstTheDayItWasMade(t *testing.T) {
+	var received, attempts int32
+	dc := budgetDC(t, &received, &attempts, nil, capOf(3), nil, nil)
+
+	// Yesterday's payment, still pending, leased by the poller today.
+	mustExec(t, dc.pool, `
+		INSERT INTO payments (idempotency_key, userid, pageid, provider, owner, status, amount, result, created_at, updated_at)
+		VALUES ('yesterday', 'foo', 'page', 'fake', $1, 'pending', 2, '{"type": "payment:fake"}', now() - INTERVAL '1 day', now() - INTERVAL '1 day')`,
+		budgetOwner)
+	leased, err := leasePendingPayment(dc.pool, "yesterday")
+	assert.Nil(t, err)
+	assert.NotNil(t, leased)
+
+	err = dc.Process(makeMessages([]string{budgetMessage("bar", "payment-1", "survey", 2)}))
+
+	assert.Nil(t, err)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&attempts), "yesterday's spend is not today's")
+	assert.Equal(t, int32(1), atomic.LoadInt32(&received))
+}
+
+func TestFailedPaymentsDoNotSpendTheBudget(t *testing.T) {
+	var received, attempts int32
+	dc := budgetDC(t, &received, &attempts, nil, capOf(3), nil, nil)
+
+	failed := fmt.Sprintf(`{
+		"userid": "foo",
+		"pageid": "page",
+		"timestamp": 1600558963867,
+		"provider": "fake",
+		"details": {
+			"id": "payment-1",
+			"amount": 2,
+			"result": {"type": "payment:fake", "success": false, "error": {"message": "no", "code": %q}}
+		}
+	}`, "INVALID_RECIPIENT_PHONE")
+
+	err := dc.Process(makeMessages([]string{failed, budgetMessage("bar", "payment-1", "survey", 2)}))
+
+	assert.Nil(t, err)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&attempts))
+	assert.Equal(t, int32(2), atomic.LoadInt32(&received))
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// Provider callbacks.
+//
+// Some providers can call us when a pending payment settles (the mobile money
+// API does, via X-Callback-Url). A callback is treated as a hint and nothing
+// more: its body is never believed. It only makes the poller ask the provider
+// about that one payment now, through the same StatusProvider path and the
+// same deliver as a scheduled sweep. That keeps the callback endpoint from
+// being a way to mark payments paid -- anyone who can reach it can at most
+// make us poll early -- and means a lost callback costs one poll interval,
+// not a payment.
+//
+// Providers are told to call back on
+// DINERSCLUB_CALLBACK_URL/callbacks/<provider>/<idempotency key>.
+
+// serveCallbacks serves provider callbacks. Failures are logged and swallowed,
+// like serveMetrics: without callbacks, pending payments still settle on the
+// poller's schedule. Run it in a goroutine.
+func (dc *DC) serveCallbacks(port int) {
+	addr := fmt.Sprintf(":%d", port)
+	log.Printf("DinersClub serving provider callbacks on %s", addr)
+
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           dc.callbackHandler(),
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+	if err := srv.ListenAndServe(); err != nil {
+		log.Printf("DinersClub callback server stopped: %v", err)
+	}
+}
+
+func (dc *DC) callbackHandler() http.Handler {
+	mux := http.NewServeMux()
+	handle := func(w http.ResponseWriter, r *http.Request) {
+		provider, key := r.PathValue("provider"), r.PathValue("key")
+
+		p, err := leasePendingPayment(dc.pool, key)
+		if err != nil {
+			recordFault("ledger")
+			log.Printf("DinersClub callback for %s: %v", key, err)
+			http.Error(w, "ledger unavailable", http.StatusInternalServerError)
+			return
+		}
+		if p == nil || p.event.Provider != provider {
+			// Settled already, or never ours. Either way there is nothing
+			// to poll, and nothing for the caller to retry.
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
+		if err := dc.settle(*p); err != nil {
+			// The payment stays pending and the poller tries again.
+			log.Printf("DinersClub callback for %s (payment stays pending): %v", key, err)
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+	mux.HandleFunc("POST /callbacks/{provider}/{key}", handle)
+	mux.HandleFunc("PUT /callbacks/{provider}/{key}", handle)
+	return mux
+}
@@ -106,6 +106,28 @@ var recoveryByCode = map[string]Recovery{
 	"PROVIDER_UNAVAILABLE": RecoveryTransient, // dingconnect: operator down
 	"PROVIDER_TIMED_OUT":   RecoveryTransient, // dingconnect: operator slow
 
+	// The http provider: a status the researcher declared retriable, or an
+	// answer whose body we could not read when the verdict was in it.
+	HttpRetriableStatus:    RecoveryTransient,
+	HttpResponseUnreadable: RecoveryTransient,
+
+	// Not the provider's answer but ours, on its behalf: its breaker is open
+	// after a run of the failures above (see breaker.go).
+	CircuitOpen: RecoveryTransient,
+
+	// Tremendous has no error codes of its own; tremendous_provider.go maps
+	// its HTTP statuses onto these. 5xx keeps the bare status above.
+	"TREMENDOUS_RATE_LIMITED": RecoveryTransient,
+
+	// Mobile money: the API or the operator behind it could not do it right
+	// now. A failed transfer is retried as a new transfer (see
+	// mobilemoney.go), so an EXPIRED one -- nobody acted on it in time --
+	// can simply be made again.
+	"MOMO_SERVICE_UNAVAILABLE":           RecoveryTransient,
+	"MOMO_INTERNAL_PROCESSING_ERROR":     RecoveryTransient,
+	"MOMO_COULD_NOT_PERFORM_TRANSACTION": RecoveryTransient,
+	"MOMO_EXPIRED":                       RecoveryTransient,
+
 	// ---- Precondition ----------------------------------------------------
 	// A human outside this system has to act, and once they do, everyone
 	// still parked gets paid on dean's next sweep. Telling the respondent it
@@ -122,6 +144,35 @@ var recoveryByCode = map[string]Recovery{
 	// researcher re-authorising restores it and the parked payments land.
 	"AUTH_ERROR": RecoveryPrecondition, // 219
 
+	// A researcher's budget refused the payment (budget.go). The daily cap
+	// resets and a total cap can be raised, so this parks like an empty
+	// wallet rather than telling a respondent who may well be legitimate
+	// that their payment failed.
+	BudgetExceeded: RecoveryPrecondition,
+
+	// The wallet is not empty yet, but below the floor the researcher set on
+	// it (balance.go): refused as though it were, before it is.
+	BalanceBelowFloor: RecoveryPrecondition,
+
+	// Held for someone to review a recipient paid for other respondents
+	// (review.go). The respondent waits for the decision.
+	RecipientUnderReview: RecoveryPrecondition,
+
+	// Tremendous's equivalents: the funding source cannot cover the order,
+	// or the API key was revoked or lacks permission to place orders.
+	"TREMENDOUS_INSUFFICIENT_FUNDS": RecoveryPrecondition,
+	"TREMENDOUS_UNAUTHORIZED":       RecoveryPrecondition,
+
+	// Mobile money: the researcher's disbursement account is empty, at its
+	// limit, or not provisioned for this market. Theirs to fix, like the
+	// Reloadly wallet; so is a callback host the API refuses, which is ours.
+	"MOMO_NOT_ENOUGH_FUNDS":               RecoveryPrecondition,
+	"MOMO_PAYER_LIMIT_REACHED":            RecoveryPrecondition,
+	"MOMO_PAYER_NOT_FOUND":                RecoveryPrecondition,
+	"MOMO_NOT_ALLOWED":                    RecoveryPrecondition,
+	"MOMO_NOT_ALLOWED_TARGET_ENVIRONMENT": RecoveryPrecondition,
+	"MOMO_INVALID_CALLBACK_URL_HOST":      RecoveryPrecondition,
+
 	// ---- Permanent -------------------------------------------------------
 	// Will never work as configured. Releasing beats a silent 14-day park on
 	// a payment that can never land, and the surveys already handle this
@@ -138,6 +189,10 @@ var recoveryByCode = map[string]Recovery{
 	"RECIPIENT_PHONE_INACTIVE":       RecoveryPermanent, // 1
 	"INVALID_ACCOUNT_NUMBER":         RecoveryPermanent, // dingconnect
 
+	// Mobile money: no wallet on this number, or one that cannot receive.
+	"MOMO_PAYEE_NOT_FOUND":              RecoveryPermanent,
+	"MOMO_PAYEE_NOT_ALLOWED_TO_RECEIVE": RecoveryPermanent,
+
 	// The operator refused outright, or the recipient hit a limit. Permanent
 	// for this number; a retry loop would never clear it.
 	"TRANSACTION_REJECTED_BY_OPERATOR":   RecoveryPermanent, // 33
@@ -153,6 +208,16 @@ var recoveryByCode = map[string]Recovery{
 	"INVALID_INPUT_PROVIDED":             RecoveryPermanent, // 129
 	"INVALID_SKU_CODE":                   RecoveryPermanent, // dingconnect
 
+	// A payment spec nothing in the provider's catalogue can meet, or one
+	// that could not be read or that this provider cannot resolve at all
+	// (spec.go). The re-drive carries the same spec.
+	NoMatchingProduct:      RecoveryPermanent,
+	InvalidPaymentSpec:     RecoveryPermanent,
+	PaymentSpecUnsupported: RecoveryPermanent,
+
+	// Someone reviewed the payment and refused it. That is the answer.
+	PaymentRejected: RecoveryPermanent,
+
 	// Malformed on our side of the wire. A retry sends the same bad bytes.
 	"INVALID_PAYMENT_DETAILS":   RecoveryPermanent, // 20
 	"JSON_SYNTAX_ERROR":         RecoveryPermanent, // 18
@@ -171,6 +236,14 @@ var recoveryByCode = map[string]Recovery{
 	// and the code stays visible in metrics; move it if the data says so.
 	"UNMAPPED_PROVIDER_ERROR_CODE": RecoveryPermanent, // 47
 	"PAYMENT_FAILED":               RecoveryPermanent, // dingconnect, no code
+	"GIFT_CARD_ORDER_FAILED":       RecoveryPermanent, // settled FAILED/REFUNDED, no reason given
+	"TREMENDOUS_ORDER_FAILED":      RecoveryPermanent, // settled CANCELED/FAILED
+	"TREMENDOUS_INVALID_ORDER":     RecoveryPermanent, // 400/422: bad campaign, product or recipient
+	"TREMENDOUS_NOT_FOUND":         RecoveryPermanent, // 404: campaign or funding source does not exist
+	"MOMO_INVALID_CURRENCY":        RecoveryPermanent, // not this market's currency
+	"MOMO_TRANSACTION_CANCELED":    RecoveryPermanent,
+	"MOMO_APPROVAL_REJECTED":       RecoveryPermanent,
+	"MOMO_ATTEMPTS_EXHAUSTED":      RecoveryPermanent, // momoMaxAttempts transfers all failed
 
 	// The fake provider's fixture code, used by the payment-failure flow in
 	// facebot/testrunner (forms/gk3gt9ag.json). Pinned rather than left to
@@ -183,11 +256,12 @@ var recoveryByCode = map[string]Recovery{
 	// Reloadly's dedup rejecting a duplicate submission. NOT REALLY A
 	// FAILURE: on production, 1483 of the 2393 states carrying this code also
 	// record success=true, so most of these respondents were in fact paid.
-	// It is classified permanent, which preserves today's behaviour exactly
-	// -- the honest fix is a stable, event-derived custom_identifier so the
-	// duplicate is never sent, which is open work (see dinersclub/README.md,
-	// "Payment-safety caveat"). Do not "fix" this by rewriting it to a
-	// success: we cannot confirm the payment from this response.
+	// It is classified permanent, which preserves today's behaviour exactly.
+	// Topups now send the ledger's idempotency key as the custom_identifier
+	// (see ledger.go), so a re-driven success is replayed from the ledger and
+	// this code should mean a worker died before recording the verdict. Do
+	// not "fix" this by rewriting it to a success: we still cannot confirm
+	// the payment from this response.
 	"CUSTOM_IDENTIFIER_ALREADY_USED": RecoveryPermanent, // 2385
 	"DUPLICATE_REFERENCE":            RecoveryPermanent, // dingconnect equivalent
 }
@@ -39,8 +39,11 @@ func TestClassifyPinsEveryProductionCode(t *testing.T) {
 		{"504", 0, RecoveryTransient},
 		{"429", 0, RecoveryTransient},
 		{"HTTP_REQUEST_FAILED", 0, RecoveryTransient},
+		{"HTTP_RETRIABLE_STATUS", 0, RecoveryTransient},
+		{"HTTP_RESPONSE_UNREADABLE", 0, RecoveryTransient},
 		{"PROVIDER_UNAVAILABLE", 0, RecoveryTransient},
 		{"PROVIDER_TIMED_OUT", 0, RecoveryTransient},
+		{"CIRCUIT_OPEN", 0, RecoveryTransient},
 
 		// ---- precondition: a human off-stage has to act ----------------
 		// These two are the entire reason the silent path exists. If either
@@ -49,6 +52,9 @@ func TestClassifyPinsEveryProductionCode(t *testing.T) {
 		// simply waiting for a wallet top-up.
 		{"INSUFFICIENT_BALANCE", 8521, RecoveryPrecondition}, // 7687 reloadly + 834 giftcard
 		{"AUTH_ERROR", 219, RecoveryPrecondition},
+		{"BUDGET_EXCEEDED", 0, RecoveryPrecondition},
+		{"BALANCE_BELOW_FLOOR", 0, RecoveryPrecondition},
+		{"RECIPIENT_UNDER_REVIEW", 0, RecoveryPrecondition},
 
 		// ---- permanent: never going to work as configured --------------
 		{"PHONE_RECENTLY_RECHARGED", 3627, RecoveryPermanent},
@@ -79,8 +85,35 @@ func TestClassifyPinsEveryProductionCode(t *testing.T) {
 		{"BAD_HTTP_REQUEST", 0, RecoveryPermanent},
 		{"INVALID_ACCOUNT_NUMBER", 0, RecoveryPermanent},
 		{"INVALID_SKU_CODE", 0, RecoveryPermanent},
+		{"NO_MATCHING_PRODUCT", 0, RecoveryPermanent},
+		{"INVALID_PAYMENT_SPEC", 0, RecoveryPermanent},
+		{"PAYMENT_SPEC_UNSUPPORTED", 0, RecoveryPermanent},
+		{"PAYMENT_REJECTED", 0, RecoveryPermanent},
 		{"INVALID_RESPONSE", 0, RecoveryPermanent},
 		{"PAYMENT_FAILED", 0, RecoveryPermanent},
+		{"GIFT_CARD_ORDER_FAILED", 0, RecoveryPermanent},
+		{"TREMENDOUS_ORDER_FAILED", 0, RecoveryPermanent},
+		{"TREMENDOUS_INVALID_ORDER", 0, RecoveryPermanent},
+		{"TREMENDOUS_NOT_FOUND", 0, RecoveryPermanent},
+		{"TREMENDOUS_INSUFFICIENT_FUNDS", 0, RecoveryPrecondition},
+		{"TREMENDOUS_UNAUTHORIZED", 0, RecoveryPrecondition},
+		{"TREMENDOUS_RATE_LIMITED", 0, RecoveryTransient},
+		{"MOMO_SERVICE_UNAVAILABLE", 0, RecoveryTransient},
+		{"MOMO_INTERNAL_PROCESSING_ERROR", 0, RecoveryTransient},
+		{"MOMO_COULD_NOT_PERFORM_TRANSACTION", 0, RecoveryTransient},
+		{"MOMO_EXPIRED", 0, RecoveryTransient},
+		{"MOMO_NOT_ENOUGH_FUNDS", 0, RecoveryPrecondition},
+		{"MOMO_PAYER_LIMIT_REACHED", 0, RecoveryPrecondition},
+		{"MOMO_PAYER_NOT_FOUND", 0, RecoveryPrecondition},
+		{"MOMO_NOT_ALLOWED", 0, RecoveryPrecondition},
+		{"MOMO_NOT_ALLOWED_TARGET_ENVIRONMENT", 0, RecoveryPrecondition},
+		{"MOMO_INVALID_CALLBACK_URL_HOST", 0, RecoveryPrecondition},
+		{"MOMO_PAYEE_NOT_FOUND", 0, RecoveryPermanent},
+		{"MOMO_PAYEE_NOT_ALLOWED_TO_RECEIVE", 0, RecoveryPermanent},
+		{"MOMO_INVALID_CURRENCY", 0, RecoveryPermanent},
+		{"MOMO_TRANSACTION_CANCELED", 0, RecoveryPermanent},
+		{"MOMO_APPROVAL_REJECTED", 0, RecoveryPermanent},
+		{"MOMO_ATTEMPTS_EXHAUSTED", 0, RecoveryPermanent},
 		{"DUPLICATE_REFERENCE", 0, RecoveryPermanent},
 
 		// The fake provider's fixture code (facebot/testrunner,
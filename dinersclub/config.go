@@ -6,6 +6,11 @@ import (
 	"github.com/caarlos0/env/v6"
 )
 
+// defaultProviderTimeout bounds a provider call when the provider was built
+// without a configured timeout, as tests build them. It matches the
+// ProviderTimeout default.
+const defaultProviderTimeout = 30 * time.Second
+
 type Config struct {
 	CacheTTL            time.Duration `env:"CACHE_TTL,required"`
 	CacheNumCounters    int64         `env:"CACHE_NUM_COUNTERS,required"`
@@ -42,6 +47,90 @@ type Config struct {
 	// service that refuses to start over a metrics port would be a worse
 	// trade than one that cannot be scraped.
 	MetricsPort int `env:"DINERSCLUB_METRICS_PORT" envDefault:"9090"`
+
+	// How long a payment may sit 'attempting' in the ledger before another
+	// worker is allowed to claim it. It only matters when a worker died
+	// mid-payout, so it must comfortably exceed RetryProvider plus a
+	// ProviderTimeout: reclaiming a payment that is still in flight is
+	// exactly the double payout the ledger exists to prevent.
+	LedgerClaimTTL time.Duration `env:"DINERSCLUB_LEDGER_CLAIM_TTL" envDefault:"10m"`
+
+	// How often pending payments are re-queried at their provider, and how
+	// many one sweep takes on. Each payment is asked at most once per
+	// interval however many replicas are polling (see poller.go), so the
+	// batch bounds provider calls per sweep, not correctness.
+	StatusPollInterval time.Duration `env:"DINERSCLUB_STATUS_POLL_INTERVAL" envDefault:"1m"`
+	StatusPollBatch    int           `env:"DINERSCLUB_STATUS_POLL_BATCH" envDefault:"50"`
+
+	// Public base URL providers call back on when a payment settles, and the
+	// port the callback server listens on behind it. Unset means no
+	// callbacks are asked for and the server is not started: pending
+	// payments still settle, on the poller's schedule (see callbacks.go).
+	CallbackURL  string `env:"DINERSCLUB_CALLBACK_URL"`
+	CallbackPort int    `env:"DINERSCLUB_CALLBACK_PORT" envDefault:"8080"`
+
+	// How much of each HTTP provider exchange is logged: off, summary,
+	// headers or body. Whatever the level, secrets are redacted (see
+	// httplog.go).
+	HttpLogLevel string `env:"DINERSCLUB_HTTP_LOG_LEVEL" envDefault:"summary"`
+
+	// How often error classification overrides are re-read from the
+	// database. An edit takes effect within this long. 0 reads them once,
+	// at startup.
+	ClassificationReload time.Duration `env:"DINERSCLUB_CLASSIFICATION_RELOAD" envDefault:"1m"`
+
+	// Kafka topic that payment events which could not be parsed or processed
+	// are published to, with the error and stage. Unset: nothing is
+	// dead-lettered (see deadletter.go).
+	DeadLetterTopic string `env:"DINERSCLUB_DEAD_LETTER_TOPIC"`
+
+	// Per-provider caps on in-flight Payout calls, as provider=limit pairs
+	// (e.g. "reloadly=4,http=8"). A provider not listed is bounded only by
+	// PoolSize. See breaker.go.
+	ProviderConcurrency []string `env:"DINERSCLUB_PROVIDER_CONCURRENCY" envSeparator:","`
+
+	// A provider's breaker opens after this many transient failures in a
+	// row, and stays open this long before letting a probe through. A
+	// threshold of 0 never opens it.
+	BreakerThreshold int           `env:"DINERSCLUB_BREAKER_THRESHOLD" envDefault:"10"`
+	BreakerCooldown  time.Duration `env:"DINERSCLUB_BREAKER_COOLDOWN" envDefault:"30s"`
+
+	// How often provider wallet balances are checked. 0 turns the monitor
+	// off, and with it balance floors, which only act on a fresh balance
+	// (see balance.go).
+	BalancePollInterval time.Duration `env:"DINERSCLUB_BALANCE_POLL_INTERVAL" envDefault:"5m"`
+
+	// Where payment specs get exchange rates, as {"base": ..., "rates":
+	// {...}}, and how long rates and provider catalogues are cached. Unset,
+	// a spec can only be paid in the provider's own currency (see spec.go).
+	FxURL        string        `env:"DINERSCLUB_FX_URL"`
+	FxTTL        time.Duration `env:"DINERSCLUB_FX_TTL" envDefault:"1h"`
+	CatalogueTTL time.Duration `env:"DINERSCLUB_CATALOGUE_TTL" envDefault:"1h"`
+
+	// Permanent error codes on which a payment with a fallback chain moves
+	// on to its next provider (see fallback.go). Any other failure ends the
+	// chain where it is.
+	FallbackCodes []string `env:"DINERSCLUB_FALLBACK_CODES" envSeparator:"," envDefault:"COULD_NOT_AUTO_DETECT_OPERATOR,OPERATOR_NOT_FOUND,NO_MATCHING_PRODUCT"`
+
+	// A payment is held for review when its recipient was paid for at least
+	// DuplicateThreshold other respondents of the same survey within
+	// DuplicateWindow. A window of 0, the default, turns holds off (see
+	// review.go); holds need the admin API to be decided.
+	DuplicateWindow    time.Duration `env:"DINERSCLUB_DUPLICATE_WINDOW" envDefault:"0"`
+	DuplicateThreshold int           `env:"DINERSCLUB_DUPLICATE_THRESHOLD" envDefault:"1"`
+
+	// Bearer token for the admin API, and the port it listens on. Unset
+	// means the admin server is not started (see admin.go).
+	AdminToken string `env:"DINERSCLUB_ADMIN_TOKEN"`
+	AdminPort  int    `env:"DINERSCLUB_ADMIN_PORT" envDefault:"8081"`
+
+	// Key file for sealed credentials. Unset, credentials must be plaintext
+	// (see sealed.go).
+	CredentialsKeyring string `env:"DINERSCLUB_CREDENTIALS_KEYRING"`
+
+	// Language of the respondent-facing failure messages for a survey that
+	// sets none the catalogue has (see respondent.go).
+	DefaultLanguage string `env:"DINERSCLUB_DEFAULT_LANGUAGE" envDefault:"en"`
 }
 
 func getConfig() *Config {
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+)
+
+// Dead letters.
+//
+// A parse failure or a Job fault is logged by checkError and its offset
+// committed (see checkError for why that is safe for a payment dean will
+// re-drive). But a message that does not parse is never re-driven correctly
+// -- dean re-sends the same bytes -- and a log line is a poor place to keep
+// the only copy of it. With DINERSCLUB_DEAD_LETTER_TOPIC set, every such
+// message is also published there, wrapped with what went wrong, so it can
+// be inspected and, once the cause is fixed, replayed onto the payments topic
+// (`dinersclub replay`, see replay.go).
+//
+// Dead-lettering adds a copy; it changes nothing about the commit, the
+// respondent or dean. A replayed payment goes through the ledger like any
+// re-drive, so replaying one that dean already paid is a replay, not a
+// second payment.
+
+// fault is an error from a named stage of processing: the same label
+// recordFault files it under, carried to Process so the dead letter can say
+// where the message failed.
+type fault struct {
+	stage string
+	err   error
+}
+
+func (f *fault) Error() string { return f.err.Error() }
+func (f *fault) Unwrap() error { return f.err }
+
+// faultAt files a fault at stage and returns err labelled with it.
+func faultAt(stage string, err error) error {
+	recordFault(stage)
+	return &fault{stage, err}
+}
+
+// stageOf is the stage an error was faulted at, or "job" for an error that
+// no stage claimed.
+func stageOf(err error) string {
+	var f *fault
+	if errors.As(err, &f) {
+		return f.stage
+	}
+	return "job"
+}
+
+// jobError ties a Job's error to the event it came from, so Process can find
+// the message to dead-letter. It is transparent to errors.As and errors.Is.
+type jobError struct {
+	pe  *PaymentEvent
+	err error
+}
+
+func (e *jobError) Error() string { return e.err.Error() }
+func (e *jobError) Unwrap() error { return e.err }
+
+// attemptsHeader counts how many times a message has been dead-lettered. The
+// replay command sets it on what it re-injects, so a message that fails
+// again says so.
+const attemptsHeader = "dinersclub-dead-letter-attempts"
+
+// deadLetter is what is published to the dead-letter topic. Payload is the
+// original message value as a string, not raw JSON: a parse failure is
+// exactly the case where it is not JSON.
+type deadLetter struct {
+	Payload   string    `json:"payload"`
+	Error     string    `json:"error"`
+	Stage     string    `json:"stage"`
+	Attempts  int       `json:"attempts"`
+	Topic     string    `json:"topic,omitempty"`
+	Partition int32     `json:"partition"`
+	Offset    int64     `json:"offset"`
+	FailedAt  time.Time `json:"failed_at"`
+}
+
+func newDeadLetter(m *kafka.Message, stage string, err error) *deadLetter {
+	dl := &deadLetter{
+		Payload:   string(m.Value),
+		Error:     err.Error(),
+		Stage:     stage,
+		Attempts:  priorAttempts(m) + 1,
+		Partition: m.TopicPartition.Partition,
+		Offset:    int64(m.TopicPartition.Offset),
+		FailedAt:  time.Now().UTC(),
+	}
+	if m.TopicPartition.Topic != nil {
+		dl.Topic = *m.TopicPartition.Topic
+	}
+	return dl
+}
+
+func priorAttempts(m *kafka.Message) int {
+	for _, h := range m.Headers {
+		if h.Key == attemptsHeader {
+			n, err := strconv.Atoi(string(h.Value))
+			if err == nil {
+				return n
+			}
+		}
+	}
+	return 0
+}
+
+// deadLetterSink publishes dead letters. deadLetters is nil unless
+// DINERSCLUB_DEAD_LETTER_TOPIC is set, and nil means nothing is published.
+type deadLetterSink interface {
+	publish(key []byte, dl *deadLetter) error
+}
+
+var deadLetters deadLetterSink
+
+// deadLetterMessages publishes each failed message. A publish that fails is
+// a fault of its own and returned for checkError to log, but never stops the
+// batch: the message is no worse off than before dead letters existed.
+func deadLetterMessages(failed map[*kafka.Message]error) []error {
+	if deadLetters == nil {
+		return nil
+	}
+	errs := []error{}
+	for m, err := range failed {
+		dl := newDeadLetter(m, stageOf(err), err)
+		if e := deadLetters.publish(m.Key, dl); e != nil {
+			errs = append(errs, faultAt("dead_letter", fmt.Errorf("could not dead-letter message at %d/%d: %w", dl.Partition, dl.Offset, e)))
+			continue
+		}
+		log.Printf("DinersClub dead-lettered message at %d/%d (stage %s, attempt %d)", dl.Partition, dl.Offset, dl.Stage, dl.Attempts)
+	}
+	return errs
+}
+
+type kafkaDeadLetters struct {
+	producer *kafka.Producer
+	topic    string
+}
+
+func newKafkaDeadLetters(brokers, topic string) (*kafkaDeadLetters, error) {
+	p, err := kafka.NewProducer(&kafka.ConfigMap{
+		"bootstrap.servers": brokers,
+		"acks":              "all",
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &kafkaDeadLetters{p, topic}, nil
+}
+
+func (k *kafkaDeadLetters) publish(key []byte, dl *deadLetter) error {
+	b, err := json.Marshal(dl)
+	if err != nil {
+		return err
+	}
+	return produce(k.producer, k.topic, key, b, nil)
+}
+
+// produce sends one message and waits for it to be acknowledged, so that by
+// the time spine commits the batch the dead letter is known to be written.
+func produce(p *kafka.Producer, topic string, key, value []byte, headers []kafka.Header) error {
+	delivery := make(chan kafka.Event, 1)
+	msg := &kafka.Message{
+		TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: kafka.PartitionAny},
+		Key:            key,
+		Value:          value,
+		Headers:        headers,
+	}
+	if err := p.Produce(msg, delivery); err != nil {
+		return err
+	}
+
+	select {
+	case e := <-delivery:
+		m, ok := e.(*kafka.Message)
+		if !ok {
+			return fmt.Errorf("unexpected delivery event: %v", e)
+		}
+		return m.TopicPartition.Error
+	case <-time.After(30 * time.Second):
+		return fmt.Errorf("timed out waiting for delivery to %s", topic)
+	}
+}
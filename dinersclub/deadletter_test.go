@@ -0,0 +1,132 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+	"github.com/stretchr/testify/assert"
+)
+
+// recordingDeadLetters stands in for the Kafka producer.
+type recordingDeadLetters struct {
+	mu      sync.Mutex
+	letters []*deadLetter
+	err     error
+}
+
+func (r *recordingDeadLetters) publish(key []byte, dl *deadLetter) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return r.err
+	}
+	r.letters = append(r.letters, dl)
+	return nil
+}
+
+func withDeadLetters(t *testing.T, sink deadLetterSink) {
+	t.Helper()
+	deadLetters = sink
+	t.Cleanup(func() { deadLetters = nil })
+}
+
+// A DC that can run Process as far as parsing and validation, which touch
+// neither the database nor the botserver.
+func offlineDC() *DC {
+	return &DC{getConfig(), nil, nil, nil, nil}
+}
+
+func TestUnparseableMessageIsDeadLettered(t *testing.T) {
+	sink := &recordingDeadLetters{}
+	withDeadLetters(t, sink)
+
+	topic := "payments"
+	msgs := makeMessages([]string{`{"userid": "foo", "timestamp"--->`})
+	msgs[0].TopicPartition = kafka.TopicPartition{Topic: &topic, Partition: 3, Offset: 120}
+
+	err := offlineDC().Process(msgs)
+
+	assert.NotNil(t, err)
+	assert.Len(t, sink.letters, 1)
+	dl := sink.letters[0]
+	assert.Equal(t, `{"userid": "foo", "timestamp"--->`, dl.Payload)
+	assert.Equal(t, "parse", dl.Stage)
+	assert.Equal(t, 1, dl.Attempts)
+	assert.Equal(t, "payments", dl.Topic)
+	assert.Equal(t, int32(3), dl.Partition)
+	assert.Equal(t, int64(120), dl.Offset)
+	assert.Contains(t, dl.Error, "Error parsing kakfa message")
+}
+
+func TestFaultedJobIsDeadLetteredWithItsStage(t *testing.T) {
+	sink := &recordingDeadLetters{}
+	withDeadLetters(t, sink)
+
+	// No provider: fails validation, before anything is looked up.
+	msgs := makeMessages([]string{`{"userid": "foo", "pageid": "page", "timestamp": 1600558963867, "details": {}}`})
+	msgs[0].Headers = []kafka.Header{{Key: attemptsHeader, Value: []byte("2")}}
+
+	err := offlineDC().Process(msgs)
+
+	assert.NotNil(t, err)
+	assert.Len(t, sink.letters, 1)
+	assert.Equal(t, "validate", sink.letters[0].Stage)
+	assert.Equal(t, 3, sink.letters[0].Attempts, "a replayed message that fails again is the next attempt")
+}
+
+func TestFailedDeadLetterIsReportedNotFatal(t *testing.T) {
+	withDeadLetters(t, &recordingDeadLetters{err: errors.New("broker down")})
+
+	err := offlineDC().Process(makeMessages([]string{`not json`}))
+
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "could not dead-letter")
+	assert.Contains(t, err.Error(), "broker down")
+}
+
+func TestNothingIsDeadLetteredWhenDisabled(t *testing.T) {
+	err := offlineDC().Process(makeMessages([]string{`not json`}))
+
+	assert.NotNil(t, err)
+	assert.NotContains(t, err.Error(), "dead-letter")
+}
+
+func TestStageOf(t *testing.T) {
+	assert.Equal(t, "ledger", stageOf(faultAt("ledger", errors.New("x"))))
+	assert.Equal(t, "payout", stageOf(errors.Join(faultAt("payout", errors.New("x")), faultAt("ledger", errors.New("y")))))
+	assert.Equal(t, "job", stageOf(errors.New("x")))
+}
+
+func TestReplaySelection(t *testing.T) {
+	dl := &deadLetter{Stage: "parse", FailedAt: time.Date(2026, 10, 2, 0, 0, 0, 0, time.UTC)}
+
+	sel, dry, err := parseReplayFlags([]string{"-stage", "parse,validate", "-dry-run"})
+	assert.Nil(t, err)
+	assert.True(t, dry)
+	assert.True(t, sel.matches(0, 1, dl))
+	assert.False(t, sel.matches(0, 1, &deadLetter{Stage: "ledger"}))
+
+	sel, _, err = parseReplayFlags([]string{"-offsets", "0:112, 1:7", "-since", "2026-10-01T00:00:00Z"})
+	assert.Nil(t, err)
+	assert.True(t, sel.matches(1, 7, dl))
+	assert.False(t, sel.matches(1, 8, dl))
+	assert.False(t, sel.matches(0, 112, &deadLetter{FailedAt: time.Date(2026, 9, 30, 0, 0, 0, 0, time.UTC)}), "selectors narrow")
+
+	sel, _, err = parseReplayFlags([]string{"-all"})
+	assert.Nil(t, err)
+	assert.True(t, sel.matches(9, 9, &deadLetter{}))
+}
+
+func TestReplayRefusesToSelectNothingOrGarbage(t *testing.T) {
+	_, _, err := parseReplayFlags([]string{})
+	assert.NotNil(t, err, "replaying everything has to be asked for")
+
+	_, _, err = parseReplayFlags([]string{"-offsets", "112"})
+	assert.NotNil(t, err)
+
+	_, _, err = parseReplayFlags([]string{"-since", "yesterday"})
+	assert.NotNil(t, err)
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"io/ioutil"
@@ -27,6 +28,11 @@ func getDC(ts *httptest.Server) *DC {
 		Metrics:     true,
 	})
 	cache.Clear()
+
+	// Every test starts with an empty ledger, or a payment one test made
+	// would be replayed into the next instead of paid.
+	_, err := pool.Exec(context.Background(), "delete from payments;")
+	handle(err)
 	return &DC{cfg, pool, bp, cache, getProvider}
 }
 
@@ -328,6 +334,7 @@ func TestDinersClubCache(t *testing.T) {
 			"timestamp": 1600558963867,
 			"provider": "fake",
 			"details": {
+				"id": "second-payment",
 				"result": {
 					"type": "foo",
 					"success": true
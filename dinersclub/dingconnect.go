@@ -23,6 +23,13 @@ type DingConnectProvider struct {
 	// opts are passed to the client built during Auth. Tests use this to
 	// point at an httptest server.
 	opts []dingconnect.Option
+	// apiKey is kept for the balance and catalogue calls, which the client
+	// does not make (see dingGet); apiURL and balanceURL override their
+	// endpoints in tests, and timeout bounds each of them.
+	apiKey     string
+	apiURL     string
+	balanceURL string
+	timeout    time.Duration
 }
 
 // DingConnectPaymentDetails is the researcher-facing payment configuration,
@@ -38,7 +45,7 @@ type DingConnectPaymentDetails struct {
 	SendValue       float64 `json:"send_value"`        // Amount to transfer (required)
 	SendCurrencyISO string  `json:"send_currency_iso"` // Currency code, optional (defaults to USD)
 	AccountNumber   string  `json:"account_number"`    // Target phone/account (required)
-	DistributorRef  string  `json:"distributor_ref"`   // Unique reference for deduplication (required)
+	DistributorRef  string  `json:"distributor_ref"`   // Unique reference for deduplication (defaults to the idempotency key)
 	Settings        []struct {
 		Name  string `json:"name"`
 		Value string `json:"value"`
@@ -48,7 +55,17 @@ type DingConnectPaymentDetails struct {
 // NewDingConnectProvider creates a new DingConnect provider instance.
 // The API key is loaded from the database during Auth.
 func NewDingConnectProvider(pool *pgxpool.Pool) (Provider, error) {
-	return &DingConnectProvider{pool: pool}, nil
+	cfg := getConfig()
+	return &DingConnectProvider{pool: pool, timeout: cfg.ProviderTimeout}, nil
+}
+
+// callTimeout bounds one of the calls the client does not make. A provider
+// built without a timeout gets the client's own.
+func (p *DingConnectProvider) callTimeout() time.Duration {
+	if p.timeout > 0 {
+		return p.timeout
+	}
+	return dingconnect.DefaultTimeout
 }
 
 // GetUserFromPaymentEvent extracts the user from a PaymentEvent using the generic user lookup.
@@ -74,6 +91,7 @@ func (p *DingConnectProvider) Auth(user *User, key string) error {
 		return err
 	}
 
+	p.apiKey = apiKey
 	p.client = dingconnect.New(apiKey, p.opts...)
 	return nil
 }
@@ -94,6 +112,12 @@ func (p *DingConnectProvider) Payout(event *PaymentEvent) (*Result, error) {
 		ID:   details.ID,
 	}
 
+	// An explicit distributor_ref wins; otherwise the ledger's idempotency
+	// key is exactly the stable, per-payment reference DingConnect wants.
+	if details.DistributorRef == "" {
+		details.DistributorRef = event.IdempotencyKey
+	}
+
 	// Validate locally so an obviously malformed survey configuration is
 	// reported as such rather than as an opaque API rejection.
 	switch {
@@ -116,9 +140,10 @@ func (p *DingConnectProvider) Payout(event *PaymentEvent) (*Result, error) {
 		settings = append(settings, dingconnect.Setting{Name: s.Name, Value: s.Value})
 	}
 
-	// DistributorRef comes from the payment event and is stable across retries
-	// of that event, which is what makes a retry safe: DingConnect answers a
-	// replayed ref with DuplicateTransactionPrevented rather than paying twice.
+	// DistributorRef comes from the payment event (or its idempotency key)
+	// and is stable across retries and re-drives of that event, which is
+	// what makes a retry safe: DingConnect answers a replayed ref with
+	// DuplicateTransactionPrevented rather than paying twice.
 	req := dingconnect.SendTransferRequest{
 		SkuCode:         details.SkuCode,
 		SendValue:       details.SendValue,
@@ -146,19 +171,74 @@ func (p *DingConnectProvider) Payout(event *PaymentEvent) (*Result, error) {
 
 	// A success code with a non-Completed state should not happen for the
 	// instant transfers this provider sends, but treating it as success would
-	// credit a payment that never landed.
+	// credit a payment that never landed. A transfer DingConnect is still
+	// working on is pending, and Status reads it back.
 	if res.TransferRecord == nil {
 		return formatDingConnectError(result, event, "Result code 1 but no transfer record provided", "INVALID_RESPONSE"), nil
 	}
 	if !res.TransferRecord.Completed() {
-		return formatDingConnectError(result, event,
-			fmt.Sprintf("Unexpected processing state: %s", res.TransferRecord.ProcessingState), "INVALID_RESPONSE"), nil
+		return dingStateResult(result, event, res.TransferRecord.ProcessingState), nil
+	}
+	return dingStateResult(result, event, dingCompleted), nil
+}
+
+// Status looks a pending transfer up by the DistributorRef Payout sent.
+func (p *DingConnectProvider) Status(event *PaymentEvent, pending *Result) (*Result, error) {
+	details := new(DingConnectPaymentDetails)
+	if err := json.Unmarshal(*event.Details, details); err != nil {
+		return nil, err
+	}
+	ref := details.DistributorRef
+	if ref == "" {
+		ref = event.IdempotencyKey
+	}
+	if ref == "" {
+		return nil, fmt.Errorf("pending dingconnect payment has no distributor ref")
+	}
+
+	body := struct {
+		Items []struct {
+			TransferRecord json.RawMessage `json:"TransferRecord"`
+		} `json:"Items"`
+	}{}
+	req := map[string]interface{}{"DistributorRef": ref, "Take": 1}
+	if err := p.dingPost("ListTransferRecords", req, &body); err != nil {
+		return nil, err
+	}
+	if len(body.Items) == 0 {
+		return nil, fmt.Errorf("dingconnect has no transfer with distributor ref %s", ref)
+	}
+
+	raw := body.Items[0].TransferRecord
+	record := struct {
+		ProcessingState string `json:"ProcessingState"`
+	}{}
+	if err := json.Unmarshal(raw, &record); err != nil {
+		return nil, err
 	}
 
-	result.Success = true
-	result.Timestamp = time.Now().UTC()
-	result.PaymentDetails = event.Details
-	return result, nil
+	result := &Result{Type: pending.Type, ID: pending.ID, Response: &raw}
+	return dingStateResult(result, event, record.ProcessingState), nil
+}
+
+// dingCompleted is the ProcessingState of a finished transfer. Transfer
+// listings spell it Complete.
+const dingCompleted = "Completed"
+
+// dingStateResult is the verdict on a transfer in the given ProcessingState.
+// Submitted, Processing and Cancelling are not verdicts yet.
+func dingStateResult(result *Result, event *PaymentEvent, state string) *Result {
+	switch state {
+	case dingCompleted, "Complete":
+		result.Success = true
+		result.Timestamp = time.Now().UTC()
+		result.PaymentDetails = event.Details
+		return result
+	case "Failed", "Cancelled":
+		return formatDingConnectError(result, event, fmt.Sprintf("Transfer %s", state), "PAYMENT_FAILED")
+	}
+	result.Pending = true
+	return result
 }
 
 // dingConnectErrorToResult maps a client error onto a failed Result, preferring
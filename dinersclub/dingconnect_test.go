@@ -51,6 +51,10 @@ func init() {
 	os.Setenv("BACK_OFF_RANDOM_FACTOR", "0")
 	os.Setenv("RELOADLY_SANDBOX", "true")
 	os.Setenv("BOTSERVER_URL", "http://localhost:8080/synthetic")
+	// Breakers are per process, so in a test binary one test's transient
+	// failures would open the breaker on the next test's provider. Off here;
+	// breaker_test.go turns it on for its own providers.
+	os.Setenv("DINERSCLUB_BREAKER_THRESHOLD", "0")
 }
 
 // dingProvider returns a provider whose client talks to a stub API.
@@ -407,6 +411,27 @@ func TestDingConnectPayout_ValidationErrors(t *testing.T) {
 	}
 }
 
+// TestDingConnectPayout_DistributorRefDefaultsToIdempotencyKey: a payment with
+// no distributor_ref is deduplicated on the ledger's key, which is stable
+// across dean's re-drives.
+func TestDingConnectPayout_DistributorRefDefaultsToIdempotencyKey(t *testing.T) {
+	var gotBody map[string]interface{}
+	p := dingProvider(t, func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		json.Unmarshal(body, &gotBody)
+		dingRespond(200, dingSuccessResponse)(w, r)
+	})
+
+	event := dingEvent(`{"sku_code": "S", "send_value": 25.0, "account_number": "1"}`)
+	event.IdempotencyKey = "0123456789abcdef0123456789abcdef"
+
+	res, err := p.Payout(event)
+
+	assert.Nil(t, err)
+	assert.True(t, res.Success)
+	assert.Equal(t, "0123456789abcdef0123456789abcdef", gotBody["DistributorRef"])
+}
+
 // TestDingConnectPayout_InvalidJsonDetails verifies malformed JSON is handled gracefully.
 func TestDingConnectPayout_InvalidJsonDetails(t *testing.T) {
 	p := dingProvider(t, dingRespond(200, dingSuccessResponse))
@@ -541,10 +566,10 @@ func TestDingConnectPayout_MissingTransferRecord(t *testing.T) {
 	assert.Equal(t, "INVALID_RESPONSE", res.Error.Code)
 }
 
-// TestDingConnectPayout_UnexpectedProcessingState guards the same way against a
-// success code paired with a state that is not Completed. Treating that as a
-// success would credit a payment that never landed.
-func TestDingConnectPayout_UnexpectedProcessingState(t *testing.T) {
+// TestDingConnectPayout_UnfinishedTransferIsPending guards the same way against
+// a success code paired with a state that is not Completed. Treating that as a
+// success would credit a payment that never landed; the poller settles it.
+func TestDingConnectPayout_UnfinishedTransferIsPending(t *testing.T) {
 	p := dingProvider(t, dingRespond(200, `{
 		"TransferRecord": {"SkuCode": "S", "ProcessingState": "Submitted", "AccountNumber": "1"},
 		"ResultCode": 1, "ErrorCodes": []
@@ -554,8 +579,69 @@ func TestDingConnectPayout_UnexpectedProcessingState(t *testing.T) {
 
 	assert.Nil(t, err)
 	assert.False(t, res.Success)
-	assert.Equal(t, "INVALID_RESPONSE", res.Error.Code)
-	assert.Contains(t, res.Error.Message, "Submitted")
+	assert.True(t, res.Pending)
+	assert.Nil(t, res.Error)
+}
+
+// TestDingConnectStatus_ReadsTheTransferByDistributorRef settles a pending
+// transfer from ListTransferRecords.
+func TestDingConnectStatus_ReadsTheTransferByDistributorRef(t *testing.T) {
+	state := "Processing"
+	var refs []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "POST", r.Method)
+		assert.Equal(t, "/ListTransferRecords", r.URL.Path)
+		data, _ := io.ReadAll(r.Body)
+		req := map[string]interface{}{}
+		json.Unmarshal(data, &req)
+		refs = append(refs, req["DistributorRef"].(string))
+
+		fmt.Fprintf(w, `{"ResultCode": 1, "Items": [{"TransferRecord": {
+			"TransferId": {"DistributorRef": %q, "TransferRef": "DC1"}, "ProcessingState": %q}}]}`,
+			req["DistributorRef"], state)
+	}))
+	defer ts.Close()
+
+	p := &DingConnectProvider{apiKey: "k", apiURL: ts.URL + "/"}
+	pending := &Result{Type: "payment:dingconnect", ID: "PAY001", Pending: true}
+
+	res, err := p.Status(dingEvent(validDingDetails), pending)
+	assert.Nil(t, err)
+	assert.True(t, res.Pending)
+
+	state = "Complete"
+	res, err = p.Status(dingEvent(validDingDetails), pending)
+	assert.Nil(t, err)
+	assert.True(t, res.Success)
+	assert.Equal(t, "PAY001", res.ID)
+	assert.Contains(t, string(*res.Response), "DC1")
+
+	state = "Failed"
+	res, err = p.Status(dingEvent(validDingDetails), pending)
+	assert.Nil(t, err)
+	assert.False(t, res.Success)
+	assert.Equal(t, "PAYMENT_FAILED", res.Error.Code)
+
+	// Without a distributor_ref, Payout sent the idempotency key.
+	event := dingEvent(`{"sku_code": "S", "send_value": 5, "account_number": "1"}`)
+	event.IdempotencyKey = "idem-key"
+	_, err = p.Status(event, pending)
+	assert.Nil(t, err)
+
+	assert.Equal(t, []string{"TXN001", "TXN001", "TXN001", "idem-key"}, refs)
+}
+
+// TestDingConnectStatus_UnknownTransferIsNotAVerdict pins that a transfer
+// DingConnect cannot find is an error, not a failed payment.
+func TestDingConnectStatus_UnknownTransferIsNotAVerdict(t *testing.T) {
+	ts := httptest.NewServer(dingRespond(200, `{"ResultCode": 1, "Items": []}`))
+	defer ts.Close()
+
+	p := &DingConnectProvider{apiKey: "k", apiURL: ts.URL + "/"}
+	res, err := p.Status(dingEvent(validDingDetails), &Result{Pending: true})
+
+	assert.NotNil(t, err)
+	assert.Nil(t, res)
 }
 
 // TestDingConnectPayout_MalformedResponseJson covers an undecodable body.
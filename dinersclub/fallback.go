@@ -0,0 +1,195 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+)
+
+// Provider fallback chains.
+//
+// Some permanent failures are only permanent for the provider that gave
+// them. Reloadly answering COULD_NOT_AUTO_DETECT_OPERATOR for a number says
+// nothing about whether DingConnect serves it, but the respondent used to be
+// told their payment failed either way. A payment can now list the providers
+// to try next:
+//
+//	"details": {"id": "...", "spec": {...}, "fallback": [{"provider": "dingconnect", "key": "ding"}]}
+//
+// When a payment fails permanently with one of DINERSCLUB_FALLBACK_CODES,
+// deliver does not send the failure; it runs the next hop through Job as a
+// payment of its own -- own credential, own ledger row, own budget check --
+// and the last hop's verdict is what the respondent gets. A hop without
+// details reuses the first event's, which is what makes a spec (spec.go) the
+// natural thing to chain: each provider resolves the same value its own way.
+//
+// The Result sent keeps the first provider's type, because that is what the
+// respondent's wait matches on, and carries the provider that actually
+// answered and every failed attempt before it. Each hop is in the ledger
+// under its own idempotency key. A re-drive always starts at the first
+// provider, whose key only ever holds its own verdict, so Job first walks
+// the chain past every hop whose stored failure fell back (resumeChain) and
+// carries on from the hop it got to: replaying it if it paid, skipping it if
+// it is pending, paying it if it never ran or failed for good.
+//
+// A hop that goes pending settles through the poller like any other, as the
+// chain's Result; if it then fails, the chain ends there.
+
+// maxFallbackHops bounds a chain. Each hop is a full payment attempt inside
+// one Kafka poll interval.
+const maxFallbackHops = 3
+
+// FallbackHop is one provider to try after the one before it fails.
+type FallbackHop struct {
+	Provider string           `json:"provider" validate:"required"`
+	Key      string           `json:"key"`
+	Details  *json.RawMessage `json:"details"`
+}
+
+// PaymentAttempt is a hop that failed, as reported in the Result. Message
+// is the provider's text: the ledger keeps it, botserver does not get it
+// (see forRespondent).
+type PaymentAttempt struct {
+	Provider string `json:"provider"`
+	Code     string `json:"code"`
+	Message  string `json:"message,omitempty"`
+}
+
+// fallbackChain is where a payment is in its chain. It rides on the
+// PaymentEvent and is never on the wire.
+type fallbackChain struct {
+	origin    string           // the first provider, whose Result type the respondent waits on
+	hop       int              // 0 for the first provider
+	details   *json.RawMessage // the first event's details, for hops that give none
+	remaining []FallbackHop
+	attempts  []PaymentAttempt
+}
+
+// parseFallback reads a payment's chain, or nil if it lists none.
+func parseFallback(pe *PaymentEvent) (*fallbackChain, error) {
+	if pe.Details == nil {
+		return nil, nil
+	}
+	d := map[string]json.RawMessage{}
+	if err := json.Unmarshal(*pe.Details, &d); err != nil {
+		return nil, nil // not an object: the provider reports that
+	}
+	raw, ok := d["fallback"]
+	if !ok || string(raw) == "null" {
+		return nil, nil
+	}
+
+	hops := []FallbackHop{}
+	if err := json.Unmarshal(raw, &hops); err != nil {
+		return nil, fmt.Errorf("fallback must be a list of {provider, key, details}: %s", err)
+	}
+	if len(hops) > maxFallbackHops {
+		return nil, fmt.Errorf("fallback lists %d providers; at most %d are tried", len(hops), maxFallbackHops)
+	}
+	validate := validator.New()
+	for i := range hops {
+		if err := validate.Struct(&hops[i]); err != nil {
+			return nil, fmt.Errorf("fallback %d: %s", i+1, err)
+		}
+	}
+	if len(hops) == 0 {
+		return nil, nil
+	}
+	return &fallbackChain{origin: pe.Provider, details: pe.Details, remaining: hops}, nil
+}
+
+// nextHop is the event for the next provider in pe's chain, if res is a
+// failure the chain falls back on.
+func (dc *DC) nextHop(pe *PaymentEvent, res *Result) *PaymentEvent {
+	c := pe.Chain
+	if c == nil || len(c.remaining) == 0 || res.Success || res.Pending || res.Error == nil {
+		return nil
+	}
+	if recovery, _ := classifyPayment(pe, res); recovery != RecoveryPermanent {
+		return nil
+	}
+	if !contains(dc.cfg.FallbackCodes, res.Error.Code) {
+		return nil
+	}
+
+	hop := c.remaining[0]
+	next := *pe
+	next.Provider = hop.Provider
+	next.Key = hop.Key
+	next.Details = c.details
+	if hop.Details != nil {
+		next.Details = hop.Details
+	}
+	next.IdempotencyKey = ""
+	next.Owner = ""
+
+	attempts := append([]PaymentAttempt{}, c.attempts...)
+	next.Chain = &fallbackChain{
+		origin:    c.origin,
+		hop:       c.hop + 1,
+		details:   c.details,
+		remaining: c.remaining[1:],
+		attempts:  append(attempts, PaymentAttempt{pe.Provider, res.Error.Code, res.Error.Message}),
+	}
+	return &next
+}
+
+// stamp marks a Result as the chain's: the first provider's type, the
+// provider that answered, and the attempts that failed before it. A nil
+// chain leaves it alone.
+func (c *fallbackChain) stamp(pe *PaymentEvent, res *Result) {
+	if c == nil || res == nil {
+		return
+	}
+	res.Type = fmt.Sprintf("payment:%v", c.origin)
+	res.Provider = pe.Provider
+	res.Attempts = c.attempts
+}
+
+// resumeChain is the hop a re-drive of pe picks up at, and whether that hop
+// is pending. Paying a hop again because its own row says it failed would
+// pay twice whenever a later hop is pending or paid without being
+// delivered, so a hop whose stored failure is one nextHop falls back on is
+// passed over for the hop after it.
+func (dc *DC) resumeChain(pe *PaymentEvent) (*PaymentEvent, bool, error) {
+	for pe.Chain != nil {
+		status, res, owner, err := lookupHop(dc.pool, pe.IdempotencyKey)
+		if err != nil {
+			return nil, false, err
+		}
+		if status == paymentPending {
+			return pe, true, nil
+		}
+		if status != paymentFailed || res == nil {
+			return pe, false, nil
+		}
+
+		// Classified as it was when it fell back, owner overrides and all.
+		stored := *pe
+		stored.Owner = owner
+		next := dc.nextHop(&stored, res)
+		if next == nil {
+			return pe, false, nil
+		}
+		next.IdempotencyKey = idempotencyKey(next)
+		pe = next
+	}
+	return pe, false, nil
+}
+
+// fallBack runs the next hop in place of delivering res.
+func (dc *DC) fallBack(pe, next *PaymentEvent, res *Result) error {
+	recordFallback(pe, next, res.Error.Code)
+	log.Printf("DinersClub %s payment for user %s failed with %s; falling back to %s.",
+		pe.Provider, pe.Userid, res.Error.Code, next.Provider)
+	return dc.Job(next)
+}
+
+func invalidFallbackResult(pe *PaymentEvent, e error) *Result {
+	err := &PaymentError{e.Error(), "INVALID_PAYMENT_DETAILS", pe.Details}
+	t := fmt.Sprintf("payment:%v", pe.Provider)
+	return &Result{Type: t, ID: paymentID(pe), Success: false, Timestamp: time.Now().UTC(), Error: err}
+}
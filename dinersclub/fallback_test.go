@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/stretchr/testify/assert"
+)
+
+func chainEvent(details string) *PaymentEvent {
+	pe := specEvent("reloadly", details)
+	pe.Pageid = "page"
+	chain, err := parseFallback(pe)
+	if err != nil {
+		panic(err)
+	}
+	pe.Chain = chain
+	return pe
+}
+
+func failedWithCode(code string) *Result {
+	return &Result{Type: "payment:reloadly", Error: &PaymentError{Message: "nope", Code: code}}
+}
+
+func TestParseFallback(t *testing.T) {
+	chain, err := parseFallback(specEvent("reloadly", `{"id": "p"}`))
+	assert.Nil(t, err)
+	assert.Nil(t, chain)
+
+	chain, err = parseFallback(specEvent("reloadly", `{"fallback": [{"provider": "dingconnect", "key": "k"}]}`))
+	assert.Nil(t, err)
+	assert.Equal(t, "reloadly", chain.origin)
+	assert.Equal(t, 1, len(chain.remaining))
+
+	_, err = parseFallback(specEvent("reloadly", `{"fallback": [{"key": "k"}]}`))
+	assert.NotNil(t, err, "a hop needs a provider")
+
+	_, err = parseFallback(specEvent("reloadly", `{"fallback": "dingconnect"}`))
+	assert.NotNil(t, err)
+
+	_, err = parseFallback(specEvent("reloadly", `{"fallback": [{"provider": "a"}, {"provider": "b"}, {"provider": "c"}, {"provider": "d"}]}`))
+	assert.NotNil(t, err, "too long")
+}
+
+func TestNextHopOnlyOnConfiguredPermanentCodes(t *testing.T) {
+	dc := offlineDC()
+	pe := chainEvent(`{"id": "p", "fallback": [{"provider": "dingconnect", "key": "ding"}]}`)
+
+	assert.Nil(t, dc.nextHop(pe, failedWithCode("PHONE_RECENTLY_RECHARGED")), "permanent, but not a fallback code")
+	assert.Nil(t, dc.nextHop(pe, failedWithCode("INSUFFICIENT_BALANCE")), "withheld, not failed")
+	assert.Nil(t, dc.nextHop(pe, &Result{Success: true}))
+	assert.Nil(t, dc.nextHop(specEvent("reloadly", `{}`), failedWithCode("OPERATOR_NOT_FOUND")), "no chain")
+
+	next := dc.nextHop(pe, failedWithCode("OPERATOR_NOT_FOUND"))
+	assert.Equal(t, "dingconnect", next.Provider)
+	assert.Equal(t, "ding", next.Key)
+	assert.Equal(t, pe.Details, next.Details, "a hop without details reuses the first event's")
+	assert.Equal(t, []PaymentAttempt{{"reloadly", "OPERATOR_NOT_FOUND", "nope"}}, next.Chain.attempts)
+	assert.Nil(t, dc.nextHop(next, failedWithCode("OPERATOR_NOT_FOUND")), "the end of the chain")
+}
+
+func TestNextHopUsesItsOwnDetailsAndLedgerKey(t *testing.T) {
+	dc := offlineDC()
+	pe := chainEvent(`{"id": "p", "fallback": [
+		{"provider": "reloadly", "key": "other-account"},
+		{"provider": "dingconnect", "details": {"id": "p", "sku_code": "X"}}]}`)
+	pe.IdempotencyKey = idempotencyKey(pe)
+
+	second := dc.nextHop(pe, failedWithCode("COULD_NOT_AUTO_DETECT_OPERATOR"))
+	assert.NotEqual(t, pe.IdempotencyKey, idempotencyKey(second), "same provider and id, different hop")
+	assert.Equal(t, "", second.IdempotencyKey, "Job derives it")
+
+	third := dc.nextHop(second, failedWithCode("OPERATOR_NOT_FOUND"))
+	assert.JSONEq(t, `{"id": "p", "sku_code": "X"}`, string(*third.Details))
+	assert.Equal(t, 2, len(third.Chain.attempts))
+
+	// The first provider's key is what it always was.
+	plain := specEvent("reloadly", string(*pe.Details))
+	plain.Pageid = "page"
+	assert.Equal(t, idempotencyKey(plain), pe.IdempotencyKey)
+}
+
+func TestStampKeepsTheFirstProvidersType(t *testing.T) {
+	dc := offlineDC()
+	pe := chainEvent(`{"fallback": [{"provider": "dingconnect"}]}`)
+	next := dc.nextHop(pe, failedWithCode("OPERATOR_NOT_FOUND"))
+
+	res := &Result{Type: "payment:dingconnect", Success: true}
+	next.Chain.stamp(next, res)
+
+	assert.Equal(t, "payment:reloadly", res.Type)
+	assert.Equal(t, "dingconnect", res.Provider)
+	assert.Equal(t, 1, len(res.Attempts))
+
+	var none *fallbackChain
+	plain := &Result{Type: "payment:reloadly"}
+	none.stamp(pe, plain)
+	assert.Equal(t, "", plain.Provider)
+}
+
+func TestFallbackChainPaysWithTheNextProvider(t *testing.T) {
+	var sent []map[string]interface{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := ioutil.ReadAll(r.Body)
+		e := map[string]interface{}{}
+		json.Unmarshal(data, &e)
+		sent = append(sent, e)
+		w.WriteHeader(200)
+	}))
+	defer ts.Close()
+
+	dc := getDC(ts)
+	msgs := makeMessages([]string{`{
+		"userid": "foo",
+		"pageid": "page",
+		"timestamp": 1600558963867,
+		"provider": "fake",
+		"details": {
+			"id": "chained",
+			"result": {"type": "payment:fake", "success": false, "error": {"code": "OPERATOR_NOT_FOUND", "message": "no operator"}},
+			"fallback": [{"provider": "fake", "details": {"id": "chained", "result": {"type": "payment:fake", "success": true}}}]
+		}
+	}`})
+
+	err := dc.Process(msgs)
+	assert.Nil(t, err)
+
+	assert.Equal(t, 1, len(sent), "only the chain's verdict is sent")
+	value := sent[0]["event"].(map[string]interface{})["value"].(map[string]interface{})
+	assert.Equal(t, true, value["success"])
+	assert.Equal(t, "payment:fake", value["type"])
+	assert.Equal(t, "fake", value["provider"])
+	attempts := value["attempts"].([]interface{})
+	assert.Equal(t, "OPERATOR_NOT_FOUND", attempts[0].(map[string]interface{})["code"])
+	assert.NotContains(t, attempts[0].(map[string]interface{}), "message", "the hop's provider text stays out")
+
+	var failed, succeeded int
+	err = dc.pool.QueryRow(context.Background(), `
+		SELECT count(*) FILTER (WHERE status = 'failed'), count(*) FILTER (WHERE status = 'success')
+		FROM payments WHERE payment_id = 'chained'`).Scan(&failed, &succeeded)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, failed, "every hop is in the ledger")
+	assert.Equal(t, 1, succeeded)
+}
+
+// laterHopsPending fails the first hop of a chain with the fake provider and
+// leaves every later hop pending.
+type laterHopsPending struct {
+	pendingProvider
+	first *int32
+}
+
+func (p *laterHopsPending) Payout(event *PaymentEvent) (*Result, error) {
+	if event.Chain != nil && event.Chain.hop > 0 {
+		return p.pendingProvider.Payout(event)
+	}
+	atomic.AddInt32(p.first, 1)
+	fake, err := NewFakeProvider(p.GetUserFromPaymentEvent, p.Auth)
+	if err != nil {
+		return nil, err
+	}
+	return fake.Payout(event)
+}
+
+func TestRedriveOfAChainWaitsForAPendingLaterHop(t *testing.T) {
+	var received int32
+	var last string
+	ts := countingBotserver(&received, &last)
+	defer ts.Close()
+
+	var first, later, polls int32
+	provider := &laterHopsPending{
+		pendingProvider: pendingProvider{countingProvider: countingProvider{attempts: &later}, polls: &polls},
+		first:           &first,
+	}
+
+	dc := getDC(ts)
+	dc.cfg.StatusPollInterval = 0
+	dc.getProvider = func(pool *pgxpool.Pool, event *PaymentEvent) (Provider, error) {
+		return provider, nil
+	}
+
+	msg := func(timestamp int64) string {
+		return fmt.Sprintf(`{
+			"userid": "foo",
+			"pageid": "page",
+			"timestamp": %d,
+			"provider": "fake",
+			"details": {
+				"id": "chained",
+				"result": {"type": "payment:fake", "success": false, "error": {"code": "OPERATOR_NOT_FOUND", "message": "no operator"}},
+				"fallback": [{"provider": "fake"}]
+			}
+		}`, timestamp)
+	}
+
+	assert.Nil(t, dc.Process(makeMessages([]string{msg(1600558963867)})))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&first))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&later))
+	assert.Equal(t, int32(0), atomic.LoadInt32(&received))
+
+	// dean re-drives while the second hop is pending. The first hop's row
+	// says failed, but paying it again would pay twice.
+	assert.Nil(t, dc.Process(makeMessages([]string{msg(1600999999999)})))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&first), "the first provider is not paid again")
+	assert.Equal(t, int32(1), atomic.LoadInt32(&later), "nor is the pending hop")
+	assert.Equal(t, int32(0), atomic.LoadInt32(&received))
+
+	provider.settled = &Result{Type: "payment:fake", ID: "chained", Success: true}
+	assert.Nil(t, dc.pollPending())
+	assert.Equal(t, int32(1), atomic.LoadInt32(&received))
+	assert.Contains(t, last, `"success":true`)
+
+	// And once it has paid, a re-drive replays it.
+	assert.Nil(t, dc.Process(makeMessages([]string{msg(1601999999999)})))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&first))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&later))
+	assert.Equal(t, int32(2), atomic.LoadInt32(&received))
+	assert.Contains(t, last, `"success":true`)
+}
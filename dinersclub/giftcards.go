@@ -2,7 +2,9 @@ package main
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/go-playground/validator/v10"
@@ -30,12 +32,16 @@ func NewGiftCardsProvider(pool *pgxpool.Pool) (Provider, error) {
 	return &GiftCardsProvider{p}, nil
 }
 
-func FormatOrder(order *reloadly.GiftCardOrder) *reloadly.GiftCardOrder {
-
-	// random hack to support non customIdentifiers until
-	// reloadly actually works
-	u := uuid.New()
-	order.CustomIdentifier = u.String()
+// FormatOrder sends the order under the payment's idempotency key, whatever
+// customIdentifier the details carry: Reloadly refuses a customIdentifier it
+// has seen, so a re-driven or reclaimed order cannot be placed twice, and
+// reconcile joins the order back to the ledger on the same key. An order
+// with no key (one that never went through Job) still gets a unique one.
+func FormatOrder(order *reloadly.GiftCardOrder, key string) *reloadly.GiftCardOrder {
+	if key == "" {
+		key = uuid.New().String()
+	}
+	order.CustomIdentifier = key
 	return order
 }
 
@@ -46,7 +52,7 @@ func (p *GiftCardsProvider) Payout(event *PaymentEvent) (*Result, error) {
 		return handleJSONUnmarshalError("giftcard", err, event.Details), nil
 	}
 
-	order = FormatOrder(order)
+	order = FormatOrder(order, event.IdempotencyKey)
 
 	result := &Result{}
 	result.Type = "payment:giftcard"
@@ -63,8 +69,74 @@ func (p *GiftCardsProvider) Payout(event *PaymentEvent) (*Result, error) {
 		return p.formatError(result, err, event.Details)
 	}
 
+	return giftCardResult(result, &t, event)
+}
+
+// Gift card order statuses. An order is not necessarily settled when Order
+// returns: Reloadly can answer PENDING or PROCESSING and fulfil it minutes
+// later, which is why this provider implements StatusProvider.
+const (
+	giftCardSuccessful = "SUCCESSFUL"
+	giftCardPending    = "PENDING"
+	giftCardProcessing = "PROCESSING"
+	giftCardFailed     = "FAILED"
+	giftCardRefunded   = "REFUNDED"
+)
+
+// giftCardResult maps an order's transaction onto result. The transaction is
+// kept as the Response, which is where Status finds the id to poll.
+func giftCardResult(result *Result, t *reloadly.Transaction, event *PaymentEvent) (*Result, error) {
+	if raw, err := json.Marshal(t); err == nil {
+		msg := json.RawMessage(raw)
+		result.Response = &msg
+	}
+
+	switch strings.ToUpper(t.Status) {
+	case giftCardPending, giftCardProcessing:
+		result.Pending = true
+		return result, nil
+	case giftCardFailed, giftCardRefunded:
+		result.Success = false
+		result.Timestamp = time.Now().UTC()
+		result.Error = &PaymentError{
+			Message:        fmt.Sprintf("Gift card order %d was not fulfilled (status %s)", t.TransactionId, t.Status),
+			Code:           "GIFT_CARD_ORDER_FAILED",
+			PaymentDetails: event.Details,
+		}
+		return result, nil
+	}
+
+	// SUCCESSFUL, or no status at all, which is how every order read before
+	// statuses were checked and so stays a success.
 	result.Success = true
-	result.Timestamp = time.Time(*t.TransactionCreatedTime)
+	result.Timestamp = time.Now().UTC()
+	if t.TransactionCreatedTime != nil {
+		result.Timestamp = time.Time(*t.TransactionCreatedTime)
+	}
 	result.PaymentDetails = event.Details
 	return result, nil
 }
+
+// Status re-reads a pending order's transaction.
+func (p *GiftCardsProvider) Status(event *PaymentEvent, pending *Result) (*Result, error) {
+	t := new(reloadly.Transaction)
+	if pending.Response == nil {
+		return nil, fmt.Errorf("pending gift card payment has no order to poll")
+	}
+	if err := json.Unmarshal(*pending.Response, t); err != nil {
+		return nil, err
+	}
+	if t.TransactionId == 0 {
+		return nil, fmt.Errorf("pending gift card payment has no transaction id")
+	}
+
+	// Any error here is an error, not a failed Result: not being able to
+	// read the order says nothing about whether it was fulfilled.
+	tx, err := p.svc.GiftCards().Transaction(t.TransactionId)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &Result{Type: pending.Type, ID: pending.ID}
+	return giftCardResult(result, &tx, event)
+}
@@ -66,21 +66,21 @@ func TestGiftCardsReportsAPIErrorsInResult(t *testing.T) {
 	assert.Equal(t, false, res.Success)
 }
 
-func TestFormatOrderAddsRandomUUIDIfNotPresent(t *testing.T) {
-	jm := json.RawMessage([]byte(`{"productId":1234,"countryCode":"test-country","quantity":1,"unitPrice":0.5,"senderName":"test-name","recipientEmail":"test@test.com","id":"test-id"}`))
+func TestFormatOrderSendsTheIdempotencyKey(t *testing.T) {
+	jm := json.RawMessage([]byte(`{"productId":1234,"countryCode":"test-country","quantity":1,"unitPrice":0.5,"customIdentifier": "foo", "senderName":"test-name","recipientEmail":"test@test.com","id":"test-id"}`))
 	order := new(reloadly.GiftCardOrder)
 	json.Unmarshal(jm, &order)
 
-	res := FormatOrder(order)
-	uuid.MustParse(res.CustomIdentifier)
+	res := FormatOrder(order, "payment-key")
+	assert.Equal(t, "payment-key", res.CustomIdentifier)
 }
 
-func TestFormatOrderAddsRandomUUIDEvenIfCustomIdentifierPresent(t *testing.T) {
+func TestFormatOrderAddsRandomUUIDWithoutAKey(t *testing.T) {
 	jm := json.RawMessage([]byte(`{"productId":1234,"countryCode":"test-country","quantity":1,"unitPrice":0.5,"customIdentifier": "foo", "senderName":"test-name","recipientEmail":"test@test.com","id":"test-id"}`))
 	order := new(reloadly.GiftCardOrder)
 	json.Unmarshal(jm, &order)
 
-	res := FormatOrder(order)
+	res := FormatOrder(order, "")
 	uuid.MustParse(res.CustomIdentifier)
 }
 
@@ -135,3 +135,66 @@ func TestGiftCardsReportsSuccessResult(t *testing.T) {
 	assert.Equal(t, true, res.Success)
 	assert.Equal(t, &jm, res.PaymentDetails)
 }
+
+func giftCardsProvider(client *http.Client) *GiftCardsProvider {
+	return &GiftCardsProvider{ReloadlyProvider{nil, &reloadly.Service{Client: client}, ""}}
+}
+
+func giftCardEvent() *PaymentEvent {
+	jm := json.RawMessage([]byte(`{"productId":1234,"countryCode":"test-country","quantity":1,"unitPrice":0.5,"senderName":"test-name","recipientEmail":"test@test.com","id":"test-id"}`))
+	return &PaymentEvent{Userid: "foo", Pageid: "page", Provider: "giftcard", Details: &jm}
+}
+
+func TestGiftCardsPendingOrderIsPending(t *testing.T) {
+	provider := giftCardsProvider(TestClient(200, `{"transactionId":7,"status":"PENDING"}`, nil))
+
+	res, err := provider.Payout(giftCardEvent())
+
+	assert.Nil(t, err)
+	assert.True(t, res.Pending)
+	assert.False(t, res.Success)
+	assert.Nil(t, res.Error)
+	assert.Contains(t, string(*res.Response), `"transactionId":7`)
+}
+
+func TestGiftCardsStatusSettles(t *testing.T) {
+	pending := giftCardsProvider(TestClient(200, `{"transactionId":7,"status":"PROCESSING"}`, nil))
+	first, err := pending.Payout(giftCardEvent())
+	assert.Nil(t, err)
+	assert.True(t, first.Pending)
+
+	tests := []struct {
+		body    string
+		pending bool
+		success bool
+		code    string
+	}{
+		{`{"transactionId":7,"status":"PROCESSING"}`, true, false, ""},
+		{`{"transactionId":7,"status":"SUCCESSFUL","transactionCreatedTime":"2021-11-15 16:55:30"}`, false, true, ""},
+		{`{"transactionId":7,"status":"REFUNDED"}`, false, false, "GIFT_CARD_ORDER_FAILED"},
+	}
+
+	for _, tt := range tests {
+		res, err := giftCardsProvider(TestClient(200, tt.body, nil)).Status(giftCardEvent(), first)
+
+		assert.Nil(t, err, tt.body)
+		assert.Equal(t, tt.pending, res.Pending, tt.body)
+		assert.Equal(t, tt.success, res.Success, tt.body)
+		assert.Equal(t, "test-id", res.ID, tt.body)
+		if tt.code != "" {
+			assert.Equal(t, tt.code, res.Error.Code, tt.body)
+		}
+	}
+}
+
+func TestGiftCardsStatusErrorIsNotAVerdict(t *testing.T) {
+	pending := giftCardsProvider(TestClient(200, `{"transactionId":7,"status":"PENDING"}`, nil))
+	first, _ := pending.Payout(giftCardEvent())
+
+	// Not being able to read the order says nothing about whether it was
+	// fulfilled, so it must not come back as a failed Result.
+	res, err := giftCardsProvider(TestClient(503, `{"errorCode": "UNAVAILABLE", "message": "down"}`, nil)).Status(giftCardEvent(), first)
+
+	assert.NotNil(t, err)
+	assert.Nil(t, res)
+}
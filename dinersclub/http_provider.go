@@ -5,10 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
-	"log"
-
 	"net/http"
-	"net/http/httputil"
 	"strings"
 	"time"
 
@@ -18,45 +15,82 @@ import (
 )
 
 type HttpProvider struct {
-	client  *http.Client
-	pool    *pgxpool.Pool
-	secrets map[string]string
+	client   *http.Client
+	pool     *pgxpool.Pool
+	secrets  map[string]string
+	logLevel httpLogLevel
 }
 
 func NewHttpProvider(pool *pgxpool.Pool) (Provider, error) {
-	return &HttpProvider{client: http.DefaultClient, pool: pool, secrets: map[string]string{}}, nil
+	cfg := getConfig()
+	logLevel := parseHttpLogLevel(cfg.HttpLogLevel)
+	return &HttpProvider{client: http.DefaultClient, pool: pool, secrets: map[string]string{}, logLevel: logLevel}, nil
 }
 
 func (p *HttpProvider) GetUserFromPaymentEvent(event *PaymentEvent) (*User, error) {
 	return GenericGetUser(p.pool, event)
 }
 
+// Auth loads the researcher's Generic Secrets into p, replacing whatever p
+// held before rather than adding to it, so a secret the researcher has since
+// deleted is gone too. It writes to p, so it is only safe on a provider
+// nothing else holds yet; checkCache uses Bind instead.
 func (p *HttpProvider) Auth(user *User, key string) error {
+	secrets, err := loadHttpSecrets(p.pool, user.Id)
+	if err != nil {
+		return err
+	}
+	p.secrets = secrets
+	return nil
+}
+
+// Bind returns a copy of p bound to the researcher's Generic Secrets, leaving
+// p untouched. The copy's secrets are never written after this returns, so
+// it can be cached and shared between concurrent payouts: each payout only
+// ever reads, and only ever sees its own researcher's secrets.
+func (p *HttpProvider) Bind(user *User, key string) (Provider, error) {
+	secrets, err := loadHttpSecrets(p.pool, user.Id)
+	if err != nil {
+		return nil, err
+	}
+	return p.withSecrets(secrets), nil
+}
+
+func (p *HttpProvider) withSecrets(secrets map[string]string) *HttpProvider {
+	return &HttpProvider{client: p.client, pool: p.pool, secrets: secrets, logLevel: p.logLevel}
+}
+
+// loadHttpSecrets reads one researcher's Generic Secrets into a fresh map.
+func loadHttpSecrets(pool *pgxpool.Pool, userid string) (map[string]string, error) {
 	query := `SELECT key, details->>'value' FROM credentials WHERE entity='secrets' AND userid=$1`
 
-	rows, err := p.pool.Query(context.Background(), query, user.Id)
+	rows, err := pool.Query(context.Background(), query, userid)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	defer rows.Close()
 
+	secrets := map[string]string{}
 	for rows.Next() {
 		var a string
 		var b string
 		err := rows.Scan(&a, &b)
 
 		if err != nil {
-			return err
+			return nil, err
 		}
-		p.secrets[a] = b
+		if b, err = openValue(credentialKeys, b); err != nil {
+			return nil, fmt.Errorf("secret %q: %w", a, err)
+		}
+		secrets[a] = b
 	}
 
-	if rows.Err() != nil {
-		return err
+	if err := rows.Err(); err != nil {
+		return nil, err
 	}
 
-	return nil
+	return secrets, nil
 }
 
 type HttpPaymentDetails struct {
@@ -67,8 +101,34 @@ type HttpPaymentDetails struct {
 	Headers      map[string]string `json:"headers"`
 	ErrorMessage string            `json:"errorMessage"`
 	ResponsePath string            `json:"responsePath"`
+
+	// SuccessPath, when set, makes success a property of the response body
+	// as well as the status: many partner APIs answer 200 with
+	// {"status": "failed"}. A 2xx response is a success only if the value at
+	// SuccessPath equals SuccessValue -- or, with no SuccessValue, is true.
+	SuccessPath  string `json:"successPath"`
+	SuccessValue string `json:"successValue"`
+
+	// ErrorCodePath reads the partner's own error code from a failed
+	// response into PaymentError.Code, in place of the bare status.
+	ErrorCodePath string `json:"errorCodePath"`
+
+	// RetriableStatuses are statuses the partner uses for "try again later"
+	// beyond the 5xx and 429 classify.go already knows. A failure with one of
+	// them is HTTP_RETRIABLE_STATUS, which is transient.
+	RetriableStatuses []int `json:"retriableStatuses"`
 }
 
+// HttpRetriableStatus is the code for a failure with one of the payment's
+// RetriableStatuses. Recovery is decided by code alone (see Classify), so a
+// researcher's "this status is transient" has to become a code; the status
+// and the partner's own code are kept in the message.
+const HttpRetriableStatus = "HTTP_RETRIABLE_STATUS"
+
+// HttpResponseUnreadable is the code for a response whose body could not be
+// read when the verdict depends on it.
+const HttpResponseUnreadable = "HTTP_RESPONSE_UNREADABLE"
+
 func (p *HttpProvider) Interpolate(s string) (string, error) {
 	tmpl := mustache.New(mustache.SilentMiss(false), mustache.Delimiters("<<", ">>"))
 	tmpl.ParseString(s)
@@ -151,23 +211,34 @@ func (p *HttpProvider) Payout(event *PaymentEvent) (*Result, error) {
 		req.Header.Add(header, headers[header])
 	}
 
-	dump, _ := httputil.DumpRequestOut(req, true)
-	// Useful debugging for http provider
-	log.Println(string(dump))
+	// Everything logged, and the transport error a respondent may see, goes
+	// through the redactor: the URL, headers and body all carry secrets.
+	r := newRedactor(p.secrets)
+	start := time.Now()
 
 	resp, err := p.client.Do(req)
 	if err != nil {
-		// Fail if any http request fails
-		// TODO: redo if transient and return error instead
-		return formatError(result, event, err.Error(), "HTTP_REQUEST_FAILED")
+		httpExchangeLog(p.logLevel, r, order.ID, req, body, 0, nil, err, time.Since(start)).write()
+
+		// We never got an answer, so we know nothing about the payment.
+		// HTTP_REQUEST_FAILED is transient: payout retries it under the
+		// retry budget, and past that it is withheld for dean to re-drive.
+		return formatError(result, event, r.redact(err.Error()), "HTTP_REQUEST_FAILED")
 	}
 
-	success := resp.StatusCode >= 200 && resp.StatusCode <= 299
+	statusOK := resp.StatusCode >= 200 && resp.StatusCode <= 299
 
 	defer resp.Body.Close()
 	bodyBytes, err := io.ReadAll(resp.Body)
+	httpExchangeLog(p.logLevel, r, order.ID, req, body, resp.StatusCode, bodyBytes, err, time.Since(start)).write()
 	if err != nil {
-		return nil, err // transient???
+		if statusOK && order.SuccessPath == "" {
+			// The status is the whole verdict, and we have it.
+			bodyBytes = nil
+		} else {
+			// The partner answered but we cannot tell what it said.
+			return formatError(result, event, r.redact(err.Error()), HttpResponseUnreadable)
+		}
 	}
 
 	responseString := GetFromJson(bodyBytes, order.ResponsePath, true)
@@ -177,6 +248,11 @@ func (p *HttpProvider) Payout(event *PaymentEvent) (*Result, error) {
 		responseString = `""`
 	}
 
+	success := statusOK
+	if statusOK && order.SuccessPath != "" {
+		success = bodySaysSuccess(bodyBytes, order.SuccessPath, order.SuccessValue)
+	}
+
 	if success {
 
 		// convert response into json
@@ -190,5 +266,34 @@ func (p *HttpProvider) Payout(event *PaymentEvent) (*Result, error) {
 	errorMessage := GetFromJson(bodyBytes, order.ErrorMessage, false)
 
 	code := fmt.Sprintf("%d", resp.StatusCode)
+	if statusOK {
+		// A 2xx the success rule refused. The status says nothing about why.
+		code = "PAYMENT_FAILED"
+	}
+	if order.ErrorCodePath != "" {
+		if c := gjson.GetBytes(bodyBytes, order.ErrorCodePath).String(); c != "" {
+			code = c
+		}
+	}
+
+	for _, s := range order.RetriableStatuses {
+		if s == resp.StatusCode {
+			msg := fmt.Sprintf("HTTP %d (code %s): %s", resp.StatusCode, code, errorMessage)
+			return formatError(result, event, msg, HttpRetriableStatus)
+		}
+	}
+
 	return formatError(result, event, errorMessage, code)
 }
+
+// bodySaysSuccess applies a payment's success rule to a response body.
+func bodySaysSuccess(body []byte, path, value string) bool {
+	v := gjson.GetBytes(body, path)
+	if !v.Exists() {
+		return false
+	}
+	if value == "" {
+		return v.Type == gjson.True
+	}
+	return v.String() == value
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"sync"
 	"testing"
 
 	"net/http"
@@ -68,6 +69,100 @@ func TestHttpProviderAuth_GetsSecrets(t *testing.T) {
 	assert.Equal(t, "qux", p.secrets["baz"])
 }
 
+// researcherServer answers 200 only when the token a request carries belongs
+// to the researcher named in its path, so a payout interpolated with someone
+// else's secrets fails.
+func researcherServer(t *testing.T, tokens map[string]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		researcher := r.URL.Path[1:]
+		if got := r.Header.Get("Authorization"); got != "Bearer "+tokens[researcher] {
+			t.Errorf("researcher %s paid with %q", researcher, got)
+			w.WriteHeader(401)
+			return
+		}
+		w.WriteHeader(200)
+	}))
+}
+
+func researcherPayment(ts *httptest.Server, researcher string) *PaymentEvent {
+	details := json.RawMessage(fmt.Sprintf(`{
+		"method": "POST",
+		"url": "%s/%s",
+		"headers": {"Authorization": "Bearer << token >>"},
+		"body": {}}`, ts.URL, researcher))
+	return &PaymentEvent{Provider: "http", Details: &details}
+}
+
+func TestHttpProviderBind_ConcurrentPayoutsOnlySeeTheirResearchersSecrets(t *testing.T) {
+	tokens := map[string]string{}
+	for i := 0; i < 8; i++ {
+		tokens[fmt.Sprintf("researcher-%d", i)] = fmt.Sprintf("token-%d", i)
+	}
+	ts := researcherServer(t, tokens)
+	defer ts.Close()
+
+	base := &HttpProvider{client: http.DefaultClient, secrets: map[string]string{}}
+
+	var wg sync.WaitGroup
+	for researcher, token := range tokens {
+		bound := base.withSecrets(map[string]string{"token": token})
+		for j := 0; j < 10; j++ {
+			wg.Add(1)
+			go func(researcher string) {
+				defer wg.Done()
+				res, err := bound.Payout(researcherPayment(ts, researcher))
+				assert.Nil(t, err)
+				assert.True(t, res.Success, researcher)
+			}(researcher)
+		}
+	}
+	wg.Wait()
+
+	assert.Empty(t, base.secrets, "binding leaves the provider it copies untouched")
+}
+
+func TestHttpProviderCheckCache_ConcurrentPayoutsAcrossResearchers(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+	dc := getDC(ts)
+	defer dc.pool.Close()
+	before(t, dc.pool)
+
+	users := map[string]string{
+		"00000000-0000-0000-0000-000000000001": "token-1",
+		"00000000-0000-0000-0000-000000000002": "token-2",
+		"00000000-0000-0000-0000-000000000003": "token-3",
+	}
+	for id, token := range users {
+		mustExec(t, dc.pool, fmt.Sprintf(`
+			INSERT INTO users(id, email) VALUES ('%s', '%s@test.com');
+			INSERT INTO credentials(userid, entity, key, details)
+			VALUES ('%s', 'secrets', 'token', '{"value": "%s"}');`, id, id, id, token))
+	}
+	partner := researcherServer(t, users)
+	defer partner.Close()
+
+	var wg sync.WaitGroup
+	for id := range users {
+		for j := 0; j < 10; j++ {
+			wg.Add(1)
+			go func(id string) {
+				defer wg.Done()
+				fresh, _ := NewHttpProvider(dc.pool)
+				pe := researcherPayment(partner, id)
+				provider, err := dc.checkCache(fresh, pe, &User{Id: id})
+				if !assert.Nil(t, err) {
+					return
+				}
+				res, err := provider.Payout(pe)
+				assert.Nil(t, err)
+				assert.True(t, res.Success, id)
+			}(id)
+		}
+	}
+	wg.Wait()
+}
+
 func TestHttpProviderPayout_MakesPostRequestsWithInterpolatedSecrets(t *testing.T) {
 	response := `{"bar": "baz"}`
 
@@ -401,3 +496,120 @@ func TestHttpProviderPayout_GeneratesErrorForUserBadRequest(t *testing.T) {
 	assert.Equal(t, false, res.Success)
 	assert.Equal(t, "BAD_HTTP_REQUEST", res.Error.Code)
 }
+
+func httpDetails(extra string) *PaymentEvent {
+	details := json.RawMessage([]byte(`{"id": "pay-1", "method": "POST", "url": "https://foo.com"` + extra + `}`))
+	return &PaymentEvent{Details: &details}
+}
+
+func TestHttpProviderPayout_SuccessPathDecidesA2xx(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		rule    string
+		success bool
+	}{
+		{"value matches", `{"status": "ok"}`, `, "successPath": "status", "successValue": "ok"`, true},
+		{"value differs", `{"status": "failed"}`, `, "successPath": "status", "successValue": "ok"`, false},
+		{"path missing", `{"other": "ok"}`, `, "successPath": "status", "successValue": "ok"`, false},
+		{"boolean true", `{"data": {"paid": true}}`, `, "successPath": "data.paid"`, true},
+		{"boolean false", `{"data": {"paid": false}}`, `, "successPath": "data.paid"`, false},
+		{"string true is not a boolean", `{"data": {"paid": "true"}}`, `, "successPath": "data.paid"`, false},
+		{"no rule, status decides", `{"status": "failed"}`, ``, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &HttpProvider{client: TestClient(200, tt.body, nil)}
+
+			res, err := p.Payout(httpDetails(tt.rule))
+
+			assert.Nil(t, err)
+			assert.Equal(t, tt.success, res.Success)
+			if !tt.success {
+				assert.Equal(t, "PAYMENT_FAILED", res.Error.Code, "a 2xx the rule refused has no status to report")
+			}
+		})
+	}
+}
+
+func TestHttpProviderPayout_SuccessPathDoesNotRescueANon2xx(t *testing.T) {
+	p := &HttpProvider{client: TestClient(500, `{"status": "ok"}`, nil)}
+
+	res, err := p.Payout(httpDetails(`, "successPath": "status", "successValue": "ok"`))
+
+	assert.Nil(t, err)
+	assert.False(t, res.Success)
+	assert.Equal(t, "500", res.Error.Code)
+}
+
+func TestHttpProviderPayout_ReadsErrorCodeFromPath(t *testing.T) {
+	body := `{"status": "failed", "error": {"code": "INSUFFICIENT_BALANCE", "message": "top up"}}`
+
+	for _, status := range []int{200, 402} {
+		p := &HttpProvider{client: TestClient(status, body, nil)}
+
+		res, err := p.Payout(httpDetails(`, "successPath": "status", "successValue": "ok", "errorCodePath": "error.code", "errorMessage": "error.message"`))
+
+		assert.Nil(t, err)
+		assert.False(t, res.Success)
+		assert.Equal(t, "INSUFFICIENT_BALANCE", res.Error.Code)
+		assert.Equal(t, "top up", res.Error.Message)
+	}
+
+	// A missing code falls back to the status.
+	p := &HttpProvider{client: TestClient(402, `{}`, nil)}
+	res, _ := p.Payout(httpDetails(`, "errorCodePath": "error.code"`))
+	assert.Equal(t, "402", res.Error.Code)
+}
+
+func TestHttpProviderPayout_RetriableStatusesAreTransient(t *testing.T) {
+	p := &HttpProvider{client: TestClient(409, `{"error": {"code": "LOCKED", "message": "busy"}}`, nil)}
+
+	res, err := p.Payout(httpDetails(`, "retriableStatuses": [409, 423], "errorCodePath": "error.code", "errorMessage": "error.message"`))
+
+	assert.Nil(t, err)
+	assert.Equal(t, HttpRetriableStatus, res.Error.Code)
+	assert.Contains(t, res.Error.Message, "409")
+	assert.Contains(t, res.Error.Message, "LOCKED")
+	assert.Contains(t, res.Error.Message, "busy")
+
+	recovery, known := ClassifyResult(res)
+	assert.True(t, known)
+	assert.Equal(t, RecoveryTransient, recovery)
+
+	// Unlisted statuses keep their code.
+	p = &HttpProvider{client: TestClient(400, `{}`, nil)}
+	res, _ = p.Payout(httpDetails(`, "retriableStatuses": [409]`))
+	assert.Equal(t, "400", res.Error.Code)
+}
+
+type failingBody struct{}
+
+func (failingBody) Read([]byte) (int, error) { return 0, fmt.Errorf("connection reset") }
+func (failingBody) Close() error             { return nil }
+
+func TestHttpProviderPayout_UnreadableBody(t *testing.T) {
+	client := func(status int) *http.Client {
+		return &http.Client{Transport: TestTransport(func(req *http.Request) (*http.Response, error) {
+			return &http.Response{StatusCode: status, Body: failingBody{}}, nil
+		})}
+	}
+
+	// The status is the whole verdict: still a success.
+	p := &HttpProvider{client: client(200)}
+	res, err := p.Payout(httpDetails(``))
+	assert.Nil(t, err)
+	assert.True(t, res.Success)
+
+	// The verdict was in the body: we do not know.
+	p = &HttpProvider{client: client(200)}
+	res, err = p.Payout(httpDetails(`, "successPath": "status"`))
+	assert.Nil(t, err)
+	assert.Equal(t, HttpResponseUnreadable, res.Error.Code)
+
+	p = &HttpProvider{client: client(500)}
+	res, err = p.Payout(httpDetails(``))
+	assert.Nil(t, err)
+	assert.Equal(t, HttpResponseUnreadable, res.Error.Code)
+}
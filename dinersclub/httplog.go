@@ -0,0 +1,181 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Request logging for the HTTP provider.
+//
+// The HTTP provider used to log httputil.DumpRequestOut of every request:
+// headers and body exactly as sent, which is to say with the researcher's
+// Generic Secrets already interpolated into them. That went to stdout and on
+// to the log aggregator. What replaces it is one JSON line per exchange, with
+// every secret value the researcher holds and every known auth header
+// redacted before anything is written, at a verbosity set by
+// DINERSCLUB_HTTP_LOG_LEVEL.
+//
+// Redaction is by value, not by template: any occurrence of any of the
+// researcher's secrets is masked wherever it ended up -- URL, header, body,
+// the partner's echo of it in the response, or a transport error quoting the
+// URL. Over-redacting a log line is cheap; the other mistake is not.
+
+type httpLogLevel int
+
+const (
+	// httpLogOff logs nothing.
+	httpLogOff httpLogLevel = iota
+	// httpLogSummary logs method, URL, status and duration.
+	httpLogSummary
+	// httpLogHeaders adds the request headers.
+	httpLogHeaders
+	// httpLogBody adds the request and response bodies: the old dump, safe.
+	httpLogBody
+)
+
+var httpLogLevels = map[string]httpLogLevel{
+	"off":     httpLogOff,
+	"summary": httpLogSummary,
+	"headers": httpLogHeaders,
+	"body":    httpLogBody,
+}
+
+// parseHttpLogLevel reads DINERSCLUB_HTTP_LOG_LEVEL. An unknown level is
+// summary rather than an error: a typo in a logging knob should not stop
+// anybody being paid.
+func parseHttpLogLevel(s string) httpLogLevel {
+	if l, ok := httpLogLevels[strings.ToLower(s)]; ok {
+		return l
+	}
+	return httpLogSummary
+}
+
+// Headers whose values are credentials whatever they contain.
+var authHeaders = map[string]bool{
+	"Authorization":             true,
+	"Proxy-Authorization":       true,
+	"Cookie":                    true,
+	"Set-Cookie":                true,
+	"X-Api-Key":                 true,
+	"Api-Key":                   true,
+	"Api_key":                   true,
+	"X-Auth-Token":              true,
+	"Ocp-Apim-Subscription-Key": true,
+}
+
+const redacted = "[REDACTED]"
+
+// Bodies are cut to this many bytes: enough to debug an integration, not
+// enough to ship a partner's whole catalogue to the aggregator per payment.
+const httpLogMaxBody = 4096
+
+// redactor masks a set of secret values, longest first so that a secret
+// containing another is masked whole.
+type redactor struct {
+	values []string
+}
+
+func newRedactor(secrets map[string]string) *redactor {
+	seen := map[string]bool{}
+	values := []string{}
+	add := func(v string) {
+		if v != "" && !seen[v] {
+			seen[v] = true
+			values = append(values, v)
+		}
+	}
+	for _, v := range secrets {
+		// A secret interpolated into a URL may arrive escaped.
+		add(v)
+		add(url.QueryEscape(v))
+		add(url.PathEscape(v))
+	}
+	sort.Slice(values, func(i, j int) bool { return len(values[i]) > len(values[j]) })
+	return &redactor{values}
+}
+
+func (r *redactor) redact(s string) string {
+	for _, v := range r.values {
+		s = strings.ReplaceAll(s, v, redacted)
+	}
+	return s
+}
+
+func (r *redactor) headers(h http.Header) map[string]string {
+	out := map[string]string{}
+	for k, vs := range h {
+		if authHeaders[http.CanonicalHeaderKey(k)] {
+			out[k] = redacted
+			continue
+		}
+		out[k] = r.redact(strings.Join(vs, ", "))
+	}
+	return out
+}
+
+type httpLogEntry struct {
+	Msg            string            `json:"msg"`
+	PaymentID      string            `json:"payment_id,omitempty"`
+	Method         string            `json:"method"`
+	URL            string            `json:"url"`
+	Status         int               `json:"status,omitempty"`
+	DurationMs     int64             `json:"duration_ms"`
+	Error          string            `json:"error,omitempty"`
+	RequestHeaders map[string]string `json:"request_headers,omitempty"`
+	RequestBody    string            `json:"request_body,omitempty"`
+	ResponseBody   string            `json:"response_body,omitempty"`
+}
+
+// httpExchangeLog builds the log entry for one request and what came of it,
+// redacted for level. It returns nil when level is off.
+func httpExchangeLog(level httpLogLevel, r *redactor, paymentID string, req *http.Request, reqBody string,
+	status int, respBody []byte, err error, d time.Duration) *httpLogEntry {
+
+	if level == httpLogOff {
+		return nil
+	}
+
+	e := &httpLogEntry{
+		Msg:        "http provider request",
+		PaymentID:  paymentID,
+		Method:     req.Method,
+		URL:        r.redact(req.URL.String()),
+		Status:     status,
+		DurationMs: d.Milliseconds(),
+	}
+	if err != nil {
+		e.Error = r.redact(err.Error())
+	}
+	if level >= httpLogHeaders {
+		e.RequestHeaders = r.headers(req.Header)
+	}
+	if level >= httpLogBody {
+		e.RequestBody = truncate(r.redact(reqBody), httpLogMaxBody)
+		e.ResponseBody = truncate(r.redact(string(respBody)), httpLogMaxBody)
+	}
+	return e
+}
+
+func (e *httpLogEntry) write() {
+	if e == nil {
+		return
+	}
+	b, err := json.Marshal(e)
+	if err != nil {
+		log.Printf("DinersClub could not encode http provider log entry: %v", err)
+		return
+	}
+	log.Println(string(b))
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n] + "...(truncated)"
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// captureLog collects what the standard logger writes during a test.
+func captureLog(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	buf := new(bytes.Buffer)
+	log.SetOutput(buf)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	return buf
+}
+
+func TestParseHttpLogLevel(t *testing.T) {
+	assert.Equal(t, httpLogOff, parseHttpLogLevel("off"))
+	assert.Equal(t, httpLogSummary, parseHttpLogLevel("summary"))
+	assert.Equal(t, httpLogHeaders, parseHttpLogLevel("HEADERS"))
+	assert.Equal(t, httpLogBody, parseHttpLogLevel("body"))
+	assert.Equal(t, httpLogSummary, parseHttpLogLevel("verbose"), "a typo falls back to summary")
+}
+
+func TestRedactorMasksEverySecretForm(t *testing.T) {
+	r := newRedactor(map[string]string{"token": "s3cr et/+", "short": "s3cr", "empty": ""})
+
+	assert.Equal(t, "a [REDACTED] b", r.redact("a s3cr et/+ b"), "longest first: the whole secret, not its prefix")
+	assert.Equal(t, "?k=[REDACTED]", r.redact("?k=s3cr+et%2F%2B"), "query-escaped")
+	assert.Equal(t, "/[REDACTED]", r.redact("/s3cr%20et%2F+"), "path-escaped")
+	assert.Equal(t, "[REDACTED]", r.redact("s3cr"))
+	assert.Equal(t, "nothing here", r.redact("nothing here"), "an empty secret redacts nothing")
+}
+
+func TestRedactorMasksAuthHeadersWhateverTheyHold(t *testing.T) {
+	r := newRedactor(map[string]string{"foo": "sosecret"})
+	h := http.Header{}
+	h.Set("Authorization", "Basic dXNlcjpwYXNz")
+	h.Set("X-Api-Key", "not-a-known-secret")
+	h.Set("X-Note", "prefix-sosecret")
+	h.Set("Accept", "application/json")
+
+	got := r.headers(h)
+
+	assert.Equal(t, redacted, got["Authorization"])
+	assert.Equal(t, redacted, got["X-Api-Key"])
+	assert.Equal(t, "prefix-[REDACTED]", got["X-Note"])
+	assert.Equal(t, "application/json", got["Accept"])
+}
+
+func TestHttpExchangeLogLevels(t *testing.T) {
+	r := newRedactor(map[string]string{"foo": "sosecret"})
+	req, _ := http.NewRequest("POST", "https://api.example.com/pay?key=sosecret", nil)
+	req.Header.Set("Authorization", "Bearer sosecret")
+
+	entry := func(l httpLogLevel) *httpLogEntry {
+		return httpExchangeLog(l, r, "pay-1", req, `{"k": "sosecret"}`, 200, []byte(`{"ok": true}`), nil, time.Second)
+	}
+
+	assert.Nil(t, entry(httpLogOff))
+
+	summary := entry(httpLogSummary)
+	assert.Equal(t, "https://api.example.com/pay?key=[REDACTED]", summary.URL)
+	assert.Equal(t, 200, summary.Status)
+	assert.Equal(t, int64(1000), summary.DurationMs)
+	assert.Nil(t, summary.RequestHeaders)
+	assert.Empty(t, summary.RequestBody)
+
+	headers := entry(httpLogHeaders)
+	assert.Equal(t, redacted, headers.RequestHeaders["Authorization"])
+	assert.Empty(t, headers.RequestBody)
+
+	body := entry(httpLogBody)
+	assert.Equal(t, `{"k": "[REDACTED]"}`, body.RequestBody)
+	assert.Equal(t, `{"ok": true}`, body.ResponseBody)
+
+	withErr := httpExchangeLog(httpLogSummary, r, "pay-1", req, "", 0, nil, errors.New(`Post "https://api.example.com/pay?key=sosecret": EOF`), 0)
+	assert.NotContains(t, withErr.Error, "sosecret")
+}
+
+func TestHttpExchangeLogTruncatesBodies(t *testing.T) {
+	req, _ := http.NewRequest("GET", "https://api.example.com", nil)
+	e := httpExchangeLog(httpLogBody, newRedactor(nil), "", req, "", 200, []byte(strings.Repeat("x", httpLogMaxBody+1)), nil, 0)
+
+	assert.Equal(t, httpLogMaxBody+len("...(truncated)"), len(e.ResponseBody))
+}
+
+// The end-to-end guarantee: at the most verbose level, nothing the provider
+// writes contains a secret, and what it writes is one JSON object per line.
+func TestHttpProviderPayout_LogsNoSecrets(t *testing.T) {
+	buf := captureLog(t)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// A partner echoing the credential back must not leak it either.
+		fmt.Fprint(w, `{"received": "`+r.Header.Get("Authorization")+`"}`)
+	}))
+	defer ts.Close()
+
+	p := &HttpProvider{
+		client:   http.DefaultClient,
+		secrets:  map[string]string{"foo": "sosecret", "bar": "ohsosecret"},
+		logLevel: httpLogBody,
+	}
+	details := json.RawMessage([]byte(fmt.Sprintf(`{
+		"id": "pay-1",
+		"method": "POST",
+		"url": "%s/foo?auth=<< bar >>",
+		"headers": {"Authorization": "Bearer << foo >>", "X-Custom": "<< bar >>"},
+		"body": {"token": "<< foo >>"}}`, ts.URL)))
+
+	res, err := p.Payout(&PaymentEvent{Details: &details})
+	assert.Nil(t, err)
+	assert.True(t, res.Success)
+
+	out := buf.String()
+	assert.NotContains(t, out, "sosecret")
+	assert.Contains(t, out, "[REDACTED]")
+
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	assert.Len(t, lines, 1)
+	entry := new(httpLogEntry)
+	assert.Nil(t, json.Unmarshal([]byte(lines[0][strings.Index(lines[0], "{"):]), entry))
+	assert.Equal(t, "pay-1", entry.PaymentID)
+	assert.Equal(t, 200, entry.Status)
+}
+
+func TestHttpProviderPayout_TransportErrorMessageIsRedacted(t *testing.T) {
+	captureLog(t)
+
+	p := &HttpProvider{client: http.DefaultClient, secrets: map[string]string{"bar": "ohsosecret"}}
+	details := json.RawMessage([]byte(`{"method": "GET", "url": "http://127.0.0.1:1/foo?auth=<< bar >>"}`))
+
+	res, err := p.Payout(&PaymentEvent{Details: &details})
+
+	assert.Nil(t, err)
+	assert.Equal(t, "HTTP_REQUEST_FAILED", res.Error.Code)
+	assert.NotContains(t, res.Error.Message, "ohsosecret", "the message reaches botserver and the respondent's state")
+}
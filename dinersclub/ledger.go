@@ -0,0 +1,303 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// The payments ledger (chatroach.payments, devops/migrations/28).
+//
+// dean re-drives a payment for up to 14 days, and a re-drive is
+// indistinguishable from a first attempt: replybot stamps each one with a new
+// timestamp. So a payment that succeeded at the provider but whose Result
+// never reached botserver -- a botserver outage outlasting RetryBotserver is
+// enough -- used to be paid again on every re-drive. The ledger is what makes
+// a re-drive of a recorded success a replay of the stored Result rather than a
+// second payout.
+//
+// It does not cover a worker that dies between the provider answering and
+// recordPayment: that row stays 'attempting' until the claim TTL and is then
+// paid again. Passing the key through to the provider (Reloadly's
+// customIdentifier, Ding's DistributorRef) is what closes that window, because
+// the provider refuses the duplicate.
+
+const (
+	paymentAttempting = "attempting"
+	paymentPending    = "pending"
+	paymentSuccess    = "success"
+	paymentFailed     = "failed"
+)
+
+// idempotencyKey derives the ledger key for a payment event.
+//
+// It is built from userid, pageid, provider and the id in the payment details
+// (the question's payment id), falling back to the raw details when there is
+// no id. It must NEVER include the event timestamp -- that changes on every
+// dean re-drive, and a key that changes is no key at all.
+//
+// Truncated to 32 hex characters (128 bits) so it fits the providers' own
+// reference fields, which it is passed through to.
+func idempotencyKey(pe *PaymentEvent) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%s\x00%s\x00", pe.Userid, pe.Pageid, pe.Provider)
+
+	if id := paymentID(pe); id != "" {
+		fmt.Fprintf(h, "id:%s", id)
+	} else if pe.Details != nil {
+		fmt.Fprintf(h, "details:%s", string(*pe.Details))
+	}
+
+	// A fallback hop is its own payment, even to a provider tried earlier in
+	// the chain on another account. The first provider's key is unchanged.
+	if pe.Chain != nil && pe.Chain.hop > 0 {
+		fmt.Fprintf(h, "\x00hop:%d", pe.Chain.hop)
+	}
+
+	return hex.EncodeToString(h.Sum(nil))[:32]
+}
+
+// paymentID reads the optional `id` every provider's details share. A
+// missing or non-string id is not an error here -- the provider reports a
+// malformed payment, the ledger just falls back to hashing the details.
+func paymentID(pe *PaymentEvent) string {
+	if pe.Details == nil {
+		return ""
+	}
+	d := struct {
+		ID interface{} `json:"id"`
+	}{}
+	if err := json.Unmarshal(*pe.Details, &d); err != nil || d.ID == nil {
+		return ""
+	}
+	if s, ok := d.ID.(string); ok {
+		return s
+	}
+	return fmt.Sprint(d.ID)
+}
+
+// lookupPayment returns the stored Result for a payment that already
+// succeeded, or nil if there is none.
+func lookupPayment(pool *pgxpool.Pool, key string) (*Result, error) {
+	query := `SELECT result FROM payments WHERE idempotency_key = $1 AND status = $2`
+
+	var raw []byte
+	err := pool.QueryRow(context.Background(), query, key, paymentSuccess).Scan(&raw)
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	res := new(Result)
+	if err := json.Unmarshal(raw, res); err != nil {
+		return nil, fmt.Errorf("stored result for payment %s is not a Result: %w", key, err)
+	}
+	return res, nil
+}
+
+// lookupHop returns a payment's ledger status, its stored Result, if any,
+// and the owner it was claimed for. The status is empty if there is no row.
+func lookupHop(pool *pgxpool.Pool, key string) (status string, res *Result, owner string, err error) {
+	query := `SELECT status, result, COALESCE(owner, '') FROM payments WHERE idempotency_key = $1`
+
+	var raw []byte
+	err = pool.QueryRow(context.Background(), query, key).Scan(&status, &raw, &owner)
+	if err == pgx.ErrNoRows {
+		return "", nil, "", nil
+	}
+	if err != nil || raw == nil {
+		return status, nil, owner, err
+	}
+
+	res = new(Result)
+	if err := json.Unmarshal(raw, res); err != nil {
+		return "", nil, "", fmt.Errorf("stored result for payment %s is not a Result: %w", key, err)
+	}
+	return status, res, owner, nil
+}
+
+// claimPayment marks a payment as being attempted. claimed is false when
+// another worker holds it -- an 'attempting' row younger than ttl -- or it
+// already succeeded or is held for review (review.go), and also when the
+// payment would breach one of the researcher's budgets, in which case breach
+// says which one.
+//
+// The budget check and the claim share one transaction, so the spend a claim
+// was checked against is the spend it adds to (see checkBudgets).
+func claimPayment(pool *pgxpool.Pool, pe *PaymentEvent, owner string, ttl time.Duration) (claimed bool, breach string, err error) {
+	ctx := context.Background()
+	amount := paymentAmount(pe)
+
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return false, "", err
+	}
+	defer tx.Rollback(ctx)
+
+	breach, err = checkBudgets(ctx, tx, pe, owner, amount)
+	if err != nil || breach != "" {
+		return false, breach, err
+	}
+
+	query := `
+		INSERT INTO payments (idempotency_key, userid, pageid, provider, payment_id, owner, status, details, shortcode, amount, platform, credential_key, recipient)
+		VALUES ($1, $2, $3, $4, NULLIF($5, ''), $6, $7, $8, NULLIF($11, ''), $12, NULLIF($13, ''), NULLIF($14, ''), NULLIF($15, ''))
+		ON CONFLICT (idempotency_key) DO UPDATE
+		SET status = excluded.status,
+			attempts = payments.attempts + 1,
+			owner = excluded.owner,
+			details = excluded.details,
+			shortcode = excluded.shortcode,
+			amount = excluded.amount,
+			platform = excluded.platform,
+			credential_key = excluded.credential_key,
+			recipient = excluded.recipient,
+			updated_at = now()
+		WHERE payments.status IN ($9, $16)
+		   OR (payments.status = $7 AND payments.updated_at < $10)
+		RETURNING attempts`
+
+	var attempts int
+	err = tx.QueryRow(ctx, query,
+		pe.IdempotencyKey, pe.Userid, pe.Pageid, pe.Provider, paymentID(pe), owner,
+		paymentAttempting, pe.Details, paymentFailed, time.Now().UTC().Add(-ttl),
+		pe.Shortcode, amount, pe.Platform, pe.Key, paymentRecipient(pe), paymentApproved).Scan(&attempts)
+
+	if err == pgx.ErrNoRows {
+		return false, "", nil
+	}
+	if err != nil {
+		return false, "", err
+	}
+	return true, "", tx.Commit(ctx)
+}
+
+// recordPayment files the verdict for a claimed payment. A nil res releases
+// the claim without one, so the next re-drive can try again immediately
+// rather than waiting out the claim TTL. A pending res is not a verdict; it
+// hands the payment to the status poller (see poller.go).
+//
+// Whatever it files is also appended to payment_attempts, which is the
+// history the admin API shows (see admin.go).
+func recordPayment(pool *pgxpool.Pool, pe *PaymentEvent, res *Result) error {
+	status := paymentFailed
+	var result []byte
+	var code *string
+
+	if res != nil {
+		b, err := json.Marshal(res)
+		if err != nil {
+			return err
+		}
+		result = b
+		if res.Pending {
+			status = paymentPending
+		} else if res.Success {
+			status = paymentSuccess
+		} else if res.Error != nil {
+			code = &res.Error.Code
+		}
+	}
+
+	query := `
+		WITH p AS (
+			UPDATE payments
+			SET status = $2, result = $3, error_code = $4, updated_at = now()
+			WHERE idempotency_key = $1
+			RETURNING idempotency_key, attempts
+		)
+		INSERT INTO payment_attempts (idempotency_key, attempt, status, result, error_code)
+		SELECT idempotency_key, attempts, $2, $3, $4 FROM p`
+
+	_, err := pool.Exec(context.Background(), query, pe.IdempotencyKey, status, result, code)
+	return err
+}
+
+// pendingPayment is a payment the status poller has leased: the event rebuilt
+// from the ledger, the researcher it belongs to, and the pending Result the
+// provider answered with.
+type pendingPayment struct {
+	event   *PaymentEvent
+	owner   string
+	pending *Result
+}
+
+// leasePendingPayments returns up to limit pending payments that nobody has
+// polled for interval, oldest first. Leasing bumps updated_at in the same
+// statement, so concurrent pollers -- one per replica -- never query the
+// provider for the same payment in the same interval.
+func leasePendingPayments(pool *pgxpool.Pool, interval time.Duration, limit int) ([]pendingPayment, error) {
+	query := `
+		UPDATE payments
+		SET updated_at = now()
+		WHERE status = $1 AND updated_at < $2
+		ORDER BY updated_at
+		LIMIT $3
+		RETURNING ` + pendingColumns
+
+	rows, err := pool.Query(context.Background(), query, paymentPending, time.Now().UTC().Add(-interval), limit)
+	if err != nil {
+		return nil, err
+	}
+	return scanPendingPayments(rows)
+}
+
+// leasePendingPayment leases one pending payment by its key, however
+// recently it was polled. It is how a provider callback gets its payment
+// asked about now rather than at the next sweep. Nothing is returned if the
+// payment is not pending -- most often because it has settled already.
+func leasePendingPayment(pool *pgxpool.Pool, key string) (*pendingPayment, error) {
+	query := `
+		UPDATE payments
+		SET updated_at = now()
+		WHERE status = $1 AND idempotency_key = $2
+		RETURNING ` + pendingColumns
+
+	rows, err := pool.Query(context.Background(), query, paymentPending, key)
+	if err != nil {
+		return nil, err
+	}
+	leased, err := scanPendingPayments(rows)
+	if err != nil || len(leased) == 0 {
+		return nil, err
+	}
+	return &leased[0], nil
+}
+
+const pendingColumns = `idempotency_key, userid, pageid, provider, COALESCE(platform, ''),
+			COALESCE(shortcode, ''), COALESCE(credential_key, ''), COALESCE(owner, ''), details, result`
+
+func scanPendingPayments(rows pgx.Rows) ([]pendingPayment, error) {
+	defer rows.Close()
+
+	leased := []pendingPayment{}
+	for rows.Next() {
+		pe := new(PaymentEvent)
+		p := pendingPayment{event: pe, pending: new(Result)}
+		var details, result []byte
+
+		err := rows.Scan(&pe.IdempotencyKey, &pe.Userid, &pe.Pageid, &pe.Provider, &pe.Platform,
+			&pe.Shortcode, &pe.Key, &p.owner, &details, &result)
+		if err != nil {
+			return nil, err
+		}
+
+		pe.Owner = p.owner
+		d := json.RawMessage(details)
+		pe.Details = &d
+		if err := json.Unmarshal(result, p.pending); err != nil {
+			return nil, fmt.Errorf("stored result for pending payment %s is not a Result: %w", pe.IdempotencyKey, err)
+		}
+		p.pending.Pending = true
+		leased = append(leased, p)
+	}
+	return leased, rows.Err()
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/stretchr/testify/assert"
+)
+
+// paymentMessage builds a fake-provider payment for foo with the given
+// details id, timestamp and result.
+func paymentMessage(id string, timestamp int64, success bool) string {
+	return fmt.Sprintf(`{
+		"userid": "foo",
+		"pageid": "page",
+		"timestamp": %d,
+		"provider": "fake",
+		"details": {
+			"id": %q,
+			"result": {
+				"type": "payment:fake",
+				"id": %q,
+				"success": %t,
+				"error": {"message": "provider said no", "code": "INVALID_RECIPIENT_PHONE"}
+			}
+		}
+	}`, timestamp, id, id, success)
+}
+
+func parsePaymentEvent(t *testing.T, msg string) *PaymentEvent {
+	t.Helper()
+	pe := new(PaymentEvent)
+	if err := json.Unmarshal([]byte(msg), pe); err != nil {
+		t.Fatal(err)
+	}
+	return pe
+}
+
+func TestIdempotencyKeyIgnoresTimestamp(t *testing.T) {
+	// dean re-drives with a fresh timestamp every time. A key that moved
+	// with it would make every re-drive a new payment.
+	a := parsePaymentEvent(t, paymentMessage("payment-1", 1600558963867, true))
+	b := parsePaymentEvent(t, paymentMessage("payment-1", 1600999999999, true))
+
+	assert.Equal(t, idempotencyKey(a), idempotencyKey(b))
+	assert.Len(t, idempotencyKey(a), 32)
+}
+
+func TestIdempotencyKeyDistinguishesPayments(t *testing.T) {
+	base := parsePaymentEvent(t, paymentMessage("payment-1", 1600558963867, true))
+
+	other := parsePaymentEvent(t, paymentMessage("payment-2", 1600558963867, true))
+	assert.NotEqual(t, idempotencyKey(base), idempotencyKey(other), "different payment ids")
+
+	otherUser := parsePaymentEvent(t, paymentMessage("payment-1", 1600558963867, true))
+	otherUser.Userid = "bar"
+	assert.NotEqual(t, idempotencyKey(base), idempotencyKey(otherUser), "different respondents")
+
+	otherProvider := parsePaymentEvent(t, paymentMessage("payment-1", 1600558963867, true))
+	otherProvider.Provider = "reloadly"
+	assert.NotEqual(t, idempotencyKey(base), idempotencyKey(otherProvider), "different providers")
+}
+
+func TestIdempotencyKeyFallsBackToDetails(t *testing.T) {
+	a := &PaymentEvent{Userid: "foo", Pageid: "page", Provider: "fake"}
+	b := &PaymentEvent{Userid: "foo", Pageid: "page", Provider: "fake"}
+	da := json.RawMessage(`{"amount": 1}`)
+	db := json.RawMessage(`{"amount": 2}`)
+	a.Details, b.Details = &da, &db
+
+	assert.NotEqual(t, idempotencyKey(a), idempotencyKey(b))
+}
+
+func TestLedgerReplaysRecordedSuccess(t *testing.T) {
+	var received int32
+	var last string
+	ts := countingBotserver(&received, &last)
+	defer ts.Close()
+
+	var attempts int32
+	dc := getDC(ts)
+	dc.getProvider = func(pool *pgxpool.Pool, event *PaymentEvent) (Provider, error) {
+		return &countingProvider{attempts: &attempts}, nil
+	}
+
+	err := dc.Process(makeMessages([]string{paymentMessage("payment-1", 1600558963867, true)}))
+	assert.Nil(t, err)
+
+	// The re-drive: same payment, new timestamp. The respondent hears about
+	// it again -- they may never have the first time -- but nobody is paid.
+	err = dc.Process(makeMessages([]string{paymentMessage("payment-1", 1600999999999, true)}))
+	assert.Nil(t, err)
+
+	assert.Equal(t, int32(1), atomic.LoadInt32(&attempts), "a recorded success must not be paid again")
+	assert.Equal(t, int32(2), atomic.LoadInt32(&received))
+	assert.Contains(t, last, `"success":true`)
+	assert.Contains(t, last, `"id":"payment-1"`)
+}
+
+func TestLedgerRetriesRecordedFailure(t *testing.T) {
+	var received int32
+	var last string
+	ts := countingBotserver(&received, &last)
+	defer ts.Close()
+
+	var attempts int32
+	dc := getDC(ts)
+	dc.getProvider = func(pool *pgxpool.Pool, event *PaymentEvent) (Provider, error) {
+		return &countingProvider{attempts: &attempts}, nil
+	}
+
+	msg := paymentMessage("payment-1", 1600558963867, false)
+	assert.Nil(t, dc.Process(makeMessages([]string{msg})))
+	assert.Nil(t, dc.Process(makeMessages([]string{msg})))
+
+	assert.Equal(t, int32(2), atomic.LoadInt32(&attempts), "a failed payment may be attempted again")
+
+	var status string
+	var count int
+	err := dc.pool.QueryRow(context.Background(), `SELECT status, attempts FROM payments`).Scan(&status, &count)
+	assert.Nil(t, err)
+	assert.Equal(t, paymentFailed, status)
+	assert.Equal(t, 2, count)
+}
+
+func TestLedgerSkipsPaymentInFlight(t *testing.T) {
+	var received int32
+	var last string
+	ts := countingBotserver(&received, &last)
+	defer ts.Close()
+
+	var attempts int32
+	dc := getDC(ts)
+	dc.getProvider = func(pool *pgxpool.Pool, event *PaymentEvent) (Provider, error) {
+		return &countingProvider{attempts: &attempts}, nil
+	}
+
+	// Another worker claimed this payment a moment ago and has not finished.
+	msg := paymentMessage("payment-1", 1600558963867, true)
+	pe := parsePaymentEvent(t, msg)
+	pe.IdempotencyKey = idempotencyKey(pe)
+	claimed, breach, err := claimPayment(dc.pool, pe, "test-id", time.Minute)
+	assert.Nil(t, err)
+	assert.True(t, claimed)
+	assert.Empty(t, breach)
+
+	assert.Nil(t, dc.Process(makeMessages([]string{msg})))
+	assert.Equal(t, int32(0), atomic.LoadInt32(&attempts))
+	assert.Equal(t, int32(0), atomic.LoadInt32(&received))
+
+	// Once the claim is older than the TTL, the worker is presumed dead.
+	dc.cfg.LedgerClaimTTL = 0
+	assert.Nil(t, dc.Process(makeMessages([]string{msg})))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&attempts))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&received))
+}
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"os"
 	"time"
 
 	"github.com/cenkalti/backoff"
@@ -37,6 +38,11 @@ func (dc *DC) Process(messages []*kafka.Message) error {
 	tasks := []interface{}{}
 	errs := []error{}
 
+	// Which message each task came from, and which messages failed, for the
+	// dead-letter topic.
+	origin := map[*PaymentEvent]*kafka.Message{}
+	failed := map[*kafka.Message]error{}
+
 	for _, m := range messages {
 		pe := new(PaymentEvent)
 		err := json.Unmarshal(m.Value, pe)
@@ -56,17 +62,17 @@ func (dc *DC) Process(messages []*kafka.Message) error {
 			// with %s flattens it to an opaque *errors.errorString and
 			// silently breaks errors.As/errors.Is for every caller --
 			// including errors.Join below, which preserves the chain.
-			recordFault("parse")
-			errs = append(errs, fmt.Errorf("Error parsing kakfa message: %s. Error: %w", string(m.Value), err))
+			err = faultAt("parse", fmt.Errorf("Error parsing kakfa message: %s. Error: %w", string(m.Value), err))
+			errs = append(errs, err)
+			failed[m] = err
 			continue
 		}
+		origin[pe] = m
 		tasks = append(tasks, pe)
 	}
 
-	// this processes them all at once
-	// maybe better for providers to have
-	// a fixed pool size, limit concurrent
-	// requests
+	// PoolSize bounds the batch as a whole; each provider's share of it is
+	// bounded in payout (see breaker.go).
 	outch := chance.Pool(dc.cfg.PoolSize, chance.Flatten(tasks), dc.Work)
 
 	// Drain outch to completion. Returning mid-range leaves the pool's
@@ -76,9 +82,14 @@ func (dc *DC) Process(messages []*kafka.Message) error {
 	for x := range outch {
 		if err, ok := x.(error); ok {
 			errs = append(errs, err)
+			var je *jobError
+			if errors.As(err, &je) {
+				failed[origin[je.pe]] = err
+			}
 		}
 	}
 
+	errs = append(errs, deadLetterMessages(failed)...)
 	return errors.Join(errs...)
 }
 
@@ -90,7 +101,8 @@ func backoffTime(d time.Duration, r float64) *backoff.ExponentialBackOff {
 }
 
 func (dc *DC) sendResult(pe *PaymentEvent, res *Result) error {
-	b, err := json.Marshal(res)
+	pe.Chain.stamp(pe, res)
+	b, err := json.Marshal(forRespondent(res))
 	jm := json.RawMessage(b)
 	if err != nil {
 		e := fmt.Errorf("Error marshalling result into json: %s. Error: %s", res.Error, err)
@@ -103,8 +115,7 @@ func (dc *DC) sendResult(pe *PaymentEvent, res *Result) error {
 	}
 
 	if err := backoff.Retry(op, backoffTime(dc.cfg.RetryBotserver, dc.cfg.BackOffRandomFactor)); err != nil {
-		recordFault("send")
-		return err
+		return faultAt("send", err)
 	}
 	return nil
 }
@@ -131,14 +142,24 @@ func authError(pe *PaymentEvent, e error) *Result {
 
 func (dc *DC) checkCache(provider Provider, pe *PaymentEvent, user *User) (Provider, error) {
 
-	// add key, provider + key + user.Id = key
-	key := pe.Provider + pe.Key + user.Id
+	// provider, key and researcher, separated so that no two of them can
+	// run together into another researcher's entry
+	key := pe.Provider + "\x00" + pe.Key + "\x00" + user.Id
 	p, ok := dc.cache.Get(key)
 	if ok {
 		return p.(Provider), nil
 	}
-	e := provider.Auth(user, pe.Key) // also add key
-	if e != nil {
+
+	// A cached provider is shared by every worker paying this researcher, so
+	// what goes into the cache must not be written to again. Providers with
+	// per-researcher state bind a fresh copy; the rest are authed in place.
+	if b, ok := provider.(BindingProvider); ok {
+		bound, e := b.Bind(user, pe.Key)
+		if e != nil {
+			return nil, e
+		}
+		provider = bound
+	} else if e := provider.Auth(user, pe.Key); e != nil {
 		return nil, e
 	}
 
@@ -173,24 +194,53 @@ func (dc *DC) payout(provider Provider, pe *PaymentEvent) (*Result, error) {
 	var res *Result
 	start := time.Now()
 
+	// An open breaker answers for the provider: CIRCUIT_OPEN is transient,
+	// so it is withheld like the failures that opened it (see breaker.go).
+	guard := guardFor(dc.cfg, pe)
+	if !guard.breaker.allow() {
+		return circuitOpenResult(pe, dc.cfg.BreakerCooldown), nil
+	}
+
+	call := func() (*Result, error) {
+		guard.acquire()
+		defer guard.release()
+		return provider.Payout(pe)
+	}
+
+	// Each attempt's verdict, for the breaker. Once it opens, this payment
+	// stops retrying too: it is withheld with the failure it has.
+	failed := func(err error) error {
+		guard.breaker.record(true)
+		if guard.breaker.open() {
+			return backoff.Permanent(err)
+		}
+		return err
+	}
+
 	op := func() error {
-		r, err := provider.Payout(pe)
+		r, err := call()
 		if err != nil {
-			return err
+			return failed(err)
 		}
 		if r == nil {
 			// A provider that answers (nil, nil) has told us nothing. The
 			// fake provider does exactly this when a payment carries no
 			// `result` block, and treating it as a verdict would dereference
 			// nil here and marshal "null" onto the wire below.
-			return fmt.Errorf("provider %s returned no result and no error", pe.Provider)
+			return failed(fmt.Errorf("provider %s returned no result and no error", pe.Provider))
 		}
 		res = r
+		if r.Pending {
+			// Accepted, not settled. Retrying would place a second order.
+			guard.breaker.record(false)
+			return nil
+		}
 		if !r.Success {
-			if recovery, _ := ClassifyResult(r); recovery == RecoveryTransient {
-				return &transientResultError{r}
+			if recovery, _ := classifyPayment(pe, r); recovery == RecoveryTransient {
+				return failed(&transientResultError{r})
 			}
 		}
+		guard.breaker.record(false)
 		return nil
 	}
 
@@ -217,25 +267,36 @@ func (dc *DC) payout(provider Provider, pe *PaymentEvent) (*Result, error) {
 // instead of in the respondent's state. That is the whole trade.
 func (dc *DC) deliver(pe *PaymentEvent, res *Result) error {
 	if res == nil {
-		recordFault("deliver")
-		return fmt.Errorf("nothing to deliver for user %s: no result was produced", pe.Userid)
+		return faultAt("deliver", fmt.Errorf("nothing to deliver for user %s: no result was produced", pe.Userid))
 	}
 
 	recordResult(pe, res)
 
+	// A failure the payment's fallback chain covers is not delivered: the
+	// next provider's verdict is (see fallback.go).
+	if next := dc.nextHop(pe, res); next != nil {
+		return dc.fallBack(pe, next, res)
+	}
+
 	if res.Success {
 		return dc.sendResult(pe, res)
 	}
 
-	recovery, known := ClassifyResult(res)
+	recovery, known := classifyPayment(pe, res)
 	code := ""
 	if res.Error != nil {
 		code = res.Error.Code
 	}
 
 	if !known {
-		log.Printf("DinersClub saw an unclassified %s error code %q for user %s -- treating it as permanent and telling the respondent. Add it to recoveryByCode in classify.go.",
+		log.Printf("DinersClub saw an unclassified %s error code %q for user %s -- treating it as permanent and telling the respondent. Classify it in payment_error_classifications.",
 			pe.Provider, code, pe.Userid)
+		if err := recordUnclassified(dc.pool, pe.Provider, code); err != nil {
+			// Triage loses a sighting; the metric still has it. Not worth
+			// failing a delivery over.
+			recordFault("classifications")
+			log.Printf("DinersClub could not record unclassified code %q: %v", code, err)
+		}
 	}
 
 	if recovery.Silent() {
@@ -244,6 +305,11 @@ func (dc *DC) deliver(pe *PaymentEvent, res *Result) error {
 		return nil
 	}
 
+	// The respondent is told in their survey's language; what the provider
+	// said stays here.
+	dc.explain(pe, res, recovery)
+	log.Printf("DinersClub sending %s failure for user %s: code=%s diagnostic=%q",
+		pe.Provider, pe.Userid, code, res.Diagnostic)
 	return dc.sendResult(pe, res)
 }
 
@@ -251,32 +317,63 @@ func (dc *DC) Job(pe *PaymentEvent) error {
 	validate := validator.New()
 	err := validate.Struct(pe)
 	if err != nil {
-		recordFault("validate")
-		return err
+		return faultAt("validate", err)
+	}
+
+	if pe.Chain == nil {
+		chain, err := parseFallback(pe)
+		if err != nil {
+			return dc.deliver(pe, invalidFallbackResult(pe, err))
+		}
+		pe.Chain = chain
 	}
 
 	if !contains(dc.cfg.Providers, pe.Provider) {
 		return dc.deliver(pe, invalidProviderResult(pe))
 	}
 
+	// A re-drive of a payment that already succeeded gets the stored Result,
+	// not a second payout. sendResult rather than deliver: the success was
+	// filed in metrics when it happened.
+	//
+	// A chain carries on from the hop it got to (see resumeChain).
+	pe.IdempotencyKey = idempotencyKey(pe)
+	pe, pending, err := dc.resumeChain(pe)
+	if err != nil {
+		return faultAt("ledger", err)
+	}
+	if pending {
+		log.Printf("DinersClub skipping %s payment %s for user %s: pending, the status poller will deliver it.",
+			pe.Provider, pe.IdempotencyKey, pe.Userid)
+		return nil
+	}
+	prior, err := lookupPayment(dc.pool, pe.IdempotencyKey)
+	if err != nil {
+		return faultAt("ledger", err)
+	}
+	if prior != nil {
+		recordReplay(pe)
+		log.Printf("DinersClub replaying recorded %s success for user %s (payment %s) instead of paying again.",
+			pe.Provider, pe.Userid, pe.IdempotencyKey)
+		return dc.sendResult(pe, prior)
+	}
+
 	provider, err := dc.getProviderFromEvent(pe)
 	if provider == nil {
 		return dc.deliver(pe, invalidProviderResult(pe))
 	}
 	if err != nil {
-		recordFault("provider")
-		return err
+		return faultAt("provider", err)
 	}
 
 	user, err := provider.GetUserFromPaymentEvent(pe)
 	if user == nil {
-		recordFault("user")
-		return fmt.Errorf(`User not found for page id: %s`, pe.Pageid)
+		return faultAt("user", fmt.Errorf(`User not found for page id: %s`, pe.Pageid))
 	}
 	if err != nil {
-		recordFault("user")
-		return err
+		return faultAt("user", err)
 	}
+	pe.Owner = user.Id
 
 	// An auth failure is a payment outcome, not a fault: AUTH_ERROR is a
 	// precondition, so deliver withholds it and the respondent waits while a
@@ -286,20 +383,102 @@ func (dc *DC) Job(pe *PaymentEvent) error {
 		return dc.deliver(pe, authError(pe, e))
 	}
 
+	// A provider-agnostic spec becomes this provider's own details here,
+	// before the ledger or a budget reads an amount from them (see spec.go).
+	unmet, err := dc.resolveSpec(provider, pe)
+	if err != nil {
+		return faultAt("resolve", err)
+	}
+	if unmet != nil {
+		return dc.deliver(pe, unmet)
+	}
+
+	// A wallet below the researcher's floor is treated as already empty.
+	floor, err := checkBalanceFloor(dc.pool, pe, user.Id, 3*dc.cfg.BalancePollInterval)
+	if err != nil {
+		return faultAt("balance", err)
+	}
+	if floor != "" {
+		return dc.deliver(pe, balanceBelowFloorResult(pe, floor))
+	}
+
+	// A recipient already paid for other respondents waits for someone to
+	// look at it (see review.go).
+	held, err := reviewPayment(dc.pool, pe, user.Id, dc.cfg)
+	if err != nil {
+		return faultAt("review", err)
+	}
+	if held != nil {
+		return dc.deliver(pe, held)
+	}
+
+	claimed, breach, err := claimPayment(dc.pool, pe, user.Id, dc.cfg.LedgerClaimTTL)
+	if err != nil {
+		return faultAt("ledger", err)
+	}
+	if breach != "" {
+		// BUDGET_EXCEEDED is a precondition, so deliver withholds it and the
+		// respondent waits for the cap to reset or be raised, exactly as
+		// they would for an empty wallet.
+		return dc.deliver(pe, budgetExceededResult(pe, breach))
+	}
+	if !claimed {
+		// Another worker holds this payment, or finished it between the
+		// lookup and here. Either way it is not ours to pay, and its Result
+		// will reach the respondent (or the next re-drive will replay it).
+		log.Printf("DinersClub skipping %s payment %s for user %s: already in flight.",
+			pe.Provider, pe.IdempotencyKey, pe.Userid)
+		return nil
+	}
+
 	res, err := dc.payout(provider, pe)
 	if err != nil {
 		// No verdict at all -- every attempt was a system fault. Nothing is
-		// sent, so the respondent stays parked and dean re-drives.
-		recordFault("payout")
+		// sent, so the respondent stays parked and dean re-drives. The claim
+		// is released so that re-drive need not wait out the TTL.
+		err = faultAt("payout", err)
+		if e := recordPayment(dc.pool, pe, nil); e != nil {
+			return errors.Join(err, faultAt("ledger", e))
+		}
 		return err
 	}
 
+	// Stamped before it is stored, so a replay or a settled pending payment
+	// is the chain's Result too.
+	pe.Chain.stamp(pe, res)
+
+	if res.Pending {
+		// Nothing is sent: the respondent stays in WAIT_EXTERNAL_EVENT and
+		// the status poller delivers the Result once the payment settles.
+		// A re-drive meanwhile finds the payment held and skips it.
+		recordPending(pe)
+		if err := recordPayment(dc.pool, pe, res); err != nil {
+			return faultAt("ledger", err)
+		}
+		log.Printf("DinersClub %s payment %s for user %s is pending; the status poller will deliver it once it settles.",
+			pe.Provider, pe.IdempotencyKey, pe.Userid)
+		return nil
+	}
+
+	// Record before delivering. If the send fails after this, the next
+	// re-drive replays the success; recorded after, it would pay again.
+	//
+	// A ledger that will not take the verdict does not stop the delivery:
+	// the money has moved either way, and delivering is what releases the
+	// respondent so no re-drive happens at all.
+	if err := recordPayment(dc.pool, pe, res); err != nil {
+		return errors.Join(faultAt("ledger", err), dc.deliver(pe, res))
+	}
+
 	return dc.deliver(pe, res)
 }
 
 func (dc *DC) Work(i interface{}) interface{} {
 	pe := i.(*PaymentEvent)
-	return dc.Job(pe)
+	if err := dc.Job(pe); err != nil {
+		return &jobError{pe, err}
+	}
+	return nil
 }
 
 func contains(s []string, target string) bool {
@@ -327,6 +506,10 @@ func getProvider(pool *pgxpool.Pool, event *PaymentEvent) (Provider, error) {
 		return NewHttpProvider(pool)
 	case "dingconnect":
 		return NewDingConnectProvider(pool)
+	case "tremendous":
+		return NewTremendousProvider(pool)
+	case "momo":
+		return NewMobileMoneyProvider(pool)
 	}
 	return nil, nil
 }
@@ -358,7 +541,36 @@ func checkError(err error) {
 
 func main() {
 	cfg := getConfig()
+
+	if len(os.Args) > 1 && os.Args[1] == "replay" {
+		if err := replayDeadLetters(cfg, os.Args[2:]); err != nil {
+			log.Fatalf("DinersClub replay failed: %v", err)
+		}
+		return
+	}
+
+	if cfg.CredentialsKeyring != "" {
+		keys, err := loadKeyring(cfg.CredentialsKeyring)
+		handle(err)
+		credentialKeys = keys
+	}
+
 	pool := getPool(cfg)
+
+	if len(os.Args) > 1 && os.Args[1] == "seal-credentials" {
+		if err := sealCredentials(pool, credentialKeys, os.Args[2:]); err != nil {
+			log.Fatalf("DinersClub seal-credentials failed: %v", err)
+		}
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "reconcile" {
+		if err := reconcilePayments(pool, getProvider, os.Args[2:], os.Stdout); err != nil {
+			log.Fatalf("DinersClub reconcile failed: %v", err)
+		}
+		return
+	}
+
 	bp := botparty.NewBotParty(cfg.Botserver)
 	cache, err := ristretto.NewCache(&ristretto.Config{
 		NumCounters: cfg.CacheNumCounters,
@@ -366,11 +578,36 @@ func main() {
 		BufferItems: cfg.CacheBufferItems,
 	})
 	handle(err)
+	_, err = parseProviderLimits(cfg.ProviderConcurrency)
+	handle(err)
+	handle(checkReviewConfig(cfg))
 	dc := &DC{cfg, pool, bp, cache, getProvider}
 
 	// Metrics are how a withheld failure stays accountable -- see metrics.go.
 	go serveMetrics(cfg.MetricsPort)
 
+	go dc.pollStatuses()
+
+	go dc.reloadClassifications()
+
+	if cfg.BalancePollInterval > 0 {
+		go dc.monitorBalances()
+	}
+
+	if cfg.DeadLetterTopic != "" {
+		dls, err := newKafkaDeadLetters(cfg.KafkaBrokers, cfg.DeadLetterTopic)
+		handle(err)
+		deadLetters = dls
+	}
+
+	if cfg.CallbackURL != "" {
+		go dc.serveCallbacks(cfg.CallbackPort)
+	}
+
+	if cfg.AdminToken != "" {
+		go dc.serveAdmin(cfg.AdminPort)
+	}
+
 	c := spine.NewKafkaConsumer(cfg.KafkaTopic, cfg.KafkaBrokers, cfg.KafkaGroup,
 		cfg.KafkaPollTimeout, cfg.KafkaBatchSize, cfg.KafkaBatchSize)
 
@@ -116,6 +116,61 @@ var (
 		Name: "dinersclub_processing_faults_total",
 		Help: "Faults in dinersclub itself, by stage. Not payment failures -- see dinersclub_payment_results_total for those.",
 	}, []string{"stage"})
+
+	// paymentReplays counts re-drives answered from the payments ledger
+	// instead of the provider -- each one is a payment that would have been
+	// made twice before the ledger existed. They are deliberately NOT counted
+	// in paymentResults, which already saw the original success.
+	paymentReplays = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "dinersclub_payment_replays_total",
+		Help: "Re-driven payments answered from the ledger with the stored success instead of paying again.",
+	}, []string{"provider"})
+
+	// pendingPayments counts payments a provider accepted without settling.
+	// Each one is parked with the status poller and reaches paymentResults
+	// only when it settles, so a gap between this and the pending results
+	// that later land is the number still waiting.
+	pendingPayments = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "dinersclub_payments_pending_total",
+		Help: "Payments accepted by the provider but not yet settled, handed to the status poller.",
+	}, []string{"provider"})
+
+	// providerBalances is the last balance read from each researcher's
+	// provider account (balance.go), in the provider's currency. Alert on it
+	// falling, not on INSUFFICIENT_BALANCE arriving: by then respondents are
+	// already parked. A check that fails leaves the last value in place;
+	// dinersclub_processing_faults_total{stage="balance"} says so.
+	providerBalances = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "dinersclub_provider_balance",
+		Help: "Last provider account balance per researcher credential, in the provider's currency.",
+	}, []string{"owner", "provider", "key", "currency"})
+
+	// breakerStates is each circuit breaker: 0 closed, 1 half-open (a
+	// probe is out), 2 open. While it is 2, that breaker's payments are
+	// withheld as CIRCUIT_OPEN without being attempted, so
+	// dinersclub_payment_results_total shows them and the provider's own
+	// codes stop. owner is set only for http, whose breakers are per
+	// researcher. See breaker.go.
+	breakerStates = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "dinersclub_circuit_breaker_state",
+		Help: "Circuit breaker state per provider (and owner, for http): 0 closed, 1 half-open, 2 open.",
+	}, []string{"provider", "owner"})
+
+	// breakerOpens counts breakers opening, including reopening after a
+	// failed probe. A state gauge sampled every scrape can miss a breaker
+	// that opened and closed in between; this cannot.
+	breakerOpens = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "dinersclub_circuit_breaker_opens_total",
+		Help: "Times a provider's circuit breaker opened.",
+	}, []string{"provider", "owner"})
+
+	// paymentFallbacks counts payments handed to the next provider in their
+	// fallback chain (fallback.go). The failure that caused it is still in
+	// paymentResults, under the provider that gave it.
+	paymentFallbacks = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "dinersclub_payment_fallbacks_total",
+		Help: "Payments passed to the next provider in their fallback chain, by provider, next provider and error code.",
+	}, []string{"provider", "next", "code"})
 )
 
 // providerOf names the provider for a metric label. It reads the PaymentEvent
@@ -137,7 +192,7 @@ func recordResult(pe *PaymentEvent, res *Result) {
 		return
 	}
 
-	recovery, known := ClassifyResult(res)
+	recovery, known := classifyPayment(pe, res)
 	code := codeUnclassified
 	if res != nil && res.Error != nil && known {
 		code = res.Error.Code
@@ -156,6 +211,36 @@ func recordFault(stage string) {
 	processingFaults.WithLabelValues(stage).Inc()
 }
 
+// recordReplay files a re-drive answered from the ledger.
+func recordReplay(pe *PaymentEvent) {
+	paymentReplays.WithLabelValues(providerOf(pe)).Inc()
+}
+
+// recordBalance publishes a balance.
+func recordBalance(t balanceTarget, b *Balance) {
+	providerBalances.WithLabelValues(t.owner, t.provider, t.key, b.Currency).Set(b.Amount)
+}
+
+// recordBreakerState publishes a breaker's state.
+func recordBreakerState(provider, owner string, s breakerState) {
+	breakerStates.WithLabelValues(provider, owner).Set(float64(s))
+}
+
+// recordBreakerOpen files a breaker opening.
+func recordBreakerOpen(provider, owner string) {
+	breakerOpens.WithLabelValues(provider, owner).Inc()
+}
+
+// recordFallback files a payment handed on to the next provider.
+func recordFallback(pe, next *PaymentEvent, code string) {
+	paymentFallbacks.WithLabelValues(providerOf(pe), providerOf(next), code).Inc()
+}
+
+// recordPending files a payment handed to the status poller.
+func recordPending(pe *PaymentEvent) {
+	pendingPayments.WithLabelValues(providerOf(pe)).Inc()
+}
+
 // observePayout records how long the payout step took.
 func observePayout(pe *PaymentEvent, res *Result, d time.Duration) {
 	outcome := outcomeFailure
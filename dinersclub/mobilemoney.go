@@ -0,0 +1,511 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+const (
+	momoBaseURL        = "https://proxy.momoapi.mtn.com"
+	momoSandboxBaseURL = "https://sandbox.momodeveloper.mtn.com"
+)
+
+// MobileMoneyProvider pays respondents straight into a mobile-money wallet
+// through a B2C disbursement API, in the shape of MTN MoMo's Disbursements
+// product: an OAuth client-credentials token, an asynchronous transfer keyed
+// by a caller-chosen reference id, and a status lookup on that id.
+//
+// Transfers are never settled by the request that makes them -- the API
+// answers 202 Accepted -- so Payout always returns a Pending Result and the
+// status poller settles it (see poller.go). When DINERSCLUB_CALLBACK_URL is
+// set, the API is also asked to call us back, and the callback makes the
+// poller ask at once instead of at its next sweep (see callbacks.go).
+//
+// Reference ids are derived from the payment's idempotency key, so a
+// re-driven payment that already reached the API is answered with
+// RESOURCE_ALREADY_EXIST rather than paid twice, and we go and read the
+// transfer we already made (see Payout).
+type MobileMoneyProvider struct {
+	pool        *pgxpool.Pool
+	client      *http.Client
+	baseURL     string
+	callbackURL string
+	sandbox     bool
+	creds       momoCredentials
+	timeout     time.Duration
+
+	// Cached providers are shared by every worker paying for the same
+	// researcher, so the token is refreshed under a lock.
+	mu          sync.Mutex
+	token       string
+	tokenExpiry time.Time
+}
+
+// momoCredentials are the researcher's API user, its key and the product
+// subscription key, stored as entity='momo'. Three values that only work
+// together, so -- like Reloadly's id and secret -- they get an entity rather
+// than three Generic Secrets (see secretForUser).
+type momoCredentials struct {
+	APIUser           string `json:"api_user"`
+	APIKey            string `json:"api_key"`
+	SubscriptionKey   string `json:"subscription_key"`
+	TargetEnvironment string `json:"target_environment"`
+}
+
+// MobileMoneyPaymentDetails is the researcher-facing payment configuration.
+//
+// calling_code is the country's calling code without the plus ("256" for
+// Uganda). It is optional, but without it a number in national format
+// (0772...) cannot be normalised and is refused.
+type MobileMoneyPaymentDetails struct {
+	ID           string  `json:"id"`
+	Amount       float64 `json:"amount"`
+	Currency     string  `json:"currency"`
+	Phone        string  `json:"phone"`
+	CallingCode  string  `json:"calling_code"`
+	PayerMessage string  `json:"payer_message"`
+	PayeeNote    string  `json:"payee_note"`
+}
+
+type momoTransfer struct {
+	Amount     string `json:"amount"`
+	Currency   string `json:"currency"`
+	ExternalID string `json:"externalId"`
+	Payee      struct {
+		PartyIDType string `json:"partyIdType"`
+		PartyID     string `json:"partyId"`
+	} `json:"payee"`
+	PayerMessage string `json:"payerMessage"`
+	PayeeNote    string `json:"payeeNote"`
+}
+
+type momoTransferStatus struct {
+	ReferenceID            string `json:"referenceId"`
+	FinancialTransactionID string `json:"financialTransactionId"`
+	ExternalID             string `json:"externalId"`
+	Status                 string `json:"status"`
+	Reason                 string `json:"reason"`
+}
+
+type momoError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Transfer statuses.
+const (
+	momoSuccessful = "SUCCESSFUL"
+	momoPending    = "PENDING"
+	momoFailed     = "FAILED"
+)
+
+// The API's error codes, as they appear both in an error response's `code`
+// and in a failed transfer's `reason`. They are prefixed MOMO_ on the way
+// out: several (NOT_ALLOWED, INTERNAL_PROCESSING_ERROR) are generic enough to
+// collide with another provider's, and recoveryByCode is one flat namespace.
+// Codes not listed here are passed through prefixed all the same and fall to
+// the unknown-code default until someone classifies them.
+var momoKnownCodes = map[string]bool{
+	"PAYEE_NOT_FOUND":                true,
+	"PAYEE_NOT_ALLOWED_TO_RECEIVE":   true,
+	"PAYER_NOT_FOUND":                true,
+	"PAYER_LIMIT_REACHED":            true,
+	"NOT_ENOUGH_FUNDS":               true,
+	"NOT_ALLOWED":                    true,
+	"NOT_ALLOWED_TARGET_ENVIRONMENT": true,
+	"INVALID_CALLBACK_URL_HOST":      true,
+	"INVALID_CURRENCY":               true,
+	"TRANSACTION_CANCELED":           true,
+	"EXPIRED":                        true,
+	"APPROVAL_REJECTED":              true,
+	"SERVICE_UNAVAILABLE":            true,
+	"INTERNAL_PROCESSING_ERROR":      true,
+	"COULD_NOT_PERFORM_TRANSACTION":  true,
+}
+
+// momoMaxAttempts bounds how many transfers one payment may make. Each failed
+// transfer is a retry dean asked for; this many means it is not going to work.
+const momoMaxAttempts = 10
+
+func momoCode(code string) string {
+	return "MOMO_" + code
+}
+
+func NewMobileMoneyProvider(pool *pgxpool.Pool) (Provider, error) {
+	cfg := getConfig()
+
+	// Bounded for the same reason as every other provider: see the comment
+	// in NewReloadlyProvider.
+	client := &http.Client{Timeout: cfg.ProviderTimeout}
+
+	baseURL := momoBaseURL
+	if cfg.Sandbox {
+		baseURL = momoSandboxBaseURL
+	}
+	return &MobileMoneyProvider{
+		pool:        pool,
+		client:      client,
+		baseURL:     baseURL,
+		callbackURL: cfg.CallbackURL,
+		sandbox:     cfg.Sandbox,
+		timeout:     cfg.ProviderTimeout,
+	}, nil
+}
+
+// callTimeout bounds one API request. A provider built without a timeout
+// gets the default.
+func (p *MobileMoneyProvider) callTimeout() time.Duration {
+	if p.timeout > 0 {
+		return p.timeout
+	}
+	return defaultProviderTimeout
+}
+
+func (p *MobileMoneyProvider) GetUserFromPaymentEvent(event *PaymentEvent) (*User, error) {
+	return GenericGetUser(p.pool, event)
+}
+
+// Auth loads the researcher's credentials and fetches a first token, so bad
+// credentials surface as an auth error before anything is claimed.
+func (p *MobileMoneyProvider) Auth(user *User, key string) error {
+	if key == "" {
+		return fmt.Errorf(`No key provided for mobile money provider. A key is required for mobile money Payment Events!`)
+	}
+
+	query := `SELECT details FROM credentials WHERE entity='momo' AND userid=$1 AND key=$2 LIMIT 1`
+	var details json.RawMessage
+	err := p.pool.QueryRow(context.Background(), query, user.Id, key).Scan(&details)
+	if err == pgx.ErrNoRows {
+		return fmt.Errorf(`No mobile money credentials were found for user: %s`, user.Id)
+	}
+	if err != nil {
+		return err
+	}
+
+	if details, err = openDetails(credentialKeys, details); err != nil {
+		return err
+	}
+	creds := momoCredentials{}
+	if err := json.Unmarshal(details, &creds); err != nil {
+		return err
+	}
+	if creds.APIUser == "" || creds.APIKey == "" || creds.SubscriptionKey == "" {
+		return fmt.Errorf(`Mobile money credentials %q need api_user, api_key and subscription_key`, key)
+	}
+	if creds.TargetEnvironment == "" && p.sandbox {
+		creds.TargetEnvironment = "sandbox"
+	}
+	if creds.TargetEnvironment == "" {
+		return fmt.Errorf(`Mobile money credentials %q need a target_environment outside the sandbox`, key)
+	}
+	p.creds = creds
+
+	_, err = p.accessToken()
+	return err
+}
+
+// accessToken returns the current token, fetching a new one when it is
+// missing or about to expire. A cached provider outlives its token: the
+// cache TTL is ours, the token's lifetime is theirs.
+func (p *MobileMoneyProvider) accessToken() (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.token != "" && time.Now().Before(p.tokenExpiry) {
+		return p.token, nil
+	}
+
+	req, err := http.NewRequest("POST", p.baseURL+"/disbursement/token/", nil)
+	if err != nil {
+		return "", err
+	}
+	req.SetBasicAuth(p.creds.APIUser, p.creds.APIKey)
+	req.Header.Set("Ocp-Apim-Subscription-Key", p.creds.SubscriptionKey)
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("mobile money token request failed: HTTP %d: %s", resp.StatusCode, body)
+	}
+
+	token := struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}{}
+	if err := json.Unmarshal(body, &token); err != nil {
+		return "", err
+	}
+	if token.AccessToken == "" {
+		return "", fmt.Errorf("mobile money token response has no access_token")
+	}
+
+	// Refresh a minute early so a token never expires between being handed
+	// out here and being checked at the other end.
+	p.token = token.AccessToken
+	p.tokenExpiry = time.Now().Add(time.Duration(token.ExpiresIn)*time.Second - time.Minute)
+	return p.token, nil
+}
+
+func (p *MobileMoneyProvider) Payout(event *PaymentEvent) (*Result, error) {
+	details := new(MobileMoneyPaymentDetails)
+	if err := json.Unmarshal(*event.Details, details); err != nil {
+		return handleJSONUnmarshalError("momo", err, event.Details), nil
+	}
+
+	result := &Result{Type: "payment:momo", ID: details.ID}
+
+	switch {
+	case details.Amount <= 0:
+		return formatError(result, event, "amount must be positive", "INVALID_PAYMENT_DETAILS")
+	case details.Currency == "":
+		return formatError(result, event, "Missing currency", "INVALID_PAYMENT_DETAILS")
+	}
+
+	msisdn, err := normaliseMSISDN(details.Phone, details.CallingCode)
+	if err != nil {
+		return formatError(result, event, err.Error(), "INVALID_RECIPIENT_PHONE")
+	}
+	result.Phone = &msisdn
+
+	transfer := momoTransfer{
+		Amount:       strconv.FormatFloat(details.Amount, 'f', -1, 64),
+		Currency:     details.Currency,
+		ExternalID:   event.IdempotencyKey,
+		PayerMessage: details.PayerMessage,
+		PayeeNote:    details.PayeeNote,
+	}
+	transfer.Payee.PartyIDType = "MSISDN"
+	transfer.Payee.PartyID = msisdn
+
+	body, err := json.Marshal(transfer)
+	if err != nil {
+		return nil, err
+	}
+
+	// Each attempt at this payment has its own reference id, derived from
+	// the idempotency key and the attempt number. The API refuses a reused
+	// id with 409, so walking the chain finds the transfer an earlier,
+	// interrupted attempt already made instead of making another. Only a
+	// transfer that FAILED is passed over: paying again after a failure is
+	// the retry dean asked for.
+	for attempt := 0; attempt < momoMaxAttempts; attempt++ {
+		ref := momoReferenceID(event.IdempotencyKey, attempt)
+		headers := map[string]string{"X-Reference-Id": ref}
+		if p.callbackURL != "" {
+			headers["X-Callback-Url"] = strings.TrimRight(p.callbackURL, "/") + "/callbacks/momo/" + event.IdempotencyKey
+		}
+
+		status, resp, err := p.request("POST", "/disbursement/v1_0/transfer", body, headers)
+		if err != nil {
+			// Never reached a verdict. Safe to retry: the same reference id
+			// is tried first next time.
+			return formatError(result, event, err.Error(), "HTTP_REQUEST_FAILED")
+		}
+
+		switch status {
+		case http.StatusAccepted:
+			transfer := &momoTransferStatus{ReferenceID: ref, ExternalID: event.IdempotencyKey, Status: momoPending}
+			raw, _ := json.Marshal(transfer)
+			return momoStatusResult(result, event, transfer, raw)
+		case http.StatusConflict:
+			transfer, raw, err := p.transfer(ref)
+			if err != nil {
+				return nil, err
+			}
+			if transfer.Status == momoFailed {
+				continue
+			}
+			return momoStatusResult(result, event, transfer, raw)
+		default:
+			return momoErrorResult(result, event, status, resp)
+		}
+	}
+	return formatError(result, event,
+		fmt.Sprintf("Mobile money transfer failed %d times", momoMaxAttempts), "MOMO_ATTEMPTS_EXHAUSTED")
+}
+
+// Status reads the transfer a pending payment made.
+func (p *MobileMoneyProvider) Status(event *PaymentEvent, pending *Result) (*Result, error) {
+	prev := new(momoTransferStatus)
+	if pending.Response != nil {
+		if err := json.Unmarshal(*pending.Response, prev); err != nil {
+			return nil, err
+		}
+	}
+	if prev.ReferenceID == "" {
+		return nil, fmt.Errorf("pending mobile money payment has no reference id")
+	}
+
+	transfer, raw, err := p.transfer(prev.ReferenceID)
+	if err != nil {
+		return nil, err
+	}
+	result := &Result{Type: pending.Type, ID: pending.ID, Phone: pending.Phone}
+	return momoStatusResult(result, event, transfer, raw)
+}
+
+// transfer looks a transfer up by its reference id. Any failure to read it is
+// an error, not a Result: it says nothing about the transfer itself.
+func (p *MobileMoneyProvider) transfer(ref string) (*momoTransferStatus, []byte, error) {
+	status, resp, err := p.request("GET", "/disbursement/v1_0/transfer/"+ref, nil, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	if status != http.StatusOK {
+		return nil, nil, fmt.Errorf("mobile money transfer %s: HTTP %d: %s", ref, status, resp)
+	}
+
+	transfer := new(momoTransferStatus)
+	if err := json.Unmarshal(resp, transfer); err != nil {
+		return nil, nil, err
+	}
+	// The lookup does not echo the id it was asked about, and Status needs
+	// it next time.
+	transfer.ReferenceID = ref
+	raw, err := json.Marshal(transfer)
+	return transfer, raw, err
+}
+
+func momoStatusResult(result *Result, event *PaymentEvent, transfer *momoTransferStatus, raw []byte) (*Result, error) {
+	response := json.RawMessage(raw)
+	result.Response = &response
+
+	switch transfer.Status {
+	case momoSuccessful:
+		result.Success = true
+		result.Timestamp = time.Now().UTC()
+		result.PaymentDetails = event.Details
+		return result, nil
+	case momoPending:
+		result.Pending = true
+		return result, nil
+	case momoFailed:
+		reason := transfer.Reason
+		if reason == "" {
+			reason = "COULD_NOT_PERFORM_TRANSACTION"
+		}
+		return formatError(result, event, fmt.Sprintf("Mobile money transfer failed: %s", reason), momoCode(reason))
+	}
+	return formatError(result, event,
+		fmt.Sprintf("Unexpected mobile money transfer status: %q", transfer.Status), "INVALID_RESPONSE")
+}
+
+func (p *MobileMoneyProvider) request(method, path string, body []byte, headers map[string]string) (int, []byte, error) {
+	token, err := p.accessToken()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), p.callTimeout())
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, method, p.baseURL+path, bytes.NewReader(body))
+	if err != nil {
+		return 0, nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Ocp-Apim-Subscription-Key", p.creds.SubscriptionKey)
+	req.Header.Set("X-Target-Environment", p.creds.TargetEnvironment)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+
+	b, err := io.ReadAll(resp.Body)
+	return resp.StatusCode, b, err
+}
+
+func momoErrorResult(result *Result, event *PaymentEvent, status int, body []byte) (*Result, error) {
+	e := new(momoError)
+	if err := json.Unmarshal(body, e); err != nil || e.Code == "" {
+		// No code to go on. The bare status is what http_provider.go does,
+		// and classify.go already knows what 5xx and 429 mean.
+		return formatError(result, event, string(body), strconv.Itoa(status))
+	}
+	if status >= 500 && !momoKnownCodes[e.Code] {
+		return formatError(result, event, e.Message, strconv.Itoa(status))
+	}
+	return formatError(result, event, e.Message, momoCode(e.Code))
+}
+
+// momoReferenceID derives an attempt's X-Reference-Id from the payment's
+// idempotency key. The API insists on a version 4 UUID, so the hash is
+// stamped with version 4 bits: deterministic, but shaped like a random one.
+func momoReferenceID(key string, attempt int) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("momo:%s:%d", key, attempt)))
+	b := sum[:16]
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	id, _ := uuid.FromBytes(b)
+	return id.String()
+}
+
+// normaliseMSISDN turns a phone number as a respondent typed it into the
+// international digits-only form the API wants as an MSISDN: no plus, no
+// spaces, no leading 00 or trunk 0.
+func normaliseMSISDN(phone, callingCode string) (string, error) {
+	n := strings.Map(func(r rune) rune {
+		switch r {
+		case ' ', '-', '(', ')', '.':
+			return -1
+		}
+		return r
+	}, phone)
+
+	switch {
+	case strings.HasPrefix(n, "+"):
+		n = n[1:]
+	case strings.HasPrefix(n, "00"):
+		n = n[2:]
+	case strings.HasPrefix(n, "0"):
+		if callingCode == "" {
+			return "", fmt.Errorf("Phone number %q is in national format and the payment has no calling_code", phone)
+		}
+		n = callingCode + n[1:]
+	}
+
+	if n == "" {
+		return "", fmt.Errorf("Missing phone number")
+	}
+	for _, r := range n {
+		if r < '0' || r > '9' {
+			return "", fmt.Errorf("Phone number %q is not a valid number", phone)
+		}
+	}
+	// E.164 caps a number at 15 digits; nothing real is shorter than 8.
+	if len(n) < 8 || len(n) > 15 {
+		return "", fmt.Errorf("Phone number %q is not a valid number", phone)
+	}
+	if callingCode != "" && !strings.HasPrefix(n, callingCode) {
+		return "", fmt.Errorf("Phone number %q is not a +%s number", phone, callingCode)
+	}
+	return n, nil
+}
@@ -0,0 +1,428 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/stretchr/testify/assert"
+)
+
+// momoAPI is a local stand-in for a disbursement API. transfers maps a
+// reference id to the status the lookup reports for it; a POST for an id
+// already in it is answered 409, as the real API does.
+type momoAPI struct {
+	transfers map[string]string
+	tokens    int32
+	posted    []*http.Request
+	bodies    []map[string]interface{}
+	postError *momoError
+	postDelay time.Duration
+}
+
+func (m *momoAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch {
+	case r.Method == "POST" && r.URL.Path == "/disbursement/token/":
+		user, key, _ := r.BasicAuth()
+		if user != "api-user" || key != "api-key" || r.Header.Get("Ocp-Apim-Subscription-Key") != "sub-key" {
+			w.WriteHeader(401)
+			return
+		}
+		atomic.AddInt32(&m.tokens, 1)
+		fmt.Fprint(w, `{"access_token": "token-1", "token_type": "access_token", "expires_in": 3600}`)
+
+	case r.Method == "POST" && r.URL.Path == "/disbursement/v1_0/transfer":
+		var body map[string]interface{}
+		b, _ := io.ReadAll(r.Body)
+		json.Unmarshal(b, &body)
+		m.posted = append(m.posted, r)
+		m.bodies = append(m.bodies, body)
+		time.Sleep(m.postDelay)
+
+		if m.postError != nil {
+			w.WriteHeader(400)
+			json.NewEncoder(w).Encode(m.postError)
+			return
+		}
+		ref := r.Header.Get("X-Reference-Id")
+		if _, ok := m.transfers[ref]; ok {
+			w.WriteHeader(409)
+			fmt.Fprint(w, `{"code": "RESOURCE_ALREADY_EXIST", "message": "Duplicated reference id"}`)
+			return
+		}
+		m.transfers[ref] = momoPending
+		w.WriteHeader(202)
+
+	case r.Method == "GET":
+		ref := r.URL.Path[len("/disbursement/v1_0/transfer/"):]
+		status, ok := m.transfers[ref]
+		if !ok {
+			w.WriteHeader(404)
+			return
+		}
+		reason := ""
+		if status == momoFailed {
+			reason = "PAYEE_NOT_FOUND"
+		}
+		fmt.Fprintf(w, `{"amount": "500", "currency": "UGX", "externalId": "key-123", "status": %q, "reason": %q}`, status, reason)
+
+	default:
+		w.WriteHeader(404)
+	}
+}
+
+func momoProvider(t *testing.T, api *momoAPI) *MobileMoneyProvider {
+	t.Helper()
+	if api.transfers == nil {
+		api.transfers = map[string]string{}
+	}
+	srv := httptest.NewServer(api)
+	t.Cleanup(srv.Close)
+	return &MobileMoneyProvider{
+		client:  srv.Client(),
+		baseURL: srv.URL,
+		creds:   momoCredentials{"api-user", "api-key", "sub-key", "sandbox"},
+	}
+}
+
+func momoEvent(details string) *PaymentEvent {
+	d := json.RawMessage([]byte(details))
+	return &PaymentEvent{Provider: "momo", Details: &d, IdempotencyKey: "key-123"}
+}
+
+const validMomoDetails = `{
+	"id": "PAY001",
+	"amount": 500,
+	"currency": "UGX",
+	"phone": "0772 123-456",
+	"calling_code": "256",
+	"payee_note": "Thanks for taking part"
+}`
+
+func TestMobileMoneyRequestFormat(t *testing.T) {
+	api := &momoAPI{}
+	p := momoProvider(t, api)
+	p.callbackURL = "https://dinersclub.example/"
+
+	res, err := p.Payout(momoEvent(validMomoDetails))
+	assert.Nil(t, err)
+	assert.True(t, res.Pending)
+
+	assert.Equal(t, int32(1), api.tokens)
+	assert.Len(t, api.posted, 1)
+	req, body := api.posted[0], api.bodies[0]
+
+	assert.Equal(t, "Bearer token-1", req.Header.Get("Authorization"))
+	assert.Equal(t, "sub-key", req.Header.Get("Ocp-Apim-Subscription-Key"))
+	assert.Equal(t, "sandbox", req.Header.Get("X-Target-Environment"))
+	assert.Equal(t, momoReferenceID("key-123", 0), req.Header.Get("X-Reference-Id"))
+	assert.Equal(t, "https://dinersclub.example/callbacks/momo/key-123", req.Header.Get("X-Callback-Url"))
+
+	assert.Equal(t, "500", body["amount"])
+	assert.Equal(t, "UGX", body["currency"])
+	assert.Equal(t, "key-123", body["externalId"])
+	assert.Equal(t, map[string]interface{}{"partyIdType": "MSISDN", "partyId": "256772123456"}, body["payee"])
+	assert.Equal(t, "256772123456", *res.Phone)
+}
+
+func TestMobileMoneyNoCallbackUrlWhenUnconfigured(t *testing.T) {
+	api := &momoAPI{}
+	p := momoProvider(t, api)
+
+	_, err := p.Payout(momoEvent(validMomoDetails))
+	assert.Nil(t, err)
+	assert.Empty(t, api.posted[0].Header.Get("X-Callback-Url"))
+}
+
+func TestMobileMoneyTokenIsReused(t *testing.T) {
+	api := &momoAPI{}
+	p := momoProvider(t, api)
+
+	p.Payout(momoEvent(validMomoDetails))
+	p.Payout(momoEvent(validMomoDetails))
+	assert.Equal(t, int32(1), api.tokens)
+}
+
+func TestMobileMoneyBadCredentialsFailTheTokenFetch(t *testing.T) {
+	p := momoProvider(t, &momoAPI{})
+	p.creds.APIKey = "wrong"
+
+	_, err := p.accessToken()
+	assert.NotNil(t, err)
+}
+
+func TestMobileMoneyProviderTimeoutBoundsTheTransfer(t *testing.T) {
+	p := momoProvider(t, &momoAPI{postDelay: 500 * time.Millisecond})
+	p.timeout = 50 * time.Millisecond
+
+	res, err := p.Payout(momoEvent(validMomoDetails))
+	assert.Nil(t, err)
+	assert.False(t, res.Success)
+	assert.False(t, res.Pending)
+	assert.Equal(t, "HTTP_REQUEST_FAILED", res.Error.Code)
+}
+
+func TestNewMobileMoneyProvider_UsesTheProviderTimeout(t *testing.T) {
+	t.Setenv("DINERSCLUB_PROVIDER_TIMEOUT", "15s")
+	provider, err := NewMobileMoneyProvider(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 15*time.Second, provider.(*MobileMoneyProvider).callTimeout())
+}
+
+func TestMobileMoneyPendingTransferIsPolledUntilSettled(t *testing.T) {
+	api := &momoAPI{}
+	p := momoProvider(t, api)
+	event := momoEvent(validMomoDetails)
+
+	res, err := p.Payout(event)
+	assert.Nil(t, err)
+	assert.True(t, res.Pending)
+
+	res, err = p.Status(event, res)
+	assert.Nil(t, err)
+	assert.True(t, res.Pending)
+
+	api.transfers[momoReferenceID("key-123", 0)] = momoSuccessful
+	res, err = p.Status(event, res)
+	assert.Nil(t, err)
+	assert.True(t, res.Success)
+	assert.Equal(t, "payment:momo", res.Type)
+	assert.Equal(t, "PAY001", res.ID)
+	assert.Equal(t, "256772123456", *res.Phone)
+}
+
+func TestMobileMoneyFailedTransferCarriesItsReason(t *testing.T) {
+	api := &momoAPI{}
+	p := momoProvider(t, api)
+	event := momoEvent(validMomoDetails)
+
+	res, _ := p.Payout(event)
+	api.transfers[momoReferenceID("key-123", 0)] = momoFailed
+
+	res, err := p.Status(event, res)
+	assert.Nil(t, err)
+	assert.False(t, res.Success)
+	assert.Equal(t, "MOMO_PAYEE_NOT_FOUND", res.Error.Code)
+}
+
+// A re-driven payment whose transfer already exists must be read, not made
+// again. A failed one is retried as the next attempt's transfer.
+func TestMobileMoneyRedriveFindsTheExistingTransfer(t *testing.T) {
+	first, second := momoReferenceID("key-123", 0), momoReferenceID("key-123", 1)
+
+	t.Run("successful", func(t *testing.T) {
+		api := &momoAPI{transfers: map[string]string{first: momoSuccessful}}
+		res, err := momoProvider(t, api).Payout(momoEvent(validMomoDetails))
+
+		assert.Nil(t, err)
+		assert.True(t, res.Success)
+		assert.Len(t, api.transfers, 1, "no second transfer is made")
+	})
+
+	t.Run("pending", func(t *testing.T) {
+		api := &momoAPI{transfers: map[string]string{first: momoPending}}
+		res, err := momoProvider(t, api).Payout(momoEvent(validMomoDetails))
+
+		assert.Nil(t, err)
+		assert.True(t, res.Pending)
+		assert.Contains(t, string(*res.Response), first)
+		assert.Len(t, api.transfers, 1)
+	})
+
+	t.Run("failed", func(t *testing.T) {
+		api := &momoAPI{transfers: map[string]string{first: momoFailed}}
+		res, err := momoProvider(t, api).Payout(momoEvent(validMomoDetails))
+
+		assert.Nil(t, err)
+		assert.True(t, res.Pending)
+		assert.Equal(t, momoPending, api.transfers[second])
+		assert.Contains(t, string(*res.Response), second, "Status must poll the new transfer")
+	})
+
+	t.Run("exhausted", func(t *testing.T) {
+		api := &momoAPI{transfers: map[string]string{}}
+		for i := 0; i < momoMaxAttempts; i++ {
+			api.transfers[momoReferenceID("key-123", i)] = momoFailed
+		}
+		res, err := momoProvider(t, api).Payout(momoEvent(validMomoDetails))
+
+		assert.Nil(t, err)
+		assert.Equal(t, "MOMO_ATTEMPTS_EXHAUSTED", res.Error.Code)
+	})
+}
+
+func TestMobileMoneyErrorCodeMapping(t *testing.T) {
+	tests := []struct {
+		code     string
+		want     string
+		recovery Recovery
+	}{
+		{"NOT_ENOUGH_FUNDS", "MOMO_NOT_ENOUGH_FUNDS", RecoveryPrecondition},
+		{"PAYER_LIMIT_REACHED", "MOMO_PAYER_LIMIT_REACHED", RecoveryPrecondition},
+		{"NOT_ALLOWED_TARGET_ENVIRONMENT", "MOMO_NOT_ALLOWED_TARGET_ENVIRONMENT", RecoveryPrecondition},
+		{"PAYEE_NOT_FOUND", "MOMO_PAYEE_NOT_FOUND", RecoveryPermanent},
+		{"INVALID_CURRENCY", "MOMO_INVALID_CURRENCY", RecoveryPermanent},
+		{"SERVICE_UNAVAILABLE", "MOMO_SERVICE_UNAVAILABLE", RecoveryTransient},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.code, func(t *testing.T) {
+			api := &momoAPI{postError: &momoError{tt.code, "Sorry"}}
+			res, err := momoProvider(t, api).Payout(momoEvent(validMomoDetails))
+
+			assert.Nil(t, err, "payment failures are Results, not errors")
+			assert.False(t, res.Success)
+			assert.Equal(t, tt.want, res.Error.Code)
+
+			recovery, known := Classify(res.Error.Code)
+			assert.True(t, known)
+			assert.Equal(t, tt.recovery, recovery)
+		})
+	}
+}
+
+func TestMobileMoneyValidationErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		details string
+		code    string
+	}{
+		{"no amount", `{"currency": "UGX", "phone": "+256772123456"}`, "INVALID_PAYMENT_DETAILS"},
+		{"no currency", `{"amount": 500, "phone": "+256772123456"}`, "INVALID_PAYMENT_DETAILS"},
+		{"bad phone", `{"amount": 500, "currency": "UGX", "phone": "call me"}`, "INVALID_RECIPIENT_PHONE"},
+		{"wrong country", `{"amount": 500, "currency": "UGX", "phone": "+254712345678", "calling_code": "256"}`, "INVALID_RECIPIENT_PHONE"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := &momoAPI{}
+			res, err := momoProvider(t, api).Payout(momoEvent(tt.details))
+
+			assert.Nil(t, err)
+			assert.Equal(t, tt.code, res.Error.Code)
+			assert.Empty(t, api.posted, "invalid details must not reach the API")
+		})
+	}
+}
+
+func TestNormaliseMSISDN(t *testing.T) {
+	tests := []struct {
+		phone, callingCode, want string
+	}{
+		{"+256 772 123456", "", "256772123456"},
+		{"00256772123456", "", "256772123456"},
+		{"0772-123-456", "256", "256772123456"},
+		{"(0772) 123.456", "256", "256772123456"},
+		{"256772123456", "256", "256772123456"},
+		{"0772123456", "", ""},
+		{"+256 77x 123456", "", ""},
+		{"+2567", "", ""},
+		{"+2567721234567890", "", ""},
+		{"+254712345678", "256", ""},
+		{"", "", ""},
+	}
+
+	for _, tt := range tests {
+		got, err := normaliseMSISDN(tt.phone, tt.callingCode)
+		assert.Equal(t, tt.want, got, tt.phone)
+		assert.Equal(t, tt.want == "", err != nil, tt.phone)
+	}
+}
+
+func TestMomoReferenceIDIsAStableV4UUID(t *testing.T) {
+	id := momoReferenceID("key-123", 0)
+
+	parsed, err := uuid.Parse(id)
+	assert.Nil(t, err)
+	assert.Equal(t, uuid.Version(4), parsed.Version())
+	assert.Equal(t, id, momoReferenceID("key-123", 0))
+	assert.NotEqual(t, id, momoReferenceID("key-123", 1))
+	assert.NotEqual(t, id, momoReferenceID("key-456", 0))
+}
+
+func TestMobileMoneyAuth_ReadsCredentials(t *testing.T) {
+	cfg := getConfig()
+	pool := getPool(cfg)
+	defer pool.Close()
+
+	before(t, pool)
+	insertDingUser(t, pool)
+	mustExec(t, pool, `
+		INSERT INTO credentials(userid, entity, key, details)
+		VALUES ('00000000-0000-0000-0000-000000000000', 'momo', 'uganda',
+		        '{"api_user": "api-user", "api_key": "api-key", "subscription_key": "sub-key", "target_environment": "mtnuganda"}');
+	`)
+
+	api := &momoAPI{}
+	p := momoProvider(t, api)
+	p.pool = pool
+	p.creds = momoCredentials{}
+
+	user := &User{Id: "00000000-0000-0000-0000-000000000000"}
+	assert.Nil(t, p.Auth(user, "uganda"))
+	assert.Equal(t, "mtnuganda", p.creds.TargetEnvironment)
+	assert.Equal(t, int32(1), api.tokens, "Auth fetches the first token")
+
+	assert.NotNil(t, p.Auth(user, "kenya"))
+	assert.NotNil(t, p.Auth(user, ""))
+}
+
+func TestCallbackSettlesItsPendingPayment(t *testing.T) {
+	var received int32
+	var last string
+	ts := countingBotserver(&received, &last)
+	defer ts.Close()
+
+	var attempts, polls int32
+	provider := &pendingProvider{countingProvider: countingProvider{attempts: &attempts}, polls: &polls}
+
+	dc := getDC(ts)
+	dc.getProvider = func(pool *pgxpool.Pool, event *PaymentEvent) (Provider, error) {
+		return provider, nil
+	}
+	assert.Nil(t, dc.Process(makeMessages([]string{paymentMessage("payment-1", 1600558963867, true)})))
+
+	var key string
+	if err := dc.pool.QueryRow(context.Background(), `SELECT idempotency_key FROM payments`).Scan(&key); err != nil {
+		t.Fatal(err)
+	}
+
+	srv := httptest.NewServer(dc.callbackHandler())
+	defer srv.Close()
+	callback := func(provider string) int {
+		resp, err := http.Post(srv.URL+"/callbacks/"+provider+"/"+key, "application/json", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	// Another provider's callback cannot touch this payment.
+	assert.Equal(t, 200, callback("momo"))
+	assert.Equal(t, int32(0), atomic.LoadInt32(&polls))
+
+	// Still pending: the callback only makes us ask.
+	assert.Equal(t, 200, callback("fake"))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&polls))
+	assert.Equal(t, int32(0), atomic.LoadInt32(&received))
+
+	provider.settled = &Result{Type: "payment:fake", ID: "payment-1", Success: true}
+	assert.Equal(t, 200, callback("fake"))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&received))
+	assert.Equal(t, paymentSuccess, ledgerStatus(t, dc.pool))
+
+	// A repeated callback for a settled payment is a no-op.
+	assert.Equal(t, 200, callback("fake"))
+	assert.Equal(t, int32(2), atomic.LoadInt32(&polls))
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// Classification overrides (chatroach.payment_error_classifications,
+// devops/migrations/31).
+//
+// recoveryByCode is compiled in, so a code it does not know was permanent --
+// sent, releasing the respondent -- until a code change shipped. Overrides are
+// rows in the database, merged over the map: for one provider or any, for one
+// researcher or all. The most specific row wins, and any row beats the map.
+//
+// Every code that ends up unclassified is recorded in the same table with no
+// recovery, counted and timestamped, so triage is setting a column on a row
+// that already exists rather than a code review. classify.go stays pure; this
+// file is the part that talks to the database.
+
+type overrideScope struct {
+	code     string
+	provider string // "" is any provider
+	owner    string // "" is every researcher
+}
+
+type overrideStore struct {
+	mu    sync.RWMutex
+	rules map[overrideScope]Recovery
+}
+
+// classificationOverrides is shared by every worker and swapped whole by
+// reloadClassifications.
+var classificationOverrides = &overrideStore{rules: map[overrideScope]Recovery{}}
+
+func (s *overrideStore) set(rules map[overrideScope]Recovery) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rules = rules
+}
+
+// lookup finds the most specific override for a code: this provider and
+// researcher, then this researcher on any provider, then this provider for
+// everyone, then the code everywhere.
+func (s *overrideStore) lookup(code, provider, owner string) (Recovery, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	scopes := []overrideScope{
+		{code, provider, owner},
+		{code, "", owner},
+		{code, provider, ""},
+		{code, "", ""},
+	}
+	for _, sc := range scopes {
+		if r, ok := s.rules[sc]; ok {
+			return r, true
+		}
+	}
+	return "", false
+}
+
+// classifyPayment is ClassifyResult with the overrides for this payment's
+// provider and researcher merged over it.
+func classifyPayment(pe *PaymentEvent, res *Result) (Recovery, bool) {
+	if pe == nil || res == nil || res.Error == nil {
+		return ClassifyResult(res)
+	}
+	if r, ok := classificationOverrides.lookup(res.Error.Code, pe.Provider, pe.Owner); ok {
+		return r, true
+	}
+	return ClassifyResult(res)
+}
+
+// loadClassifications reads every classified row.
+func loadClassifications(pool *pgxpool.Pool) (map[overrideScope]Recovery, error) {
+	query := `
+		SELECT code, COALESCE(provider, ''), COALESCE(userid::STRING, ''), recovery
+		FROM payment_error_classifications
+		WHERE recovery IS NOT NULL`
+
+	rows, err := pool.Query(context.Background(), query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rules := map[overrideScope]Recovery{}
+	for rows.Next() {
+		var sc overrideScope
+		var r string
+		if err := rows.Scan(&sc.code, &sc.provider, &sc.owner, &r); err != nil {
+			return nil, err
+		}
+		rules[sc] = Recovery(r)
+	}
+	return rules, rows.Err()
+}
+
+// reloadClassifications loads the overrides now and then every interval,
+// forever; an interval that is not positive loads them once. Run it in a
+// goroutine. A failed load keeps the overrides already in force: reverting
+// to the compiled-in map because the database blinked would silently flip
+// codes a researcher has classified.
+func (dc *DC) reloadClassifications() {
+	load := func() {
+		rules, err := loadClassifications(dc.pool)
+		if err != nil {
+			recordFault("classifications")
+			log.Printf("DinersClub could not reload error classifications (keeping the current ones): %v", err)
+			return
+		}
+		classificationOverrides.set(rules)
+	}
+
+	load()
+	if dc.cfg.ClassificationReload <= 0 {
+		return
+	}
+	ticker := time.NewTicker(dc.cfg.ClassificationReload)
+	defer ticker.Stop()
+	for range ticker.C {
+		load()
+	}
+}
+
+// recordUnclassified files a sighting of a code nothing classifies, against
+// its provider, for every researcher. A row that already exists -- seen
+// before, or classified since the last reload -- only has its count and
+// last-seen time bumped.
+func recordUnclassified(pool *pgxpool.Pool, provider, code string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	query := `
+		INSERT INTO payment_error_classifications (code, provider, seen_count, first_seen_at, last_seen_at)
+		VALUES ($1, $2, 1, now(), now())
+		ON CONFLICT (provider, code) WHERE userid IS NULL
+		DO UPDATE SET seen_count = payment_error_classifications.seen_count + 1, last_seen_at = now()`
+
+	_, err := pool.Exec(ctx, query, code, provider)
+	return err
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// withOverrides installs rules for the length of a test.
+func withOverrides(t *testing.T, rules map[overrideScope]Recovery) {
+	t.Helper()
+	classificationOverrides.set(rules)
+	t.Cleanup(func() { classificationOverrides.set(map[overrideScope]Recovery{}) })
+}
+
+func failedWith(code string) *Result {
+	return &Result{Error: &PaymentError{Message: "no", Code: code}}
+}
+
+func TestOverridesBeatTheBuiltInMap(t *testing.T) {
+	withOverrides(t, map[overrideScope]Recovery{
+		{"INVALID_RECIPIENT_PHONE", "", ""}: RecoveryTransient,
+	})
+
+	got, known := classifyPayment(&PaymentEvent{Provider: "reloadly"}, failedWith("INVALID_RECIPIENT_PHONE"))
+	assert.True(t, known)
+	assert.Equal(t, RecoveryTransient, got)
+
+	// Classify itself stays the compiled-in table.
+	got, _ = Classify("INVALID_RECIPIENT_PHONE")
+	assert.Equal(t, RecoveryPermanent, got)
+}
+
+func TestMostSpecificOverrideWins(t *testing.T) {
+	withOverrides(t, map[overrideScope]Recovery{
+		{"PARTNER_BUSY", "", ""}:                 RecoveryPermanent,
+		{"PARTNER_BUSY", "http", ""}:             RecoveryPrecondition,
+		{"PARTNER_BUSY", "", "researcher-a"}:     RecoveryTransient,
+		{"PARTNER_BUSY", "http", "researcher-b"}: RecoveryTransient,
+	})
+
+	tests := []struct {
+		provider, owner string
+		want            Recovery
+	}{
+		{"http", "researcher-b", RecoveryTransient},     // provider and researcher
+		{"reloadly", "researcher-a", RecoveryTransient}, // researcher, any provider
+		{"http", "researcher-c", RecoveryPrecondition},  // provider, everyone
+		{"reloadly", "researcher-c", RecoveryPermanent}, // everywhere
+		{"reloadly", "researcher-b", RecoveryPermanent}, // b's row is http only
+	}
+
+	for _, tt := range tests {
+		got, known := classifyPayment(&PaymentEvent{Provider: tt.provider, Owner: tt.owner}, failedWith("PARTNER_BUSY"))
+		assert.True(t, known)
+		assert.Equal(t, tt.want, got, "%s/%s", tt.provider, tt.owner)
+	}
+}
+
+func TestUnoverriddenCodesFallBackToTheMap(t *testing.T) {
+	withOverrides(t, map[overrideScope]Recovery{{"OTHER", "", ""}: RecoveryTransient})
+
+	got, known := classifyPayment(&PaymentEvent{Provider: "reloadly"}, failedWith("INSUFFICIENT_BALANCE"))
+	assert.True(t, known)
+	assert.Equal(t, RecoveryPrecondition, got)
+
+	got, known = classifyPayment(&PaymentEvent{Provider: "reloadly"}, failedWith("NEVER_SEEN"))
+	assert.False(t, known)
+	assert.Equal(t, RecoveryPermanent, got)
+}
+
+func TestLoadClassificationsReadsOnlyClassifiedRows(t *testing.T) {
+	cfg := getConfig()
+	pool := getPool(cfg)
+	defer pool.Close()
+
+	before(t, pool)
+	mustExec(t, pool, `DELETE FROM payment_error_classifications`)
+	insertDingUser(t, pool)
+	mustExec(t, pool, `
+		INSERT INTO payment_error_classifications (code, provider, userid, recovery) VALUES
+		('A', NULL, NULL, 'transient'),
+		('B', 'http', '00000000-0000-0000-0000-000000000000', 'precondition'),
+		('C', 'http', NULL, NULL)`)
+
+	rules, err := loadClassifications(pool)
+
+	assert.Nil(t, err)
+	assert.Equal(t, map[overrideScope]Recovery{
+		{"A", "", ""}: RecoveryTransient,
+		{"B", "http", "00000000-0000-0000-0000-000000000000"}: RecoveryPrecondition,
+	}, rules)
+}
+
+// A reload interval of 0 loads the overrides once and returns, where a
+// ticker would have panicked.
+func TestReloadClassificationsWithoutAnIntervalLoadsOnce(t *testing.T) {
+	cfg := getConfig()
+	pool := getPool(cfg)
+	defer pool.Close()
+
+	before(t, pool)
+	mustExec(t, pool, `DELETE FROM payment_error_classifications`)
+	mustExec(t, pool, `
+		INSERT INTO payment_error_classifications (code, provider, userid, recovery) VALUES
+		('ONCE', NULL, NULL, 'transient')`)
+	t.Cleanup(func() { classificationOverrides.set(map[overrideScope]Recovery{}) })
+
+	cfg.ClassificationReload = 0
+	dc := &DC{cfg, pool, nil, nil, nil}
+	dc.reloadClassifications()
+
+	recovery, known := classifyPayment(&PaymentEvent{Provider: "http"}, failedWith("ONCE"))
+	assert.True(t, known)
+	assert.Equal(t, RecoveryTransient, recovery)
+}
+
+func TestUnclassifiedCodesAreRecordedForTriage(t *testing.T) {
+	var received int32
+	var last string
+	ts := countingBotserver(&received, &last)
+	defer ts.Close()
+
+	dc := getDC(ts)
+	mustExec(t, dc.pool, `DELETE FROM payment_error_classifications`)
+
+	msg := func(id string) string {
+		return `{"userid": "foo", "pageid": "page", "timestamp": 1600558963867, "provider": "fake",
+			"details": {"id": "` + id + `", "result": {"type": "payment:fake", "success": false,
+			"error": {"message": "no", "code": "BRAND_NEW_CODE"}}}}`
+	}
+	assert.Nil(t, dc.Process(makeMessages([]string{msg("payment-1"), msg("payment-2")})))
+
+	var count int
+	var recovery *string
+	err := dc.pool.QueryRow(context.Background(), `
+		SELECT seen_count, recovery FROM payment_error_classifications
+		WHERE code = 'BRAND_NEW_CODE' AND provider = 'fake' AND userid IS NULL`).Scan(&count, &recovery)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, count)
+	assert.Nil(t, recovery)
+
+	// Unclassified is permanent: both were sent.
+	assert.Equal(t, int32(2), atomic.LoadInt32(&received))
+
+	// Triage: classify the recorded row, reload, and the next one is withheld.
+	mustExec(t, dc.pool, `UPDATE payment_error_classifications SET recovery = 'precondition' WHERE code = 'BRAND_NEW_CODE'`)
+	rules, err := loadClassifications(dc.pool)
+	assert.Nil(t, err)
+	withOverrides(t, rules)
+
+	assert.Nil(t, dc.Process(makeMessages([]string{msg("payment-3")})))
+	assert.Equal(t, int32(2), atomic.LoadInt32(&received))
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// The status poller settles pending payments.
+//
+// A provider that accepts a payment without settling it answers Payout with a
+// Pending Result. Job parks that in the ledger and sends nothing, so the
+// respondent stays in WAIT_EXTERNAL_EVENT exactly as for a withheld failure.
+// This loop then asks the provider again, through StatusProvider, until the
+// payment settles, and only then delivers the Result -- through deliver, so a
+// settled failure is classified and withheld or sent like any other.
+//
+// dean keeps re-driving the payment while it is pending. Those re-drives find
+// it held in the ledger and are skipped; the poller is the only thing that
+// finishes a pending payment.
+
+// pollStatuses runs the poller forever. Run it in a goroutine.
+func (dc *DC) pollStatuses() {
+	ticker := time.NewTicker(dc.cfg.StatusPollInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := dc.pollPending(); err != nil {
+			// Like checkError: nothing was sent, the payments stay pending,
+			// and the next sweep tries again.
+			log.Printf("DinersClub status poll error (payments stay pending): %v", err)
+		}
+	}
+}
+
+// pollPending runs one sweep over the pending payments due a poll.
+func (dc *DC) pollPending() error {
+	leased, err := leasePendingPayments(dc.pool, dc.cfg.StatusPollInterval, dc.cfg.StatusPollBatch)
+	if err != nil {
+		recordFault("ledger")
+		return err
+	}
+
+	errs := []error{}
+	for _, p := range leased {
+		if err := dc.settle(p); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%d of %d pending payments could not be polled: %v", len(errs), len(leased), errs)
+	}
+	return nil
+}
+
+// settle asks the provider about one pending payment and, if it has settled,
+// records and delivers the verdict.
+func (dc *DC) settle(p pendingPayment) error {
+	pe := p.event
+
+	provider, err := dc.getProviderFromEvent(pe)
+	if provider == nil || err != nil {
+		recordFault("status")
+		return fmt.Errorf("no provider %s for pending payment %s: %v", pe.Provider, pe.IdempotencyKey, err)
+	}
+
+	provider, err = dc.checkCache(provider, pe, &User{Id: p.owner})
+	if err != nil {
+		recordFault("status")
+		return fmt.Errorf("auth failed for pending payment %s: %w", pe.IdempotencyKey, err)
+	}
+
+	sp, ok := provider.(StatusProvider)
+	if !ok {
+		// Payout answered pending but the provider cannot be asked again.
+		// That is a bug in the provider, and the payment stays parked
+		// until someone settles it by hand.
+		recordFault("status")
+		return fmt.Errorf("provider %s returned a pending payment (%s) but has no Status", pe.Provider, pe.IdempotencyKey)
+	}
+
+	res, err := sp.Status(pe, p.pending)
+	if err != nil {
+		recordFault("status")
+		return fmt.Errorf("status of pending payment %s: %w", pe.IdempotencyKey, err)
+	}
+	if res == nil || res.Pending {
+		return nil
+	}
+
+	// A fallback hop's pending Result was stamped as the chain's (see
+	// fallback.go); the settled one must be too, or no wait matches it.
+	if p.pending.Provider != "" {
+		res.Type, res.Provider, res.Attempts = p.pending.Type, p.pending.Provider, p.pending.Attempts
+	}
+
+	if err := recordPayment(dc.pool, pe, res); err != nil {
+		recordFault("ledger")
+		return err
+	}
+	return dc.deliver(pe, res)
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/stretchr/testify/assert"
+)
+
+// pendingProvider accepts every payment as pending and answers Status with
+// settled once it is set.
+type pendingProvider struct {
+	countingProvider
+	polls   *int32
+	settled *Result
+}
+
+func (p *pendingProvider) Payout(event *PaymentEvent) (*Result, error) {
+	atomic.AddInt32(p.attempts, 1)
+	return &Result{Type: "payment:fake", ID: "payment-1", Pending: true}, nil
+}
+
+func (p *pendingProvider) Status(event *PaymentEvent, pending *Result) (*Result, error) {
+	atomic.AddInt32(p.polls, 1)
+	if p.settled == nil {
+		return pending, nil
+	}
+	return p.settled, nil
+}
+
+func ledgerStatus(t *testing.T, pool *pgxpool.Pool) string {
+	t.Helper()
+	var status string
+	if err := pool.QueryRow(context.Background(), `SELECT status FROM payments`).Scan(&status); err != nil {
+		t.Fatal(err)
+	}
+	return status
+}
+
+func TestPendingPaymentIsDeliveredOnlyOnceSettled(t *testing.T) {
+	var received int32
+	var last string
+	ts := countingBotserver(&received, &last)
+	defer ts.Close()
+
+	var attempts, polls int32
+	provider := &pendingProvider{countingProvider: countingProvider{attempts: &attempts}, polls: &polls}
+
+	dc := getDC(ts)
+	dc.cfg.StatusPollInterval = 0
+	dc.getProvider = func(pool *pgxpool.Pool, event *PaymentEvent) (Provider, error) {
+		return provider, nil
+	}
+
+	msg := paymentMessage("payment-1", 1600558963867, true)
+	assert.Nil(t, dc.Process(makeMessages([]string{msg})))
+	assert.Equal(t, int32(0), atomic.LoadInt32(&received), "a pending payment must not release the respondent")
+	assert.Equal(t, paymentPending, ledgerStatus(t, dc.pool))
+
+	// dean re-drives while it is pending: the payment is held, not re-paid.
+	assert.Nil(t, dc.Process(makeMessages([]string{paymentMessage("payment-1", 1600999999999, true)})))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&attempts))
+
+	// Still pending at the provider.
+	assert.Nil(t, dc.pollPending())
+	assert.Equal(t, int32(1), atomic.LoadInt32(&polls))
+	assert.Equal(t, int32(0), atomic.LoadInt32(&received))
+
+	provider.settled = &Result{Type: "payment:fake", ID: "payment-1", Success: true}
+	assert.Nil(t, dc.pollPending())
+	assert.Equal(t, int32(1), atomic.LoadInt32(&received))
+	assert.Contains(t, last, `"success":true`)
+	assert.Equal(t, paymentSuccess, ledgerStatus(t, dc.pool))
+
+	// Settled payments are not polled again.
+	assert.Nil(t, dc.pollPending())
+	assert.Equal(t, int32(2), atomic.LoadInt32(&polls))
+}
+
+func TestPendingPaymentWithoutStatusStaysPending(t *testing.T) {
+	var received int32
+	var last string
+	ts := countingBotserver(&received, &last)
+	defer ts.Close()
+
+	var attempts, polls int32
+	dc := getDC(ts)
+	dc.cfg.StatusPollInterval = 0
+	dc.getProvider = func(pool *pgxpool.Pool, event *PaymentEvent) (Provider, error) {
+		return &pendingProvider{countingProvider: countingProvider{attempts: &attempts}, polls: &polls}, nil
+	}
+	assert.Nil(t, dc.Process(makeMessages([]string{paymentMessage("payment-1", 1600558963867, true)})))
+
+	// The provider that answered pending can no longer be asked.
+	dc.getProvider = func(pool *pgxpool.Pool, event *PaymentEvent) (Provider, error) {
+		return &countingProvider{attempts: &attempts}, nil
+	}
+	dc.cache.Clear()
+
+	assert.NotNil(t, dc.pollPending())
+	assert.Equal(t, int32(0), atomic.LoadInt32(&received))
+	assert.Equal(t, paymentPending, ledgerStatus(t, dc.pool))
+}
@@ -22,8 +22,6 @@ func (t *JSTimestamp) UnmarshalJSON(b []byte) error {
 	return nil
 }
 
-// Add id for payment??? hash of userid/pageid/timestamp? question ref? id field in description?
-// Add shortcode to payment event - to associate different reloadly accounts with different surveys?
 type PaymentEvent struct {
 	Userid string `json:"userid" validate:"required"`
 	Pageid string `json:"pageid" validate:"required"`
@@ -32,11 +30,29 @@ type PaymentEvent struct {
 	// recently started emitting it, so in-flight events may lack it — in
 	// that case credential lookup falls back to key-only (see
 	// GenericGetUser).
-	Platform  string           `json:"platform"`
+	Platform string `json:"platform"`
+	// Shortcode is the survey the payment was made from. Optional for the
+	// same reason as Platform; an event without it is only subject to
+	// researcher-wide budgets (see budget.go).
+	Shortcode string           `json:"shortcode"`
 	Timestamp *JSTimestamp     `json:"timestamp" validate:"required"`
 	Provider  string           `json:"provider" validate:"required"`
 	Key       string           `json:"key"`
 	Details   *json.RawMessage `json:"details" validate:"required"`
+
+	// IdempotencyKey is derived by Job (see idempotencyKey in ledger.go),
+	// never read off the wire. Providers that support client-side dedup pass
+	// it through so a re-driven payment cannot be paid twice.
+	IdempotencyKey string `json:"-"`
+
+	// Owner is the researcher paying, once Job has resolved them. Like
+	// IdempotencyKey it is never on the wire; classification overrides are
+	// scoped by it (see overrides.go).
+	Owner string `json:"-"`
+
+	// Chain is where the payment is in its fallback chain, if its details
+	// list one (see fallback.go). Never on the wire either.
+	Chain *fallbackChain `json:"-"`
 }
 
 type PaymentError struct {
@@ -58,6 +74,25 @@ type Result struct {
 	Error          *PaymentError    `json:"error,omitempty"`
 	PaymentDetails *json.RawMessage `json:"payment_details,omitempty"`
 	Response       *json.RawMessage `json:"response,omitempty"`
+
+	// Provider and Attempts are set for a payment with a fallback chain:
+	// the provider that answered -- Type stays the first provider's, which
+	// is what the respondent waits on -- and the hops that failed before it.
+	Provider string           `json:"provider,omitempty"`
+	Attempts []PaymentAttempt `json:"attempts,omitempty"`
+
+	// Diagnostic is what Error.Message said before deliver replaced it with
+	// a message for the respondent (see respondent.go): the provider's own
+	// text, for the log line deliver writes. The ledger does not see it --
+	// recordPayment stored the Result before it was explained, with that
+	// text still in Error.Message. sendResult strips it.
+	Diagnostic string `json:"diagnostic,omitempty"`
+
+	// Pending marks a payment the provider accepted but has not settled. It
+	// is not a verdict and never reaches botserver: the payment is parked in
+	// the ledger and the status poller asks the provider again (see
+	// StatusProvider). Success and Error are meaningless while it is set.
+	Pending bool `json:"-"`
 }
 
 type Provider interface {
@@ -66,6 +101,27 @@ type Provider interface {
 	Payout(*PaymentEvent) (*Result, error)
 }
 
+// StatusProvider is implemented by providers whose Payout can answer with a
+// Pending Result. Status re-queries the provider for a payment that is still
+// pending, given the pending Result Payout returned, and answers with a final
+// Result or another pending one.
+//
+// Unlike Payout, a failure to ask is an error, never a failed Result: the
+// payment may well have gone through, and a failed verdict would let dean's
+// next re-drive pay it again. The poller simply asks again later.
+type StatusProvider interface {
+	Status(event *PaymentEvent, pending *Result) (*Result, error)
+}
+
+// BindingProvider is implemented by providers that hold per-researcher state
+// after Auth. Bind returns a new provider authorised for the researcher and
+// leaves the receiver untouched. checkCache caches what it is given and hands
+// it to concurrent payouts, so a provider that Auth would write to must not
+// be the value cached: Bind's result is, and is never written again.
+type BindingProvider interface {
+	Bind(user *User, key string) (Provider, error)
+}
+
 type GetUserFromPaymentEvent func(event *PaymentEvent) (*User, error)
 type Auth func(user *User, key string) error
 
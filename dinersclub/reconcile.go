@@ -0,0 +1,524 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/vlab-research/go-reloadly/reloadly"
+)
+
+// `dinersclub reconcile` checks the ledger against what the providers say
+// they did. For every researcher credential that paid in the range, it
+// authorises with that credential, lists the provider's transactions, and
+// joins them to the payments table on the reference we gave the provider --
+// Reloadly's customIdentifier, DingConnect's DistributorRef, both the
+// idempotency key unless topup or Ding details set their own. Gift card
+// orders are always sent under the key. What comes out is the
+// difference, as CSV or JSON:
+//
+//	unrecorded       the provider paid, and the ledger has no success for it
+//	unpaid           the ledger has a success the provider has no payment for
+//	amount_mismatch  both paid, for different amounts
+//
+// A payment at the edge of the range can be stamped either side of it by the
+// two systems, so both are read a day wider than the range and only
+// differences inside it are reported. A credential whose transactions could
+// not be listed is left out of the report and the command fails after
+// writing it, so an incomplete report is never mistaken for a clean one.
+//
+// Only providers implementing TransactionLister take part.
+//
+//	dinersclub reconcile -from 2026-10-01 -to 2026-10-08 > diff.csv
+//	dinersclub reconcile -from 2026-10-01T00:00:00Z -provider dingconnect -format json
+
+const reconcileSlack = 24 * time.Hour
+
+const (
+	Unrecorded     = "unrecorded"
+	Unpaid         = "unpaid"
+	AmountMismatch = "amount_mismatch"
+)
+
+// errNotReconcilable is returned by a TransactionLister whose transactions
+// cannot be joined to the ledger.
+var errNotReconcilable = errors.New("transactions carry no reference to the payment")
+
+// ProviderTransaction is one transaction in a provider's history.
+type ProviderTransaction struct {
+	Provider string
+	ID       string  // the provider's own id
+	Ref      string  // the reference we sent: customIdentifier, DistributorRef
+	Status   string  // as the provider reports it
+	Paid     bool    // whether the provider charged for it
+	Amount   float64 // in Currency, comparable to payments.amount
+	Currency string
+	At       time.Time
+}
+
+// TransactionLister is implemented by providers that can list the
+// transactions of the account they were authorised against. Optional, like
+// BalanceProvider.
+type TransactionLister interface {
+	Transactions(from, to time.Time) ([]ProviderTransaction, error)
+}
+
+// ledgerPayment is a payments row, as reconcile reads it.
+type ledgerPayment struct {
+	target    balanceTarget
+	key       string
+	ref       string
+	userid    string
+	status    string
+	amount    float64
+	createdAt time.Time
+}
+
+// Discrepancy is one line of the report.
+type Discrepancy struct {
+	Kind           string    `json:"kind"`
+	Provider       string    `json:"provider"`
+	Owner          string    `json:"owner"`
+	CredentialKey  string    `json:"credential_key"`
+	Ref            string    `json:"ref"`
+	IdempotencyKey string    `json:"idempotency_key"`
+	Userid         string    `json:"userid"`
+	LedgerStatus   string    `json:"ledger_status"`
+	LedgerAmount   *float64  `json:"ledger_amount"`
+	ProviderID     string    `json:"provider_id"`
+	ProviderStatus string    `json:"provider_status"`
+	ProviderAmount *float64  `json:"provider_amount"`
+	Currency       string    `json:"currency"`
+	At             time.Time `json:"at"`
+}
+
+// reconcileRef is the reference a payment was sent to its provider under.
+// Topup details are a go-reloadly TopupJob, whose tag is custom_identifier;
+// only Reloadly's own API spells it customIdentifier.
+func reconcileRef(details []byte, key string) string {
+	d := struct {
+		CustomIdentifier string `json:"custom_identifier"`
+		DistributorRef   string `json:"distributor_ref"`
+	}{}
+	if err := json.Unmarshal(details, &d); err == nil {
+		if d.CustomIdentifier != "" {
+			return d.CustomIdentifier
+		}
+		if d.DistributorRef != "" {
+			return d.DistributorRef
+		}
+	}
+	return key
+}
+
+// reconcile compares the ledger with the providers' transactions. Both are
+// read with reconcileSlack either side of [from, to); only differences
+// inside it are reported, and none for a credential in skipped.
+func reconcile(rows []ledgerPayment, txns []ProviderTransaction, skipped map[balanceTarget]bool, from, to time.Time) []Discrepancy {
+	inRange := func(t time.Time) bool { return !t.Before(from) && t.Before(to) }
+
+	ledger := map[string]ledgerPayment{}
+	for _, r := range rows {
+		ledger[r.target.provider+"\x00"+r.ref] = r
+	}
+	paid := map[string]bool{}
+	for _, t := range txns {
+		if t.Paid {
+			paid[t.Provider+"\x00"+t.Ref] = true
+		}
+	}
+
+	out := []Discrepancy{}
+	for _, t := range txns {
+		if !t.Paid || !inRange(t.At) {
+			continue
+		}
+		amount := t.Amount
+		d := Discrepancy{
+			Provider: t.Provider, Ref: t.Ref, ProviderID: t.ID, ProviderStatus: t.Status,
+			ProviderAmount: &amount, Currency: t.Currency, At: t.At,
+		}
+		r, ok := ledger[t.Provider+"\x00"+t.Ref]
+		if ok {
+			a := r.amount
+			d.Owner, d.CredentialKey, d.IdempotencyKey, d.Userid = r.target.owner, r.target.key, r.key, r.userid
+			d.LedgerStatus, d.LedgerAmount = r.status, &a
+		}
+		switch {
+		case !ok || r.status != paymentSuccess:
+			d.Kind = Unrecorded
+		case math.Abs(r.amount-t.Amount) > 0.005:
+			d.Kind = AmountMismatch
+		default:
+			continue
+		}
+		out = append(out, d)
+	}
+
+	for _, r := range rows {
+		if r.status != paymentSuccess || !inRange(r.createdAt) || skipped[r.target] {
+			continue
+		}
+		if paid[r.target.provider+"\x00"+r.ref] {
+			continue
+		}
+		a := r.amount
+		out = append(out, Discrepancy{
+			Kind: Unpaid, Provider: r.target.provider, Owner: r.target.owner, CredentialKey: r.target.key,
+			Ref: r.ref, IdempotencyKey: r.key, Userid: r.userid, LedgerStatus: r.status, LedgerAmount: &a,
+			At: r.createdAt,
+		})
+	}
+
+	sort.SliceStable(out, func(i, j int) bool { return out[i].At.Before(out[j].At) })
+	return out
+}
+
+// ledgerPayments reads every payment to one of providers created in
+// [from, to).
+func ledgerPayments(pool *pgxpool.Pool, providers []string, from, to time.Time) ([]ledgerPayment, error) {
+	query := `
+		SELECT idempotency_key, provider, COALESCE(owner, ''), COALESCE(credential_key, ''), userid, status,
+			amount::FLOAT, details, created_at
+		FROM payments
+		WHERE provider = ANY($1) AND created_at >= $2 AND created_at < $3`
+
+	rows, err := pool.Query(context.Background(), query, providers, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []ledgerPayment{}
+	for rows.Next() {
+		var r ledgerPayment
+		var details []byte
+		err := rows.Scan(&r.key, &r.target.provider, &r.target.owner, &r.target.key, &r.userid, &r.status,
+			&r.amount, &details, &r.createdAt)
+		if err != nil {
+			return nil, err
+		}
+		r.ref = reconcileRef(details, r.key)
+		out = append(out, r)
+	}
+	return out, rows.Err()
+}
+
+// listTransactions authorises the target's provider with its credential and
+// lists its transactions. A provider that cannot list them is
+// errNotReconcilable.
+func listTransactions(pool *pgxpool.Pool, get GetProvider, t balanceTarget, from, to time.Time) ([]ProviderTransaction, error) {
+	provider, err := get(pool, &PaymentEvent{Provider: t.provider, Key: t.key})
+	if err != nil {
+		return nil, err
+	}
+	lister, ok := provider.(TransactionLister)
+	if !ok {
+		return nil, errNotReconcilable
+	}
+	if err := provider.Auth(&User{Id: t.owner}, t.key); err != nil {
+		return nil, err
+	}
+	return lister.Transactions(from, to)
+}
+
+type reconcileFlags struct {
+	from, to  time.Time
+	providers []string
+	format    string
+}
+
+func parseReconcileFlags(args []string, now time.Time) (*reconcileFlags, error) {
+	fs := flag.NewFlagSet("reconcile", flag.ContinueOnError)
+	from := fs.String("from", "", "start of the range, RFC3339 or YYYY-MM-DD (required)")
+	to := fs.String("to", "", "end of the range, exclusive, RFC3339 or YYYY-MM-DD (default now)")
+	providers := fs.String("provider", "reloadly,giftcard,dingconnect", "comma-separated providers to reconcile")
+	format := fs.String("format", "csv", "csv or json")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	parse := func(name, s string) (time.Time, error) {
+		if t, err := time.Parse(time.RFC3339, s); err == nil {
+			return t, nil
+		}
+		t, err := time.Parse("2006-01-02", s)
+		if err != nil {
+			return t, fmt.Errorf("-%s %q is neither RFC3339 nor YYYY-MM-DD", name, s)
+		}
+		return t, nil
+	}
+
+	f := &reconcileFlags{to: now, providers: splitList(*providers), format: *format}
+	if *from == "" {
+		return nil, errors.New("-from is required")
+	}
+	var err error
+	if f.from, err = parse("from", *from); err != nil {
+		return nil, err
+	}
+	if *to != "" {
+		if f.to, err = parse("to", *to); err != nil {
+			return nil, err
+		}
+	}
+	if !f.from.Before(f.to) {
+		return nil, errors.New("-from must be before -to")
+	}
+	if f.format != "csv" && f.format != "json" {
+		return nil, fmt.Errorf("-format %q: use csv or json", f.format)
+	}
+	if len(f.providers) == 0 {
+		return nil, errors.New("-provider lists no providers")
+	}
+	return f, nil
+}
+
+// reconcilePayments is the reconcile command. The report goes to w.
+func reconcilePayments(pool *pgxpool.Pool, get GetProvider, args []string, w io.Writer) error {
+	f, err := parseReconcileFlags(args, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+	from, to := f.from.Add(-reconcileSlack), f.to.Add(reconcileSlack)
+
+	rows, err := ledgerPayments(pool, f.providers, from, to)
+	if err != nil {
+		return err
+	}
+
+	targets := []balanceTarget{}
+	seen := map[balanceTarget]bool{}
+	for _, r := range rows {
+		if !seen[r.target] && !r.createdAt.Before(f.from) && r.createdAt.Before(f.to) {
+			seen[r.target] = true
+			targets = append(targets, r.target)
+		}
+	}
+
+	// Two credentials on one account list the same transactions.
+	txns := []ProviderTransaction{}
+	listed := map[string]bool{}
+	skipped := map[balanceTarget]bool{}
+	failed := 0
+	for _, t := range targets {
+		list, err := listTransactions(pool, get, t, from, to)
+		if errors.Is(err, errNotReconcilable) {
+			skipped[t] = true
+			continue
+		}
+		if err != nil {
+			failed++
+			log.Printf("DinersClub reconcile: could not list %s transactions for %s (key %q): %v", t.provider, t.owner, t.key, err)
+			skipped[t] = true
+			continue
+		}
+		for _, tx := range list {
+			if id := tx.Provider + "\x00" + tx.ID; !listed[id] {
+				listed[id] = true
+				txns = append(txns, tx)
+			}
+		}
+	}
+
+	diff := reconcile(rows, txns, skipped, f.from, f.to)
+	if err := writeDiscrepancies(w, f.format, diff); err != nil {
+		return err
+	}
+	log.Printf("DinersClub reconciled %d payments against %d provider transactions: %d discrepancies",
+		len(rows), len(txns), len(diff))
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d credentials could not be listed; their payments are not in the report", failed, len(targets))
+	}
+	return nil
+}
+
+func writeDiscrepancies(w io.Writer, format string, diff []Discrepancy) error {
+	if format == "json" {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(diff)
+	}
+
+	amount := func(f *float64) string {
+		if f == nil {
+			return ""
+		}
+		return strconv.FormatFloat(*f, 'f', -1, 64)
+	}
+
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"kind", "provider", "owner", "credential_key", "ref", "idempotency_key", "userid",
+		"ledger_status", "ledger_amount", "provider_id", "provider_status", "provider_amount", "currency", "at"})
+	for _, d := range diff {
+		cw.Write([]string{d.Kind, d.Provider, d.Owner, d.CredentialKey, d.Ref, d.IdempotencyKey, d.Userid,
+			d.LedgerStatus, amount(d.LedgerAmount), d.ProviderID, d.ProviderStatus, amount(d.ProviderAmount),
+			d.Currency, d.At.Format(time.RFC3339)})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// reloadlyTime is how Reloadly writes and reads report times, in UTC.
+const reloadlyTime = "2006-01-02 15:04:05"
+
+// Reloadly's topup report, a page at a time. The reference is our
+// customIdentifier, and requestedAmount is the amount Payout asked for.
+func (p *ReloadlyProvider) Transactions(from, to time.Time) ([]ProviderTransaction, error) {
+	out := []ProviderTransaction{}
+	for page := 1; ; page++ {
+		q := url.Values{}
+		q.Set("page", strconv.Itoa(page))
+		q.Set("size", "200")
+		q.Set("startDate", from.UTC().Format(reloadlyTime))
+		q.Set("endDate", to.UTC().Format(reloadlyTime))
+
+		resp := struct {
+			Content []struct {
+				TransactionID               int64   `json:"transactionId"`
+				CustomIdentifier            string  `json:"customIdentifier"`
+				Status                      string  `json:"status"`
+				RequestedAmount             float64 `json:"requestedAmount"`
+				RequestedAmountCurrencyCode string  `json:"requestedAmountCurrencyCode"`
+				TransactionDate             string  `json:"transactionDate"`
+			} `json:"content"`
+			TotalPages int `json:"totalPages"`
+		}{}
+		if _, err := p.svc.Request("GET", "topups/reports/transactions?"+q.Encode(), nil, &resp); err != nil {
+			return nil, err
+		}
+
+		for _, c := range resp.Content {
+			at, err := time.Parse(reloadlyTime, c.TransactionDate)
+			if err != nil {
+				return nil, fmt.Errorf("reloadly transaction %d: %w", c.TransactionID, err)
+			}
+			out = append(out, ProviderTransaction{
+				Provider: "reloadly",
+				ID:       strconv.FormatInt(c.TransactionID, 10),
+				Ref:      c.CustomIdentifier,
+				Status:   c.Status,
+				Paid:     c.Status == "SUCCESSFUL" || c.Status == "PROCESSING",
+				Amount:   c.RequestedAmount,
+				Currency: c.RequestedAmountCurrencyCode,
+				At:       at,
+			})
+		}
+		if page >= resp.TotalPages || len(resp.Content) == 0 {
+			return out, nil
+		}
+	}
+}
+
+// Reloadly's gift card report, a page at a time. Orders are sent under the
+// idempotency key (see FormatOrder), and unitPrice times quantity is what the
+// ledger records as the amount. currencyCode is the currency the account was
+// charged in, not unitPrice's, so it is left off. An order is charged unless it failed or was
+// refunded, so a pending one counts as paid, as a processing topup does.
+func (p *GiftCardsProvider) Transactions(from, to time.Time) ([]ProviderTransaction, error) {
+	out := []ProviderTransaction{}
+	for page := 1; ; page++ {
+		q := url.Values{}
+		q.Set("page", strconv.Itoa(page))
+		q.Set("size", "200")
+		q.Set("startDate", from.UTC().Format(reloadlyTime))
+		q.Set("endDate", to.UTC().Format(reloadlyTime))
+
+		resp := struct {
+			Content    []reloadly.Transaction `json:"content"`
+			TotalPages int                    `json:"totalPages"`
+		}{}
+		if _, err := p.svc.Request("GET", "reports/transactions?"+q.Encode(), nil, &resp); err != nil {
+			return nil, err
+		}
+
+		for _, c := range resp.Content {
+			if c.TransactionCreatedTime == nil {
+				return nil, fmt.Errorf("reloadly gift card transaction %d has no creation time", c.TransactionId)
+			}
+			status := strings.ToUpper(c.Status)
+			out = append(out, ProviderTransaction{
+				Provider: "giftcard",
+				ID:       strconv.FormatInt(c.TransactionId, 10),
+				Ref:      c.CustomIdentifier,
+				Status:   c.Status,
+				Paid:     status != giftCardFailed && status != giftCardRefunded,
+				Amount:   c.UnitPrice * float64(c.Quantity),
+				At:       time.Time(*c.TransactionCreatedTime),
+			})
+		}
+		if page >= resp.TotalPages || len(resp.Content) == 0 {
+			return out, nil
+		}
+	}
+}
+
+// dingPageSize is how many transfer records are asked for at a time.
+const dingPageSize = 100
+
+// DingConnect's ListTransferRecords has no date filter. It lists the newest
+// first, so paging stops at the first page entirely older than from.
+func (p *DingConnectProvider) Transactions(from, to time.Time) ([]ProviderTransaction, error) {
+	out := []ProviderTransaction{}
+	for skip := 0; ; skip += dingPageSize {
+		body := struct {
+			Items []struct {
+				TransferRecord struct {
+					TransferId struct {
+						TransferRef    string `json:"TransferRef"`
+						DistributorRef string `json:"DistributorRef"`
+					} `json:"TransferId"`
+					Price struct {
+						SendValue       float64 `json:"SendValue"`
+						SendCurrencyIso string  `json:"SendCurrencyIso"`
+					} `json:"Price"`
+					StartedUtc      time.Time `json:"StartedUtc"`
+					ProcessingState string    `json:"ProcessingState"`
+				} `json:"TransferRecord"`
+			} `json:"Items"`
+		}{}
+		req := map[string]int{"Skip": skip, "Take": dingPageSize}
+		if err := p.dingPost("ListTransferRecords", req, &body); err != nil {
+			return nil, err
+		}
+
+		older := 0
+		for _, item := range body.Items {
+			r := item.TransferRecord
+			if r.StartedUtc.Before(from) {
+				older++
+				continue
+			}
+			if !r.StartedUtc.Before(to) {
+				continue
+			}
+			state := r.ProcessingState
+			out = append(out, ProviderTransaction{
+				Provider: "dingconnect",
+				ID:       r.TransferId.TransferRef,
+				Ref:      r.TransferId.DistributorRef,
+				Status:   state,
+				Paid:     state == "Complete" || state == "Submitted" || state == "Processing",
+				Amount:   r.Price.SendValue,
+				Currency: r.Price.SendCurrencyIso,
+				At:       r.StartedUtc,
+			})
+		}
+		if len(body.Items) < dingPageSize || older == len(body.Items) {
+			return out, nil
+		}
+	}
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vlab-research/go-reloadly/reloadly"
+)
+
+var (
+	reconcileFrom = time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	reconcileTo   = time.Date(2026, 10, 8, 0, 0, 0, 0, time.UTC)
+)
+
+func ledgerRow(ref, status string, amount float64, at time.Time) ledgerPayment {
+	return ledgerPayment{
+		target: balanceTarget{"owner", "reloadly", "key"},
+		key:    ref, ref: ref, userid: "user-" + ref, status: status, amount: amount, createdAt: at,
+	}
+}
+
+func providerTxn(ref string, paid bool, amount float64, at time.Time) ProviderTransaction {
+	return ProviderTransaction{Provider: "reloadly", ID: "tx-" + ref, Ref: ref, Status: "SUCCESSFUL",
+		Paid: paid, Amount: amount, Currency: "USD", At: at}
+}
+
+func TestReconcileReportsEachKindOfDifference(t *testing.T) {
+	day := reconcileFrom.Add(48 * time.Hour)
+	rows := []ledgerPayment{
+		ledgerRow("matched", paymentSuccess, 5, day),
+		ledgerRow("unpaid", paymentSuccess, 5, day),
+		ledgerRow("mismatch", paymentSuccess, 5, day),
+		ledgerRow("failed-here", paymentFailed, 5, day),
+		ledgerRow("failed-both", paymentFailed, 5, day),
+	}
+	txns := []ProviderTransaction{
+		providerTxn("matched", true, 5, day),
+		providerTxn("mismatch", true, 7.5, day),
+		providerTxn("failed-here", true, 5, day),
+		providerTxn("failed-both", false, 5, day),
+		providerTxn("stranger", true, 3, day),
+	}
+
+	diff := reconcile(rows, txns, map[balanceTarget]bool{}, reconcileFrom, reconcileTo)
+
+	kinds := map[string]string{}
+	for _, d := range diff {
+		kinds[d.Ref] = d.Kind
+	}
+	assert.Equal(t, map[string]string{
+		"unpaid":      Unpaid,
+		"mismatch":    AmountMismatch,
+		"failed-here": Unrecorded,
+		"stranger":    Unrecorded,
+	}, kinds)
+}
+
+func TestReconcileOnlyReportsInsideTheRange(t *testing.T) {
+	// Recorded just before the range, paid by the provider just inside it.
+	edge := ledgerRow("edge", paymentSuccess, 5, reconcileFrom.Add(-time.Minute))
+	txn := providerTxn("edge", true, 5, reconcileFrom.Add(time.Minute))
+	assert.Empty(t, reconcile([]ledgerPayment{edge}, []ProviderTransaction{txn}, nil, reconcileFrom, reconcileTo))
+
+	late := ledgerRow("late", paymentSuccess, 5, reconcileTo.Add(time.Hour))
+	assert.Empty(t, reconcile([]ledgerPayment{late}, nil, nil, reconcileFrom, reconcileTo))
+}
+
+func TestReconcileDoesNotCallASkippedCredentialUnpaid(t *testing.T) {
+	row := ledgerRow("unlisted", paymentSuccess, 5, reconcileFrom.Add(time.Hour))
+	skipped := map[balanceTarget]bool{row.target: true}
+	assert.Empty(t, reconcile([]ledgerPayment{row}, nil, skipped, reconcileFrom, reconcileTo))
+}
+
+func TestReconcileRef(t *testing.T) {
+	assert.Equal(t, "key", reconcileRef([]byte(`{"number": "+123"}`), "key"))
+	assert.Equal(t, "mine", reconcileRef([]byte(`{"custom_identifier": "mine"}`), "key"))
+	// A gift card order's customIdentifier is replaced by the key.
+	assert.Equal(t, "key", reconcileRef([]byte(`{"customIdentifier": "theirs"}`), "key"))
+	assert.Equal(t, "ref", reconcileRef([]byte(`{"distributor_ref": "ref"}`), "key"))
+	assert.Equal(t, "key", reconcileRef(nil, "key"))
+}
+
+func TestParseReconcileFlags(t *testing.T) {
+	now := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+
+	f, err := parseReconcileFlags([]string{"-from", "2026-10-01"}, now)
+	assert.Nil(t, err)
+	assert.Equal(t, reconcileFrom, f.from)
+	assert.Equal(t, now, f.to)
+	assert.Equal(t, []string{"reloadly", "giftcard", "dingconnect"}, f.providers)
+	assert.Equal(t, "csv", f.format)
+
+	f, err = parseReconcileFlags([]string{"-from", "2026-10-01T00:00:00Z", "-to", "2026-10-08", "-provider", "dingconnect", "-format", "json"}, now)
+	assert.Nil(t, err)
+	assert.Equal(t, reconcileTo, f.to)
+	assert.Equal(t, []string{"dingconnect"}, f.providers)
+
+	for _, args := range [][]string{
+		{},
+		{"-from", "yesterday"},
+		{"-from", "2026-10-08", "-to", "2026-10-01"},
+		{"-from", "2026-10-01", "-format", "xml"},
+	} {
+		_, err := parseReconcileFlags(args, now)
+		assert.NotNil(t, err, args)
+	}
+}
+
+func TestWriteDiscrepanciesAsCSV(t *testing.T) {
+	amount := 5.0
+	diff := []Discrepancy{{Kind: Unpaid, Provider: "reloadly", Ref: "r", LedgerStatus: "success", LedgerAmount: &amount, At: reconcileFrom}}
+
+	var buf bytes.Buffer
+	assert.Nil(t, writeDiscrepancies(&buf, "csv", diff))
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	assert.Equal(t, 2, len(lines))
+	assert.True(t, strings.HasPrefix(lines[0], "kind,provider,owner"))
+	assert.Equal(t, "unpaid,reloadly,,,r,,,success,5,,,,,2026-10-01T00:00:00Z", lines[1])
+
+	buf.Reset()
+	assert.Nil(t, writeDiscrepancies(&buf, "json", diff))
+	out := []Discrepancy{}
+	assert.Nil(t, json.Unmarshal(buf.Bytes(), &out))
+	assert.Equal(t, diff, out)
+}
+
+func TestReloadlyTransactionsPages(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/topups/reports/transactions", r.URL.Path)
+		assert.Equal(t, "2026-10-01 00:00:00", r.URL.Query().Get("startDate"))
+		page := r.URL.Query().Get("page")
+		fmt.Fprintf(w, `{"content": [{"transactionId": %s, "customIdentifier": "key-%s", "status": "SUCCESSFUL",
+			"requestedAmount": 5, "requestedAmountCurrencyCode": "USD", "transactionDate": "2026-10-02 10:00:00"}],
+			"totalPages": 2}`, page, page)
+	}))
+	defer ts.Close()
+
+	svc := reloadly.NewTopups()
+	svc.BaseUrl = ts.URL
+	txns, err := (&ReloadlyProvider{svc: svc}).Transactions(reconcileFrom, reconcileTo)
+
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(txns))
+	assert.Equal(t, ProviderTransaction{"reloadly", "1", "key-1", "SUCCESSFUL", true, 5, "USD",
+		time.Date(2026, 10, 2, 10, 0, 0, 0, time.UTC)}, txns[0])
+	assert.Equal(t, "key-2", txns[1].Ref)
+}
+
+func TestGiftCardTransactions(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/reports/transactions", r.URL.Path)
+		assert.Equal(t, "2026-10-01 00:00:00", r.URL.Query().Get("startDate"))
+		fmt.Fprint(w, `{"content": [
+			{"transactionId": 7, "customIdentifier": "key-7", "status": "SUCCESSFUL", "unitPrice": 5, "quantity": 2,
+				"currencyCode": "USD", "transactionCreatedTime": "2026-10-02 10:00:00"},
+			{"transactionId": 8, "customIdentifier": "key-8", "status": "REFUNDED", "unitPrice": 5, "quantity": 1,
+				"transactionCreatedTime": "2026-10-02 11:00:00"}],
+			"totalPages": 1}`)
+	}))
+	defer ts.Close()
+
+	svc := reloadly.NewGiftCards()
+	svc.BaseUrl = ts.URL
+	txns, err := (&GiftCardsProvider{ReloadlyProvider{svc: svc}}).Transactions(reconcileFrom, reconcileTo)
+
+	assert.Nil(t, err)
+	assert.Equal(t, []ProviderTransaction{
+		{"giftcard", "7", "key-7", "SUCCESSFUL", true, 10, "", time.Date(2026, 10, 2, 10, 0, 0, 0, time.UTC)},
+		{"giftcard", "8", "key-8", "REFUNDED", false, 5, "", time.Date(2026, 10, 2, 11, 0, 0, 0, time.UTC)},
+	}, txns)
+}
+
+func TestDingConnectTransactionsStopAtTheRange(t *testing.T) {
+	var skips []float64
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "POST", r.Method)
+		assert.Equal(t, "/ListTransferRecords", r.URL.Path)
+		data, _ := ioutil.ReadAll(r.Body)
+		req := map[string]float64{}
+		json.Unmarshal(data, &req)
+		skips = append(skips, req["Skip"])
+
+		// A full page inside the range, then a full page entirely before it.
+		started := "2026-10-03T09:00:00Z"
+		if req["Skip"] > 0 {
+			started = "2026-09-20T09:00:00Z"
+		}
+		items := []string{}
+		for i := 0; i < dingPageSize; i++ {
+			items = append(items, fmt.Sprintf(`{"TransferRecord": {"TransferId": {"TransferRef": "T%v-%d", "DistributorRef": "ref-%d"},
+				"Price": {"SendValue": 2.5, "SendCurrencyIso": "USD"}, "StartedUtc": %q, "ProcessingState": "Complete"}}`,
+				req["Skip"], i, i, started))
+		}
+		fmt.Fprintf(w, `{"ResultCode": 1, "Items": [%s]}`, strings.Join(items, ","))
+	}))
+	defer ts.Close()
+
+	p := &DingConnectProvider{apiKey: "k", apiURL: ts.URL + "/"}
+	txns, err := p.Transactions(reconcileFrom, reconcileTo)
+
+	assert.Nil(t, err)
+	assert.Equal(t, []float64{0, dingPageSize}, skips)
+	assert.Equal(t, dingPageSize, len(txns))
+	assert.Equal(t, "ref-0", txns[0].Ref)
+	assert.True(t, txns[0].Paid)
+	assert.Equal(t, 2.5, txns[0].Amount)
+}
@@ -252,12 +252,17 @@ func TestMalformedMessageDoesNotAbandonTheRestOfTheBatch(t *testing.T) {
 }
 
 // countingProvider records how many times Payout was called and echoes the
-// Result embedded in the payment details, like the fake provider.
+// Result embedded in the payment details, like the fake provider. The payment
+// belongs to owner, or to "test-id" when that is empty.
 type countingProvider struct {
 	attempts *int32
+	owner    string
 }
 
 func (p *countingProvider) GetUserFromPaymentEvent(event *PaymentEvent) (*User, error) {
+	if p.owner != "" {
+		return &User{Id: p.owner}, nil
+	}
 	return &User{Id: "test-id"}, nil
 }
 
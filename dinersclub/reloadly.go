@@ -54,8 +54,8 @@ func (p *ReloadlyProvider) formatError(res *Result, err error, details *json.Raw
 	// - ORDER_IS_NOT_READY
 	// - IMPORT_CARD_ERROR
 
-	// what to do if fallback is not provider you checked
-	// has correct option types?
+	// A payment can fall back to another provider on these -- the
+	// operator codes above all -- see fallback.go.
 
 	// IMPOSSIBLE_AMOUNT
 	// Add special message in typeform logic to deal with this???
@@ -119,8 +119,17 @@ func (p *ReloadlyProvider) getCredentials(userid string, key string) (*Credentia
 	if err == pgx.ErrNoRows {
 		return nil, nil
 	}
+	if err != nil {
+		return nil, err
+	}
 
-	return &c, err
+	details, err := openDetails(credentialKeys, *c.Details)
+	if err != nil {
+		return nil, err
+	}
+	opened := json.RawMessage(details)
+	c.Details = &opened
+	return &c, nil
 }
 
 func (p *ReloadlyProvider) Payout(event *PaymentEvent) (*Result, error) {
@@ -130,6 +139,13 @@ func (p *ReloadlyProvider) Payout(event *PaymentEvent) (*Result, error) {
 		return handleJSONUnmarshalError("reloadly", err, event.Details), nil
 	}
 
+	// Reloadly refuses a second topup with the same customIdentifier, which
+	// makes the ledger's idempotency key a dedup the provider enforces too.
+	// A researcher-supplied custom_identifier still wins.
+	if job.CustomIdentifier == "" {
+		job.CustomIdentifier = event.IdempotencyKey
+	}
+
 	result := &Result{}
 	result.Type = "payment:reloadly"
 	result.ID = job.ID
@@ -8,6 +8,7 @@ import (
 	"github.com/vlab-research/go-reloadly/reloadly"
 	"io/ioutil"
 	"net/http"
+	"strings"
 	"testing"
 	"time"
 )
@@ -239,3 +240,25 @@ func TestReloadlyAuthsWithCredsBasedOnKey(t *testing.T) {
 	assert.Nil(t, err)
 
 }
+
+func TestReloadlyPassesIdempotencyKeyAsCustomIdentifier(t *testing.T) {
+	bodies := []string{}
+	rt := func(req *http.Request) (*http.Response, error) {
+		if req.Body != nil {
+			b, _ := ioutil.ReadAll(req.Body)
+			bodies = append(bodies, string(b))
+		}
+		body := `{"suggestedAmountsMap":{"2.5": 2.5},"transactionDate":"2020-09-19 12:53:22","transactionId": 567}`
+		return &http.Response{StatusCode: 200, Body: ioutil.NopCloser(strings.NewReader(body))}, nil
+	}
+
+	jm := json.RawMessage([]byte(`{"number": "+123", "amount": 2.5, "country": "IN"}`))
+	pe := &PaymentEvent{Provider: "reloadly", Details: &jm, IdempotencyKey: "0123456789abcdef0123456789abcdef"}
+	svc := &reloadly.Service{Client: &http.Client{Transport: TestTransport(rt)}}
+	provider := &ReloadlyProvider{nil, svc, ""}
+
+	res, err := provider.Payout(pe)
+	assert.Nil(t, err)
+	assert.True(t, res.Success)
+	assert.Contains(t, strings.Join(bodies, "\n"), `"customIdentifier":"0123456789abcdef0123456789abcdef"`)
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+)
+
+// `dinersclub replay` re-injects dead letters onto the payments topic once
+// whatever made them fail has been fixed. It reads the dead-letter topic from
+// the beginning up to where it ends now, picks out the letters selected by
+// the flags, and publishes each one's original payload back to KAFKA_TOPIC
+// under its original key -- with the attempts header set, so a message that
+// fails again is dead-lettered as attempt n+1 rather than 1.
+//
+// It commits nothing and deletes nothing: the dead-letter topic is the
+// record, and retention is what ages it out. Replaying twice re-injects
+// twice, which the ledger makes harmless for anything that was paid.
+//
+//	dinersclub replay -stage parse -since 2026-10-01T00:00:00Z -dry-run
+//	dinersclub replay -offsets 0:112,0:113
+
+type replaySelection struct {
+	all     bool
+	stages  map[string]bool
+	offsets map[string]bool // "partition:offset" in the dead-letter topic
+	since   time.Time
+}
+
+// matches reports whether a dead letter, read from partition:offset of the
+// dead-letter topic, is selected. Selectors narrow: a letter must satisfy
+// every one given.
+func (s *replaySelection) matches(partition int32, offset int64, dl *deadLetter) bool {
+	if s.all {
+		return true
+	}
+	if len(s.stages) > 0 && !s.stages[dl.Stage] {
+		return false
+	}
+	if len(s.offsets) > 0 && !s.offsets[fmt.Sprintf("%d:%d", partition, offset)] {
+		return false
+	}
+	if !s.since.IsZero() && dl.FailedAt.Before(s.since) {
+		return false
+	}
+	return true
+}
+
+func parseReplayFlags(args []string) (*replaySelection, bool, error) {
+	fs := flag.NewFlagSet("replay", flag.ContinueOnError)
+	all := fs.Bool("all", false, "replay every dead letter")
+	stages := fs.String("stage", "", "comma-separated stages to replay (parse, validate, user, ledger, payout, ...)")
+	offsets := fs.String("offsets", "", "comma-separated partition:offset positions in the dead-letter topic")
+	since := fs.String("since", "", "only letters dead-lettered at or after this RFC3339 time")
+	dryRun := fs.Bool("dry-run", false, "list what would be replayed without publishing")
+	if err := fs.Parse(args); err != nil {
+		return nil, false, err
+	}
+
+	sel := &replaySelection{all: *all, stages: map[string]bool{}, offsets: map[string]bool{}}
+	for _, s := range splitList(*stages) {
+		sel.stages[s] = true
+	}
+	for _, o := range splitList(*offsets) {
+		p, off, ok := strings.Cut(o, ":")
+		if !ok {
+			return nil, false, fmt.Errorf("offset %q is not partition:offset", o)
+		}
+		pn, err1 := strconv.ParseInt(p, 10, 32)
+		on, err2 := strconv.ParseInt(off, 10, 64)
+		if err1 != nil || err2 != nil {
+			return nil, false, fmt.Errorf("offset %q is not partition:offset", o)
+		}
+		sel.offsets[fmt.Sprintf("%d:%d", pn, on)] = true
+	}
+	if *since != "" {
+		t, err := time.Parse(time.RFC3339, *since)
+		if err != nil {
+			return nil, false, fmt.Errorf("-since: %w", err)
+		}
+		sel.since = t
+	}
+
+	if !sel.all && len(sel.stages) == 0 && len(sel.offsets) == 0 && sel.since.IsZero() {
+		return nil, false, errors.New("nothing selected: give -stage, -offsets or -since, or -all to replay everything")
+	}
+	return sel, *dryRun, nil
+}
+
+func splitList(s string) []string {
+	out := []string{}
+	for _, x := range strings.Split(s, ",") {
+		if x = strings.TrimSpace(x); x != "" {
+			out = append(out, x)
+		}
+	}
+	return out
+}
+
+// replayDeadLetters is the replay command.
+func replayDeadLetters(cfg *Config, args []string) error {
+	if cfg.DeadLetterTopic == "" {
+		return errors.New("DINERSCLUB_DEAD_LETTER_TOPIC is not set")
+	}
+	sel, dryRun, err := parseReplayFlags(args)
+	if err != nil {
+		return err
+	}
+
+	c, err := kafka.NewConsumer(&kafka.ConfigMap{
+		"bootstrap.servers":  cfg.KafkaBrokers,
+		"group.id":           fmt.Sprintf("dinersclub-replay-%d", time.Now().UnixNano()),
+		"enable.auto.commit": "false",
+	})
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	p, err := kafka.NewProducer(&kafka.ConfigMap{"bootstrap.servers": cfg.KafkaBrokers, "acks": "all"})
+	if err != nil {
+		return err
+	}
+	defer p.Close()
+
+	// Read each partition up to its end as of now. Letters dead-lettered
+	// while this runs -- including any we replay that fail again -- are
+	// left for the next run.
+	topic := cfg.DeadLetterTopic
+	md, err := c.GetMetadata(&topic, false, 10000)
+	if err != nil {
+		return err
+	}
+	ends := map[int32]int64{}
+	assign := []kafka.TopicPartition{}
+	for _, part := range md.Topics[topic].Partitions {
+		low, high, err := c.QueryWatermarkOffsets(topic, part.ID, 10000)
+		if err != nil {
+			return err
+		}
+		if high > low {
+			ends[part.ID] = high
+			assign = append(assign, kafka.TopicPartition{Topic: &topic, Partition: part.ID, Offset: kafka.OffsetBeginning})
+		}
+	}
+	if err := c.Assign(assign); err != nil {
+		return err
+	}
+
+	replayed, skipped := 0, 0
+	for len(ends) > 0 {
+		m, err := c.ReadMessage(30 * time.Second)
+		if err != nil {
+			return fmt.Errorf("reading %s: %w", topic, err)
+		}
+		partition, offset := m.TopicPartition.Partition, int64(m.TopicPartition.Offset)
+		end, reading := ends[partition]
+		if !reading || offset >= end {
+			continue
+		}
+		if offset == end-1 {
+			delete(ends, partition)
+		}
+
+		dl := new(deadLetter)
+		if err := json.Unmarshal(m.Value, dl); err != nil {
+			log.Printf("skipping %d:%d, not a dead letter: %v", partition, offset, err)
+			skipped++
+			continue
+		}
+		if !sel.matches(partition, offset, dl) {
+			continue
+		}
+
+		log.Printf("replaying %d:%d (stage %s, attempt %d, failed %s): %s",
+			partition, offset, dl.Stage, dl.Attempts, dl.FailedAt.Format(time.RFC3339), dl.Error)
+		if dryRun {
+			replayed++
+			continue
+		}
+
+		headers := []kafka.Header{{Key: attemptsHeader, Value: []byte(strconv.Itoa(dl.Attempts))}}
+		if err := produce(p, cfg.KafkaTopic, m.Key, []byte(dl.Payload), headers); err != nil {
+			return fmt.Errorf("replaying %d:%d (%d replayed so far): %w", partition, offset, replayed, err)
+		}
+		replayed++
+	}
+
+	verb := "replayed"
+	if dryRun {
+		verb = "would replay"
+	}
+	log.Printf("DinersClub %s %d dead letters onto %s (%d unreadable skipped)", verb, replayed, cfg.KafkaTopic, skipped)
+	return nil
+}
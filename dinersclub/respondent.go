@@ -0,0 +1,232 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"text/template"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// Respondent-facing failure messages.
+//
+// replybot flattens a delivered Result into the respondent's metadata, and
+// surveys show md.e_payment_<provider>_error_message to the respondent. That
+// used to be whatever the provider or dinersclub put in PaymentError.Message:
+// raw provider text in the provider's language, or an English sentence
+// written for a developer ("no provider with that name is configured").
+//
+// A failure that is sent now carries a message from this catalogue instead,
+// in the survey's language. The text the provider gave is kept in
+// Result.Diagnostic, which is logged but stripped before a Result goes to
+// botserver, so it never reaches the respondent. The same goes for the
+// messages of fallback hops that failed first (Result.Attempts): only their
+// codes are sent.
+//
+// A message is chosen by error code when the catalogue has one for it, and
+// otherwise by Recovery class. Templates see the Result's Code and the
+// Provider. Every language must have every message; a test holds it to that.
+//
+// The language is the survey's own setting (settings.language in the stored
+// Typeform form). "pt_BR" and "pt-BR" both fall back to "pt", and anything
+// the catalogue does not have to DINERSCLUB_DEFAULT_LANGUAGE.
+
+// respondentMessageKey groups the codes that warrant the same explanation.
+var respondentMessageKey = map[string]string{
+	"INVALID_RECIPIENT_PHONE":        "phone",
+	"INVALID_PHONE_NUMBER":           "phone",
+	"COULD_NOT_AUTO_DETECT_OPERATOR": "phone",
+	"OPERATOR_NOT_FOUND":             "phone",
+	"RECIPIENT_PHONE_INACTIVE":       "phone",
+	"PHONE_BANNED_BY_OPERATOR":       "phone",
+
+	"PHONE_RECENTLY_RECHARGED":           "recharged",
+	"RECIPIENT_REACHED_MAX_TOPUP_NUMBER": "recharged",
+
+	"IMPOSSIBLE_AMOUNT":                  "amount",
+	"INVALID_AMOUNT_FOR_RECIPIENT_PHONE": "amount",
+	"INVALID_AMOUNT_FOR_OPERATOR":        "amount",
+
+	"INVALID_ACCOUNT_NUMBER":            "account",
+	"MOMO_PAYEE_NOT_FOUND":              "account",
+	"MOMO_PAYEE_NOT_ALLOWED_TO_RECEIVE": "account",
+
+	PaymentRejected: "rejected",
+}
+
+// respondentCatalogue holds, per language, a template for each message key
+// and each Recovery class.
+var respondentCatalogue = map[string]map[string]string{
+	"en": {
+		string(RecoveryPermanent):    "Sorry, we could not send your payment. Please contact the study team and mention this reference: {{.Code}}.",
+		string(RecoveryTransient):    "Your payment is delayed. We will try again, and you do not need to do anything.",
+		string(RecoveryPrecondition): "Your payment is on hold for now. We will send it as soon as we can.",
+		"phone":                      "We could not send your payment to that phone number. Please check that the number is correct and active.",
+		"recharged":                  "That phone number has received too many top-ups recently, so we could not send your payment to it.",
+		"amount":                     "Your payment could not be sent to that number for this amount. Please contact the study team and mention this reference: {{.Code}}.",
+		"account":                    "We could not send your payment to that account. Please check that the account details are correct.",
+		"rejected":                   "Your payment was not approved. Please contact the study team if you think this is a mistake.",
+	},
+	"es": {
+		string(RecoveryPermanent):    "Lo sentimos, no pudimos enviar su pago. Comuníquese con el equipo del estudio e indique esta referencia: {{.Code}}.",
+		string(RecoveryTransient):    "Su pago está retrasado. Lo intentaremos de nuevo; no necesita hacer nada.",
+		string(RecoveryPrecondition): "Su pago está en espera por ahora. Lo enviaremos lo antes posible.",
+		"phone":                      "No pudimos enviar su pago a ese número de teléfono. Verifique que el número sea correcto y esté activo.",
+		"recharged":                  "Ese número de teléfono ha recibido demasiadas recargas recientemente, por lo que no pudimos enviarle su pago.",
+		"amount":                     "No se pudo enviar su pago a ese número por este monto. Comuníquese con el equipo del estudio e indique esta referencia: {{.Code}}.",
+		"account":                    "No pudimos enviar su pago a esa cuenta. Verifique que los datos de la cuenta sean correctos.",
+		"rejected":                   "Su pago no fue aprobado. Comuníquese con el equipo del estudio si cree que se trata de un error.",
+	},
+	"fr": {
+		string(RecoveryPermanent):    "Désolé, nous n'avons pas pu envoyer votre paiement. Veuillez contacter l'équipe de l'étude en indiquant cette référence : {{.Code}}.",
+		string(RecoveryTransient):    "Votre paiement est retardé. Nous allons réessayer ; vous n'avez rien à faire.",
+		string(RecoveryPrecondition): "Votre paiement est en attente pour le moment. Nous l'enverrons dès que possible.",
+		"phone":                      "Nous n'avons pas pu envoyer votre paiement à ce numéro de téléphone. Veuillez vérifier que le numéro est correct et actif.",
+		"recharged":                  "Ce numéro de téléphone a reçu trop de recharges récemment, nous n'avons donc pas pu y envoyer votre paiement.",
+		"amount":                     "Votre paiement n'a pas pu être envoyé à ce numéro pour ce montant. Veuillez contacter l'équipe de l'étude en indiquant cette référence : {{.Code}}.",
+		"account":                    "Nous n'avons pas pu envoyer votre paiement sur ce compte. Veuillez vérifier que les informations du compte sont correctes.",
+		"rejected":                   "Votre paiement n'a pas été approuvé. Veuillez contacter l'équipe de l'étude si vous pensez qu'il s'agit d'une erreur.",
+	},
+	"pt": {
+		string(RecoveryPermanent):    "Desculpe, não conseguimos enviar seu pagamento. Entre em contato com a equipe do estudo e informe esta referência: {{.Code}}.",
+		string(RecoveryTransient):    "Seu pagamento está atrasado. Tentaremos novamente; você não precisa fazer nada.",
+		string(RecoveryPrecondition): "Seu pagamento está em espera no momento. Vamos enviá-lo assim que possível.",
+		"phone":                      "Não conseguimos enviar seu pagamento para esse número de telefone. Verifique se o número está correto e ativo.",
+		"recharged":                  "Esse número de telefone recebeu recargas demais recentemente, por isso não conseguimos enviar seu pagamento para ele.",
+		"amount":                     "Não foi possível enviar seu pagamento para esse número neste valor. Entre em contato com a equipe do estudo e informe esta referência: {{.Code}}.",
+		"account":                    "Não conseguimos enviar seu pagamento para essa conta. Verifique se os dados da conta estão corretos.",
+		"rejected":                   "Seu pagamento não foi aprovado. Entre em contato com a equipe do estudo se achar que é um engano.",
+	},
+}
+
+// respondentTemplates is respondentCatalogue, parsed once.
+var respondentTemplates = func() map[string]map[string]*template.Template {
+	parsed := map[string]map[string]*template.Template{}
+	for lang, messages := range respondentCatalogue {
+		parsed[lang] = map[string]*template.Template{}
+		for key, text := range messages {
+			parsed[lang][key] = template.Must(template.New(lang + "/" + key).Parse(text))
+		}
+	}
+	return parsed
+}()
+
+// catalogueLanguage picks the catalogue language for a survey language,
+// falling back from a regional variant to its base and then to fallback.
+func catalogueLanguage(lang, fallback string) string {
+	lang = strings.ToLower(strings.TrimSpace(lang))
+	if _, ok := respondentTemplates[lang]; ok {
+		return lang
+	}
+	if i := strings.IndexAny(lang, "-_"); i > 0 {
+		if _, ok := respondentTemplates[lang[:i]]; ok {
+			return lang[:i]
+		}
+	}
+	if _, ok := respondentTemplates[fallback]; ok {
+		return fallback
+	}
+	return "en"
+}
+
+// respondentMessage renders the message for a failure with code and
+// recovery, in lang.
+func respondentMessage(lang, code, provider string, recovery Recovery) (string, error) {
+	messages := respondentTemplates[lang]
+	tmpl, ok := messages[respondentMessageKey[code]]
+	if !ok {
+		if tmpl, ok = messages[string(recovery)]; !ok {
+			tmpl = messages[string(RecoveryPermanent)]
+		}
+	}
+
+	var b bytes.Buffer
+	data := struct{ Code, Provider string }{code, provider}
+	if err := tmpl.Execute(&b, data); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// surveyLanguage reads the language setting of the survey a payment came
+// from. It is "" when the event does not say which survey, or the survey has
+// none.
+func (dc *DC) surveyLanguage(pe *PaymentEvent) (string, error) {
+	if pe.Shortcode == "" || pe.Owner == "" || dc.pool == nil {
+		return "", nil
+	}
+
+	key := "language\x00" + pe.Owner + "\x00" + pe.Shortcode
+	if dc.cache != nil {
+		if v, ok := dc.cache.Get(key); ok {
+			return v.(string), nil
+		}
+	}
+
+	query := `
+		SELECT COALESCE(form_json->'settings'->>'language', '')
+		FROM surveys
+		WHERE shortcode = $1 AND userid::STRING = $2
+		ORDER BY created DESC
+		LIMIT 1`
+
+	var lang string
+	err := dc.pool.QueryRow(context.Background(), query, pe.Shortcode, pe.Owner).Scan(&lang)
+	if err != nil && err != pgx.ErrNoRows {
+		return "", err
+	}
+	if dc.cache != nil {
+		dc.cache.SetWithTTL(key, lang, 1, dc.cfg.CacheTTL)
+	}
+	return lang, nil
+}
+
+// explain replaces a failure's message with the catalogue's, keeping what
+// was there as the diagnostic. Explaining twice changes nothing. A survey
+// language that cannot be read is not worth failing a delivery over: the
+// default language is used.
+func (dc *DC) explain(pe *PaymentEvent, res *Result, recovery Recovery) {
+	if res == nil || res.Error == nil || res.Diagnostic != "" {
+		return
+	}
+
+	lang, err := dc.surveyLanguage(pe)
+	if err != nil {
+		recordFault("language")
+		log.Printf("DinersClub could not read the language of survey %s: %v", pe.Shortcode, err)
+	}
+	lang = catalogueLanguage(lang, dc.cfg.DefaultLanguage)
+
+	message, err := respondentMessage(lang, res.Error.Code, pe.Provider, recovery)
+	if err != nil {
+		// The catalogue is fixed and tested; a template that cannot render
+		// is a bug, and the respondent still gets the code.
+		log.Printf("DinersClub could not render the %s message for %s: %v", lang, res.Error.Code, err)
+		message = fmt.Sprintf("Payment failed: %s", res.Error.Code)
+	}
+
+	res.Diagnostic = res.Error.Message
+	if res.Diagnostic == "" {
+		res.Diagnostic = "(no message)"
+	}
+	res.Error = &PaymentError{message, res.Error.Code, res.Error.PaymentDetails}
+}
+
+// forRespondent is res as botserver gets it: without the diagnostic or the
+// failed hops' messages, which are provider text too. A payment that
+// succeeded on a fallback still carries the hops that failed, so this is
+// not only about failures.
+func forRespondent(res *Result) *Result {
+	out := *res
+	out.Diagnostic = ""
+	if len(res.Attempts) > 0 {
+		out.Attempts = make([]PaymentAttempt, len(res.Attempts))
+		for i, a := range res.Attempts {
+			out.Attempts[i] = PaymentAttempt{Provider: a.Provider, Code: a.Code}
+		}
+	}
+	return &out
+}
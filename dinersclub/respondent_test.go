@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"sync/atomic"
+	"testing"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEveryLanguageHasEveryMessage(t *testing.T) {
+	keys := []string{string(RecoveryPermanent), string(RecoveryTransient), string(RecoveryPrecondition)}
+	for _, key := range respondentMessageKey {
+		keys = append(keys, key)
+	}
+	for lang, messages := range respondentCatalogue {
+		assert.Equal(t, len(respondentCatalogue["en"]), len(messages), lang)
+		for _, key := range keys {
+			assert.NotEmpty(t, messages[key], "%s has no %q message", lang, key)
+		}
+	}
+}
+
+func TestCatalogueLanguage(t *testing.T) {
+	assert.Equal(t, "es", catalogueLanguage("es", "en"))
+	assert.Equal(t, "pt", catalogueLanguage("pt_BR", "en"))
+	assert.Equal(t, "fr", catalogueLanguage(" FR-ca ", "en"))
+	assert.Equal(t, "en", catalogueLanguage("sw", "en"))
+	assert.Equal(t, "fr", catalogueLanguage("", "fr"))
+	assert.Equal(t, "en", catalogueLanguage("", "klingon"))
+}
+
+func TestRespondentMessageByCodeThenRecovery(t *testing.T) {
+	m, err := respondentMessage("en", "INVALID_RECIPIENT_PHONE", "reloadly", RecoveryPermanent)
+	assert.Nil(t, err)
+	assert.Contains(t, m, "phone number")
+
+	m, _ = respondentMessage("es", "SOMETHING_NEW", "reloadly", RecoveryPermanent)
+	assert.Contains(t, m, "Lo sentimos")
+	assert.Contains(t, m, "SOMETHING_NEW", "the reference is the code")
+}
+
+func TestExplainKeepsTheProviderTextOutOfTheResult(t *testing.T) {
+	dc := offlineDC()
+	dc.cfg.DefaultLanguage = "en"
+	pe := &PaymentEvent{Provider: "reloadly"}
+	res := &Result{Type: "payment:reloadly", Error: &PaymentError{"Número inválido: 0801 (operator 341)", "INVALID_RECIPIENT_PHONE", nil}}
+
+	dc.explain(pe, res, RecoveryPermanent)
+	assert.Equal(t, "Número inválido: 0801 (operator 341)", res.Diagnostic)
+	assert.Contains(t, res.Error.Message, "phone number")
+	assert.Equal(t, "INVALID_RECIPIENT_PHONE", res.Error.Code)
+
+	explained := res.Error.Message
+	dc.explain(pe, res, RecoveryPermanent)
+	assert.Equal(t, explained, res.Error.Message, "explaining twice changes nothing")
+
+	b, _ := json.Marshal(forRespondent(res))
+	assert.NotContains(t, string(b), "0801")
+	assert.NotContains(t, string(b), "diagnostic")
+	assert.Equal(t, "Número inválido: 0801 (operator 341)", res.Diagnostic, "the Result itself keeps it")
+}
+
+// The hops a fallback chain tried first carry provider text too, whether
+// the chain ended in a failure or a success.
+func TestForRespondentStripsTheFailedHopsMessages(t *testing.T) {
+	dc := offlineDC()
+	dc.cfg.DefaultLanguage = "en"
+	attempts := []PaymentAttempt{{"reloadly", "INVALID_RECIPIENT_PHONE", "Número inválido: 0801 (operator 341)"}}
+
+	failed := &Result{Type: "payment:reloadly", Provider: "dingconnect", Attempts: attempts,
+		Error: &PaymentError{"AccountNumberInvalid: 0801", "INVALID_ACCOUNT_NUMBER", nil}}
+	dc.explain(&PaymentEvent{Provider: "dingconnect"}, failed, RecoveryPermanent)
+	succeeded := &Result{Type: "payment:reloadly", Provider: "dingconnect", Success: true, Attempts: attempts}
+
+	for _, res := range []*Result{failed, succeeded} {
+		b, _ := json.Marshal(forRespondent(res))
+		assert.NotContains(t, string(b), "0801")
+		assert.Contains(t, string(b), `"attempts":[{"provider":"reloadly","code":"INVALID_RECIPIENT_PHONE"}]`)
+	}
+	assert.Equal(t, "Número inválido: 0801 (operator 341)", attempts[0].Message, "the Result itself keeps it")
+}
+
+func TestFailureIsExplainedInTheSurveysLanguage(t *testing.T) {
+	var received int32
+	var last string
+	ts := countingBotserver(&received, &last)
+	defer ts.Close()
+
+	var attempts int32
+	owner := "00000000-0000-0000-0000-000000000000"
+	provider := &countingProvider{attempts: &attempts, owner: owner}
+	dc := getDC(ts)
+	dc.getProvider = func(pool *pgxpool.Pool, event *PaymentEvent) (Provider, error) { return provider, nil }
+	before(t, dc.pool)
+	insertDingUser(t, dc.pool)
+	mustExec(t, dc.pool, `
+		INSERT INTO surveys(created, formid, form, shortcode, title, userid)
+		VALUES (now(), 'f1', '{"settings": {"language": "es"}}', 'estudio', 'Estudio', '00000000-0000-0000-0000-000000000000');
+	`)
+
+	msg := `{
+		"userid": "foo",
+		"pageid": "page",
+		"shortcode": "estudio",
+		"timestamp": 1600558963867,
+		"provider": "fake",
+		"details": {
+			"result": {
+				"type": "payment:fake",
+				"id": "payment-1",
+				"success": false,
+				"error": {"message": "Operator said: recipient msisdn invalid", "code": "INVALID_RECIPIENT_PHONE"}
+			}
+		}
+	}`
+	assert.Nil(t, dc.Process(makeMessages([]string{msg})))
+
+	assert.Equal(t, int32(1), atomic.LoadInt32(&received))
+	assert.Contains(t, last, "No pudimos enviar su pago a ese número de teléfono")
+	assert.Contains(t, last, `"code":"INVALID_RECIPIENT_PHONE"`)
+	assert.NotContains(t, last, "msisdn")
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// Duplicate-recipient holds (devops/migrations/33).
+//
+// Job used to pay anything that validated, and incentivised surveys attract
+// people who take them over and over under different accounts with every
+// payment going to one phone. Before a payment is claimed, the ledger is
+// asked whether its recipient -- the number, account or email in the
+// details, normalised -- has been paid for DINERSCLUB_DUPLICATE_THRESHOLD or
+// more other respondents of the same researcher and survey within
+// DINERSCLUB_DUPLICATE_WINDOW. If so the payment is recorded 'held' and
+// withheld as RECIPIENT_UNDER_REVIEW, a precondition: the respondent waits
+// and dean keeps re-driving, and every re-drive finds it still held.
+//
+// Someone then approves or rejects it through the admin API (admin.go). An
+// approved payment is paid on the next re-drive and never held again; a
+// rejected one is answered PAYMENT_REJECTED, permanent, so the respondent is
+// told.
+//
+// Holds are off unless DINERSCLUB_DUPLICATE_WINDOW is set, and dinersclub
+// refuses to start with them on and no admin API to decide them
+// (checkReviewConfig): nobody could ever release a held payment.
+//
+// It is a heuristic, not a lock: two duplicates racing each other can both
+// pass. And it only sees what the ledger has recorded, so the first payment
+// to a recipient is never held -- only the ones after it.
+
+const (
+	RecipientUnderReview = "RECIPIENT_UNDER_REVIEW"
+	PaymentRejected      = "PAYMENT_REJECTED"
+)
+
+const (
+	paymentHeld     = "held"
+	paymentApproved = "approved"
+	paymentRejected = "rejected"
+)
+
+// checkReviewConfig refuses holds that nobody could decide.
+func checkReviewConfig(cfg *Config) error {
+	if cfg.DuplicateWindow > 0 && cfg.DuplicateThreshold > 0 && cfg.AdminToken == "" {
+		return fmt.Errorf("DINERSCLUB_DUPLICATE_WINDOW holds payments for review, but DINERSCLUB_ADMIN_TOKEN is unset, so the admin API that decides them is off")
+	}
+	return nil
+}
+
+// paymentRecipient is who a payment goes to, from whichever field its
+// provider uses, or "" if it has none. Phone numbers keep only their digits
+// and emails are lowercased, so "+234 801-234" and "234801234" match.
+func paymentRecipient(pe *PaymentEvent) string {
+	if pe.Details == nil {
+		return ""
+	}
+	d := map[string]interface{}{}
+	if err := json.Unmarshal(*pe.Details, &d); err != nil {
+		return ""
+	}
+
+	for _, field := range []string{"number", "account_number", "phone", "recipient_phone"} {
+		if v, ok := d[field]; ok && v != nil {
+			digits := strings.Map(func(r rune) rune {
+				if unicode.IsDigit(r) {
+					return r
+				}
+				return -1
+			}, fmt.Sprint(v))
+			if digits != "" {
+				return digits
+			}
+		}
+	}
+	for _, field := range []string{"recipientEmail", "recipient_email"} {
+		if v, ok := d[field].(string); ok && strings.TrimSpace(v) != "" {
+			return strings.ToLower(strings.TrimSpace(v))
+		}
+	}
+	return ""
+}
+
+// reviewPayment decides whether a payment may go ahead. It returns the Result
+// to deliver instead -- held or rejected -- or nil to pay it.
+func reviewPayment(pool *pgxpool.Pool, pe *PaymentEvent, owner string, cfg *Config) (*Result, error) {
+	ctx := context.Background()
+
+	var status string
+	var review *string
+	err := pool.QueryRow(ctx, `SELECT status, review FROM payments WHERE idempotency_key = $1`, pe.IdempotencyKey).
+		Scan(&status, &review)
+	if err != nil && err != pgx.ErrNoRows {
+		return nil, err
+	}
+
+	switch {
+	case review != nil && *review == paymentRejected:
+		return reviewResult(pe, PaymentRejected, "This payment was reviewed and rejected."), nil
+	case review != nil && *review == paymentApproved:
+		return nil, nil
+	case status == paymentHeld:
+		return reviewResult(pe, RecipientUnderReview, "This payment is held for review."), nil
+	}
+
+	recipient := paymentRecipient(pe)
+	if recipient == "" || cfg.DuplicateWindow <= 0 || cfg.DuplicateThreshold <= 0 {
+		return nil, nil
+	}
+
+	query := `
+		SELECT count(DISTINCT userid)
+		FROM payments
+		WHERE owner = $1
+		  AND recipient = $2
+		  AND userid != $3
+		  AND shortcode IS NOT DISTINCT FROM NULLIF($4, '')
+		  AND status IN ('attempting', 'pending', 'success', 'held', 'approved')
+		  AND created_at > $5`
+
+	var others int
+	err = pool.QueryRow(ctx, query, owner, recipient, pe.Userid, pe.Shortcode, time.Now().UTC().Add(-cfg.DuplicateWindow)).
+		Scan(&others)
+	if err != nil {
+		return nil, err
+	}
+	if others < cfg.DuplicateThreshold {
+		return nil, nil
+	}
+
+	reason := fmt.Sprintf("Recipient %s was paid for %d other respondent(s) of this survey in the last %s.", recipient, others, cfg.DuplicateWindow)
+	if err := holdPayment(ctx, pool, pe, owner, recipient, reason); err != nil {
+		return nil, err
+	}
+	return reviewResult(pe, RecipientUnderReview, "This payment is held for review."), nil
+}
+
+// holdPayment records a payment as held. Only a payment with no row, or a
+// failed one, gets here: anything else was answered above or is in flight.
+func holdPayment(ctx context.Context, pool *pgxpool.Pool, pe *PaymentEvent, owner, recipient, reason string) error {
+	query := `
+		INSERT INTO payments (idempotency_key, userid, pageid, provider, payment_id, owner, status, details,
+			shortcode, amount, platform, credential_key, recipient, review_reason)
+		VALUES ($1, $2, $3, $4, NULLIF($5, ''), $6, $7, $8, NULLIF($9, ''), $10, NULLIF($11, ''), NULLIF($12, ''), $13, $14)
+		ON CONFLICT (idempotency_key) DO UPDATE
+		SET status = excluded.status, details = excluded.details, recipient = excluded.recipient,
+			review_reason = excluded.review_reason, updated_at = now()
+		WHERE payments.status = $15`
+
+	_, err := pool.Exec(ctx, query,
+		pe.IdempotencyKey, pe.Userid, pe.Pageid, pe.Provider, paymentID(pe), owner, paymentHeld, pe.Details,
+		pe.Shortcode, paymentAmount(pe), pe.Platform, pe.Key, recipient, reason, paymentFailed)
+	return err
+}
+
+// decideReview approves or rejects a held payment. It reports false if the
+// payment is not held.
+func decideReview(pool *pgxpool.Pool, key, decision, reviewer string) (bool, error) {
+	query := `
+		UPDATE payments
+		SET status = $2, review = $2, reviewed_by = NULLIF($3, ''), reviewed_at = now(), updated_at = now()
+		WHERE idempotency_key = $1 AND status = $4`
+
+	tag, err := pool.Exec(context.Background(), query, key, decision, reviewer, paymentHeld)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+func reviewResult(pe *PaymentEvent, code, message string) *Result {
+	err := &PaymentError{message, code, pe.Details}
+	t := fmt.Sprintf("payment:%v", pe.Provider)
+	return &Result{Type: t, ID: paymentID(pe), Success: false, Timestamp: time.Now().UTC(), Error: err}
+}
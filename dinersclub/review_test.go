@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// recipientMessage is a successful fake-provider payment from userid to
+// number.
+func recipientMessage(userid, id, number string) string {
+	return fmt.Sprintf(`{
+		"userid": %q,
+		"pageid": "page",
+		"timestamp": 1600558963867,
+		"provider": "fake",
+		"details": {
+			"id": %q,
+			"number": %q,
+			"result": {"type": "payment:fake", "id": %q, "success": true}
+		}
+	}`, userid, id, number, id)
+}
+
+func TestPaymentRecipient(t *testing.T) {
+	tests := []struct {
+		details string
+		want    string
+	}{
+		{`{"number": "+234 801-234"}`, "234801234"},
+		{`{"account_number": 12345}`, "12345"},
+		{`{"recipient_phone": "(555) 010"}`, "555010"},
+		{`{"recipientEmail": " Someone@Example.COM "}`, "someone@example.com"},
+		{`{"recipient_email": "a@b.c"}`, "a@b.c"},
+		{`{"number": "", "recipientEmail": "a@b.c"}`, "a@b.c"},
+		{`{"amount": 5}`, ""},
+		{`[1, 2]`, ""},
+	}
+	for _, tt := range tests {
+		d := json.RawMessage(tt.details)
+		assert.Equal(t, tt.want, paymentRecipient(&PaymentEvent{Details: &d}), tt.details)
+	}
+	assert.Equal(t, "", paymentRecipient(&PaymentEvent{}))
+}
+
+func TestHoldsNeedTheAdminAPI(t *testing.T) {
+	cfg := &Config{DuplicateThreshold: 1}
+	assert.Nil(t, checkReviewConfig(cfg), "holds are off by default")
+
+	cfg.DuplicateWindow = 720 * time.Hour
+	assert.NotNil(t, checkReviewConfig(cfg), "nobody could decide a hold")
+
+	cfg.AdminToken = "secret"
+	assert.Nil(t, checkReviewConfig(cfg))
+}
+
+func TestAdminRefusesRequestsWithoutTheToken(t *testing.T) {
+	dc := offlineDC()
+	dc.cfg.AdminToken = "secret"
+	h := dc.adminHandler()
+
+	for _, auth := range []string{"", "Bearer wrong", "secret"} {
+		req := httptest.NewRequest("GET", "/admin/reviews", nil)
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusUnauthorized, rec.Code, auth)
+	}
+}
+
+func adminRequest(t *testing.T, dc *DC, method, path string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, path, strings.NewReader(`{"reviewer": "ops"}`))
+	req.Header.Set("Authorization", "Bearer "+dc.cfg.AdminToken)
+	rec := httptest.NewRecorder()
+	dc.adminHandler().ServeHTTP(rec, req)
+	return rec
+}
+
+func heldPayments(t *testing.T, dc *DC) []HeldPayment {
+	t.Helper()
+	rec := adminRequest(t, dc, "GET", "/admin/reviews")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	held := []HeldPayment{}
+	assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), &held))
+	return held
+}
+
+func TestDuplicateRecipientIsHeldUntilApproved(t *testing.T) {
+	var received int32
+	var last string
+	ts := countingBotserver(&received, &last)
+	defer ts.Close()
+
+	dc := getDC(ts)
+	dc.cfg.AdminToken = "secret"
+	dc.cfg.DuplicateWindow = 720 * time.Hour
+
+	assert.Nil(t, dc.Process(makeMessages([]string{recipientMessage("foo", "p-foo", "+234 801")})))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&received))
+
+	dup := recipientMessage("bar", "p-bar", "234-801")
+	assert.Nil(t, dc.Process(makeMessages([]string{dup})))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&received), "a held payment must not release the respondent")
+
+	held := heldPayments(t, dc)
+	assert.Equal(t, 1, len(held))
+	assert.Equal(t, "bar", held[0].Userid)
+	assert.Equal(t, "234801", *held[0].Recipient)
+
+	// Still held on a re-drive.
+	assert.Nil(t, dc.Process(makeMessages([]string{dup})))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&received))
+
+	rec := adminRequest(t, dc, "POST", "/admin/reviews/"+held[0].IdempotencyKey+"/approve")
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+	assert.Equal(t, 0, len(heldPayments(t, dc)))
+
+	assert.Nil(t, dc.Process(makeMessages([]string{dup})))
+	assert.Equal(t, int32(2), atomic.LoadInt32(&received))
+	assert.Contains(t, last, `"success":true`)
+
+	rec = adminRequest(t, dc, "POST", "/admin/reviews/"+held[0].IdempotencyKey+"/approve")
+	assert.Equal(t, http.StatusNotFound, rec.Code, "only a held payment can be decided")
+}
+
+func TestRejectedPaymentIsAnsweredPaymentRejected(t *testing.T) {
+	var received int32
+	var last string
+	ts := countingBotserver(&received, &last)
+	defer ts.Close()
+
+	dc := getDC(ts)
+	dc.cfg.AdminToken = "secret"
+	dc.cfg.DuplicateWindow = 720 * time.Hour
+
+	assert.Nil(t, dc.Process(makeMessages([]string{recipientMessage("foo", "p-foo", "+234 801")})))
+	dup := recipientMessage("bar", "p-bar", "+234 801")
+	assert.Nil(t, dc.Process(makeMessages([]string{dup})))
+
+	held := heldPayments(t, dc)
+	assert.Equal(t, 1, len(held))
+	rec := adminRequest(t, dc, "POST", "/admin/reviews/"+held[0].IdempotencyKey+"/reject")
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+
+	assert.Nil(t, dc.Process(makeMessages([]string{dup})))
+	assert.Equal(t, int32(2), atomic.LoadInt32(&received))
+	assert.Contains(t, last, PaymentRejected)
+}
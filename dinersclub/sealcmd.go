@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"sort"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// `dinersclub seal-credentials` seals the secret fields of existing
+// credentials (see sealed.go) and re-wraps fields sealed under any key but
+// the keyring's current one. Run it after turning sealing on, after adding a
+// key to rotate to, and whenever the dashboard may have written new
+// plaintext; a row with nothing to do is not touched.
+//
+//	dinersclub seal-credentials -dry-run
+//	dinersclub seal-credentials -entities secrets,reloadly,momo,facebook_page
+//
+// Each row is updated only if its details are still what was read, so a
+// credential the dashboard changes mid-run is left for the next run rather
+// than overwritten with the old value.
+//
+// The messaging entities are not sealed by default. dashboard-server reads
+// page and WhatsApp tokens straight from the table, and would be handed
+// ciphertext.
+
+// sealedFields are the fields of each entity that hold a secret.
+var sealedFields = map[string][]string{
+	"secrets":           {"value"},
+	"reloadly":          {"secret"},
+	"momo":              {"api_key", "subscription_key"},
+	"facebook_page":     {"access_token", "token"},
+	"whatsapp_business": {"access_token", "token"},
+}
+
+const defaultSealedEntities = "secrets,reloadly,momo"
+
+// sealFields seals or re-wraps the named fields of details. changed is false
+// when there was nothing to do.
+func sealFields(w KeyWrapper, details []byte, names []string) (out []byte, changed bool, err error) {
+	fields := map[string]json.RawMessage{}
+	if err := json.Unmarshal(details, &fields); err != nil {
+		return nil, false, err
+	}
+	for _, name := range names {
+		var v string
+		if raw, ok := fields[name]; !ok || json.Unmarshal(raw, &v) != nil || v == "" {
+			continue
+		}
+
+		var next string
+		if isSealed(v) {
+			var moved bool
+			if next, moved, err = rewrapValue(w, v); err != nil {
+				return nil, false, fmt.Errorf("%s: %w", name, err)
+			}
+			if !moved {
+				continue
+			}
+		} else if next, err = sealValue(w, v); err != nil {
+			return nil, false, fmt.Errorf("%s: %w", name, err)
+		}
+
+		if fields[name], err = json.Marshal(next); err != nil {
+			return nil, false, err
+		}
+		changed = true
+	}
+	if !changed {
+		return details, false, nil
+	}
+	out, err = json.Marshal(fields)
+	return out, true, err
+}
+
+func parseSealFlags(args []string) (entities []string, dryRun bool, err error) {
+	fs := flag.NewFlagSet("seal-credentials", flag.ContinueOnError)
+	list := fs.String("entities", defaultSealedEntities, "comma-separated credential entities to seal")
+	dry := fs.Bool("dry-run", false, "count what would change without writing")
+	if err := fs.Parse(args); err != nil {
+		return nil, false, err
+	}
+
+	entities = splitList(*list)
+	if len(entities) == 0 {
+		return nil, false, errors.New("-entities lists no entities")
+	}
+	for _, e := range entities {
+		if _, ok := sealedFields[e]; !ok {
+			known := []string{}
+			for k := range sealedFields {
+				known = append(known, k)
+			}
+			sort.Strings(known)
+			return nil, false, fmt.Errorf("no secret fields are known for entity %q (known: %v)", e, known)
+		}
+	}
+	return entities, *dry, nil
+}
+
+// sealCredentials is the seal-credentials command.
+func sealCredentials(pool *pgxpool.Pool, w KeyWrapper, args []string) error {
+	if w == nil {
+		return errors.New("DINERSCLUB_CREDENTIALS_KEYRING is not set")
+	}
+	entities, dryRun, err := parseSealFlags(args)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	type row struct {
+		userid, entity, key string
+		details             []byte
+	}
+	rows := []row{}
+	res, err := pool.Query(ctx, `SELECT userid::STRING, entity, key, details FROM credentials WHERE entity = ANY($1)`, entities)
+	if err != nil {
+		return err
+	}
+	for res.Next() {
+		var r row
+		if err := res.Scan(&r.userid, &r.entity, &r.key, &r.details); err != nil {
+			res.Close()
+			return err
+		}
+		rows = append(rows, r)
+	}
+	res.Close()
+	if err := res.Err(); err != nil {
+		return err
+	}
+
+	sealed, raced := 0, 0
+	for _, r := range rows {
+		out, changed, err := sealFields(w, r.details, sealedFields[r.entity])
+		if err != nil {
+			return fmt.Errorf("%s credential %q: %w", r.entity, r.key, err)
+		}
+		if !changed {
+			continue
+		}
+		sealed++
+		if dryRun {
+			continue
+		}
+
+		tag, err := pool.Exec(ctx, `
+			UPDATE credentials SET details = $4
+			WHERE userid::STRING = $1 AND entity = $2 AND key = $3 AND details = $5`,
+			r.userid, r.entity, r.key, out, r.details)
+		if err != nil {
+			return fmt.Errorf("%s credential %q: %w", r.entity, r.key, err)
+		}
+		if tag.RowsAffected() == 0 {
+			sealed--
+			raced++
+		}
+	}
+
+	verb := "sealed or re-wrapped"
+	if dryRun {
+		verb = "would seal or re-wrap"
+	}
+	log.Printf("DinersClub %s %d of %d credentials under key %q (%d changed underneath us, left for the next run)",
+		verb, sealed, len(rows), w.CurrentKeyID(), raced)
+	return nil
+}
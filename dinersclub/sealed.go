@@ -0,0 +1,254 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Sealed credentials.
+//
+// Researcher API keys used to sit in credentials.details in plaintext, so a
+// database dump or a read replica was every provider account we pay through.
+// A secret field can now be sealed in place with envelope encryption:
+//
+//	"secret": "sealed:v1:<key id>:<wrapped data key>:<ciphertext>"
+//
+// Each value gets its own random data key, AES-256-GCM. The data key is
+// wrapped by a key-encryption key named by <key id>, and only the KEK lives
+// outside the database -- in a key file (fileKeyring), or in a KMS behind the
+// KeyWrapper interface. Only the secret fields are sealed, so everything else
+// in details (names, page ids, the facebook_page_id column computed from
+// them) reads as before.
+//
+// Every credential read in dinersclub goes through openValue or openDetails.
+// A value that is not sealed passes through unchanged, which is what lets
+// rows be sealed gradually: the dashboard still writes plaintext, and
+// `dinersclub seal-credentials` (sealcmd.go) seals whatever it finds --
+// and re-wraps what was sealed under a retired key, which is rotation.
+//
+// message-worker opens the same format (message-worker/sealed.go) for the
+// messaging tokens. Change one, change both.
+
+const sealedPrefix = "sealed:v1:"
+
+// KeyWrapper wraps and unwraps data keys under named key-encryption keys.
+// A KMS client fits it as well as a key file does.
+type KeyWrapper interface {
+	// CurrentKeyID is the key new values are sealed under.
+	CurrentKeyID() string
+	WrapKey(kid string, dek []byte) ([]byte, error)
+	UnwrapKey(kid string, wrapped []byte) ([]byte, error)
+}
+
+// credentialKeys opens sealed credentials. Nil when
+// DINERSCLUB_CREDENTIALS_KEYRING is unset: plaintext credentials still work,
+// sealed ones are an error naming the setting.
+var credentialKeys KeyWrapper
+
+// fileKeyring is a KeyWrapper over KEKs read from a JSON key file:
+//
+//	{"current": "2026-10", "keys": {"2026-10": "<base64 32 bytes>", "2026-01": "..."}}
+//
+// Rotation is adding a key, making it current, and running seal-credentials;
+// the old key can go once nothing is sealed under it.
+type fileKeyring struct {
+	current string
+	keys    map[string][]byte
+}
+
+func loadKeyring(path string) (*fileKeyring, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	file := struct {
+		Current string            `json:"current"`
+		Keys    map[string]string `json:"keys"`
+	}{}
+	if err := json.Unmarshal(b, &file); err != nil {
+		return nil, fmt.Errorf("keyring %s: %w", path, err)
+	}
+
+	k := &fileKeyring{current: file.Current, keys: map[string][]byte{}}
+	for kid, enc := range file.Keys {
+		if kid == "" || strings.Contains(kid, ":") {
+			return nil, fmt.Errorf("keyring %s: key id %q must be non-empty and contain no colon", path, kid)
+		}
+		key, err := base64.StdEncoding.DecodeString(enc)
+		if err != nil || len(key) != 32 {
+			return nil, fmt.Errorf("keyring %s: key %q must be 32 bytes, base64", path, kid)
+		}
+		k.keys[kid] = key
+	}
+	if _, ok := k.keys[k.current]; !ok {
+		return nil, fmt.Errorf("keyring %s: current key %q is not in keys", path, k.current)
+	}
+	return k, nil
+}
+
+func (k *fileKeyring) CurrentKeyID() string { return k.current }
+
+func (k *fileKeyring) WrapKey(kid string, dek []byte) ([]byte, error) {
+	kek, ok := k.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("no key %q in the keyring", kid)
+	}
+	return gcmSeal(kek, dek)
+}
+
+func (k *fileKeyring) UnwrapKey(kid string, wrapped []byte) ([]byte, error) {
+	kek, ok := k.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("no key %q in the keyring", kid)
+	}
+	return gcmOpen(kek, wrapped)
+}
+
+// gcmSeal encrypts with AES-GCM under key, nonce first.
+func gcmSeal(key, plaintext []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func gcmOpen(key, sealed []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, errors.New("sealed value is truncated")
+	}
+	n := gcm.NonceSize()
+	return gcm.Open(nil, sealed[:n], sealed[n:], nil)
+}
+
+func isSealed(v string) bool { return strings.HasPrefix(v, sealedPrefix) }
+
+// sealValue seals plaintext under w's current key.
+func sealValue(w KeyWrapper, plaintext string) (string, error) {
+	dek := make([]byte, 32)
+	if _, err := rand.Read(dek); err != nil {
+		return "", err
+	}
+	ct, err := gcmSeal(dek, []byte(plaintext))
+	if err != nil {
+		return "", err
+	}
+	kid := w.CurrentKeyID()
+	wrapped, err := w.WrapKey(kid, dek)
+	if err != nil {
+		return "", err
+	}
+	enc := base64.RawStdEncoding.EncodeToString
+	return sealedPrefix + kid + ":" + enc(wrapped) + ":" + enc(ct), nil
+}
+
+// parseSealed splits a sealed value into its key id, wrapped data key and
+// ciphertext.
+func parseSealed(v string) (kid string, wrapped, ct []byte, err error) {
+	parts := strings.Split(strings.TrimPrefix(v, sealedPrefix), ":")
+	if len(parts) != 3 {
+		return "", nil, nil, errors.New("malformed sealed value")
+	}
+	dec := base64.RawStdEncoding.DecodeString
+	if wrapped, err = dec(parts[1]); err != nil {
+		return "", nil, nil, fmt.Errorf("malformed sealed value: %w", err)
+	}
+	if ct, err = dec(parts[2]); err != nil {
+		return "", nil, nil, fmt.Errorf("malformed sealed value: %w", err)
+	}
+	return parts[0], wrapped, ct, nil
+}
+
+// openValue returns a sealed value's plaintext. Anything not sealed is
+// returned as it is.
+func openValue(w KeyWrapper, v string) (string, error) {
+	if !isSealed(v) {
+		return v, nil
+	}
+	if w == nil {
+		return "", errors.New("credential is sealed but DINERSCLUB_CREDENTIALS_KEYRING is not set")
+	}
+	kid, wrapped, ct, err := parseSealed(v)
+	if err != nil {
+		return "", err
+	}
+	dek, err := w.UnwrapKey(kid, wrapped)
+	if err != nil {
+		return "", fmt.Errorf("unwrapping credential key under %q: %w", kid, err)
+	}
+	pt, err := gcmOpen(dek, ct)
+	if err != nil {
+		return "", fmt.Errorf("opening credential: %w", err)
+	}
+	return string(pt), nil
+}
+
+// rewrapValue moves a sealed value onto w's current key, leaving its data
+// key and ciphertext alone. changed is false if it was already there.
+func rewrapValue(w KeyWrapper, v string) (out string, changed bool, err error) {
+	kid, wrapped, ct, err := parseSealed(v)
+	if err != nil {
+		return "", false, err
+	}
+	current := w.CurrentKeyID()
+	if kid == current {
+		return v, false, nil
+	}
+	dek, err := w.UnwrapKey(kid, wrapped)
+	if err != nil {
+		return "", false, err
+	}
+	if wrapped, err = w.WrapKey(current, dek); err != nil {
+		return "", false, err
+	}
+	enc := base64.RawStdEncoding.EncodeToString
+	return sealedPrefix + current + ":" + enc(wrapped) + ":" + enc(ct), true, nil
+}
+
+// openDetails opens every sealed top-level field of a credential's details.
+func openDetails(w KeyWrapper, details []byte) ([]byte, error) {
+	if !strings.Contains(string(details), sealedPrefix) {
+		return details, nil
+	}
+	fields := map[string]json.RawMessage{}
+	if err := json.Unmarshal(details, &fields); err != nil {
+		return nil, err
+	}
+	for name, raw := range fields {
+		var s string
+		if json.Unmarshal(raw, &s) != nil || !isSealed(s) {
+			continue
+		}
+		pt, err := openValue(w, s)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		if fields[name], err = json.Marshal(pt); err != nil {
+			return nil, err
+		}
+	}
+	return json.Marshal(fields)
+}
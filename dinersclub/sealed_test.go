@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testKeyring writes a key file with a fresh key for each id and loads it,
+// current being the first. It is the stand-in for a KMS in tests.
+func testKeyring(t *testing.T, ids ...string) (*fileKeyring, map[string]string) {
+	t.Helper()
+	keys := map[string]string{}
+	for _, id := range ids {
+		k := make([]byte, 32)
+		rand.Read(k)
+		keys[id] = base64.StdEncoding.EncodeToString(k)
+	}
+	return writeKeyring(t, ids[0], keys), keys
+}
+
+func writeKeyring(t *testing.T, current string, keys map[string]string) *fileKeyring {
+	t.Helper()
+	b, _ := json.Marshal(map[string]interface{}{"current": current, "keys": keys})
+	path := filepath.Join(t.TempDir(), "keyring.json")
+	if err := os.WriteFile(path, b, 0600); err != nil {
+		t.Fatal(err)
+	}
+	k, err := loadKeyring(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+func TestSealedValueRoundTrips(t *testing.T) {
+	k, _ := testKeyring(t, "k1")
+
+	sealed, err := sealValue(k, "api-key-123")
+	assert.Nil(t, err)
+	assert.True(t, strings.HasPrefix(sealed, "sealed:v1:k1:"))
+	assert.NotContains(t, sealed, "api-key-123")
+
+	again, _ := sealValue(k, "api-key-123")
+	assert.NotEqual(t, sealed, again, "every value gets its own data key and nonce")
+
+	opened, err := openValue(k, sealed)
+	assert.Nil(t, err)
+	assert.Equal(t, "api-key-123", opened)
+}
+
+func TestPlaintextPassesThrough(t *testing.T) {
+	v, err := openValue(nil, "plain")
+	assert.Nil(t, err)
+	assert.Equal(t, "plain", v)
+
+	details := []byte(`{"id": "abc", "secret": "plain"}`)
+	out, err := openDetails(nil, details)
+	assert.Nil(t, err)
+	assert.Equal(t, details, out)
+}
+
+func TestOpeningNeedsTheRightKey(t *testing.T) {
+	k, _ := testKeyring(t, "k1")
+	sealed, _ := sealValue(k, "api-key-123")
+
+	_, err := openValue(nil, sealed)
+	assert.Contains(t, err.Error(), "DINERSCLUB_CREDENTIALS_KEYRING")
+
+	other, _ := testKeyring(t, "k1")
+	_, err = openValue(other, sealed)
+	assert.NotNil(t, err)
+
+	_, err = openValue(k, "sealed:v1:k1:garbage")
+	assert.Contains(t, err.Error(), "malformed")
+}
+
+func TestRotationRewrapsOntoTheCurrentKey(t *testing.T) {
+	old, keys := testKeyring(t, "k1")
+	sealed, _ := sealValue(old, "api-key-123")
+
+	keys["k2"] = base64.StdEncoding.EncodeToString(make([]byte, 32))
+	rotated := writeKeyring(t, "k2", keys)
+
+	out, changed, err := rewrapValue(rotated, sealed)
+	assert.Nil(t, err)
+	assert.True(t, changed)
+	assert.True(t, strings.HasPrefix(out, "sealed:v1:k2:"))
+
+	opened, err := openValue(rotated, out)
+	assert.Nil(t, err)
+	assert.Equal(t, "api-key-123", opened)
+
+	_, changed, _ = rewrapValue(rotated, out)
+	assert.False(t, changed)
+}
+
+func TestSealFieldsOnlySealsSecrets(t *testing.T) {
+	k, _ := testKeyring(t, "k1")
+	details := []byte(`{"api_user": "user", "api_key": "key", "subscription_key": "sub", "target_environment": "mtnghana"}`)
+
+	out, changed, err := sealFields(k, details, sealedFields["momo"])
+	assert.Nil(t, err)
+	assert.True(t, changed)
+
+	fields := map[string]string{}
+	json.Unmarshal(out, &fields)
+	assert.Equal(t, "user", fields["api_user"])
+	assert.Equal(t, "mtnghana", fields["target_environment"])
+	assert.True(t, isSealed(fields["api_key"]))
+	assert.True(t, isSealed(fields["subscription_key"]))
+
+	_, changed, err = sealFields(k, out, sealedFields["momo"])
+	assert.Nil(t, err)
+	assert.False(t, changed, "sealing twice changes nothing")
+
+	opened, err := openDetails(k, out)
+	assert.Nil(t, err)
+	assert.JSONEq(t, string(details), string(opened))
+}
+
+func TestLoadKeyringValidates(t *testing.T) {
+	good := base64.StdEncoding.EncodeToString(make([]byte, 32))
+	for _, file := range []string{
+		`{"current": "k2", "keys": {"k1": "` + good + `"}}`,
+		`{"current": "k1", "keys": {"k1": "c2hvcnQ="}}`,
+		`{"current": "a:b", "keys": {"a:b": "` + good + `"}}`,
+		`not json`,
+	} {
+		path := filepath.Join(t.TempDir(), "keyring.json")
+		os.WriteFile(path, []byte(file), 0600)
+		_, err := loadKeyring(path)
+		assert.NotNil(t, err, file)
+	}
+}
+
+func TestParseSealFlags(t *testing.T) {
+	entities, dryRun, err := parseSealFlags(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"secrets", "reloadly", "momo"}, entities)
+	assert.False(t, dryRun)
+
+	_, _, err = parseSealFlags([]string{"-entities", "users"})
+	assert.Contains(t, err.Error(), "users")
+}
+
+func TestSealedGenericSecretIsOpened(t *testing.T) {
+	cfg := getConfig()
+	pool := getPool(cfg)
+	defer pool.Close()
+
+	before(t, pool)
+	insertDingUser(t, pool)
+
+	k, _ := testKeyring(t, "k1")
+	credentialKeys = k
+	defer func() { credentialKeys = nil }()
+
+	mustExec(t, pool, `
+		INSERT INTO credentials(userid, entity, key, details)
+		VALUES ('00000000-0000-0000-0000-000000000000', 'secrets', 'API_KEY', '{"value": "test_api_key_12345"}');
+	`)
+
+	assert.Nil(t, sealCredentials(pool, k, nil))
+
+	var stored string
+	err := pool.QueryRow(context.Background(), `SELECT details->>'value' FROM credentials WHERE key = 'API_KEY'`).Scan(&stored)
+	assert.Nil(t, err)
+	assert.True(t, isSealed(stored), fmt.Sprintf("stored %q", stored))
+
+	secret, err := secretForUser(pool, "00000000-0000-0000-0000-000000000000", "API_KEY")
+	assert.Nil(t, err)
+	assert.Equal(t, "test_api_key_12345", secret)
+}
@@ -50,5 +50,9 @@ func secretForUser(pool *pgxpool.Pool, userid, name string) (string, error) {
 		return "", fmt.Errorf(`The Generic Secret named %q is empty. Set its value in the dashboard under Connected Accounts.`, name)
 	}
 
-	return *value, nil
+	secret, err := openValue(credentialKeys, *value)
+	if err != nil {
+		return "", fmt.Errorf(`The Generic Secret named %q could not be opened: %w`, name, err)
+	}
+	return secret, nil
 }
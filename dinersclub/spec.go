@@ -0,0 +1,623 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dgraph-io/ristretto"
+	"github.com/go-playground/validator/v10"
+	"github.com/vlab-research/go-reloadly/reloadly"
+)
+
+// Provider-agnostic payment specs.
+//
+// Every provider has its own details format, and each one hard-codes an
+// amount in that provider's terms: a Reloadly `amount` in the account's
+// currency, a DingConnect `sku_code` and `send_value`, a gift card product
+// and `unitPrice`. A researcher moving a country from one provider to another
+// re-derives all of it by hand. A spec says what they actually mean instead:
+//
+//	"details": {"id": "...", "spec": {"value": 5, "currency": "USD", "country": "NG", "recipient": "+234..."}}
+//
+// After Auth, and before the ledger or a budget reads an amount, Job hands
+// the spec to the provider, which picks the product and the amount in its
+// own currency from its catalogue, converting with cached FX rates. The
+// provider's details are written over the spec's and the resolution is kept
+// beside them under `resolution`, so it is in the ledger and in the Result's
+// payment_details. Everything else in details -- the id, a gift card
+// senderName, DingConnect settings -- passes through untouched.
+//
+// A spec that cannot be met is a payment outcome, not a fault: a bad spec is
+// INVALID_PAYMENT_SPEC, a provider with no catalogue to resolve against is
+// PAYMENT_SPEC_UNSUPPORTED, and a catalogue with nothing near the value is
+// NO_MATCHING_PRODUCT. All three are permanent; a re-drive carries the same
+// spec. A catalogue or FX source that cannot be reached is a fault, and the
+// payment is re-driven like any other.
+
+const (
+	InvalidPaymentSpec     = "INVALID_PAYMENT_SPEC"
+	PaymentSpecUnsupported = "PAYMENT_SPEC_UNSUPPORTED"
+	NoMatchingProduct      = "NO_MATCHING_PRODUCT"
+)
+
+// defaultSpecTolerance is how far above the value a fixed denomination may
+// be, as a fraction, when the spec does not say.
+const defaultSpecTolerance = 0.1
+
+// PaymentSpec is a payment stated as a value rather than a product.
+type PaymentSpec struct {
+	Value     float64 `json:"value" validate:"gt=0"`
+	Currency  string  `json:"currency" validate:"len=3,alpha"`
+	Country   string  `json:"country" validate:"len=2,alpha"`
+	Recipient string  `json:"recipient" validate:"required"`
+	// Tolerance is the fraction above Value a fixed denomination may cost.
+	Tolerance *float64 `json:"tolerance" validate:"omitempty,gte=0"`
+	// Brand narrows gift card products; SenderName is the gift card sender.
+	Brand      string `json:"brand"`
+	SenderName string `json:"sender_name"`
+}
+
+func (s *PaymentSpec) tolerance() float64 {
+	if s.Tolerance == nil {
+		return defaultSpecTolerance
+	}
+	return *s.Tolerance
+}
+
+// Resolution records how a spec became a payment.
+type Resolution struct {
+	Product        string    `json:"product"`
+	Value          float64   `json:"value"`
+	Currency       string    `json:"currency"`
+	Amount         float64   `json:"amount"`
+	AmountCurrency string    `json:"amount_currency"`
+	FxRate         float64   `json:"fx_rate"`
+	LocalAmount    float64   `json:"local_amount,omitempty"`
+	LocalCurrency  string    `json:"local_currency,omitempty"`
+	ResolvedAt     time.Time `json:"resolved_at"`
+}
+
+// SpecResolver is implemented by providers that can turn a spec into their
+// own details. Optional, like StatusProvider; it is called after Auth.
+type SpecResolver interface {
+	ResolveSpec(spec *PaymentSpec, r *specResolver) (map[string]interface{}, *Resolution, error)
+}
+
+// specError is a spec that cannot be met. resolveSpec delivers it as a
+// failed Result; any other error is a fault.
+type specError struct {
+	code    string
+	message string
+}
+
+func (e *specError) Error() string {
+	return e.message
+}
+
+func noMatchingProduct(format string, args ...interface{}) error {
+	return &specError{NoMatchingProduct, fmt.Sprintf(format, args...)}
+}
+
+// resolveSpec rewrites pe.Details from its spec, if it has one. It returns a
+// Result when the spec cannot be met and an error when resolving faulted.
+func (dc *DC) resolveSpec(provider Provider, pe *PaymentEvent) (*Result, error) {
+	spec, err := paymentSpec(pe)
+	if err != nil {
+		return specErrorResult(pe, &specError{InvalidPaymentSpec, err.Error()}), nil
+	}
+	if spec == nil {
+		return nil, nil
+	}
+
+	resolver, ok := provider.(SpecResolver)
+	if !ok {
+		message := fmt.Sprintf("The %s provider cannot resolve a payment spec. Give its details directly instead.", pe.Provider)
+		return specErrorResult(pe, &specError{PaymentSpecUnsupported, message}), nil
+	}
+
+	r := &specResolver{cfg: dc.cfg, cache: dc.cache, scope: pe.Provider + "\x00" + pe.Key + "\x00" + pe.Owner}
+	native, res, err := resolver.ResolveSpec(spec, r)
+	var se *specError
+	if errors.As(err, &se) {
+		return specErrorResult(pe, se), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	res.ResolvedAt = time.Now().UTC()
+	details, err := mergeResolution(pe.Details, native, res)
+	if err != nil {
+		return nil, err
+	}
+	pe.Details = details
+
+	log.Printf("DinersClub resolved %.2f %s for user %s to %s %.2f %s on %s.",
+		spec.Value, spec.Currency, pe.Userid, res.Product, res.Amount, res.AmountCurrency, pe.Provider)
+	return nil, nil
+}
+
+// paymentSpec reads the spec out of a payment's details: (nil, nil) if there
+// is none, or if the details are not an object at all -- that is for the
+// provider to report.
+func paymentSpec(pe *PaymentEvent) (*PaymentSpec, error) {
+	if pe.Details == nil {
+		return nil, nil
+	}
+	d := map[string]json.RawMessage{}
+	if err := json.Unmarshal(*pe.Details, &d); err != nil {
+		return nil, nil
+	}
+	raw, ok := d["spec"]
+	if !ok || string(raw) == "null" {
+		return nil, nil
+	}
+
+	spec := new(PaymentSpec)
+	if err := json.Unmarshal(raw, spec); err != nil {
+		return nil, fmt.Errorf("The payment spec could not be read: %s", err)
+	}
+	if err := validator.New().Struct(spec); err != nil {
+		return nil, fmt.Errorf("The payment spec is invalid: %s", err)
+	}
+	spec.Currency = strings.ToUpper(spec.Currency)
+	spec.Country = strings.ToUpper(spec.Country)
+	return spec, nil
+}
+
+// mergeResolution writes the provider's details and the resolution over the
+// original details, keeping the spec.
+func mergeResolution(original *json.RawMessage, native map[string]interface{}, res *Resolution) (*json.RawMessage, error) {
+	// RawMessage, so what is passed through is passed through byte for byte.
+	d := map[string]json.RawMessage{}
+	if err := json.Unmarshal(*original, &d); err != nil {
+		return nil, err
+	}
+	native["resolution"] = res
+	for k, v := range native {
+		b, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		d[k] = b
+	}
+
+	b, err := json.Marshal(d)
+	if err != nil {
+		return nil, err
+	}
+	details := json.RawMessage(b)
+	return &details, nil
+}
+
+func specErrorResult(pe *PaymentEvent, e *specError) *Result {
+	err := &PaymentError{e.message, e.code, pe.Details}
+	t := fmt.Sprintf("payment:%v", pe.Provider)
+	return &Result{Type: t, ID: paymentID(pe), Success: false, Timestamp: time.Now().UTC(), Error: err}
+}
+
+// specResolver is what a provider resolves against: currency conversion and
+// a cache for its catalogue. Catalogues are cached per provider, key and
+// researcher, since two accounts at one provider need not see the same
+// products or currency.
+type specResolver struct {
+	cfg   *Config
+	cache *ristretto.Cache
+	scope string
+}
+
+func (r *specResolver) cached(key string, ttl time.Duration, fetch func() (interface{}, error)) (interface{}, error) {
+	if r.cache == nil || ttl <= 0 {
+		return fetch()
+	}
+	if v, ok := r.cache.Get(key); ok {
+		return v, nil
+	}
+	v, err := fetch()
+	if err != nil {
+		return nil, err
+	}
+	r.cache.SetWithTTL(key, v, 1, ttl)
+	return v, nil
+}
+
+// catalogue caches one catalogue lookup for DINERSCLUB_CATALOGUE_TTL.
+func (r *specResolver) catalogue(name string, fetch func() (interface{}, error)) (interface{}, error) {
+	return r.cached("spec:catalogue:"+r.scope+":"+name, r.cfg.CatalogueTTL, fetch)
+}
+
+// convert converts value from one currency to another, rounded to the cent.
+// It returns the rate used, which is 1 for the same currency -- no FX source
+// is needed for a researcher paying in the provider's own currency.
+func (r *specResolver) convert(value float64, from, to string) (float64, float64, error) {
+	from, to = strings.ToUpper(from), strings.ToUpper(to)
+	if from == to {
+		return roundCents(value), 1, nil
+	}
+
+	v, err := r.cached("spec:fx:"+r.cfg.FxURL, r.cfg.FxTTL, func() (interface{}, error) {
+		return fetchFxRates(r.cfg)
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	rates := v.(*fxRates)
+
+	rate, err := rates.rate(from, to)
+	if err != nil {
+		return 0, 0, err
+	}
+	return roundCents(value * rate), rate, nil
+}
+
+// fxRates is a table of rates against one base currency, in the shape most
+// FX services serve: {"base": "USD", "rates": {"EUR": 0.92, ...}}.
+type fxRates struct {
+	Base  string             `json:"base"`
+	Rates map[string]float64 `json:"rates"`
+}
+
+func (f *fxRates) rate(from, to string) (float64, error) {
+	get := func(c string) (float64, error) {
+		if c == strings.ToUpper(f.Base) {
+			return 1, nil
+		}
+		r, ok := f.Rates[c]
+		if !ok || r <= 0 {
+			return 0, &specError{InvalidPaymentSpec, fmt.Sprintf("No exchange rate is available for %s.", c)}
+		}
+		return r, nil
+	}
+
+	fromRate, err := get(from)
+	if err != nil {
+		return 0, err
+	}
+	toRate, err := get(to)
+	if err != nil {
+		return 0, err
+	}
+	return toRate / fromRate, nil
+}
+
+func fetchFxRates(cfg *Config) (*fxRates, error) {
+	if cfg.FxURL == "" {
+		return nil, fmt.Errorf("a payment spec needs a currency conversion but DINERSCLUB_FX_URL is not set")
+	}
+	client := &http.Client{Timeout: cfg.ProviderTimeout}
+	res, err := client.Get(cfg.FxURL)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("FX rates: HTTP %d", res.StatusCode)
+	}
+
+	rates := new(fxRates)
+	if err := json.NewDecoder(res.Body).Decode(rates); err != nil {
+		return nil, fmt.Errorf("FX rates: %w", err)
+	}
+	if rates.Base == "" || len(rates.Rates) == 0 {
+		return nil, fmt.Errorf("FX rates: no base or no rates")
+	}
+	return rates, nil
+}
+
+func roundCents(v float64) float64 {
+	return math.Round(v*100) / 100
+}
+
+// pickDenomination is the smallest of a product's fixed amounts that is at
+// least target and no more than tolerance above it.
+func pickDenomination(amounts []float64, target, tolerance float64) (float64, bool) {
+	sorted := append([]float64{}, amounts...)
+	sort.Float64s(sorted)
+	for _, a := range sorted {
+		if a >= target-0.005 && a <= target*(1+tolerance)+0.005 {
+			return a, true
+		}
+	}
+	return 0, false
+}
+
+// Reloadly topups are sent in the account's currency and converted by the
+// operator, so resolving one is finding the recipient's operator and
+// converting the value to its sender currency. The topup itself still
+// auto-detects the operator and picks the suggested amount, exactly as for
+// hand-written details.
+func (p *ReloadlyProvider) ResolveSpec(spec *PaymentSpec, r *specResolver) (map[string]interface{}, *Resolution, error) {
+	v, err := r.catalogue("operator:"+spec.Country+":"+spec.Recipient, func() (interface{}, error) {
+		return p.svc.Topups().OperatorsAutoDetect(spec.Recipient, spec.Country)
+	})
+	if err != nil {
+		return nil, nil, reloadlySpecError(err)
+	}
+	op := v.(*reloadly.Operator)
+
+	amount, rate, err := r.convert(spec.Value, spec.Currency, op.SenderCurrencyCode)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	native := map[string]interface{}{
+		"number":  spec.Recipient,
+		"country": spec.Country,
+		"amount":  amount,
+	}
+	res := &Resolution{
+		Product:        op.Name,
+		Value:          spec.Value,
+		Currency:       spec.Currency,
+		Amount:         amount,
+		AmountCurrency: op.SenderCurrencyCode,
+		FxRate:         rate,
+		LocalAmount:    roundCents(amount * op.Fx.Rate),
+		LocalCurrency:  op.DestinationCurrencyCode,
+	}
+	return native, res, nil
+}
+
+// Gift card prices are in the card's own currency. The first product in the
+// country (of the spec's brand, if it names one) that can be bought at the
+// value wins: a range product at exactly the value, or a fixed one within
+// the tolerance.
+func (p *GiftCardsProvider) ResolveSpec(spec *PaymentSpec, r *specResolver) (map[string]interface{}, *Resolution, error) {
+	v, err := r.catalogue("products:"+spec.Country, func() (interface{}, error) {
+		return p.svc.GiftCards().ProductsByCountry(spec.Country)
+	})
+	if err != nil {
+		return nil, nil, reloadlySpecError(err)
+	}
+	products := v.([]reloadly.Product)
+
+	for _, prod := range products {
+		if spec.Brand != "" && !strings.EqualFold(prod.BrandName, spec.Brand) {
+			continue
+		}
+		target, rate, err := r.convert(spec.Value, spec.Currency, prod.RecipientCurrencyCode)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		price, ok := 0.0, false
+		if strings.ToUpper(prod.DenominationType) == "RANGE" {
+			price, ok = target, target >= prod.MinRecipientDenomination && target <= prod.MaxRecipientDenomination
+		} else {
+			price, ok = pickDenomination(prod.FixedRecipientDenominations, target, spec.tolerance())
+		}
+		if !ok {
+			continue
+		}
+
+		native := map[string]interface{}{
+			"productId":      prod.ProductID,
+			"countryCode":    spec.Country,
+			"quantity":       1,
+			"unitPrice":      price,
+			"recipientEmail": spec.Recipient,
+		}
+		if spec.SenderName != "" {
+			native["senderName"] = spec.SenderName
+		}
+		res := &Resolution{
+			Product:        fmt.Sprintf("%d %s", prod.ProductID, prod.ProductName),
+			Value:          spec.Value,
+			Currency:       spec.Currency,
+			Amount:         price,
+			AmountCurrency: prod.RecipientCurrencyCode,
+			FxRate:         rate,
+			LocalAmount:    price,
+			LocalCurrency:  prod.RecipientCurrencyCode,
+		}
+		return native, res, nil
+	}
+
+	brand := ""
+	if spec.Brand != "" {
+		brand = " " + spec.Brand
+	}
+	return nil, nil, noMatchingProduct("No%s gift card in %s can be bought for %.2f %s.", brand, spec.Country, spec.Value, spec.Currency)
+}
+
+// reloadlySpecError makes a Reloadly refusal during resolution -- an
+// undetectable number, an unknown country -- a failed payment under
+// Reloadly's own code, as it would be from Payout.
+func reloadlySpecError(err error) error {
+	if e, ok := err.(reloadly.APIError); ok {
+		return &specError{e.ErrorCode, e.Message}
+	}
+	if e, ok := err.(reloadly.ReloadlyError); ok {
+		return &specError{e.ErrorCode, e.Message}
+	}
+	return err
+}
+
+// dingAPIURL is the base of DingConnect's catalogue calls, which, like
+// GetBalance, the dingconnect client does not make.
+const dingAPIURL = "https://api.dingconnect.com/api/V1/"
+
+type dingValue struct {
+	SendValue          float64 `json:"SendValue"`
+	SendCurrencyIso    string  `json:"SendCurrencyIso"`
+	ReceiveValue       float64 `json:"ReceiveValue"`
+	ReceiveCurrencyIso string  `json:"ReceiveCurrencyIso"`
+}
+
+type dingProduct struct {
+	SkuCode      string    `json:"SkuCode"`
+	ProviderCode string    `json:"ProviderCode"`
+	Minimum      dingValue `json:"Minimum"`
+	Maximum      dingValue `json:"Maximum"`
+}
+
+// DingConnect products are per operator. The recipient's operators come
+// from their number; of their products, a ranged one that takes the value
+// exactly is preferred, then the cheapest fixed one within the tolerance.
+func (p *DingConnectProvider) ResolveSpec(spec *PaymentSpec, r *specResolver) (map[string]interface{}, *Resolution, error) {
+	v, err := r.catalogue("providers:"+spec.Country+":"+spec.Recipient, func() (interface{}, error) {
+		q := url.Values{"accountNumber": {spec.Recipient}, "countryIsos": {spec.Country}}
+		body := struct {
+			Items []struct {
+				ProviderCode string `json:"ProviderCode"`
+			} `json:"Items"`
+		}{}
+		if err := p.dingGet("GetProviders", q, &body); err != nil {
+			return nil, err
+		}
+		codes := []string{}
+		for _, i := range body.Items {
+			codes = append(codes, i.ProviderCode)
+		}
+		return codes, nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	codes := v.([]string)
+	if len(codes) == 0 {
+		return nil, nil, noMatchingProduct("No DingConnect operator in %s serves %s.", spec.Country, spec.Recipient)
+	}
+
+	v, err = r.catalogue("products:"+strings.Join(codes, ","), func() (interface{}, error) {
+		body := struct {
+			Items []dingProduct `json:"Items"`
+		}{}
+		err := p.dingGet("GetProducts", url.Values{"providerCodes": {strings.Join(codes, ",")}}, &body)
+		return body.Items, err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	products := v.([]dingProduct)
+
+	var best *dingProduct
+	var bestValue, bestRate, bestLocal float64
+	for i := range products {
+		prod := &products[i]
+		min, max := prod.Minimum, prod.Maximum
+		target, rate, err := r.convert(spec.Value, spec.Currency, min.SendCurrencyIso)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if max.SendValue > min.SendValue {
+			if target < min.SendValue || target > max.SendValue {
+				continue
+			}
+			// What the recipient gets is linear across the range.
+			local := min.ReceiveValue + (target-min.SendValue)*(max.ReceiveValue-min.ReceiveValue)/(max.SendValue-min.SendValue)
+			best, bestValue, bestRate, bestLocal = prod, target, rate, local
+			break
+		}
+		if _, ok := pickDenomination([]float64{min.SendValue}, target, spec.tolerance()); !ok {
+			continue
+		}
+		if best == nil || min.SendValue < bestValue {
+			best, bestValue, bestRate, bestLocal = prod, min.SendValue, rate, min.ReceiveValue
+		}
+	}
+	if best == nil {
+		return nil, nil, noMatchingProduct("No DingConnect product for %s can be sent for %.2f %s.", spec.Recipient, spec.Value, spec.Currency)
+	}
+
+	native := map[string]interface{}{
+		"sku_code":          best.SkuCode,
+		"send_value":        bestValue,
+		"send_currency_iso": best.Minimum.SendCurrencyIso,
+		"account_number":    spec.Recipient,
+	}
+	res := &Resolution{
+		Product:        best.SkuCode,
+		Value:          spec.Value,
+		Currency:       spec.Currency,
+		Amount:         bestValue,
+		AmountCurrency: best.Minimum.SendCurrencyIso,
+		FxRate:         bestRate,
+		LocalAmount:    roundCents(bestLocal),
+		LocalCurrency:  best.Minimum.ReceiveCurrencyIso,
+	}
+	return native, res, nil
+}
+
+// dingGet makes one of the DingConnect calls the client does not, and
+// decodes the answer into out. Anything but HTTP 200 and result code 1 is an
+// error.
+func (p *DingConnectProvider) dingGet(method string, query url.Values, out interface{}) error {
+	return p.dingCall("GET", method, query, nil, out)
+}
+
+// dingPost is dingGet for the calls that take a JSON body.
+func (p *DingConnectProvider) dingPost(method string, body interface{}, out interface{}) error {
+	return p.dingCall("POST", method, nil, body, out)
+}
+
+func (p *DingConnectProvider) dingCall(verb, method string, query url.Values, body interface{}, out interface{}) error {
+	if p.apiKey == "" {
+		return fmt.Errorf("dingconnect: %s called before Auth", method)
+	}
+	base := p.apiURL
+	if base == "" {
+		base = dingAPIURL
+	}
+	u := base + method
+	if method == "GetBalance" && p.balanceURL != "" {
+		u = p.balanceURL
+	}
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+
+	var reqBody io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewReader(b)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), p.callTimeout())
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, verb, u, reqBody)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("api_key", p.apiKey)
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	b, err := io.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+	status := struct {
+		ResultCode int `json:"ResultCode"`
+	}{}
+	if err := json.Unmarshal(b, &status); err != nil {
+		return fmt.Errorf("dingconnect %s: HTTP %d: %w", method, res.StatusCode, err)
+	}
+	if res.StatusCode != http.StatusOK || status.ResultCode != 1 {
+		return fmt.Errorf("dingconnect %s: HTTP %d, result code %d", method, res.StatusCode, status.ResultCode)
+	}
+	return json.Unmarshal(b, out)
+}
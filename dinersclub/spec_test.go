@@ -0,0 +1,269 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vlab-research/go-reloadly/reloadly"
+)
+
+// An FX source where 1 USD is 0.9 EUR and 1500 NGN.
+func fxServer(t *testing.T) *httptest.Server {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"base": "USD", "rates": {"EUR": 0.9, "NGN": 1500}}`))
+	}))
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func specDC(fxURL string) *DC {
+	cfg := getConfig()
+	cfg.FxURL = fxURL
+	return &DC{cfg, nil, nil, nil, nil}
+}
+
+func specEvent(provider, details string) *PaymentEvent {
+	d := json.RawMessage(details)
+	return &PaymentEvent{Userid: "respondent", Provider: provider, Details: &d}
+}
+
+func resolvedDetails(t *testing.T, pe *PaymentEvent) map[string]interface{} {
+	t.Helper()
+	d := map[string]interface{}{}
+	assert.Nil(t, json.Unmarshal(*pe.Details, &d))
+	return d
+}
+
+func TestSpecConvertsThroughTheBaseCurrency(t *testing.T) {
+	r := &specResolver{cfg: specDC(fxServer(t).URL).cfg}
+
+	amount, rate, err := r.convert(10, "USD", "EUR")
+	assert.Nil(t, err)
+	assert.Equal(t, 9.0, amount)
+	assert.Equal(t, 0.9, rate)
+
+	amount, _, err = r.convert(9, "eur", "NGN")
+	assert.Nil(t, err)
+	assert.Equal(t, 15000.0, amount)
+
+	_, _, err = r.convert(10, "USD", "XYZ")
+	assert.Equal(t, InvalidPaymentSpec, err.(*specError).code)
+}
+
+func TestSpecInTheProvidersCurrencyNeedsNoFxSource(t *testing.T) {
+	r := &specResolver{cfg: specDC("").cfg}
+
+	amount, rate, err := r.convert(4.999, "USD", "USD")
+	assert.Nil(t, err)
+	assert.Equal(t, 5.0, amount)
+	assert.Equal(t, 1.0, rate)
+
+	_, _, err = r.convert(5, "USD", "EUR")
+	assert.Contains(t, err.Error(), "DINERSCLUB_FX_URL")
+}
+
+func TestPickDenomination(t *testing.T) {
+	a, ok := pickDenomination([]float64{25, 5, 10}, 9, 0.2)
+	assert.True(t, ok)
+	assert.Equal(t, 10.0, a)
+
+	_, ok = pickDenomination([]float64{25, 5}, 9, 0.2)
+	assert.False(t, ok, "5 is too little and 25 too much")
+}
+
+func TestReloadlySpecResolvesToTheSenderCurrency(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/operators/auto-detect/phone/+2348012345678/countries/NG", r.URL.Path)
+		w.Write([]byte(`{"operatorId": 341, "name": "MTN Nigeria", "senderCurrencyCode": "EUR",
+			"destinationCurrencyCode": "NGN", "fx": {"rate": 1650, "currencyCode": "NGN"}}`))
+	}))
+	defer ts.Close()
+	svc := reloadly.NewTopups()
+	svc.BaseUrl = ts.URL
+	provider := &ReloadlyProvider{nil, svc, "INVALID_PAYMENT_DETAILS"}
+
+	pe := specEvent("reloadly", `{"id": "pay-1", "tolerance": 0.5,
+		"spec": {"value": 10, "currency": "usd", "country": "ng", "recipient": "+2348012345678"}}`)
+	res, err := specDC(fxServer(t).URL).resolveSpec(provider, pe)
+
+	assert.Nil(t, err)
+	assert.Nil(t, res)
+	d := resolvedDetails(t, pe)
+	assert.Equal(t, "pay-1", d["id"])
+	assert.Equal(t, 0.5, d["tolerance"], "the researcher's own details pass through")
+	assert.Equal(t, 9.0, d["amount"])
+	assert.Equal(t, "+2348012345678", d["number"])
+	assert.Equal(t, "NG", d["country"])
+	assert.NotNil(t, d["spec"])
+
+	resolution := d["resolution"].(map[string]interface{})
+	assert.Equal(t, "MTN Nigeria", resolution["product"])
+	assert.Equal(t, "EUR", resolution["amount_currency"])
+	assert.Equal(t, 14850.0, resolution["local_amount"])
+	assert.Equal(t, "NGN", resolution["local_currency"])
+
+	// What budgets and the ledger see is the resolved amount.
+	assert.Equal(t, 9.0, paymentAmount(pe))
+	assert.Equal(t, "pay-1", paymentID(pe))
+}
+
+func TestReloadlySpecRefusalIsAPaymentOutcome(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(404)
+		w.Write([]byte(`{"errorCode": "COULD_NOT_AUTO_DETECT_OPERATOR", "message": "Could not auto detect operator"}`))
+	}))
+	defer ts.Close()
+	svc := reloadly.NewTopups()
+	svc.BaseUrl = ts.URL
+	provider := &ReloadlyProvider{nil, svc, "INVALID_PAYMENT_DETAILS"}
+
+	pe := specEvent("reloadly", `{"id": "pay-1", "spec": {"value": 10, "currency": "USD", "country": "NG", "recipient": "123"}}`)
+	res, err := specDC("").resolveSpec(provider, pe)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "COULD_NOT_AUTO_DETECT_OPERATOR", res.Error.Code)
+	assert.Equal(t, "pay-1", res.ID)
+}
+
+func TestGiftCardSpecPicksTheFirstProductThatFits(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/countries/NG/products", r.URL.Path)
+		w.Write([]byte(`[
+			{"productId": 1, "productName": "Jumia 50", "brandName": "Jumia", "denominationType": "FIXED",
+			 "recipientCurrencyCode": "NGN", "fixedRecipientDenominations": [50000]},
+			{"productId": 2, "productName": "Jumia", "brandName": "Jumia", "denominationType": "FIXED",
+			 "recipientCurrencyCode": "NGN", "fixedRecipientDenominations": [5000, 16000, 20000]},
+			{"productId": 3, "productName": "Any", "brandName": "Other", "denominationType": "RANGE",
+			 "recipientCurrencyCode": "NGN", "minRecipientDenomination": 100, "maxRecipientDenomination": 100000}
+		]`))
+	}))
+	defer ts.Close()
+	svc := reloadly.NewGiftCards()
+	svc.BaseUrl = ts.URL
+	provider := &GiftCardsProvider{ReloadlyProvider{nil, svc, "INVALID_GIFT_CARD_DETAILS"}}
+
+	pe := specEvent("giftcard", `{"id": "gc-1", "senderName": "Vlab",
+		"spec": {"value": 10, "currency": "USD", "country": "NG", "recipient": "r@example.com", "brand": "jumia"}}`)
+	res, err := specDC(fxServer(t).URL).resolveSpec(provider, pe)
+
+	assert.Nil(t, err)
+	assert.Nil(t, res)
+	d := resolvedDetails(t, pe)
+	assert.Equal(t, 2.0, d["productId"])
+	assert.Equal(t, 16000.0, d["unitPrice"], "the smallest denomination within 10% of 15000")
+	assert.Equal(t, "Vlab", d["senderName"])
+	assert.Equal(t, "r@example.com", d["recipientEmail"])
+	assert.Equal(t, 16000.0, paymentAmount(pe))
+
+	pe = specEvent("giftcard", `{"spec": {"value": 10, "currency": "USD", "country": "NG", "recipient": "r@example.com", "brand": "other"}}`)
+	_, err = specDC(fxServer(t).URL).resolveSpec(provider, pe)
+	assert.Nil(t, err)
+	assert.Equal(t, 15000.0, resolvedDetails(t, pe)["unitPrice"], "a range product takes the value exactly")
+}
+
+func TestGiftCardSpecWithNoProductIsNoMatchingProduct(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[{"productId": 1, "brandName": "Jumia", "denominationType": "FIXED",
+			"recipientCurrencyCode": "USD", "fixedRecipientDenominations": [50]}]`))
+	}))
+	defer ts.Close()
+	svc := reloadly.NewGiftCards()
+	svc.BaseUrl = ts.URL
+	provider := &GiftCardsProvider{ReloadlyProvider{nil, svc, "INVALID_GIFT_CARD_DETAILS"}}
+
+	pe := specEvent("giftcard", `{"spec": {"value": 10, "currency": "USD", "country": "NG", "recipient": "r@example.com"}}`)
+	res, err := specDC("").resolveSpec(provider, pe)
+
+	assert.Nil(t, err)
+	assert.Equal(t, NoMatchingProduct, res.Error.Code)
+	recovery, known := ClassifyResult(res)
+	assert.True(t, known)
+	assert.Equal(t, RecoveryPermanent, recovery)
+}
+
+func dingCatalogue(t *testing.T, products string) *httptest.Server {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "secret-key", r.Header.Get("api_key"))
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/GetProviders"):
+			assert.Equal(t, "2348012345678", r.URL.Query().Get("accountNumber"))
+			assert.Equal(t, "NG", r.URL.Query().Get("countryIsos"))
+			w.Write([]byte(`{"ResultCode": 1, "Items": [{"ProviderCode": "MTNG"}]}`))
+		case strings.HasSuffix(r.URL.Path, "/GetProducts"):
+			assert.Equal(t, "MTNG", r.URL.Query().Get("providerCodes"))
+			fmt.Fprintf(w, `{"ResultCode": 1, "Items": %s}`, products)
+		default:
+			t.Errorf("unexpected call to %s", r.URL.Path)
+		}
+	}))
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func TestDingConnectSpecPrefersARangedProduct(t *testing.T) {
+	ts := dingCatalogue(t, `[
+		{"SkuCode": "MTNG-5", "Minimum": {"SendValue": 5, "SendCurrencyIso": "USD", "ReceiveValue": 7000, "ReceiveCurrencyIso": "NGN"},
+		 "Maximum": {"SendValue": 5, "SendCurrencyIso": "USD", "ReceiveValue": 7000, "ReceiveCurrencyIso": "NGN"}},
+		{"SkuCode": "MTNG-TOPUP", "Minimum": {"SendValue": 1, "SendCurrencyIso": "USD", "ReceiveValue": 1400, "ReceiveCurrencyIso": "NGN"},
+		 "Maximum": {"SendValue": 50, "SendCurrencyIso": "USD", "ReceiveValue": 70000, "ReceiveCurrencyIso": "NGN"}}
+	]`)
+	provider := &DingConnectProvider{apiKey: "secret-key", apiURL: ts.URL + "/"}
+
+	pe := specEvent("dingconnect", `{"id": "d-1", "settings": [{"name": "a", "value": "b"}],
+		"spec": {"value": 5, "currency": "USD", "country": "NG", "recipient": "2348012345678"}}`)
+	res, err := specDC("").resolveSpec(provider, pe)
+
+	assert.Nil(t, err)
+	assert.Nil(t, res)
+	d := resolvedDetails(t, pe)
+	assert.Equal(t, "MTNG-TOPUP", d["sku_code"])
+	assert.Equal(t, 5.0, d["send_value"])
+	assert.Equal(t, "USD", d["send_currency_iso"])
+	assert.Equal(t, "2348012345678", d["account_number"])
+	assert.NotNil(t, d["settings"])
+	assert.Equal(t, 7000.0, d["resolution"].(map[string]interface{})["local_amount"])
+}
+
+func TestDingConnectSpecFallsBackToAFixedProduct(t *testing.T) {
+	ts := dingCatalogue(t, `[
+		{"SkuCode": "MTNG-5", "Minimum": {"SendValue": 5.25, "SendCurrencyIso": "USD", "ReceiveValue": 7000, "ReceiveCurrencyIso": "NGN"},
+		 "Maximum": {"SendValue": 5.25, "SendCurrencyIso": "USD", "ReceiveValue": 7000, "ReceiveCurrencyIso": "NGN"}},
+		{"SkuCode": "MTNG-10", "Minimum": {"SendValue": 10, "SendCurrencyIso": "USD", "ReceiveValue": 14000, "ReceiveCurrencyIso": "NGN"},
+		 "Maximum": {"SendValue": 10, "SendCurrencyIso": "USD", "ReceiveValue": 14000, "ReceiveCurrencyIso": "NGN"}}
+	]`)
+	provider := &DingConnectProvider{apiKey: "secret-key", apiURL: ts.URL + "/"}
+
+	pe := specEvent("dingconnect", `{"spec": {"value": 5, "currency": "USD", "country": "NG", "recipient": "2348012345678"}}`)
+	_, err := specDC("").resolveSpec(provider, pe)
+	assert.Nil(t, err)
+	assert.Equal(t, "MTNG-5", resolvedDetails(t, pe)["sku_code"])
+
+	pe = specEvent("dingconnect", `{"spec": {"value": 5, "currency": "USD", "country": "NG", "recipient": "2348012345678", "tolerance": 0}}`)
+	res, err := specDC("").resolveSpec(provider, pe)
+	assert.Nil(t, err)
+	assert.Equal(t, NoMatchingProduct, res.Error.Code)
+}
+
+func TestSpecThatCannotBeResolved(t *testing.T) {
+	fake, _ := NewFakeProvider(getUserFromFakePaymentEvent, auth)
+	dc := specDC("")
+
+	res, err := dc.resolveSpec(fake, specEvent("fake", `{"spec": {"value": 5, "currency": "USD", "country": "NG", "recipient": "x"}}`))
+	assert.Nil(t, err)
+	assert.Equal(t, PaymentSpecUnsupported, res.Error.Code)
+
+	res, err = dc.resolveSpec(fake, specEvent("fake", `{"spec": {"value": 5, "currency": "dollars", "country": "NG", "recipient": "x"}}`))
+	assert.Nil(t, err)
+	assert.Equal(t, InvalidPaymentSpec, res.Error.Code)
+
+	pe := specEvent("fake", `{"id": "plain", "amount": 5}`)
+	res, err = dc.resolveSpec(fake, pe)
+	assert.Nil(t, err)
+	assert.Nil(t, res)
+	assert.Equal(t, `{"id": "plain", "amount": 5}`, string(*pe.Details), "details without a spec are left alone")
+}
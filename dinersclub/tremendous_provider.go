@@ -1,170 +1,329 @@
 package main
 
-// import (
-// 	"context"
-// 	"encoding/json"
-// 	"fmt"
-// 	"io"
-// 	"log"
-
-// 	"net/http"
-// 	"net/http/httputil"
-// 	"strings"
-// 	"time"
-
-// 	"github.com/alexkappa/mustache"
-// 	"github.com/jackc/pgx/v4/pgxpool"
-// 	"github.com/tidwall/gjson"
-// )
-
-// type TremendousProvider struct {
-// 	client  *http.Client
-// 	pool    *pgxpool.Pool
-// 	secrets map[string]string
-// }
-
-// func NewHttpProvider(pool *pgxpool.Pool) (Provider, error) {
-// 	return &HttpProvider{client: http.DefaultClient, pool: pool, secrets: map[string]string{}}, nil
-// }
-
-// func (p *HttpProvider) GetUserFromPaymentEvent(event *PaymentEvent) (*User, error) {
-// 	return GenericGetUser(p.pool, event)
-// }
-
-// func (p *HttpProvider) Auth(user *User, key string) error {
-// 	query := `SELECT key, details->>'value' FROM credentials WHERE entity='secrets' AND userid=$1`
-
-// 	rows, err := p.pool.Query(context.Background(), query, user.Id)
-// 	if err != nil {
-// 		return err
-// 	}
-
-// 	defer rows.Close()
-
-// 	for rows.Next() {
-// 		var a string
-// 		var b string
-// 		err := rows.Scan(&a, &b)
-
-// 		if err != nil {
-// 			return err
-// 		}
-// 		p.secrets[a] = b
-// 	}
-
-// 	if rows.Err() != nil {
-// 		return err
-// 	}
-
-// 	return nil
-// }
-
-// type HttpPaymentDetails struct {
-// 	ID           string            `json:"id"`
-// 	Method       string            `json:"method"`
-// 	Url          string            `json:"url"`
-// 	Body         *json.RawMessage  `json:"body"`
-// 	Headers      map[string]string `json:"headers"`
-// 	ErrorMessage string            `json:"errorMessage"`
-// }
-
-// func (p *HttpProvider) Interpolate(s string) (string, error) {
-// 	tmpl := mustache.New(mustache.SilentMiss(false), mustache.Delimiters("<<", ">>"))
-// 	tmpl.ParseString(s)
-// 	res, err := tmpl.RenderString(p.secrets)
-// 	return res, err
-// }
-
-// func formatError(result *Result, event *PaymentEvent, message, code string) (*Result, error) {
-// 	result.Success = false
-
-// 	error := &PaymentError{
-// 		Message:        message,
-// 		Code:           code,
-// 		PaymentDetails: event.Details,
-// 	}
-
-// 	result.Error = error
-// 	return result, nil
-// }
-
-// func (p *HttpProvider) Payout(event *PaymentEvent) (*Result, error) {
-// 	order := new(HttpPaymentDetails)
-
-// 	err := json.Unmarshal(*event.Details, &order)
-// 	if err != nil {
-// 		return nil, err
-// 	}
-
-// 	result := &Result{}
-// 	result.Type = "payment:http"
-// 	result.ID = order.ID
-
-// 	url, err := p.Interpolate(order.Url)
-// 	if err != nil {
-// 		return formatError(result, event, err.Error(), "MISSING_SECRET")
-// 	}
-
-// 	headers := map[string]string{
-// 		"Accept": "application/json",
-// 	}
-
-// 	for key := range order.Headers {
-// 		val, err := p.Interpolate(order.Headers[key])
-// 		if err != nil {
-// 			return formatError(result, event, err.Error(), "MISSING_SECRET")
-// 		}
-// 		headers[key] = val
-// 	}
-
-// 	body := ""
-// 	if order.Body != nil {
-// 		body, err = p.Interpolate(string(*order.Body))
-// 		if err != nil {
-// 			return formatError(result, event, err.Error(), "MISSING_SECRET")
-// 		}
-// 	}
-
-// 	b := strings.NewReader(body)
-
-// 	ctx, _ := context.WithTimeout(context.Background(), 60*time.Second)
-// 	req, err := http.NewRequestWithContext(ctx, order.Method, url, b)
-// 	if err != nil {
-// 		return formatError(result, event, err.Error(), "BAD_HTTP_REQUEST")
-// 	}
-
-// 	for header := range headers {
-// 		req.Header.Add(header, headers[header])
-// 	}
-
-// 	dump, _ := httputil.DumpRequestOut(req, true)
-// 	// Useful debugging for http provider
-// 	log.Println(string(dump))
-
-// 	resp, err := p.client.Do(req)
-// 	if err != nil {
-// 		// redo, hopefully transient
-// 		return nil, err
-// 	}
-
-// 	defer resp.Body.Close()
-// 	bodyBytes, err := io.ReadAll(resp.Body)
-// 	if err != nil {
-// 		return nil, err // transient???
-// 	}
-
-// 	// add response to json
-// 	response := json.RawMessage(bodyBytes)
-// 	result.Response = &response
-
-// 	if (resp.StatusCode >= 200) && (resp.StatusCode <= 299) {
-// 		result.Success = true
-// 		result.PaymentDetails = event.Details
-// 		return result, nil
-// 	}
-
-// 	responseBody := string(bodyBytes)
-// 	errorMessage := gjson.Get(responseBody, order.ErrorMessage).String()
-// 	code := fmt.Sprintf("%d", resp.StatusCode)
-// 	return formatError(result, event, errorMessage, code)
-// }
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+const (
+	tremendousBaseURL        = "https://www.tremendous.com/api/v2"
+	tremendousSandboxBaseURL = "https://testflight.tremendous.com/api/v2"
+)
+
+// TremendousProvider pays rewards through Tremendous (https://tremendous.com):
+// a link or an email the respondent redeems for a gift card, a prepaid card or
+// a bank transfer of their choice.
+//
+// Orders are idempotent on external_id, which is the payment's idempotency key
+// (see ledger.go): Tremendous answers a repeated external_id with the order it
+// already created rather than placing another, so a retry after a timeout is
+// safe.
+type TremendousProvider struct {
+	pool    *pgxpool.Pool
+	client  *http.Client
+	baseURL string
+	apiKey  string
+	timeout time.Duration
+}
+
+// TremendousPaymentDetails is the researcher-facing payment configuration.
+//
+// Exactly one of campaign_id or products chooses what the respondent can
+// redeem; a campaign is configured in the Tremendous dashboard and is the
+// usual choice. delivery_method is LINK (the default: the reward link comes
+// back in the Result's response for the survey to show), EMAIL or PHONE, and
+// needs recipient_email or recipient_phone to match.
+type TremendousPaymentDetails struct {
+	ID              string   `json:"id"`
+	Amount          float64  `json:"amount"`
+	CurrencyCode    string   `json:"currency_code"`
+	CampaignID      string   `json:"campaign_id"`
+	Products        []string `json:"products"`
+	DeliveryMethod  string   `json:"delivery_method"`
+	RecipientName   string   `json:"recipient_name"`
+	RecipientEmail  string   `json:"recipient_email"`
+	RecipientPhone  string   `json:"recipient_phone"`
+	FundingSourceID string   `json:"funding_source_id"`
+}
+
+type tremendousOrderRequest struct {
+	ExternalID string `json:"external_id,omitempty"`
+	Payment    struct {
+		FundingSourceID string `json:"funding_source_id"`
+	} `json:"payment"`
+	Reward tremendousReward `json:"reward"`
+}
+
+type tremendousReward struct {
+	CampaignID string   `json:"campaign_id,omitempty"`
+	Products   []string `json:"products,omitempty"`
+	Value      struct {
+		Denomination float64 `json:"denomination"`
+		CurrencyCode string  `json:"currency_code"`
+	} `json:"value"`
+	Recipient struct {
+		Name  string `json:"name,omitempty"`
+		Email string `json:"email,omitempty"`
+		Phone string `json:"phone,omitempty"`
+	} `json:"recipient"`
+	Delivery struct {
+		Method string `json:"method"`
+	} `json:"delivery"`
+}
+
+type tremendousOrder struct {
+	ID         string `json:"id"`
+	ExternalID string `json:"external_id"`
+	Status     string `json:"status"`
+}
+
+type tremendousOrderResponse struct {
+	Order tremendousOrder `json:"order"`
+}
+
+type tremendousErrorResponse struct {
+	Errors struct {
+		Message string `json:"message"`
+	} `json:"errors"`
+}
+
+// Tremendous order statuses.
+const (
+	tremendousExecuted        = "EXECUTED"
+	tremendousPendingApproval = "PENDING APPROVAL"
+	tremendousPendingInternal = "PENDING INTERNAL PAYMENT APPROVAL"
+	tremendousCanceled        = "CANCELED"
+	tremendousFailed          = "FAILED"
+)
+
+// Tremendous reports failures as an HTTP status and a free-text message, with
+// no error code of its own, so the status is mapped onto codes of ours here.
+// Their recovery classes live in classify.go with everyone else's.
+var tremendousCodeByStatus = map[int]string{
+	http.StatusBadRequest:          "TREMENDOUS_INVALID_ORDER",
+	http.StatusUnauthorized:        "TREMENDOUS_UNAUTHORIZED",
+	http.StatusPaymentRequired:     "TREMENDOUS_INSUFFICIENT_FUNDS",
+	http.StatusForbidden:           "TREMENDOUS_UNAUTHORIZED",
+	http.StatusNotFound:            "TREMENDOUS_NOT_FOUND",
+	http.StatusUnprocessableEntity: "TREMENDOUS_INVALID_ORDER",
+	http.StatusTooManyRequests:     "TREMENDOUS_RATE_LIMITED",
+}
+
+func NewTremendousProvider(pool *pgxpool.Pool) (Provider, error) {
+	cfg := getConfig()
+
+	// Bounded for the same reason as every other provider: see the comment
+	// in NewReloadlyProvider.
+	client := &http.Client{Timeout: cfg.ProviderTimeout}
+
+	baseURL := tremendousBaseURL
+	if cfg.Sandbox {
+		baseURL = tremendousSandboxBaseURL
+	}
+	return &TremendousProvider{pool: pool, client: client, baseURL: baseURL, timeout: cfg.ProviderTimeout}, nil
+}
+
+// callTimeout bounds one request. A provider built without a timeout gets
+// the default.
+func (p *TremendousProvider) callTimeout() time.Duration {
+	if p.timeout > 0 {
+		return p.timeout
+	}
+	return defaultProviderTimeout
+}
+
+func (p *TremendousProvider) GetUserFromPaymentEvent(event *PaymentEvent) (*User, error) {
+	return GenericGetUser(p.pool, event)
+}
+
+// Auth resolves the researcher's Tremendous API key, a Generic Secret named
+// by the survey's `payment.key` -- a single opaque string, so it needs no
+// entity of its own (see secretForUser).
+func (p *TremendousProvider) Auth(user *User, key string) error {
+	if key == "" {
+		return fmt.Errorf(`No key provided for Tremendous provider. Set "key" in your survey's payment block to the name of the Generic Secret holding your Tremendous API key.`)
+	}
+
+	apiKey, err := secretForUser(p.pool, user.Id, key)
+	if err != nil {
+		return err
+	}
+	p.apiKey = apiKey
+	return nil
+}
+
+func (p *TremendousProvider) Payout(event *PaymentEvent) (*Result, error) {
+	details := new(TremendousPaymentDetails)
+	if err := json.Unmarshal(*event.Details, details); err != nil {
+		return handleJSONUnmarshalError("tremendous", err, event.Details), nil
+	}
+
+	result := &Result{Type: "payment:tremendous", ID: details.ID}
+
+	order, msg := tremendousOrderFromDetails(details)
+	if msg != "" {
+		return formatError(result, event, msg, "INVALID_PAYMENT_DETAILS")
+	}
+	order.ExternalID = event.IdempotencyKey
+
+	body, err := json.Marshal(order)
+	if err != nil {
+		return nil, err
+	}
+
+	status, resp, err := p.request("POST", "/orders", body)
+	if err != nil {
+		// Never reached a verdict. Safe to retry: external_id makes the
+		// order idempotent even if this one did land.
+		return formatError(result, event, err.Error(), "HTTP_REQUEST_FAILED")
+	}
+	return tremendousResult(result, event, status, resp)
+}
+
+// Status re-reads an order Tremendous is holding for approval.
+func (p *TremendousProvider) Status(event *PaymentEvent, pending *Result) (*Result, error) {
+	if pending.Response == nil {
+		return nil, fmt.Errorf("pending tremendous payment has no order to poll")
+	}
+	prev := new(tremendousOrderResponse)
+	if err := json.Unmarshal(*pending.Response, prev); err != nil {
+		return nil, err
+	}
+	if prev.Order.ID == "" {
+		return nil, fmt.Errorf("pending tremendous payment has no order id")
+	}
+
+	status, resp, err := p.request("GET", "/orders/"+prev.Order.ID, nil)
+	if err != nil {
+		return nil, err
+	}
+	if status < 200 || status > 299 {
+		// Not a verdict on the order -- only on this attempt to read it.
+		return nil, fmt.Errorf("tremendous order %s: HTTP %d: %s", prev.Order.ID, status, tremendousErrorMessage(resp))
+	}
+
+	result := &Result{Type: pending.Type, ID: pending.ID}
+	return tremendousResult(result, event, status, resp)
+}
+
+func tremendousOrderFromDetails(d *TremendousPaymentDetails) (*tremendousOrderRequest, string) {
+	switch {
+	case d.Amount <= 0:
+		return nil, "amount must be positive"
+	case d.CampaignID == "" && len(d.Products) == 0:
+		return nil, "Missing campaign_id or products"
+	}
+
+	order := new(tremendousOrderRequest)
+	order.Payment.FundingSourceID = d.FundingSourceID
+	if order.Payment.FundingSourceID == "" {
+		order.Payment.FundingSourceID = "BALANCE"
+	}
+
+	r := &order.Reward
+	r.CampaignID = d.CampaignID
+	r.Products = d.Products
+	r.Value.Denomination = d.Amount
+	r.Value.CurrencyCode = d.CurrencyCode
+	if r.Value.CurrencyCode == "" {
+		r.Value.CurrencyCode = "USD"
+	}
+	r.Recipient.Name = d.RecipientName
+	r.Recipient.Email = d.RecipientEmail
+	r.Recipient.Phone = d.RecipientPhone
+
+	r.Delivery.Method = d.DeliveryMethod
+	switch r.Delivery.Method {
+	case "":
+		r.Delivery.Method = "LINK"
+	case "EMAIL":
+		if d.RecipientEmail == "" {
+			return nil, "delivery_method EMAIL needs recipient_email"
+		}
+	case "PHONE":
+		if d.RecipientPhone == "" {
+			return nil, "delivery_method PHONE needs recipient_phone"
+		}
+	case "LINK":
+	default:
+		return nil, fmt.Sprintf("Unknown delivery_method %q (must be LINK, EMAIL or PHONE)", d.DeliveryMethod)
+	}
+	return order, ""
+}
+
+func (p *TremendousProvider) request(method, path string, body []byte) (int, []byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), p.callTimeout())
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, method, p.baseURL+path, bytes.NewReader(body))
+	if err != nil {
+		return 0, nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+p.apiKey)
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+
+	b, err := io.ReadAll(resp.Body)
+	return resp.StatusCode, b, err
+}
+
+// tremendousResult maps an order response onto result. The whole response is
+// kept: for LINK delivery it carries the reward link the survey shows.
+func tremendousResult(result *Result, event *PaymentEvent, status int, body []byte) (*Result, error) {
+	if status < 200 || status > 299 {
+		code, ok := tremendousCodeByStatus[status]
+		if !ok {
+			code = fmt.Sprintf("%d", status)
+		}
+		return formatError(result, event, tremendousErrorMessage(body), code)
+	}
+
+	order := new(tremendousOrderResponse)
+	if err := json.Unmarshal(body, order); err != nil {
+		return formatError(result, event, fmt.Sprintf("Could not read Tremendous order: %s", err), "INVALID_RESPONSE")
+	}
+
+	response := json.RawMessage(body)
+	result.Response = &response
+
+	switch order.Order.Status {
+	case tremendousExecuted:
+		result.Success = true
+		result.Timestamp = time.Now().UTC()
+		result.PaymentDetails = event.Details
+		return result, nil
+	case tremendousPendingApproval, tremendousPendingInternal:
+		// The order waits on an approval in the researcher's Tremendous
+		// account. It is placed, not paid: the status poller picks it up.
+		result.Pending = true
+		return result, nil
+	case tremendousCanceled, tremendousFailed:
+		return formatError(result, event,
+			fmt.Sprintf("Tremendous order %s was %s", order.Order.ID, order.Order.Status), "TREMENDOUS_ORDER_FAILED")
+	}
+	return formatError(result, event,
+		fmt.Sprintf("Unexpected Tremendous order status: %q", order.Order.Status), "INVALID_RESPONSE")
+}
+
+func tremendousErrorMessage(body []byte) string {
+	e := new(tremendousErrorResponse)
+	if err := json.Unmarshal(body, e); err == nil && e.Errors.Message != "" {
+		return e.Errors.Message
+	}
+	return string(body)
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// tremendousProvider returns a provider whose base URL is a local stand-in
+// for the Tremendous API.
+func tremendousProvider(t *testing.T, h http.HandlerFunc) *TremendousProvider {
+	t.Helper()
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+	return &TremendousProvider{client: srv.Client(), baseURL: srv.URL, apiKey: "test_api_key_123"}
+}
+
+func tremendousEvent(details string) *PaymentEvent {
+	d := json.RawMessage([]byte(details))
+	return &PaymentEvent{Provider: "tremendous", Details: &d, IdempotencyKey: "key-123"}
+}
+
+func tremendousOrderBody(id, status string) string {
+	return fmt.Sprintf(`{"order": {"id": %q, "external_id": "key-123", "status": %q, "rewards": [{"id": "R1", "delivery": {"method": "LINK", "link": "https://reward.example/R1"}}]}}`, id, status)
+}
+
+const validTremendousDetails = `{
+	"id": "PAY001",
+	"amount": 5,
+	"currency_code": "USD",
+	"campaign_id": "CAMPAIGN1"
+}`
+
+func TestTremendousRequestFormat(t *testing.T) {
+	var gotPath, gotMethod string
+	var gotHeaders http.Header
+	var gotBody map[string]interface{}
+
+	p := tremendousProvider(t, func(w http.ResponseWriter, r *http.Request) {
+		gotPath, gotMethod, gotHeaders = r.URL.Path, r.Method, r.Header
+		body, _ := io.ReadAll(r.Body)
+		json.Unmarshal(body, &gotBody)
+		dingRespond(200, tremendousOrderBody("ORDER1", "EXECUTED"))(w, r)
+	})
+
+	res, err := p.Payout(tremendousEvent(validTremendousDetails))
+	assert.Nil(t, err)
+	assert.True(t, res.Success)
+
+	assert.Equal(t, "POST", gotMethod)
+	assert.Equal(t, "/orders", gotPath)
+	assert.Equal(t, "Bearer test_api_key_123", gotHeaders.Get("Authorization"))
+	assert.Equal(t, "application/json", gotHeaders.Get("Content-Type"))
+
+	// external_id is what makes a retried order idempotent.
+	assert.Equal(t, "key-123", gotBody["external_id"])
+	assert.Equal(t, map[string]interface{}{"funding_source_id": "BALANCE"}, gotBody["payment"])
+
+	reward := gotBody["reward"].(map[string]interface{})
+	assert.Equal(t, "CAMPAIGN1", reward["campaign_id"])
+	assert.Equal(t, map[string]interface{}{"denomination": 5.0, "currency_code": "USD"}, reward["value"])
+	assert.Equal(t, map[string]interface{}{"method": "LINK"}, reward["delivery"])
+}
+
+func TestTremendousPayout_Success(t *testing.T) {
+	p := tremendousProvider(t, dingRespond(200, tremendousOrderBody("ORDER1", "EXECUTED")))
+
+	res, err := p.Payout(tremendousEvent(validTremendousDetails))
+
+	assert.Nil(t, err)
+	assert.True(t, res.Success)
+	assert.False(t, res.Pending)
+	assert.Equal(t, "payment:tremendous", res.Type)
+	assert.Equal(t, "PAY001", res.ID)
+	assert.Nil(t, res.Error)
+	assert.NotNil(t, res.PaymentDetails)
+	assert.Contains(t, string(*res.Response), "https://reward.example/R1", "the reward link must reach the survey")
+}
+
+func TestTremendousPayout_PendingApprovalIsPolledUntilSettled(t *testing.T) {
+	status := "PENDING APPROVAL"
+	var gotPath, gotMethod string
+
+	p := tremendousProvider(t, func(w http.ResponseWriter, r *http.Request) {
+		gotPath, gotMethod = r.URL.Path, r.Method
+		dingRespond(200, tremendousOrderBody("ORDER1", status))(w, r)
+	})
+
+	event := tremendousEvent(validTremendousDetails)
+	res, err := p.Payout(event)
+	assert.Nil(t, err)
+	assert.True(t, res.Pending)
+	assert.False(t, res.Success)
+
+	res, err = p.Status(event, res)
+	assert.Nil(t, err)
+	assert.True(t, res.Pending)
+	assert.Equal(t, "GET", gotMethod)
+	assert.Equal(t, "/orders/ORDER1", gotPath)
+
+	status = "EXECUTED"
+	res, err = p.Status(event, res)
+	assert.Nil(t, err)
+	assert.True(t, res.Success)
+	assert.Equal(t, "PAY001", res.ID)
+
+	status = "CANCELED"
+	res, err = p.Status(event, &Result{Type: "payment:tremendous", ID: "PAY001", Response: res.Response})
+	assert.Nil(t, err)
+	assert.False(t, res.Success)
+	assert.Equal(t, "TREMENDOUS_ORDER_FAILED", res.Error.Code)
+}
+
+func TestTremendousStatus_ErrorsAreNotVerdicts(t *testing.T) {
+	p := tremendousProvider(t, dingRespond(503, `{"errors": {"message": "down"}}`))
+
+	response := json.RawMessage(tremendousOrderBody("ORDER1", "PENDING APPROVAL"))
+	res, err := p.Status(tremendousEvent(validTremendousDetails), &Result{Pending: true, Response: &response})
+
+	assert.NotNil(t, err)
+	assert.Nil(t, res)
+}
+
+func TestTremendousPayout_ValidationErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		details string
+	}{
+		{"no amount", `{"id": "P", "campaign_id": "C"}`},
+		{"no campaign or products", `{"id": "P", "amount": 5}`},
+		{"email delivery without email", `{"id": "P", "amount": 5, "campaign_id": "C", "delivery_method": "EMAIL"}`},
+		{"phone delivery without phone", `{"id": "P", "amount": 5, "campaign_id": "C", "delivery_method": "PHONE"}`},
+		{"unknown delivery method", `{"id": "P", "amount": 5, "campaign_id": "C", "delivery_method": "PIGEON"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := tremendousProvider(t, func(w http.ResponseWriter, r *http.Request) {
+				t.Fatal("invalid details must not reach Tremendous")
+			})
+
+			res, err := p.Payout(tremendousEvent(tt.details))
+
+			assert.Nil(t, err)
+			assert.False(t, res.Success)
+			assert.Equal(t, "INVALID_PAYMENT_DETAILS", res.Error.Code)
+		})
+	}
+}
+
+func TestTremendousPayout_ErrorCodeMapping(t *testing.T) {
+	tests := []struct {
+		status   int
+		wantCode string
+		recovery Recovery
+	}{
+		{400, "TREMENDOUS_INVALID_ORDER", RecoveryPermanent},
+		{401, "TREMENDOUS_UNAUTHORIZED", RecoveryPrecondition},
+		{402, "TREMENDOUS_INSUFFICIENT_FUNDS", RecoveryPrecondition},
+		{403, "TREMENDOUS_UNAUTHORIZED", RecoveryPrecondition},
+		{404, "TREMENDOUS_NOT_FOUND", RecoveryPermanent},
+		{422, "TREMENDOUS_INVALID_ORDER", RecoveryPermanent},
+		{429, "TREMENDOUS_RATE_LIMITED", RecoveryTransient},
+		{500, "500", RecoveryTransient},
+		{503, "503", RecoveryTransient},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.wantCode, func(t *testing.T) {
+			p := tremendousProvider(t, dingRespond(tt.status, `{"errors": {"message": "Sorry"}}`))
+
+			res, err := p.Payout(tremendousEvent(validTremendousDetails))
+
+			assert.Nil(t, err, "payment failures are Results, not errors")
+			assert.False(t, res.Success)
+			assert.Equal(t, tt.wantCode, res.Error.Code)
+			assert.Equal(t, "Sorry", res.Error.Message)
+
+			recovery, known := Classify(res.Error.Code)
+			assert.True(t, known)
+			assert.Equal(t, tt.recovery, recovery)
+		})
+	}
+}
+
+func TestTremendousPayout_HttpRequestFails(t *testing.T) {
+	p := tremendousProvider(t, dingRespond(200, "{}"))
+	p.baseURL = "http://127.0.0.1:1"
+
+	res, err := p.Payout(tremendousEvent(validTremendousDetails))
+
+	assert.Nil(t, err)
+	assert.False(t, res.Success)
+	assert.Equal(t, "HTTP_REQUEST_FAILED", res.Error.Code)
+}
+
+func TestTremendousPayout_ProviderTimeoutBoundsTheRequest(t *testing.T) {
+	p := tremendousProvider(t, func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(500 * time.Millisecond)
+	})
+	p.timeout = 50 * time.Millisecond
+
+	res, err := p.Payout(tremendousEvent(validTremendousDetails))
+
+	assert.Nil(t, err)
+	assert.False(t, res.Success)
+	assert.Equal(t, "HTTP_REQUEST_FAILED", res.Error.Code)
+}
+
+func TestNewTremendousProvider_UsesTheProviderTimeout(t *testing.T) {
+	t.Setenv("DINERSCLUB_PROVIDER_TIMEOUT", "15s")
+	provider, err := NewTremendousProvider(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 15*time.Second, provider.(*TremendousProvider).callTimeout())
+}
+
+func TestNewTremendousProvider_Sandbox(t *testing.T) {
+	t.Setenv("RELOADLY_SANDBOX", "true")
+	provider, err := NewTremendousProvider(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, tremendousSandboxBaseURL, provider.(*TremendousProvider).baseURL)
+
+	t.Setenv("RELOADLY_SANDBOX", "false")
+	provider, err = NewTremendousProvider(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, tremendousBaseURL, provider.(*TremendousProvider).baseURL)
+}
+
+func TestTremendousAuth_ReadsGenericSecret(t *testing.T) {
+	cfg := getConfig()
+	pool := getPool(cfg)
+	defer pool.Close()
+
+	before(t, pool)
+	insertDingUser(t, pool)
+	mustExec(t, pool, `
+		INSERT INTO credentials(userid, entity, key, details)
+		VALUES ('00000000-0000-0000-0000-000000000000', 'secrets', 'TREMENDOUS_API_KEY', '{"value": "tremendous_key"}');
+	`)
+
+	provider, err := NewTremendousProvider(pool)
+	assert.Nil(t, err)
+
+	err = provider.Auth(&User{Id: "00000000-0000-0000-0000-000000000000"}, "TREMENDOUS_API_KEY")
+	assert.Nil(t, err)
+	assert.Equal(t, "tremendous_key", provider.(*TremendousProvider).apiKey)
+
+	assert.NotNil(t, provider.Auth(&User{Id: "00000000-0000-0000-0000-000000000000"}, ""))
+}
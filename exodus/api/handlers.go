@@ -175,6 +175,13 @@ func (s *Server) CreateBail(c echo.Context) error {
 	ctx, cancel := parseTimeout(c.Request().Context())
 	defer cancel()
 
+	if err := s.checkUserListReference(ctx, &req.Definition, userID); err != nil {
+		if err == errUserListNotFound {
+			return respondError(c, http.StatusBadRequest, "invalid_definition", "user_list.list_id does not refer to one of your user lists")
+		}
+		return respondError(c, http.StatusInternalServerError, "database_error", err.Error())
+	}
+
 	if err := s.db.CreateBail(ctx, dbBail); err != nil {
 		return respondError(c, http.StatusInternalServerError, "database_error", err.Error())
 	}
@@ -241,6 +248,13 @@ func (s *Server) UpdateBail(c echo.Context) error {
 			return respondError(c, http.StatusBadRequest, "invalid_definition", err.Error())
 		}
 
+		if err := s.checkUserListReference(ctx, req.Definition, userID); err != nil {
+			if err == errUserListNotFound {
+				return respondError(c, http.StatusBadRequest, "invalid_definition", "user_list.list_id does not refer to one of your user lists")
+			}
+			return respondError(c, http.StatusInternalServerError, "database_error", err.Error())
+		}
+
 		definitionJSON, err := json.Marshal(req.Definition)
 		if err != nil {
 			return respondError(c, http.StatusInternalServerError, "marshal_error", "Failed to marshal definition")
@@ -415,7 +429,7 @@ func (s *Server) GetUserEvents(c echo.Context) error {
 // POST /users/:userId/bails/preview
 func (s *Server) PreviewBail(c echo.Context) error {
 	userIDStr := c.Param("userId")
-	_, err := uuid.Parse(userIDStr)
+	userID, err := uuid.Parse(userIDStr)
 	if err != nil {
 		return respondError(c, http.StatusBadRequest, "invalid_user_id", "User ID must be a valid UUID")
 	}
@@ -429,6 +443,34 @@ func (s *Server) PreviewBail(c echo.Context) error {
 		return respondError(c, http.StatusBadRequest, "invalid_definition", err.Error())
 	}
 
+	// For stored user lists, return the list's entries
+	if req.Definition.Type == "user_list" && req.Definition.UserList != nil && req.Definition.UserList.ListID != nil {
+		ctx, cancel := parseTimeout(c.Request().Context())
+		defer cancel()
+
+		listID := *req.Definition.UserList.ListID
+		if _, err := s.ownedUserList(ctx, listID, userID); err != nil {
+			return respondUserListError(c, err)
+		}
+
+		entries, err := s.db.GetUserListEntries(ctx, listID)
+		if err != nil {
+			return respondError(c, http.StatusInternalServerError, "database_error", err.Error())
+		}
+
+		users := make([]UserPreview, len(entries))
+		for i, entry := range entries {
+			users[i] = UserPreview{
+				UserID: entry.UserID,
+				PageID: entry.PageID,
+			}
+		}
+		return c.JSON(http.StatusOK, PreviewResponse{
+			Users: users,
+			Count: len(users),
+		})
+	}
+
 	// For user_list bails, skip query building and return the user list directly
 	if req.Definition.Type == "user_list" && req.Definition.UserList != nil {
 		users := make([]UserPreview, len(req.Definition.UserList.Users))
@@ -457,6 +499,9 @@ func (s *Server) PreviewBail(c echo.Context) error {
 		return respondError(c, http.StatusInternalServerError, "query_error", err.Error())
 	}
 
+	// BuildQuery already rejected bad templates, so this cannot fail here
+	refs, _ := req.Definition.Action.MetadataRefs()
+
 	users := make([]UserPreview, len(results))
 	for i, row := range results {
 		userID, ok := row["userid"].(string)
@@ -471,6 +516,15 @@ func (s *Server) PreviewBail(c echo.Context) error {
 			UserID: userID,
 			PageID: pageID,
 		}
+		if len(refs) > 0 {
+			values := make(map[string]string, len(refs))
+			for _, ref := range refs {
+				if v, ok := row[ref.String()].(string); ok {
+					values[ref.String()] = v
+				}
+			}
+			users[i].Metadata = req.Definition.Action.RenderMetadata(values)
+		}
 	}
 
 	return c.JSON(http.StatusOK, PreviewResponse{
@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"strings"
@@ -31,6 +32,9 @@ type mockDB struct {
 	latestSummariesFunc         func(ctx context.Context, ids []uuid.UUID) (map[uuid.UUID]*db.BailEventSummary, error)
 	latestSummariesCallCount    int
 	latestSummariesLastCalled   []uuid.UUID
+	userLists                   []*db.UserList
+	userListEntries             map[uuid.UUID][]*db.UserListEntry
+	userListCheck               *db.UserListCheck
 }
 
 func (m *mockDB) GetBailsByUser(ctx context.Context, userID uuid.UUID) ([]*db.Bail, error) {
@@ -181,6 +185,80 @@ func (m *mockDB) Query(ctx context.Context, sql string, args ...interface{}) ([]
 	return []map[string]interface{}{}, nil
 }
 
+func (m *mockDB) CreateUserList(ctx context.Context, list *db.UserList, next func() (*db.UserListEntry, error)) error {
+	var entries []*db.UserListEntry
+	for {
+		entry, err := next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		entries = append(entries, entry)
+	}
+
+	list.ID = uuid.New()
+	list.CreatedAt = time.Now()
+	list.RowCount = len(entries)
+	m.userLists = append(m.userLists, list)
+	if m.userListEntries == nil {
+		m.userListEntries = make(map[uuid.UUID][]*db.UserListEntry)
+	}
+	m.userListEntries[list.ID] = entries
+	return nil
+}
+
+func (m *mockDB) CheckUserList(ctx context.Context, listID, ownerID uuid.UUID, limit int) (*db.UserListCheck, error) {
+	if m.userListCheck != nil {
+		return m.userListCheck, nil
+	}
+	return &db.UserListCheck{}, nil
+}
+
+func (m *mockDB) SetUserListReport(ctx context.Context, id uuid.UUID, report json.RawMessage) error {
+	for _, list := range m.userLists {
+		if list.ID == id {
+			list.Report = report
+			return nil
+		}
+	}
+	return fmt.Errorf("user list not found: %s", id)
+}
+
+func (m *mockDB) GetUserList(ctx context.Context, id uuid.UUID) (*db.UserList, error) {
+	for _, list := range m.userLists {
+		if list.ID == id {
+			return list, nil
+		}
+	}
+	return nil, pgx.ErrNoRows
+}
+
+func (m *mockDB) GetUserListsByUser(ctx context.Context, userID uuid.UUID) ([]*db.UserList, error) {
+	var result []*db.UserList
+	for _, list := range m.userLists {
+		if list.UserID == userID {
+			result = append(result, list)
+		}
+	}
+	return result, nil
+}
+
+func (m *mockDB) GetUserListEntries(ctx context.Context, listID uuid.UUID) ([]*db.UserListEntry, error) {
+	return m.userListEntries[listID], nil
+}
+
+func (m *mockDB) DeleteUserList(ctx context.Context, id uuid.UUID) error {
+	for i, list := range m.userLists {
+		if list.ID == id {
+			m.userLists = append(m.userLists[:i], m.userLists[i+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("user list not found: %s", id)
+}
+
 func (m *mockDB) Close() {
 	// no-op for mock
 }
@@ -787,6 +865,46 @@ func TestPreviewBail(t *testing.T) {
 	}
 }
 
+func TestPreviewBail_TemplatedMetadata(t *testing.T) {
+	userID := uuid.New()
+
+	mock := &mockDB{
+		queryFunc: func(ctx context.Context, sql string, args ...interface{}) ([]map[string]interface{}, error) {
+			return []map[string]interface{}{
+				{"userid": "user1", "pageid": "page1", "response.q_name": "Ada"},
+			}, nil
+		},
+	}
+
+	server := New(mock)
+
+	def := testBailDefinition()
+	def.Conditions = simpleFormCondition("test-form")
+	def.Action.Metadata = map[string]interface{}{"name": "Hi {{response.q_name}}"}
+
+	reqJSON, _ := json.Marshal(PreviewRequest{Definition: def})
+
+	req := httptest.NewRequest(http.MethodPost, "/users/"+userID.String()+"/bails/preview", strings.NewReader(string(reqJSON)))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	c := server.echo.NewContext(req, rec)
+	c.SetParamNames("userId")
+	c.SetParamValues(userID.String())
+
+	if err := server.PreviewBail(c); err != nil {
+		t.Fatalf("PreviewBail failed: %v", err)
+	}
+
+	var response PreviewResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to parse response: %v", err)
+	}
+
+	if len(response.Users) != 1 || response.Users[0].Metadata["name"] != "Hi Ada" {
+		t.Errorf("Expected rendered metadata in preview, got %+v", response.Users)
+	}
+}
+
 func TestCreateBail_UserListType(t *testing.T) {
 	userID := uuid.New()
 	mock := &mockDB{
@@ -2,6 +2,7 @@ package api
 
 import (
 	"context"
+	"encoding/json"
 	"time"
 
 	"github.com/google/uuid"
@@ -22,6 +23,13 @@ type DBInterface interface {
 	GetLatestEventSummariesByBailIDs(ctx context.Context, bailIDs []uuid.UUID) (map[uuid.UUID]*db.BailEventSummary, error)
 	GetEventsByUser(ctx context.Context, userID uuid.UUID, limit int) ([]*db.BailEvent, error)
 	Query(ctx context.Context, sql string, args ...interface{}) ([]map[string]interface{}, error)
+	CreateUserList(ctx context.Context, list *db.UserList, next func() (*db.UserListEntry, error)) error
+	CheckUserList(ctx context.Context, listID, ownerID uuid.UUID, limit int) (*db.UserListCheck, error)
+	SetUserListReport(ctx context.Context, id uuid.UUID, report json.RawMessage) error
+	GetUserList(ctx context.Context, id uuid.UUID) (*db.UserList, error)
+	GetUserListsByUser(ctx context.Context, userID uuid.UUID) ([]*db.UserList, error)
+	GetUserListEntries(ctx context.Context, listID uuid.UUID) ([]*db.UserListEntry, error)
+	DeleteUserList(ctx context.Context, id uuid.UUID) error
 	Close()
 }
 
@@ -69,6 +77,10 @@ func (s *Server) registerRoutes() {
 	userGroup.DELETE("/bails/:id", s.DeleteBail)
 	userGroup.GET("/bails/:id/events", s.GetBailEvents)
 	userGroup.GET("/bail-events", s.GetUserEvents)
+	userGroup.GET("/user-lists", s.ListUserLists)
+	userGroup.POST("/user-lists", s.UploadUserList)
+	userGroup.GET("/user-lists/:id", s.GetUserList)
+	userGroup.DELETE("/user-lists/:id", s.DeleteUserList)
 }
 
 // Run starts the HTTP server on the specified address (blocking)
@@ -60,8 +60,9 @@ type PreviewResponse struct {
 
 // UserPreview represents a user that matches bail conditions
 type UserPreview struct {
-	UserID string `json:"userid"`
-	PageID string `json:"pageid"`
+	UserID   string                 `json:"userid"`
+	PageID   string                 `json:"pageid"`
+	Metadata map[string]interface{} `json:"metadata,omitempty"` // rendered, only when the action uses templates
 }
 
 // ErrorResponse represents an error response
@@ -69,3 +70,8 @@ type ErrorResponse struct {
 	Error   string `json:"error"`
 	Message string `json:"message,omitempty"`
 }
+
+// UserListsResponse contains a list of uploaded user lists
+type UserListsResponse struct {
+	UserLists []*types.StoredUserList `json:"user_lists"`
+}
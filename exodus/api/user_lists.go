@@ -0,0 +1,484 @@
+package api
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4"
+	"github.com/labstack/echo/v4"
+	"github.com/vlab-research/exodus/db"
+	"github.com/vlab-research/exodus/types"
+)
+
+// maxReportExamples caps the sample rows kept per report category. The
+// counts are always exact; the examples only need to be enough to spot the
+// pattern behind a bad upload.
+const maxReportExamples = 100
+
+// uploadTimeout bounds a user list upload. It is far longer than the usual
+// database timeout because a 100k-row list is read, inserted and checked in a
+// single request.
+const uploadTimeout = 5 * time.Minute
+
+// csvFormatError is a problem with the upload as a whole (unreadable CSV,
+// missing columns, no usable rows), as opposed to a bad row, which is
+// reported and skipped.
+type csvFormatError struct {
+	msg string
+}
+
+func (e *csvFormatError) Error() string {
+	return e.msg
+}
+
+// userListKey identifies a row for duplicate detection
+type userListKey struct {
+	userID string
+	pageID string
+}
+
+// csvUserListReader streams user list entries out of a CSV upload.
+//
+// Columns are userid, pageid, shortcode and an optional platform. A header
+// row naming them may appear first, in any order; without one the columns are
+// taken positionally. Rows that are missing a required field are recorded as
+// invalid, and repeats of an earlier (userid, pageid) as duplicates -- neither
+// is returned from Next, everything else is.
+type csvUserListReader struct {
+	r      *csv.Reader
+	cols   map[string]int
+	line   int
+	seen   map[userListKey]int
+	report types.UserListReport
+	valid  int
+
+	// pending holds the first record when the file has no header
+	pending []string
+}
+
+var requiredUserListColumns = []string{"userid", "pageid", "shortcode"}
+
+func newCSVUserListReader(r io.Reader) *csvUserListReader {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.LazyQuotes = true
+	cr.TrimLeadingSpace = true
+
+	return &csvUserListReader{
+		r:    cr,
+		seen: make(map[userListKey]int),
+		report: types.UserListReport{
+			InvalidRows:   types.UserListIssues{Examples: []types.UserListIssue{}},
+			DuplicateRows: types.UserListIssues{Examples: []types.UserListIssue{}},
+		},
+	}
+}
+
+// readHeader detects and maps the header row, if there is one
+func (c *csvUserListReader) readHeader() error {
+	record, err := c.read()
+	if err == io.EOF {
+		return &csvFormatError{"the uploaded file is empty"}
+	}
+	if err != nil {
+		return err
+	}
+
+	cols := make(map[string]int, len(record))
+	for i, field := range record {
+		cols[strings.ToLower(strings.TrimSpace(field))] = i
+	}
+
+	if _, isHeader := cols["userid"]; !isHeader {
+		c.cols = map[string]int{"userid": 0, "pageid": 1, "shortcode": 2, "platform": 3}
+		c.pending = record
+		return nil
+	}
+
+	for _, col := range requiredUserListColumns {
+		if _, ok := cols[col]; !ok {
+			return &csvFormatError{fmt.Sprintf("header row is missing the %q column", col)}
+		}
+	}
+	c.cols = cols
+	return nil
+}
+
+// read returns the next raw record and advances the line counter
+func (c *csvUserListReader) read() ([]string, error) {
+	record, err := c.r.Read()
+	if err == io.EOF {
+		return nil, err
+	}
+	c.line++
+	if err != nil {
+		return nil, &csvFormatError{fmt.Sprintf("could not read CSV at line %d: %v", c.line, err)}
+	}
+	return record, nil
+}
+
+// field returns a trimmed column value, or "" if the row is too short
+func (c *csvUserListReader) field(record []string, col string) string {
+	i, ok := c.cols[col]
+	if !ok || i >= len(record) {
+		return ""
+	}
+	return strings.TrimSpace(record[i])
+}
+
+// Next returns the next usable entry, or io.EOF once the upload is exhausted.
+// It matches the iterator db.CreateUserList consumes.
+func (c *csvUserListReader) Next() (*db.UserListEntry, error) {
+	if c.cols == nil {
+		if err := c.readHeader(); err != nil {
+			return nil, err
+		}
+	}
+
+	for {
+		record := c.pending
+		c.pending = nil
+		if record == nil {
+			var err error
+			record, err = c.read()
+			if err == io.EOF {
+				if c.valid == 0 {
+					return nil, &csvFormatError{"the uploaded file contains no valid rows"}
+				}
+				return nil, io.EOF
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		// Skip blank lines rather than reporting them
+		if len(record) == 1 && strings.TrimSpace(record[0]) == "" {
+			continue
+		}
+		c.report.TotalRows++
+
+		entry := &db.UserListEntry{
+			UserID:    c.field(record, "userid"),
+			PageID:    c.field(record, "pageid"),
+			Shortcode: c.field(record, "shortcode"),
+			Platform:  strings.ToLower(c.field(record, "platform")),
+		}
+
+		issue := types.UserListIssue{
+			Line:      c.line,
+			UserID:    entry.UserID,
+			PageID:    entry.PageID,
+			Shortcode: entry.Shortcode,
+		}
+
+		if missing := missingUserListField(entry); missing != "" {
+			issue.Message = missing + " is required"
+			addIssue(&c.report.InvalidRows, issue)
+			continue
+		}
+
+		key := userListKey{entry.UserID, entry.PageID}
+		if first, dup := c.seen[key]; dup {
+			issue.Message = fmt.Sprintf("duplicate of line %d", first)
+			addIssue(&c.report.DuplicateRows, issue)
+			continue
+		}
+		c.seen[key] = c.line
+
+		c.valid++
+		return entry, nil
+	}
+}
+
+// Report returns the row-level part of the validation report
+func (c *csvUserListReader) Report() types.UserListReport {
+	return c.report
+}
+
+func missingUserListField(e *db.UserListEntry) string {
+	switch {
+	case e.UserID == "":
+		return "userid"
+	case e.PageID == "":
+		return "pageid"
+	case e.Shortcode == "":
+		return "shortcode"
+	}
+	return ""
+}
+
+func addIssue(issues *types.UserListIssues, issue types.UserListIssue) {
+	issues.Count++
+	if len(issues.Examples) < maxReportExamples {
+		issues.Examples = append(issues.Examples, issue)
+	}
+}
+
+// entriesToIssues converts a database check sample into report issues
+func entriesToIssues(entries []db.UserListEntry, count int, message string) types.UserListIssues {
+	issues := types.UserListIssues{Count: count, Examples: make([]types.UserListIssue, len(entries))}
+	for i, e := range entries {
+		issues.Examples[i] = types.UserListIssue{
+			UserID:    e.UserID,
+			PageID:    e.PageID,
+			Shortcode: e.Shortcode,
+			Message:   message,
+		}
+	}
+	return issues
+}
+
+// UploadUserList stores a CSV user list and returns its validation report
+// POST /users/:userId/user-lists?name=<name>
+//
+// The body is either the raw CSV (text/csv) or a multipart form with the CSV
+// in a "file" field and an optional "name" field.
+func (s *Server) UploadUserList(c echo.Context) error {
+	userIDStr := c.Param("userId")
+	userID, err := uuid.Parse(userIDStr)
+	if err != nil {
+		return respondError(c, http.StatusBadRequest, "invalid_user_id", "User ID must be a valid UUID")
+	}
+
+	name := c.QueryParam("name")
+	var body io.Reader = c.Request().Body
+
+	if strings.HasPrefix(c.Request().Header.Get(echo.HeaderContentType), echo.MIMEMultipartForm) {
+		fh, err := c.FormFile("file")
+		if err != nil {
+			return respondError(c, http.StatusBadRequest, "invalid_request", "Multipart upload must include a 'file' field")
+		}
+		f, err := fh.Open()
+		if err != nil {
+			return respondError(c, http.StatusBadRequest, "invalid_request", "Failed to read uploaded file")
+		}
+		defer f.Close()
+		body = f
+
+		if name == "" {
+			name = c.FormValue("name")
+		}
+		if name == "" {
+			name = fh.Filename
+		}
+	}
+
+	if name == "" {
+		return respondError(c, http.StatusBadRequest, "missing_field", "Name is required")
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request().Context(), uploadTimeout)
+	defer cancel()
+
+	reader := newCSVUserListReader(body)
+	list := &db.UserList{UserID: userID, Name: name}
+
+	if err := s.db.CreateUserList(ctx, list, reader.Next); err != nil {
+		var formatErr *csvFormatError
+		if errors.As(err, &formatErr) {
+			return respondError(c, http.StatusBadRequest, "invalid_csv", formatErr.Error())
+		}
+		return respondError(c, http.StatusInternalServerError, "database_error", err.Error())
+	}
+
+	check, err := s.db.CheckUserList(ctx, list.ID, userID, maxReportExamples)
+	if err != nil {
+		return respondError(c, http.StatusInternalServerError, "database_error", err.Error())
+	}
+
+	report := reader.Report()
+	report.StoredRows = list.RowCount
+	report.UnknownUsers = entriesToIssues(check.UnknownUsers, check.UnknownUsersCount, "user has no state on any page")
+	report.NotInStates = entriesToIssues(check.NotInStates, check.NotInStatesCount, "user has no state on this page")
+	report.UnknownShortcodes = check.UnknownShortcodes
+	if report.UnknownShortcodes == nil {
+		report.UnknownShortcodes = []string{}
+	}
+
+	reportJSON, err := json.Marshal(report)
+	if err != nil {
+		return respondError(c, http.StatusInternalServerError, "marshal_error", "Failed to marshal report")
+	}
+	if err := s.db.SetUserListReport(ctx, list.ID, reportJSON); err != nil {
+		return respondError(c, http.StatusInternalServerError, "database_error", err.Error())
+	}
+	list.Report = reportJSON
+
+	stored, err := dbUserListToTypesUserList(list)
+	if err != nil {
+		return respondError(c, http.StatusInternalServerError, "conversion_error", fmt.Sprintf("Failed to convert user list: %v", err))
+	}
+
+	return c.JSON(http.StatusCreated, stored)
+}
+
+// ListUserLists retrieves all uploaded user lists for a user
+// GET /users/:userId/user-lists
+func (s *Server) ListUserLists(c echo.Context) error {
+	userIDStr := c.Param("userId")
+	userID, err := uuid.Parse(userIDStr)
+	if err != nil {
+		return respondError(c, http.StatusBadRequest, "invalid_user_id", "User ID must be a valid UUID")
+	}
+
+	ctx, cancel := parseTimeout(c.Request().Context())
+	defer cancel()
+
+	dbLists, err := s.db.GetUserListsByUser(ctx, userID)
+	if err != nil {
+		return respondError(c, http.StatusInternalServerError, "database_error", err.Error())
+	}
+
+	lists := make([]*types.StoredUserList, len(dbLists))
+	for i, dbList := range dbLists {
+		lists[i], err = dbUserListToTypesUserList(dbList)
+		if err != nil {
+			return respondError(c, http.StatusInternalServerError, "conversion_error", fmt.Sprintf("Failed to convert user list: %v", err))
+		}
+	}
+
+	return c.JSON(http.StatusOK, UserListsResponse{UserLists: lists})
+}
+
+// GetUserList retrieves a single uploaded user list with its report
+// GET /users/:userId/user-lists/:id
+func (s *Server) GetUserList(c echo.Context) error {
+	userIDStr := c.Param("userId")
+	userID, err := uuid.Parse(userIDStr)
+	if err != nil {
+		return respondError(c, http.StatusBadRequest, "invalid_user_id", "User ID must be a valid UUID")
+	}
+
+	listIDStr := c.Param("id")
+	listID, err := uuid.Parse(listIDStr)
+	if err != nil {
+		return respondError(c, http.StatusBadRequest, "invalid_user_list_id", "User list ID must be a valid UUID")
+	}
+
+	ctx, cancel := parseTimeout(c.Request().Context())
+	defer cancel()
+
+	dbList, err := s.ownedUserList(ctx, listID, userID)
+	if err != nil {
+		return respondUserListError(c, err)
+	}
+
+	stored, err := dbUserListToTypesUserList(dbList)
+	if err != nil {
+		return respondError(c, http.StatusInternalServerError, "conversion_error", fmt.Sprintf("Failed to convert user list: %v", err))
+	}
+
+	return c.JSON(http.StatusOK, stored)
+}
+
+// DeleteUserList removes an uploaded user list. A list still referenced by
+// one of the user's bails cannot be deleted: the bail would start failing on
+// its next execution.
+// DELETE /users/:userId/user-lists/:id
+func (s *Server) DeleteUserList(c echo.Context) error {
+	userIDStr := c.Param("userId")
+	userID, err := uuid.Parse(userIDStr)
+	if err != nil {
+		return respondError(c, http.StatusBadRequest, "invalid_user_id", "User ID must be a valid UUID")
+	}
+
+	listIDStr := c.Param("id")
+	listID, err := uuid.Parse(listIDStr)
+	if err != nil {
+		return respondError(c, http.StatusBadRequest, "invalid_user_list_id", "User list ID must be a valid UUID")
+	}
+
+	ctx, cancel := parseTimeout(c.Request().Context())
+	defer cancel()
+
+	if _, err = s.ownedUserList(ctx, listID, userID); err != nil {
+		return respondUserListError(c, err)
+	}
+
+	bails, err := s.db.GetBailsByUser(ctx, userID)
+	if err != nil {
+		return respondError(c, http.StatusInternalServerError, "database_error", err.Error())
+	}
+	for _, bail := range bails {
+		var def types.BailDefinition
+		if err := json.Unmarshal(bail.Definition, &def); err != nil {
+			continue
+		}
+		if def.UserList != nil && def.UserList.ListID != nil && *def.UserList.ListID == listID {
+			return respondError(c, http.StatusConflict, "user_list_in_use",
+				fmt.Sprintf("User list is used by bail %q", bail.Name))
+		}
+	}
+
+	if err := s.db.DeleteUserList(ctx, listID); err != nil {
+		return respondError(c, http.StatusInternalServerError, "database_error", err.Error())
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// errUserListNotFound is returned by ownedUserList for a missing list and for
+// a list owned by someone else alike, so list IDs cannot be probed.
+var errUserListNotFound = errors.New("user list not found")
+
+// ownedUserList loads a user list and verifies it belongs to userID
+func (s *Server) ownedUserList(ctx context.Context, listID, userID uuid.UUID) (*db.UserList, error) {
+	list, err := s.db.GetUserList(ctx, listID)
+	if err == pgx.ErrNoRows {
+		return nil, errUserListNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if list.UserID != userID {
+		return nil, errUserListNotFound
+	}
+	return list, nil
+}
+
+// checkUserListReference verifies that a user_list definition referencing a
+// stored list points at one of the user's own lists
+func (s *Server) checkUserListReference(ctx context.Context, def *types.BailDefinition, userID uuid.UUID) error {
+	if def.Type != "user_list" || def.UserList == nil || def.UserList.ListID == nil {
+		return nil
+	}
+	_, err := s.ownedUserList(ctx, *def.UserList.ListID, userID)
+	return err
+}
+
+func respondUserListError(c echo.Context, err error) error {
+	if err == errUserListNotFound {
+		return respondError(c, http.StatusNotFound, "user_list_not_found", "User list not found")
+	}
+	return respondError(c, http.StatusInternalServerError, "database_error", err.Error())
+}
+
+// dbUserListToTypesUserList converts a db.UserList to types.StoredUserList
+func dbUserListToTypesUserList(dbList *db.UserList) (*types.StoredUserList, error) {
+	stored := &types.StoredUserList{
+		ID:        dbList.ID,
+		UserID:    dbList.UserID,
+		Name:      dbList.Name,
+		RowCount:  dbList.RowCount,
+		CreatedAt: dbList.CreatedAt,
+	}
+
+	if len(dbList.Report) > 0 && string(dbList.Report) != "{}" {
+		var report types.UserListReport
+		if err := json.Unmarshal(dbList.Report, &report); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal report: %w", err)
+		}
+		stored.Report = &report
+	}
+
+	return stored, nil
+}
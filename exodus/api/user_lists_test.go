@@ -0,0 +1,400 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/vlab-research/exodus/db"
+	"github.com/vlab-research/exodus/types"
+)
+
+// readAllEntries drains a csvUserListReader the way db.CreateUserList does
+func readAllEntries(r *csvUserListReader) ([]*db.UserListEntry, error) {
+	var entries []*db.UserListEntry
+	for {
+		entry, err := r.Next()
+		if err == io.EOF {
+			return entries, nil
+		}
+		if err != nil {
+			return entries, err
+		}
+		entries = append(entries, entry)
+	}
+}
+
+func TestCSVUserListReader(t *testing.T) {
+	tests := []struct {
+		name        string
+		csv         string
+		wantUsers   []string
+		wantInvalid int
+		wantDups    int
+		wantTotal   int
+	}{
+		{
+			name:      "header row",
+			csv:       "userid,pageid,shortcode\nu1,p1,s1\nu2,p1,s1\n",
+			wantUsers: []string{"u1", "u2"},
+			wantTotal: 2,
+		},
+		{
+			name:      "header in another order with platform",
+			csv:       "Shortcode, PageID, UserID, Platform\ns1,p1,u1,Instagram\n",
+			wantUsers: []string{"u1"},
+			wantTotal: 1,
+		},
+		{
+			name:      "positional columns without header",
+			csv:       "u1,p1,s1\nu2,p2,s1,messenger\n",
+			wantUsers: []string{"u1", "u2"},
+			wantTotal: 2,
+		},
+		{
+			name:        "invalid rows are skipped",
+			csv:         "userid,pageid,shortcode\nu1,p1,s1\nu2,,s1\nu3,p1\n",
+			wantUsers:   []string{"u1"},
+			wantInvalid: 2,
+			wantTotal:   3,
+		},
+		{
+			name:      "duplicates are skipped",
+			csv:       "userid,pageid,shortcode\nu1,p1,s1\nu1,p1,s2\nu1,p2,s1\n",
+			wantUsers: []string{"u1", "u1"},
+			wantDups:  1,
+			wantTotal: 3,
+		},
+		{
+			name:      "blank lines are ignored",
+			csv:       "userid,pageid,shortcode\n\nu1,p1,s1\n\n",
+			wantUsers: []string{"u1"},
+			wantTotal: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newCSVUserListReader(strings.NewReader(tt.csv))
+			entries, err := readAllEntries(r)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			if len(entries) != len(tt.wantUsers) {
+				t.Fatalf("Expected %d entries, got %d", len(tt.wantUsers), len(entries))
+			}
+			for i, want := range tt.wantUsers {
+				if entries[i].UserID != want {
+					t.Errorf("Entry %d: expected userid %s, got %s", i, want, entries[i].UserID)
+				}
+			}
+
+			report := r.Report()
+			if report.TotalRows != tt.wantTotal {
+				t.Errorf("Expected %d total rows, got %d", tt.wantTotal, report.TotalRows)
+			}
+			if report.InvalidRows.Count != tt.wantInvalid {
+				t.Errorf("Expected %d invalid rows, got %d", tt.wantInvalid, report.InvalidRows.Count)
+			}
+			if report.DuplicateRows.Count != tt.wantDups {
+				t.Errorf("Expected %d duplicate rows, got %d", tt.wantDups, report.DuplicateRows.Count)
+			}
+		})
+	}
+}
+
+func TestCSVUserListReader_Platform(t *testing.T) {
+	r := newCSVUserListReader(strings.NewReader("userid,pageid,shortcode,platform\nu1,p1,s1,Instagram\n"))
+	entries, err := readAllEntries(r)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if entries[0].Platform != "instagram" {
+		t.Errorf("Expected platform to be lowercased to 'instagram', got %q", entries[0].Platform)
+	}
+}
+
+func TestCSVUserListReader_DuplicateReportsFirstLine(t *testing.T) {
+	r := newCSVUserListReader(strings.NewReader("userid,pageid,shortcode\nu1,p1,s1\nu2,p1,s1\nu1,p1,s1\n"))
+	if _, err := readAllEntries(r); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	dups := r.Report().DuplicateRows
+	if len(dups.Examples) != 1 {
+		t.Fatalf("Expected 1 duplicate example, got %d", len(dups.Examples))
+	}
+	if dups.Examples[0].Line != 4 || dups.Examples[0].Message != "duplicate of line 2" {
+		t.Errorf("Unexpected duplicate example: %+v", dups.Examples[0])
+	}
+}
+
+func TestCSVUserListReader_FormatErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		csv  string
+	}{
+		{"empty file", ""},
+		{"header only", "userid,pageid,shortcode\n"},
+		{"header missing column", "userid,pageid\nu1,p1\n"},
+		{"no valid rows", "userid,pageid,shortcode\nu1,,\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := readAllEntries(newCSVUserListReader(strings.NewReader(tt.csv)))
+			var formatErr *csvFormatError
+			if !errors.As(err, &formatErr) {
+				t.Errorf("Expected a csvFormatError, got %v", err)
+			}
+		})
+	}
+}
+
+func TestCSVUserListReader_CapsExamples(t *testing.T) {
+	var sb strings.Builder
+	sb.WriteString("userid,pageid,shortcode\nu0,p1,s1\n")
+	for i := 0; i < maxReportExamples+50; i++ {
+		sb.WriteString("u0,p1,s1\n")
+	}
+
+	r := newCSVUserListReader(strings.NewReader(sb.String()))
+	if _, err := readAllEntries(r); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	dups := r.Report().DuplicateRows
+	if dups.Count != maxReportExamples+50 {
+		t.Errorf("Expected exact duplicate count %d, got %d", maxReportExamples+50, dups.Count)
+	}
+	if len(dups.Examples) != maxReportExamples {
+		t.Errorf("Expected examples capped at %d, got %d", maxReportExamples, len(dups.Examples))
+	}
+}
+
+func TestUploadUserList(t *testing.T) {
+	userID := uuid.New()
+	mock := &mockDB{
+		userListCheck: &db.UserListCheck{
+			UnknownUsers:      []db.UserListEntry{{UserID: "u2", PageID: "p1", Shortcode: "s1"}},
+			UnknownUsersCount: 1,
+			UnknownShortcodes: []string{"s1"},
+		},
+	}
+	server := New(mock)
+
+	body := "userid,pageid,shortcode\nu1,p1,s1\nu2,p1,s1\nu1,p1,s1\n"
+	req := httptest.NewRequest(http.MethodPost, "/users/"+userID.String()+"/user-lists?name=wave-2", strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, "text/csv")
+	rec := httptest.NewRecorder()
+	c := server.echo.NewContext(req, rec)
+	c.SetParamNames("userId")
+	c.SetParamValues(userID.String())
+
+	if err := server.UploadUserList(c); err != nil {
+		t.Fatalf("UploadUserList failed: %v", err)
+	}
+
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	var response types.StoredUserList
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to parse response: %v", err)
+	}
+
+	if response.Name != "wave-2" {
+		t.Errorf("Expected name 'wave-2', got '%s'", response.Name)
+	}
+	if response.RowCount != 2 {
+		t.Errorf("Expected 2 stored rows, got %d", response.RowCount)
+	}
+	if response.Report == nil {
+		t.Fatal("Expected a validation report")
+	}
+	if response.Report.DuplicateRows.Count != 1 {
+		t.Errorf("Expected 1 duplicate row, got %d", response.Report.DuplicateRows.Count)
+	}
+	if response.Report.UnknownUsers.Count != 1 {
+		t.Errorf("Expected 1 unknown user, got %d", response.Report.UnknownUsers.Count)
+	}
+	if len(response.Report.UnknownShortcodes) != 1 || response.Report.UnknownShortcodes[0] != "s1" {
+		t.Errorf("Expected unknown shortcode s1, got %v", response.Report.UnknownShortcodes)
+	}
+
+	// The report is persisted so it can be shown again later
+	if len(mock.userLists) != 1 || len(mock.userLists[0].Report) == 0 {
+		t.Error("Expected the report to be stored with the list")
+	}
+}
+
+func TestUploadUserList_Multipart(t *testing.T) {
+	userID := uuid.New()
+	mock := &mockDB{}
+	server := New(mock)
+
+	var buf bytes.Buffer
+	w := multipart.NewWriter(&buf)
+	part, _ := w.CreateFormFile("file", "respondents.csv")
+	part.Write([]byte("u1,p1,s1\n"))
+	w.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/users/"+userID.String()+"/user-lists", &buf)
+	req.Header.Set(echo.HeaderContentType, w.FormDataContentType())
+	rec := httptest.NewRecorder()
+	c := server.echo.NewContext(req, rec)
+	c.SetParamNames("userId")
+	c.SetParamValues(userID.String())
+
+	if err := server.UploadUserList(c); err != nil {
+		t.Fatalf("UploadUserList failed: %v", err)
+	}
+
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if mock.userLists[0].Name != "respondents.csv" {
+		t.Errorf("Expected name to default to the file name, got '%s'", mock.userLists[0].Name)
+	}
+}
+
+func TestUploadUserList_Errors(t *testing.T) {
+	userID := uuid.New()
+
+	tests := []struct {
+		name     string
+		query    string
+		body     string
+		wantCode string
+	}{
+		{"missing name", "", "u1,p1,s1\n", "missing_field"},
+		{"no valid rows", "?name=x", "userid,pageid,shortcode\n", "invalid_csv"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := New(&mockDB{})
+
+			req := httptest.NewRequest(http.MethodPost, "/users/"+userID.String()+"/user-lists"+tt.query, strings.NewReader(tt.body))
+			rec := httptest.NewRecorder()
+			c := server.echo.NewContext(req, rec)
+			c.SetParamNames("userId")
+			c.SetParamValues(userID.String())
+
+			if err := server.UploadUserList(c); err != nil {
+				t.Fatalf("UploadUserList failed: %v", err)
+			}
+
+			if rec.Code != http.StatusBadRequest {
+				t.Errorf("Expected status 400, got %d", rec.Code)
+			}
+
+			var response ErrorResponse
+			json.Unmarshal(rec.Body.Bytes(), &response)
+			if response.Error != tt.wantCode {
+				t.Errorf("Expected error code '%s', got '%s'", tt.wantCode, response.Error)
+			}
+		})
+	}
+}
+
+func TestGetUserList_OtherOwner(t *testing.T) {
+	owner := uuid.New()
+	other := uuid.New()
+	listID := uuid.New()
+
+	mock := &mockDB{
+		userLists: []*db.UserList{{ID: listID, UserID: owner, Name: "list", CreatedAt: time.Now()}},
+	}
+	server := New(mock)
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	rec := httptest.NewRecorder()
+	c := server.echo.NewContext(req, rec)
+	c.SetParamNames("userId", "id")
+	c.SetParamValues(other.String(), listID.String())
+
+	if err := server.GetUserList(c); err != nil {
+		t.Fatalf("GetUserList failed: %v", err)
+	}
+
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 for another user's list, got %d", rec.Code)
+	}
+}
+
+func TestDeleteUserList_InUse(t *testing.T) {
+	userID := uuid.New()
+	listID := uuid.New()
+
+	def := testBailDefinition()
+	def.Type = "user_list"
+	def.Conditions = nil
+	def.UserList = &types.UserList{ListID: &listID}
+	defJSON, _ := json.Marshal(def)
+
+	mock := &mockDB{
+		bails:     []*db.Bail{{ID: uuid.New(), UserID: userID, Name: "uses-list", Definition: defJSON}},
+		userLists: []*db.UserList{{ID: listID, UserID: userID, Name: "list", CreatedAt: time.Now()}},
+	}
+	server := New(mock)
+
+	req := httptest.NewRequest(http.MethodDelete, "/", nil)
+	rec := httptest.NewRecorder()
+	c := server.echo.NewContext(req, rec)
+	c.SetParamNames("userId", "id")
+	c.SetParamValues(userID.String(), listID.String())
+
+	if err := server.DeleteUserList(c); err != nil {
+		t.Fatalf("DeleteUserList failed: %v", err)
+	}
+
+	if rec.Code != http.StatusConflict {
+		t.Errorf("Expected status 409, got %d", rec.Code)
+	}
+	if len(mock.userLists) != 1 {
+		t.Error("Expected the list to be kept while a bail uses it")
+	}
+}
+
+func TestCreateBail_RejectsUnownedUserList(t *testing.T) {
+	userID := uuid.New()
+	listID := uuid.New()
+
+	mock := &mockDB{
+		userLists: []*db.UserList{{ID: listID, UserID: uuid.New(), Name: "theirs", CreatedAt: time.Now()}},
+	}
+	server := New(mock)
+
+	def := testBailDefinition()
+	def.Type = "user_list"
+	def.Conditions = nil
+	def.UserList = &types.UserList{ListID: &listID}
+
+	body, _ := json.Marshal(CreateBailRequest{Name: "bail", Definition: def})
+	req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	c := server.echo.NewContext(req, rec)
+	c.SetParamNames("userId")
+	c.SetParamValues(userID.String())
+
+	if err := server.CreateBail(c); err != nil {
+		t.Fatalf("CreateBail failed: %v", err)
+	}
+
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
@@ -23,7 +23,14 @@ type Config struct {
 	RateLimit    time.Duration `env:"EXODUS_RATE_LIMIT" envDefault:"1s"`
 	MaxBailUsers int           `env:"EXODUS_MAX_BAIL_USERS" envDefault:"100000"`
 
-	// API settings
+	// Scheduler settings (scheduler mode only). The lease must outlive the
+	// gap between renewals, which happen every LeaseTTL/3.
+	TickInterval time.Duration `env:"EXODUS_TICK_INTERVAL" envDefault:"30s"`
+	LeaseTTL     time.Duration `env:"EXODUS_LEASE_TTL" envDefault:"60s"`
+	LeaseName    string        `env:"EXODUS_LEASE_NAME" envDefault:"exodus-executor"`
+	LeaseHolder  string        `env:"HOSTNAME"` // pod name in Kubernetes
+
+	// API settings (also serves health/readiness in scheduler mode)
 	Port int `env:"PORT" envDefault:"8080"`
 
 	// Operational
@@ -57,5 +64,22 @@ func (c *Config) Validate(mode string) error {
 	if mode == "api" && c.Port == 0 {
 		return fmt.Errorf("PORT is required in API mode")
 	}
+	if mode == "scheduler" {
+		if c.BotserverURL == "" {
+			return fmt.Errorf("BOTSERVER_URL is required in scheduler mode")
+		}
+		if c.Port == 0 {
+			return fmt.Errorf("PORT is required in scheduler mode")
+		}
+		if c.TickInterval <= 0 {
+			return fmt.Errorf("EXODUS_TICK_INTERVAL must be positive")
+		}
+		if c.LeaseTTL <= 0 {
+			return fmt.Errorf("EXODUS_LEASE_TTL must be positive")
+		}
+		if c.LeaseHolder == "" {
+			return fmt.Errorf("HOSTNAME is required in scheduler mode to identify the lease holder")
+		}
+	}
 	return nil
 }
@@ -0,0 +1,53 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// AcquireLease takes or renews the named lease for holder, valid for ttl.
+//
+// It returns true if holder owns the lease when the statement commits: either
+// nobody held it, holder already held it (a renewal), or the previous holder
+// let it expire. It returns false, with no error, if another holder's lease is
+// still live. The whole decision is one conditional upsert, so two replicas
+// racing for an expired lease cannot both win.
+func (d *DB) AcquireLease(ctx context.Context, name, holder string, ttl time.Duration) (bool, error) {
+	query := `
+		INSERT INTO chatroach.exodus_leases AS l (name, holder, expires_at)
+		VALUES ($1, $2, now() + ($3 * INTERVAL '1 millisecond'))
+		ON CONFLICT (name) DO UPDATE
+		  SET holder = excluded.holder,
+		      expires_at = excluded.expires_at,
+		      acquired_at = CASE WHEN l.holder = excluded.holder THEN l.acquired_at ELSE now() END
+		  WHERE l.holder = excluded.holder OR l.expires_at < now()
+		RETURNING holder
+	`
+
+	var got string
+	err := d.pool.QueryRow(ctx, query, name, holder, ttl.Milliseconds()).Scan(&got)
+	if err == pgx.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to acquire lease %s: %w", name, err)
+	}
+
+	return got == holder, nil
+}
+
+// ReleaseLease gives up the named lease if holder owns it, so another replica
+// can take over without waiting out the TTL. Releasing a lease held by someone
+// else is a no-op.
+func (d *DB) ReleaseLease(ctx context.Context, name, holder string) error {
+	query := `DELETE FROM chatroach.exodus_leases WHERE name = $1 AND holder = $2`
+
+	if _, err := d.pool.Exec(ctx, query, name, holder); err != nil {
+		return fmt.Errorf("failed to release lease %s: %w", name, err)
+	}
+
+	return nil
+}
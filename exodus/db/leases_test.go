@@ -0,0 +1,101 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestAcquireLease(t *testing.T) {
+	pool := TestPool()
+	defer pool.Close()
+	if err := ResetDB(pool, []string{"exodus_leases"}); err != nil {
+		t.Fatalf("ResetDB failed: %v", err)
+	}
+
+	db := &DB{pool: pool}
+	ctx := context.Background()
+
+	held, err := db.AcquireLease(ctx, "test-lease", "pod-a", time.Minute)
+	if err != nil {
+		t.Fatalf("AcquireLease failed: %v", err)
+	}
+	if !held {
+		t.Fatal("Expected pod-a to acquire a free lease")
+	}
+
+	held, err = db.AcquireLease(ctx, "test-lease", "pod-b", time.Minute)
+	if err != nil {
+		t.Fatalf("AcquireLease failed: %v", err)
+	}
+	if held {
+		t.Error("Expected pod-b not to acquire a lease pod-a holds")
+	}
+
+	held, err = db.AcquireLease(ctx, "test-lease", "pod-a", time.Minute)
+	if err != nil {
+		t.Fatalf("AcquireLease failed: %v", err)
+	}
+	if !held {
+		t.Error("Expected pod-a to renew its own lease")
+	}
+}
+
+func TestAcquireLease_TakesOverExpiredLease(t *testing.T) {
+	pool := TestPool()
+	defer pool.Close()
+	if err := ResetDB(pool, []string{"exodus_leases"}); err != nil {
+		t.Fatalf("ResetDB failed: %v", err)
+	}
+
+	db := &DB{pool: pool}
+	ctx := context.Background()
+
+	MustExec(t, pool, `
+		INSERT INTO chatroach.exodus_leases (name, holder, expires_at)
+		VALUES ('test-lease', 'pod-a', now() - INTERVAL '1 second')
+	`)
+
+	held, err := db.AcquireLease(ctx, "test-lease", "pod-b", time.Minute)
+	if err != nil {
+		t.Fatalf("AcquireLease failed: %v", err)
+	}
+	if !held {
+		t.Error("Expected pod-b to take over an expired lease")
+	}
+}
+
+func TestReleaseLease(t *testing.T) {
+	pool := TestPool()
+	defer pool.Close()
+	if err := ResetDB(pool, []string{"exodus_leases"}); err != nil {
+		t.Fatalf("ResetDB failed: %v", err)
+	}
+
+	db := &DB{pool: pool}
+	ctx := context.Background()
+
+	if _, err := db.AcquireLease(ctx, "test-lease", "pod-a", time.Minute); err != nil {
+		t.Fatalf("AcquireLease failed: %v", err)
+	}
+
+	// Releasing someone else's lease must not free it.
+	if err := db.ReleaseLease(ctx, "test-lease", "pod-b"); err != nil {
+		t.Fatalf("ReleaseLease failed: %v", err)
+	}
+	held, _ := db.AcquireLease(ctx, "test-lease", "pod-b", time.Minute)
+	if held {
+		t.Fatal("Expected lease to survive a release by a non-holder")
+	}
+
+	if err := db.ReleaseLease(ctx, "test-lease", "pod-a"); err != nil {
+		t.Fatalf("ReleaseLease failed: %v", err)
+	}
+	held, err := db.AcquireLease(ctx, "test-lease", "pod-b", time.Minute)
+	if err != nil {
+		t.Fatalf("AcquireLease failed: %v", err)
+	}
+	if !held {
+		t.Error("Expected pod-b to acquire a released lease")
+	}
+}
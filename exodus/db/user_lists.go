@@ -0,0 +1,360 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4"
+)
+
+// userListInsertBatch is how many entries go into one multi-row INSERT. Each
+// row carries five parameters, which keeps a batch well under the 65535
+// placeholder limit while still loading 100k rows in ~100 round-trips.
+const userListInsertBatch = 1000
+
+// UserList is an uploaded list of users referenced by user_list-type bails
+type UserList struct {
+	ID        uuid.UUID       `json:"id"`
+	UserID    uuid.UUID       `json:"user_id"`
+	Name      string          `json:"name"`
+	RowCount  int             `json:"row_count"`
+	Report    json.RawMessage `json:"report"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// UserListEntry is a single stored row of a user list
+type UserListEntry struct {
+	UserID    string `json:"userid"`
+	PageID    string `json:"pageid"`
+	Shortcode string `json:"shortcode"`
+	Platform  string `json:"platform,omitempty"`
+}
+
+// UserListCheck holds the database-side validation of a stored user list.
+// Each slice is a sample capped at the limit passed to CheckUserList; the
+// matching count is the full total.
+type UserListCheck struct {
+	UnknownUsers      []UserListEntry // userid never seen in states on any page
+	UnknownUsersCount int
+	NotInStates       []UserListEntry // userid known, but has no state on this pageid
+	NotInStatesCount  int
+	UnknownShortcodes []string // shortcode is not one of the owner's surveys
+}
+
+// CreateUserList stores a list and its entries in one transaction.
+//
+// Entries are pulled from next until it returns io.EOF, so the caller can
+// stream a large upload straight from the request body without holding it in
+// memory. list.ID, list.RowCount and list.CreatedAt are populated on success.
+// If next returns any other error, nothing is stored.
+func (d *DB) CreateUserList(ctx context.Context, list *UserList, next func() (*UserListEntry, error)) error {
+	tx, err := d.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin user list transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	report := list.Report
+	if report == nil {
+		report = json.RawMessage("{}")
+	}
+
+	err = tx.QueryRow(ctx, `
+		INSERT INTO chatroach.bail_user_lists (user_id, name, report)
+		VALUES ($1, $2, $3)
+		RETURNING id, created_at
+	`, list.UserID, list.Name, report).Scan(&list.ID, &list.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to create user list: %w", err)
+	}
+
+	batch := make([]*UserListEntry, 0, userListInsertBatch)
+	count := 0
+
+	for {
+		entry, err := next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		batch = append(batch, entry)
+		if len(batch) == userListInsertBatch {
+			n, err := insertUserListEntries(ctx, tx, list.ID, batch)
+			if err != nil {
+				return err
+			}
+			count += n
+			batch = batch[:0]
+		}
+	}
+
+	if len(batch) > 0 {
+		n, err := insertUserListEntries(ctx, tx, list.ID, batch)
+		if err != nil {
+			return err
+		}
+		count += n
+	}
+
+	_, err = tx.Exec(ctx, `UPDATE chatroach.bail_user_lists SET row_count = $2 WHERE id = $1`, list.ID, count)
+	if err != nil {
+		return fmt.Errorf("failed to update user list row count: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit user list: %w", err)
+	}
+
+	list.RowCount = count
+	return nil
+}
+
+// insertUserListEntries writes one batch and returns how many rows were new.
+// A (userid, pageid) already in the list is skipped rather than an error.
+func insertUserListEntries(ctx context.Context, tx pgx.Tx, listID uuid.UUID, entries []*UserListEntry) (int, error) {
+	var sb strings.Builder
+	sb.WriteString(`INSERT INTO chatroach.bail_user_list_entries (list_id, userid, pageid, shortcode, platform) VALUES `)
+
+	args := make([]interface{}, 0, len(entries)*5)
+	for i, e := range entries {
+		if i > 0 {
+			sb.WriteString(", ")
+		}
+		n := i * 5
+		fmt.Fprintf(&sb, "($%d, $%d, $%d, $%d, $%d)", n+1, n+2, n+3, n+4, n+5)
+
+		var platform *string
+		if e.Platform != "" {
+			platform = &e.Platform
+		}
+		args = append(args, listID, e.UserID, e.PageID, e.Shortcode, platform)
+	}
+	sb.WriteString(` ON CONFLICT (list_id, userid, pageid) DO NOTHING`)
+
+	tag, err := tx.Exec(ctx, sb.String(), args...)
+	if err != nil {
+		return 0, fmt.Errorf("failed to insert user list entries: %w", err)
+	}
+	return int(tag.RowsAffected()), nil
+}
+
+// SetUserListReport replaces the stored validation report for a list
+func (d *DB) SetUserListReport(ctx context.Context, id uuid.UUID, report json.RawMessage) error {
+	result, err := d.pool.Exec(ctx, `UPDATE chatroach.bail_user_lists SET report = $2 WHERE id = $1`, id, report)
+	if err != nil {
+		return fmt.Errorf("failed to set user list report: %w", err)
+	}
+	if result.RowsAffected() == 0 {
+		return fmt.Errorf("user list not found: %s", id)
+	}
+	return nil
+}
+
+// CheckUserList validates a stored list against states and the owner's
+// surveys. At most limit examples are returned per category.
+func (d *DB) CheckUserList(ctx context.Context, listID, ownerID uuid.UUID, limit int) (*UserListCheck, error) {
+	check := &UserListCheck{}
+
+	// count(*) OVER () is computed before LIMIT, so one query yields both the
+	// total and the sample.
+	unknownUsers := `
+		SELECT e.userid, e.pageid, e.shortcode, count(*) OVER ()
+		FROM chatroach.bail_user_list_entries e
+		WHERE e.list_id = $1
+		  AND NOT EXISTS (SELECT 1 FROM chatroach.states s WHERE s.userid = e.userid)
+		ORDER BY e.userid, e.pageid
+		LIMIT $2
+	`
+	var err error
+	check.UnknownUsers, check.UnknownUsersCount, err = d.queryEntrySample(ctx, unknownUsers, listID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check unknown users: %w", err)
+	}
+
+	notInStates := `
+		SELECT e.userid, e.pageid, e.shortcode, count(*) OVER ()
+		FROM chatroach.bail_user_list_entries e
+		WHERE e.list_id = $1
+		  AND EXISTS (SELECT 1 FROM chatroach.states s WHERE s.userid = e.userid)
+		  AND NOT EXISTS (SELECT 1 FROM chatroach.states s WHERE s.userid = e.userid AND s.pageid = e.pageid)
+		ORDER BY e.userid, e.pageid
+		LIMIT $2
+	`
+	check.NotInStates, check.NotInStatesCount, err = d.queryEntrySample(ctx, notInStates, listID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check users not in states: %w", err)
+	}
+
+	shortcodes := `
+		SELECT DISTINCT e.shortcode
+		FROM chatroach.bail_user_list_entries e
+		WHERE e.list_id = $1
+		  AND NOT EXISTS (
+		    SELECT 1 FROM chatroach.surveys sv
+		    WHERE sv.userid = $2 AND sv.shortcode = e.shortcode
+		  )
+		ORDER BY e.shortcode
+	`
+	rows, err := d.pool.Query(ctx, shortcodes, listID, ownerID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check unknown shortcodes: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var shortcode string
+		if err := rows.Scan(&shortcode); err != nil {
+			return nil, fmt.Errorf("failed to scan shortcode: %w", err)
+		}
+		check.UnknownShortcodes = append(check.UnknownShortcodes, shortcode)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating shortcodes: %w", err)
+	}
+
+	return check, nil
+}
+
+// queryEntrySample runs a CheckUserList query whose fourth column is the
+// windowed total
+func (d *DB) queryEntrySample(ctx context.Context, sql string, listID uuid.UUID, limit int) ([]UserListEntry, int, error) {
+	rows, err := d.pool.Query(ctx, sql, listID, limit)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var entries []UserListEntry
+	total := 0
+	for rows.Next() {
+		var e UserListEntry
+		if err := rows.Scan(&e.UserID, &e.PageID, &e.Shortcode, &total); err != nil {
+			return nil, 0, err
+		}
+		entries = append(entries, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	return entries, total, nil
+}
+
+// GetUserList retrieves a user list by its ID (without entries)
+func (d *DB) GetUserList(ctx context.Context, id uuid.UUID) (*UserList, error) {
+	query := `
+		SELECT id, user_id, name, row_count, report, created_at
+		FROM chatroach.bail_user_lists
+		WHERE id = $1
+	`
+
+	list := &UserList{}
+	err := d.pool.QueryRow(ctx, query, id).Scan(
+		&list.ID,
+		&list.UserID,
+		&list.Name,
+		&list.RowCount,
+		&list.Report,
+		&list.CreatedAt,
+	)
+	if err == pgx.ErrNoRows {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user list: %w", err)
+	}
+
+	return list, nil
+}
+
+// GetUserListsByUser retrieves all user lists for a user, newest first
+func (d *DB) GetUserListsByUser(ctx context.Context, userID uuid.UUID) ([]*UserList, error) {
+	query := `
+		SELECT id, user_id, name, row_count, report, created_at
+		FROM chatroach.bail_user_lists
+		WHERE user_id = $1
+		ORDER BY created_at DESC
+	`
+
+	rows, err := d.pool.Query(ctx, query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query user lists: %w", err)
+	}
+	defer rows.Close()
+
+	var lists []*UserList
+	for rows.Next() {
+		list := &UserList{}
+		err := rows.Scan(
+			&list.ID,
+			&list.UserID,
+			&list.Name,
+			&list.RowCount,
+			&list.Report,
+			&list.CreatedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan user list: %w", err)
+		}
+		lists = append(lists, list)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating user lists: %w", err)
+	}
+
+	return lists, nil
+}
+
+// GetUserListEntries retrieves every entry of a user list
+func (d *DB) GetUserListEntries(ctx context.Context, listID uuid.UUID) ([]*UserListEntry, error) {
+	query := `
+		SELECT userid, pageid, shortcode, COALESCE(platform, '')
+		FROM chatroach.bail_user_list_entries
+		WHERE list_id = $1
+		ORDER BY userid, pageid
+	`
+
+	rows, err := d.pool.Query(ctx, query, listID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query user list entries: %w", err)
+	}
+	defer rows.Close()
+
+	var entries []*UserListEntry
+	for rows.Next() {
+		e := &UserListEntry{}
+		if err := rows.Scan(&e.UserID, &e.PageID, &e.Shortcode, &e.Platform); err != nil {
+			return nil, fmt.Errorf("failed to scan user list entry: %w", err)
+		}
+		entries = append(entries, e)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating user list entries: %w", err)
+	}
+
+	return entries, nil
+}
+
+// DeleteUserList removes a user list and, by cascade, its entries
+func (d *DB) DeleteUserList(ctx context.Context, id uuid.UUID) error {
+	result, err := d.pool.Exec(ctx, `DELETE FROM chatroach.bail_user_lists WHERE id = $1`, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete user list: %w", err)
+	}
+
+	if result.RowsAffected() == 0 {
+		return fmt.Errorf("user list not found: %s", id)
+	}
+
+	return nil
+}
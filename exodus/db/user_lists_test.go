@@ -0,0 +1,158 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"testing"
+)
+
+// entryIterator returns a CreateUserList iterator over entries
+func entryIterator(entries []*UserListEntry) func() (*UserListEntry, error) {
+	i := 0
+	return func() (*UserListEntry, error) {
+		if i >= len(entries) {
+			return nil, io.EOF
+		}
+		i++
+		return entries[i-1], nil
+	}
+}
+
+func TestCreateAndGetUserList(t *testing.T) {
+	pool := TestPool()
+	defer pool.Close()
+	Before(pool)
+
+	userID := SetupTestUser(t, pool)
+	db := &DB{pool: pool}
+	ctx := context.Background()
+
+	// More than one insert batch, with a duplicate straddling the boundary
+	var entries []*UserListEntry
+	for i := 0; i < userListInsertBatch+10; i++ {
+		entries = append(entries, &UserListEntry{UserID: fmt.Sprintf("u%d", i), PageID: "p1", Shortcode: "s1"})
+	}
+	entries = append(entries, &UserListEntry{UserID: "u0", PageID: "p1", Shortcode: "s1", Platform: "instagram"})
+
+	list := &UserList{UserID: userID, Name: "wave-2"}
+	if err := db.CreateUserList(ctx, list, entryIterator(entries)); err != nil {
+		t.Fatalf("CreateUserList failed: %v", err)
+	}
+
+	if list.RowCount != userListInsertBatch+10 {
+		t.Errorf("Expected %d rows, got %d", userListInsertBatch+10, list.RowCount)
+	}
+
+	retrieved, err := db.GetUserList(ctx, list.ID)
+	if err != nil {
+		t.Fatalf("GetUserList failed: %v", err)
+	}
+	if retrieved.Name != "wave-2" || retrieved.RowCount != list.RowCount {
+		t.Errorf("Unexpected user list: %+v", retrieved)
+	}
+
+	stored, err := db.GetUserListEntries(ctx, list.ID)
+	if err != nil {
+		t.Fatalf("GetUserListEntries failed: %v", err)
+	}
+	if len(stored) != list.RowCount {
+		t.Errorf("Expected %d entries, got %d", list.RowCount, len(stored))
+	}
+
+	lists, err := db.GetUserListsByUser(ctx, userID)
+	if err != nil {
+		t.Fatalf("GetUserListsByUser failed: %v", err)
+	}
+	if len(lists) != 1 {
+		t.Errorf("Expected 1 list, got %d", len(lists))
+	}
+}
+
+func TestCreateUserList_IteratorErrorStoresNothing(t *testing.T) {
+	pool := TestPool()
+	defer pool.Close()
+	Before(pool)
+
+	userID := SetupTestUser(t, pool)
+	db := &DB{pool: pool}
+	ctx := context.Background()
+
+	calls := 0
+	next := func() (*UserListEntry, error) {
+		calls++
+		if calls > 2 {
+			return nil, fmt.Errorf("bad csv")
+		}
+		return &UserListEntry{UserID: fmt.Sprintf("u%d", calls), PageID: "p1", Shortcode: "s1"}, nil
+	}
+
+	if err := db.CreateUserList(ctx, &UserList{UserID: userID, Name: "broken"}, next); err == nil {
+		t.Fatal("Expected CreateUserList to return the iterator error")
+	}
+
+	lists, err := db.GetUserListsByUser(ctx, userID)
+	if err != nil {
+		t.Fatalf("GetUserListsByUser failed: %v", err)
+	}
+	if len(lists) != 0 {
+		t.Errorf("Expected no list to be stored, got %d", len(lists))
+	}
+}
+
+func TestCheckUserList(t *testing.T) {
+	pool := TestPool()
+	defer pool.Close()
+	Before(pool)
+
+	userID := SetupTestUser(t, pool)
+	db := &DB{pool: pool}
+	ctx := context.Background()
+
+	MustExec(t, pool, `
+		INSERT INTO chatroach.surveys (id, userid, created, formid, form, shortcode, title)
+		VALUES (gen_random_uuid(), $1, now(), 'form-id', '{}', 'known', 'Test Survey')
+	`, userID)
+	MustExec(t, pool, `
+		INSERT INTO chatroach.states (userid, pageid, updated, current_state, state_json)
+		VALUES ('known-user', 'p1', now(), 'RESPONDING', '{}')
+	`)
+
+	entries := []*UserListEntry{
+		{UserID: "known-user", PageID: "p1", Shortcode: "known"},
+		{UserID: "known-user", PageID: "p2", Shortcode: "known"},
+		{UserID: "stranger", PageID: "p1", Shortcode: "unknown"},
+	}
+	list := &UserList{UserID: userID, Name: "checked"}
+	if err := db.CreateUserList(ctx, list, entryIterator(entries)); err != nil {
+		t.Fatalf("CreateUserList failed: %v", err)
+	}
+
+	check, err := db.CheckUserList(ctx, list.ID, userID, 10)
+	if err != nil {
+		t.Fatalf("CheckUserList failed: %v", err)
+	}
+
+	if check.UnknownUsersCount != 1 || check.UnknownUsers[0].UserID != "stranger" {
+		t.Errorf("Expected stranger to be an unknown user, got %+v", check.UnknownUsers)
+	}
+	if check.NotInStatesCount != 1 || check.NotInStates[0].PageID != "p2" {
+		t.Errorf("Expected known-user on p2 to be not in states, got %+v", check.NotInStates)
+	}
+	if len(check.UnknownShortcodes) != 1 || check.UnknownShortcodes[0] != "unknown" {
+		t.Errorf("Expected unknown shortcode 'unknown', got %v", check.UnknownShortcodes)
+	}
+
+	report := json.RawMessage(`{"total_rows": 3}`)
+	if err := db.SetUserListReport(ctx, list.ID, report); err != nil {
+		t.Fatalf("SetUserListReport failed: %v", err)
+	}
+
+	if err := db.DeleteUserList(ctx, list.ID); err != nil {
+		t.Fatalf("DeleteUserList failed: %v", err)
+	}
+	if stored, _ := db.GetUserListEntries(ctx, list.ID); len(stored) != 0 {
+		t.Errorf("Expected entries to be deleted with the list, got %d", len(stored))
+	}
+}
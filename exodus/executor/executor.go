@@ -19,6 +19,8 @@ type BailStore interface {
 	GetEnabledBails(ctx context.Context) ([]*db.Bail, error)
 	GetLastSuccessfulExecution(ctx context.Context, bailID uuid.UUID) (*time.Time, error)
 	RecordEvent(ctx context.Context, event *db.BailEvent) error
+	GetUserList(ctx context.Context, id uuid.UUID) (*db.UserList, error)
+	GetUserListEntries(ctx context.Context, listID uuid.UUID) ([]*db.UserListEntry, error)
 }
 
 // QueryExecutor defines the interface for executing SQL queries
@@ -197,6 +199,9 @@ func (e *Executor) queryUsers(ctx context.Context, dbBail *db.Bail, bailDef *typ
 		if bailDef.UserList == nil {
 			return nil, fmt.Errorf("user_list is nil for user_list-type bail")
 		}
+		if bailDef.UserList.ListID != nil {
+			return e.storedListTargets(ctx, dbBail, *bailDef.UserList.ListID)
+		}
 		log.Printf("Converting user_list to targets for bail %s", dbBail.Name)
 		return userListToTargets(bailDef.UserList), nil
 	}
@@ -216,6 +221,9 @@ func (e *Executor) queryUsers(ctx context.Context, dbBail *db.Bail, bailDef *typ
 		return nil, fmt.Errorf("failed to execute query: %w", err)
 	}
 
+	// BuildQuery already rejected bad templates, so this cannot fail here
+	refs, _ := bailDef.Action.MetadataRefs()
+
 	// Convert results to UserTarget structs with resolved destination form
 	var users []sender.UserTarget
 	for _, row := range rows {
@@ -231,16 +239,32 @@ func (e *Executor) queryUsers(ctx context.Context, dbBail *db.Bail, bailDef *typ
 			continue
 		}
 
-		users = append(users, sender.UserTarget{
+		target := sender.UserTarget{
 			UserID:          userID,
 			PageID:          pageID,
 			DestinationForm: bailDef.Action.DestinationForm,
-		})
+		}
+		if len(refs) > 0 {
+			target.Metadata = bailDef.Action.RenderMetadata(metadataValues(row, refs))
+		}
+		users = append(users, target)
 	}
 
 	return users, nil
 }
 
+// metadataValues picks the resolved template columns out of a query row.
+// NULL (no answer, no seed) is left out and renders as "".
+func metadataValues(row map[string]interface{}, refs []types.MetadataRef) map[string]string {
+	values := make(map[string]string, len(refs))
+	for _, ref := range refs {
+		if v, ok := row[ref.String()].(string); ok {
+			values[ref.String()] = v
+		}
+	}
+	return values
+}
+
 // userListToTargets converts a UserList to a slice of UserTarget structs
 // Each entry's shortcode becomes the destination form for that user
 func userListToTargets(ul *types.UserList) []sender.UserTarget {
@@ -255,6 +279,37 @@ func userListToTargets(ul *types.UserList) []sender.UserTarget {
 	return targets
 }
 
+// storedListTargets loads an uploaded user list and converts it to UserTarget
+// structs. The list must belong to the bail's owner: list IDs are not secret,
+// and a bail must not be able to reach another researcher's respondents.
+func (e *Executor) storedListTargets(ctx context.Context, dbBail *db.Bail, listID uuid.UUID) ([]sender.UserTarget, error) {
+	list, err := e.store.GetUserList(ctx, listID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load user list %s: %w", listID, err)
+	}
+	if list.UserID != dbBail.UserID {
+		return nil, fmt.Errorf("user list %s does not belong to the bail's owner", listID)
+	}
+
+	log.Printf("Loading stored user list %s (%d rows) for bail %s", listID, list.RowCount, dbBail.Name)
+
+	entries, err := e.store.GetUserListEntries(ctx, listID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load user list entries: %w", err)
+	}
+
+	targets := make([]sender.UserTarget, len(entries))
+	for i, entry := range entries {
+		targets[i] = sender.UserTarget{
+			UserID:          entry.UserID,
+			PageID:          entry.PageID,
+			DestinationForm: entry.Shortcode,
+			Platform:        entry.Platform,
+		}
+	}
+	return targets, nil
+}
+
 // recordSuccess records a successful bail execution event.
 // Returns an error if marshaling fails (corrupt snapshot would be worse than no record)
 // or if the DB write fails.
@@ -21,6 +21,8 @@ type mockBailStore struct {
 	getBailsError     error
 	getLastExecError  error
 	recordEventError  error
+	userLists         map[uuid.UUID]*db.UserList
+	userListEntries   map[uuid.UUID][]*db.UserListEntry
 }
 
 func (m *mockBailStore) GetEnabledBails(ctx context.Context) ([]*db.Bail, error) {
@@ -45,6 +47,18 @@ func (m *mockBailStore) RecordEvent(ctx context.Context, event *db.BailEvent) er
 	return nil
 }
 
+func (m *mockBailStore) GetUserList(ctx context.Context, id uuid.UUID) (*db.UserList, error) {
+	list, ok := m.userLists[id]
+	if !ok {
+		return nil, errors.New("user list not found")
+	}
+	return list, nil
+}
+
+func (m *mockBailStore) GetUserListEntries(ctx context.Context, listID uuid.UUID) ([]*db.UserListEntry, error) {
+	return m.userListEntries[listID], nil
+}
+
 type mockQueryExecutor struct {
 	results    []map[string]interface{}
 	queryError error
@@ -683,3 +697,156 @@ func TestExecutor_Run_UserListBail_WithLimit(t *testing.T) {
 		t.Errorf("Expected 2 users bailed (limit), got %d", event.UsersBailed)
 	}
 }
+
+// createTestStoredListBail creates a user_list bail that references an
+// uploaded list by ID, owned by ownerID
+func createTestStoredListBail(id, ownerID, listID uuid.UUID) *db.Bail {
+	def := map[string]interface{}{
+		"type":      "user_list",
+		"user_list": map[string]interface{}{"list_id": listID.String()},
+		"execution": map[string]interface{}{"timing": "immediate"},
+		"action":    map[string]interface{}{},
+	}
+	defJSON, _ := json.Marshal(def)
+
+	return &db.Bail{
+		ID:         id,
+		UserID:     ownerID,
+		Name:       "stored_list_bail",
+		Enabled:    true,
+		Definition: defJSON,
+		CreatedAt:  time.Now(),
+		UpdatedAt:  time.Now(),
+	}
+}
+
+func TestExecutor_Run_StoredUserListBail(t *testing.T) {
+	ownerID := uuid.New()
+	listID := uuid.New()
+	bail := createTestStoredListBail(uuid.New(), ownerID, listID)
+
+	store := &mockBailStore{
+		bails: []*db.Bail{bail},
+		userLists: map[uuid.UUID]*db.UserList{
+			listID: {ID: listID, UserID: ownerID, Name: "uploaded", RowCount: 2},
+		},
+		userListEntries: map[uuid.UUID][]*db.UserListEntry{
+			listID: {
+				{UserID: "user1", PageID: "page1", Shortcode: "form1"},
+				{UserID: "user2", PageID: "page2", Shortcode: "form2", Platform: "whatsapp"},
+			},
+		},
+	}
+	sender := &mockBailSender{}
+
+	err := New(store, &mockQueryExecutor{}, sender, 100).Run(context.Background())
+	if err != nil {
+		t.Errorf("Expected no error, got: %v", err)
+	}
+
+	if len(sender.sentBailouts) != 2 {
+		t.Fatalf("Expected 2 bailouts sent, got %d", len(sender.sentBailouts))
+	}
+	if sender.sentBailouts[1].DestinationForm != "form2" {
+		t.Errorf("Expected destination form 'form2', got '%s'", sender.sentBailouts[1].DestinationForm)
+	}
+	if sender.sentBailouts[0].Platform != "" || sender.sentBailouts[1].Platform != "whatsapp" {
+		t.Errorf("Expected platforms '' and 'whatsapp', got '%s' and '%s'",
+			sender.sentBailouts[0].Platform, sender.sentBailouts[1].Platform)
+	}
+	if len(store.recordedEvents) != 1 || store.recordedEvents[0].EventType != "execution" {
+		t.Errorf("Expected one execution event, got %+v", store.recordedEvents)
+	}
+}
+
+func TestExecutor_Run_StoredUserListBail_RejectsOtherOwnersList(t *testing.T) {
+	listID := uuid.New()
+	bail := createTestStoredListBail(uuid.New(), uuid.New(), listID)
+
+	store := &mockBailStore{
+		bails: []*db.Bail{bail},
+		userLists: map[uuid.UUID]*db.UserList{
+			listID: {ID: listID, UserID: uuid.New(), Name: "someone else's"},
+		},
+		userListEntries: map[uuid.UUID][]*db.UserListEntry{
+			listID: {{UserID: "user1", PageID: "page1", Shortcode: "form1"}},
+		},
+	}
+	sender := &mockBailSender{}
+
+	if err := New(store, &mockQueryExecutor{}, sender, 100).Run(context.Background()); err != nil {
+		t.Errorf("Expected no error from Run, got: %v", err)
+	}
+
+	if len(sender.sentBailouts) != 0 {
+		t.Errorf("Expected no bailouts for a list owned by another user, got %d", len(sender.sentBailouts))
+	}
+	if len(store.recordedEvents) != 1 || store.recordedEvents[0].EventType != "error" {
+		t.Fatalf("Expected one error event, got %+v", store.recordedEvents)
+	}
+}
+
+func TestExecutor_Run_TemplatedMetadata(t *testing.T) {
+	bailID := uuid.New()
+	bail := createTestBail(bailID, "templated_bail", "immediate", nil, nil, nil)
+
+	var def map[string]interface{}
+	json.Unmarshal(bail.Definition, &def)
+	def["action"].(map[string]interface{})["metadata"] = map[string]interface{}{
+		"name":   "{{response.q_name}}",
+		"seed":   "{{seed}}",
+		"reason": "followup",
+	}
+	bail.Definition, _ = json.Marshal(def)
+
+	store := &mockBailStore{bails: []*db.Bail{bail}}
+	query := &mockQueryExecutor{
+		results: []map[string]interface{}{
+			{"userid": "user1", "pageid": "page1", "response.q_name": "Ada", "seed": "42"},
+			{"userid": "user2", "pageid": "page1", "response.q_name": nil, "seed": "7"},
+		},
+	}
+	sender := &mockBailSender{}
+
+	executor := New(store, query, sender, 100)
+	if err := executor.Run(context.Background()); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	if len(sender.sentBailouts) != 2 {
+		t.Fatalf("Expected 2 bailouts sent, got %d", len(sender.sentBailouts))
+	}
+
+	first := sender.sentBailouts[0].Metadata
+	if first["name"] != "Ada" || first["seed"] != "42" || first["reason"] != "followup" {
+		t.Errorf("Unexpected metadata for user1: %v", first)
+	}
+
+	// A user with no answer gets an empty string, not the raw template
+	second := sender.sentBailouts[1].Metadata
+	if second["name"] != "" || second["seed"] != "7" {
+		t.Errorf("Unexpected metadata for user2: %v", second)
+	}
+}
+
+func TestExecutor_Run_StaticMetadataNotPerUser(t *testing.T) {
+	bailID := uuid.New()
+	bail := createTestBail(bailID, "static_bail", "immediate", nil, nil, nil)
+
+	store := &mockBailStore{bails: []*db.Bail{bail}}
+	query := &mockQueryExecutor{
+		results: []map[string]interface{}{
+			{"userid": "user1", "pageid": "page1"},
+		},
+	}
+	sender := &mockBailSender{}
+
+	executor := New(store, query, sender, 100)
+	if err := executor.Run(context.Background()); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	if sender.sentBailouts[0].Metadata != nil {
+		t.Errorf("Expected no per-user metadata without templates, got %v", sender.sentBailouts[0].Metadata)
+	}
+}
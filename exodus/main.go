@@ -5,6 +5,7 @@ import (
 	"flag"
 	"fmt"
 	"log"
+	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
@@ -15,11 +16,12 @@ import (
 	"github.com/vlab-research/exodus/config"
 	"github.com/vlab-research/exodus/db"
 	"github.com/vlab-research/exodus/executor"
+	"github.com/vlab-research/exodus/scheduler"
 	"github.com/vlab-research/exodus/sender"
 )
 
 func main() {
-	mode := flag.String("mode", "executor", "Mode: api or executor")
+	mode := flag.String("mode", "executor", "Mode: api, executor or scheduler")
 	flag.Parse()
 
 	cfg, err := config.Load()
@@ -42,8 +44,10 @@ func main() {
 		runAPI(cfg, database)
 	case "executor":
 		runExecutor(cfg, database)
+	case "scheduler":
+		runScheduler(cfg, database)
 	default:
-		log.Fatalf("Invalid mode: %s (must be 'api' or 'executor')", *mode)
+		log.Fatalf("Invalid mode: %s (must be 'api', 'executor' or 'scheduler')", *mode)
 	}
 }
 
@@ -99,3 +103,57 @@ func runExecutor(cfg *config.Config, database *db.DB) {
 
 	log.Println("Executor completed successfully")
 }
+
+func runScheduler(cfg *config.Config, database *db.DB) {
+	snd := sender.New(cfg.BotserverURL, cfg.RateLimit, cfg.DryRun)
+	exec := executor.New(database, database, snd, cfg.MaxBailUsers)
+
+	// db.DB implements LeaseStore as well
+	sched := scheduler.New(database, exec, scheduler.Config{
+		LeaseName: cfg.LeaseName,
+		Holder:    cfg.LeaseHolder,
+		Interval:  cfg.TickInterval,
+		LeaseTTL:  cfg.LeaseTTL,
+	})
+
+	if cfg.DryRun {
+		log.Println("DRY RUN MODE - no bailouts will be sent")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Liveness is unconditional; readiness means "this replica holds the
+	// lease", so standbys stay alive but unready.
+	mux := http.NewServeMux()
+	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	mux.HandleFunc("/ready", func(w http.ResponseWriter, r *http.Request) {
+		if sched.Ready() {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+
+	probes := &http.Server{
+		Addr:              fmt.Sprintf(":%d", cfg.Port),
+		Handler:           mux,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+	go func() {
+		log.Printf("Serving scheduler probes on %s", probes.Addr)
+		if err := probes.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatalf("Probe server failed: %v", err)
+		}
+	}()
+
+	sched.Start(ctx)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := probes.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Probe server shutdown error: %v", err)
+	}
+}
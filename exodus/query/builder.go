@@ -31,7 +31,9 @@ func NewQueryBuilder() *QueryBuilder {
 }
 
 // BuildQuery generates SQL query and parameters from a BailDefinition
-// Returns the complete SQL query string, parameters slice, and any error
+// Returns the complete SQL query string, parameters slice, and any error.
+// Each metadata template ref in the action adds a text column named by
+// MetadataRef.String(), NULL when the user has no value.
 func BuildQuery(def *types.BailDefinition) (string, []interface{}, error) {
 	builder := NewQueryBuilder()
 
@@ -41,6 +43,16 @@ func BuildQuery(def *types.BailDefinition) (string, []interface{}, error) {
 		return "", nil, fmt.Errorf("failed to build conditions: %w", err)
 	}
 
+	// Resolve per-user metadata templates into extra columns
+	refs, err := def.Action.MetadataRefs()
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to parse metadata templates: %w", err)
+	}
+	var metadataColumns []string
+	for _, ref := range refs {
+		metadataColumns = append(metadataColumns, builder.buildMetadataColumn(ref))
+	}
+
 	// Assemble the complete query
 	var query strings.Builder
 
@@ -52,7 +64,12 @@ func BuildQuery(def *types.BailDefinition) (string, []interface{}, error) {
 	}
 
 	// Main SELECT statement
-	query.WriteString("SELECT DISTINCT s.userid, s.pageid\nFROM states s")
+	query.WriteString("SELECT DISTINCT s.userid, s.pageid")
+	for _, col := range metadataColumns {
+		query.WriteString(", ")
+		query.WriteString(col)
+	}
+	query.WriteString("\nFROM states s")
 
 	// Add CTE joins if any
 	if len(builder.cteJoins) > 0 {
@@ -254,6 +271,55 @@ func (qb *QueryBuilder) buildSurveyIDCondition(cond *types.SimpleCondition) (str
 	return fmt.Sprintf("s.current_form IN (SELECT shortcode FROM surveys WHERE id = $%d)", paramNum), nil
 }
 
+// buildMetadataColumn returns the select expression that resolves one
+// metadata template ref for each user. Response refs get a CTE holding each
+// user's latest answer to the question, LEFT JOINed so users who never
+// answered still match the conditions. Like every other responses CTE it is
+// scoped to a form: the ref's own, or else the user's current form, which the
+// JOIN matches against since the CTE cannot see states.
+func (qb *QueryBuilder) buildMetadataColumn(ref types.MetadataRef) string {
+	var expr string
+	switch ref.Kind {
+	case "seed":
+		expr = "s.state_json->'md'->>'seed'"
+	case "current_form":
+		expr = "s.current_form"
+	case "response":
+		cteName := fmt.Sprintf("metadata_responses_%d", qb.cteIndex)
+		alias := fmt.Sprintf("mr%d", qb.cteIndex)
+		qb.cteIndex++
+
+		var cte, join string
+		if ref.Form != "" {
+			formParam := qb.addParam(ref.Form)
+			questionParam := qb.addParam(ref.QuestionRef)
+			cte = fmt.Sprintf(`%s AS (
+    SELECT DISTINCT ON (userid) userid, response
+    FROM responses
+    WHERE shortcode = $%d AND question_ref = $%d
+    ORDER BY userid, timestamp DESC
+)`, cteName, formParam, questionParam)
+			join = fmt.Sprintf("LEFT JOIN %s %s ON s.userid = %s.userid", cteName, alias, alias)
+		} else {
+			questionParam := qb.addParam(ref.QuestionRef)
+			cte = fmt.Sprintf(`%s AS (
+    SELECT DISTINCT ON (userid, shortcode) userid, shortcode, response
+    FROM responses
+    WHERE question_ref = $%d
+    ORDER BY userid, shortcode, timestamp DESC
+)`, cteName, questionParam)
+			join = fmt.Sprintf("LEFT JOIN %s %s ON s.userid = %s.userid AND s.current_form = %s.shortcode",
+				cteName, alias, alias, alias)
+		}
+
+		qb.ctes = append(qb.ctes, cte)
+		qb.cteJoins = append(qb.cteJoins, join)
+		expr = alias + ".response"
+	}
+
+	return fmt.Sprintf(`(%s)::STRING AS "%s"`, expr, ref.String())
+}
+
 // buildLogicalOperator handles AND/OR/NOT operations recursively
 func (qb *QueryBuilder) buildLogicalOperator(op *types.LogicalOperator) (string, error) {
 	if op.Op == "not" {
@@ -854,3 +854,134 @@ func TestBuildQuery_SurveyIDInsideAnd(t *testing.T) {
 		t.Errorf("Expected params[1]='550e8400-e29b-41d4-a716-446655440000', got %v", params[1])
 	}
 }
+
+func TestBuildQuery_MetadataTemplates(t *testing.T) {
+	def := &types.BailDefinition{
+		Conditions: conditionFromJSON(`{"type": "form", "value": "myform"}`),
+		Execution:  types.Execution{Timing: "immediate"},
+		Action: types.Action{
+			DestinationForm: "exit-form",
+			Metadata: map[string]interface{}{
+				"name":   "{{response.q_name}}",
+				"seed":   "{{seed}}",
+				"origin": "{{current_form}}",
+				"static": "unchanged",
+			},
+		},
+	}
+
+	sql, params, err := BuildQuery(def)
+	if err != nil {
+		t.Fatalf("BuildQuery failed: %v", err)
+	}
+
+	// Columns are added in sorted ref order, after userid and pageid
+	wantSelect := `SELECT DISTINCT s.userid, s.pageid, (s.current_form)::STRING AS "current_form", (mr0.response)::STRING AS "response.q_name", (s.state_json->'md'->>'seed')::STRING AS "seed"`
+	if !strings.Contains(sql, wantSelect) {
+		t.Errorf("SQL missing metadata columns, got: %s", sql)
+	}
+	if !strings.Contains(sql, "metadata_responses_0 AS (") {
+		t.Errorf("SQL missing metadata response CTE, got: %s", sql)
+	}
+	if !strings.Contains(sql, "SELECT DISTINCT ON (userid, shortcode) userid, shortcode, response") {
+		t.Errorf("SQL should select each user's latest response per form, got: %s", sql)
+	}
+	if !strings.Contains(sql, "LEFT JOIN metadata_responses_0 mr0 ON s.userid = mr0.userid AND s.current_form = mr0.shortcode") {
+		t.Errorf("SQL should join the metadata CTE on the user's current form, got: %s", sql)
+	}
+
+	// Verify parameters: $1=myform (condition), $2=q_name (metadata)
+	if len(params) != 2 {
+		t.Fatalf("Expected 2 parameters, got %d", len(params))
+	}
+	if params[0] != "myform" || params[1] != "q_name" {
+		t.Errorf("Unexpected parameters: %v", params)
+	}
+	if !strings.Contains(sql, "WHERE question_ref = $2") {
+		t.Errorf("Metadata CTE should use $2, got: %s", sql)
+	}
+}
+
+func TestBuildQuery_MetadataTemplateInANamedForm(t *testing.T) {
+	def := &types.BailDefinition{
+		Conditions: conditionFromJSON(`{"type": "form", "value": "myform"}`),
+		Execution:  types.Execution{Timing: "immediate"},
+		Action: types.Action{
+			DestinationForm: "exit-form",
+			Metadata:        map[string]interface{}{"age": "{{response.intake.q_age}}"},
+		},
+	}
+
+	sql, params, err := BuildQuery(def)
+	if err != nil {
+		t.Fatalf("BuildQuery failed: %v", err)
+	}
+
+	// $1=myform (condition), $2=intake, $3=q_age (metadata)
+	if len(params) != 3 || params[1] != "intake" || params[2] != "q_age" {
+		t.Fatalf("Unexpected parameters: %v", params)
+	}
+	for _, want := range []string{
+		"SELECT DISTINCT ON (userid) userid, response",
+		"WHERE shortcode = $2 AND question_ref = $3",
+		"LEFT JOIN metadata_responses_0 mr0 ON s.userid = mr0.userid\nWHERE",
+		`(mr0.response)::STRING AS "response.intake.q_age"`,
+	} {
+		if !strings.Contains(sql, want) {
+			t.Errorf("SQL missing %q, got: %s", want, sql)
+		}
+	}
+}
+
+func TestBuildQuery_MetadataTemplatesWithConditionCTEs(t *testing.T) {
+	def := &types.BailDefinition{
+		Conditions: conditionFromJSON(`{"type": "question_response", "form": "f", "question_ref": "q1"}`),
+		Execution:  types.Execution{Timing: "immediate"},
+		Action: types.Action{
+			DestinationForm: "exit-form",
+			Metadata:        map[string]interface{}{"a": "{{response.q1}}", "b": "{{response.q2}}"},
+		},
+	}
+
+	sql, params, err := BuildQuery(def)
+	if err != nil {
+		t.Fatalf("BuildQuery failed: %v", err)
+	}
+
+	// CTE names keep counting after the condition CTEs so they never collide
+	for _, want := range []string{
+		"question_responses_0 AS (",
+		"metadata_responses_1 AS (",
+		"metadata_responses_2 AS (",
+		`(mr1.response)::STRING AS "response.q1"`,
+		`(mr2.response)::STRING AS "response.q2"`,
+	} {
+		if !strings.Contains(sql, want) {
+			t.Errorf("SQL missing %q, got: %s", want, sql)
+		}
+	}
+
+	// $1=f, $2=q1 (condition), $3=q1, $4=q2 (metadata)
+	if len(params) != 4 {
+		t.Errorf("Expected 4 parameters, got %d: %v", len(params), params)
+	}
+}
+
+func TestBuildQuery_NoMetadataTemplates(t *testing.T) {
+	def := &types.BailDefinition{
+		Conditions: conditionFromJSON(`{"type": "form", "value": "myform"}`),
+		Execution:  types.Execution{Timing: "immediate"},
+		Action: types.Action{
+			DestinationForm: "exit-form",
+			Metadata:        map[string]interface{}{"reason": "static"},
+		},
+	}
+
+	sql, _, err := BuildQuery(def)
+	if err != nil {
+		t.Fatalf("BuildQuery failed: %v", err)
+	}
+	if !strings.Contains(sql, "SELECT DISTINCT s.userid, s.pageid\nFROM states s") {
+		t.Errorf("Static metadata should not add columns, got: %s", sql)
+	}
+}
@@ -292,3 +292,70 @@ func TestIntegration_QuestionResponse_NoMatch(t *testing.T) {
 		t.Errorf("expected no matches, got: %v", matched)
 	}
 }
+
+func TestIntegration_MetadataTemplates(t *testing.T) {
+	pool := integrationPool(t)
+	defer pool.Close()
+	resetTablesForQuery(t, pool)
+
+	surveyID := insertSurvey(t, pool, "intake-form")
+
+	// userA answered q_name twice (latest wins), userB only in another form,
+	// whose q_name is a different question
+	userA, userB := "user-md-a", "user-md-b"
+	insertState(t, pool, userA, "intake-form")
+	insertState(t, pool, userB, "intake-form")
+	insertResponse(t, pool, surveyID, userA, "intake-form", "q_name", "Ad")
+	time.Sleep(10 * time.Millisecond)
+	insertResponse(t, pool, surveyID, userA, "intake-form", "q_name", "Ada")
+	insertResponse(t, pool, surveyID, userB, "other-form", "q_name", "Not a name")
+
+	def := &types.BailDefinition{
+		Conditions: conditionFromJSON(`{"type": "form", "value": "intake-form"}`),
+		Execution:  types.Execution{Timing: "immediate"},
+		Action: types.Action{
+			DestinationForm: "exit-form",
+			Metadata: map[string]interface{}{
+				"name":   "{{response.q_name}}",
+				"origin": "{{current_form}}",
+			},
+		},
+	}
+
+	sql, params, err := BuildQuery(def)
+	if err != nil {
+		t.Fatalf("BuildQuery: %v", err)
+	}
+
+	rows, err := pool.Query(context.Background(), sql, params...)
+	if err != nil {
+		t.Fatalf("query: %v\nSQL:\n%s", err, sql)
+	}
+	defer rows.Close()
+
+	names := map[string]*string{}
+	for rows.Next() {
+		var userid, pageid string
+		var origin, name *string
+		if err := rows.Scan(&userid, &pageid, &origin, &name); err != nil {
+			t.Fatalf("scan: %v", err)
+		}
+		if origin == nil || *origin != "intake-form" {
+			t.Errorf("expected current_form 'intake-form' for %s, got %v", userid, origin)
+		}
+		names[userid] = name
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatalf("rows: %v", err)
+	}
+
+	if len(names) != 2 {
+		t.Fatalf("expected both users to match exactly once, got %d rows", len(names))
+	}
+	if names[userA] == nil || *names[userA] != "Ada" {
+		t.Errorf("expected userA's latest answer 'Ada', got %v", names[userA])
+	}
+	if names[userB] != nil {
+		t.Errorf("expected NULL for userB who never answered in their form, got %v", *names[userB])
+	}
+}
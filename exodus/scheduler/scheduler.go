@@ -0,0 +1,183 @@
+package scheduler
+
+import (
+	"context"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// LeaseStore defines the database operations needed for leader election
+type LeaseStore interface {
+	AcquireLease(ctx context.Context, name, holder string, ttl time.Duration) (bool, error)
+	ReleaseLease(ctx context.Context, name, holder string) error
+}
+
+// Runner is one pass over all enabled bails. executor.Executor satisfies it.
+type Runner interface {
+	Run(ctx context.Context) error
+}
+
+// Config controls tick and lease timing
+type Config struct {
+	LeaseName string        // Row in exodus_leases shared by all replicas
+	Holder    string        // Identity of this replica (pod name)
+	Interval  time.Duration // Time between executor runs on the leader
+	LeaseTTL  time.Duration // How long a lease survives without renewal
+}
+
+// Scheduler runs the executor on a fixed interval for as long as this replica
+// holds the lease, so that `immediate` bails fire every tick rather than every
+// CronJob invocation, and overlapping replicas never double-fire.
+//
+// Two loops share the process. The lease loop renews every LeaseTTL/3, which
+// leaves two renewals' worth of slack before the lease can lapse. The tick
+// loop runs the executor only while the lease is held. A run can outlast a
+// tick (the sender rate-limits every bailout), so losing the lease cancels the
+// run in progress: the new leader will pick the remaining users up on its own
+// next tick, and a stale leader that kept sending would be exactly the double
+// fire this exists to prevent.
+type Scheduler struct {
+	leases LeaseStore
+	runner Runner
+	cfg    Config
+
+	leader atomic.Bool
+
+	mu        sync.Mutex
+	cancelRun context.CancelFunc
+}
+
+// New creates a new Scheduler instance
+func New(leases LeaseStore, runner Runner, cfg Config) *Scheduler {
+	return &Scheduler{
+		leases: leases,
+		runner: runner,
+		cfg:    cfg,
+	}
+}
+
+// Ready reports whether this replica currently holds the lease. It backs the
+// readiness probe, so exactly one replica reports ready in steady state.
+func (s *Scheduler) Ready() bool {
+	return s.leader.Load()
+}
+
+// Start blocks until ctx is cancelled, then releases the lease if held so a
+// standby replica can take over without waiting out the TTL.
+func (s *Scheduler) Start(ctx context.Context) {
+	log.Printf("Starting exodus scheduler as %s (interval %s, lease ttl %s)",
+		s.cfg.Holder, s.cfg.Interval, s.cfg.LeaseTTL)
+
+	// Contend for the lease before the first tick, so a replica that can
+	// lead does not sit out a whole interval first.
+	s.renew(ctx)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		s.keepLease(ctx)
+	}()
+
+	s.tick(ctx)
+	ticker := time.NewTicker(s.cfg.Interval)
+	defer ticker.Stop()
+
+loop:
+	for {
+		select {
+		case <-ctx.Done():
+			break loop
+		case <-ticker.C:
+			s.tick(ctx)
+		}
+	}
+
+	wg.Wait()
+
+	if s.leader.Swap(false) {
+		// The parent context is already done, so release on a fresh one.
+		releaseCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := s.leases.ReleaseLease(releaseCtx, s.cfg.LeaseName, s.cfg.Holder); err != nil {
+			log.Printf("Warning: failed to release lease on shutdown: %v", err)
+		}
+	}
+
+	log.Println("Scheduler stopped")
+}
+
+// keepLease renews the lease until ctx is cancelled
+func (s *Scheduler) keepLease(ctx context.Context) {
+	ticker := time.NewTicker(s.cfg.LeaseTTL / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.renew(ctx)
+		}
+	}
+}
+
+// renew makes one attempt to take or keep the lease
+func (s *Scheduler) renew(ctx context.Context) {
+	held, err := s.leases.AcquireLease(ctx, s.cfg.LeaseName, s.cfg.Holder, s.cfg.LeaseTTL)
+	if err != nil {
+		if ctx.Err() != nil {
+			return
+		}
+		// We cannot prove we still hold the lease, so act as if we do not. A
+		// database blip costs one missed tick; assuming leadership through it
+		// risks two leaders.
+		log.Printf("Failed to renew lease %s: %v", s.cfg.LeaseName, err)
+		held = false
+	}
+
+	was := s.leader.Swap(held)
+	switch {
+	case held && !was:
+		log.Printf("Acquired lease %s; this replica is now the leader", s.cfg.LeaseName)
+	case !held && was:
+		log.Printf("Lost lease %s; cancelling any run in progress", s.cfg.LeaseName)
+		s.cancelCurrentRun()
+	}
+}
+
+// tick runs the executor once if this replica is the leader
+func (s *Scheduler) tick(ctx context.Context) {
+	if !s.leader.Load() {
+		return
+	}
+
+	runCtx, cancel := context.WithCancel(ctx)
+	s.mu.Lock()
+	s.cancelRun = cancel
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		s.cancelRun = nil
+		s.mu.Unlock()
+		cancel()
+	}()
+
+	// Run reloads enabled bails itself, so edits made through the API take
+	// effect on the next tick without a restart.
+	if err := s.runner.Run(runCtx); err != nil {
+		log.Printf("Executor run failed: %v", err)
+	}
+}
+
+// cancelCurrentRun stops an in-flight run, if any
+func (s *Scheduler) cancelCurrentRun() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cancelRun != nil {
+		s.cancelRun()
+	}
+}
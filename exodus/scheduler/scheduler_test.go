@@ -0,0 +1,177 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Mock implementations for testing
+
+type mockLeaseStore struct {
+	mu       sync.Mutex
+	held     bool
+	err      error
+	acquires int
+	released bool
+}
+
+func (m *mockLeaseStore) AcquireLease(ctx context.Context, name, holder string, ttl time.Duration) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.acquires++
+	return m.held, m.err
+}
+
+func (m *mockLeaseStore) ReleaseLease(ctx context.Context, name, holder string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.released = true
+	return nil
+}
+
+func (m *mockLeaseStore) set(held bool, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.held = held
+	m.err = err
+}
+
+type mockRunner struct {
+	runs  int32
+	block bool // block until ctx is cancelled
+}
+
+func (m *mockRunner) Run(ctx context.Context) error {
+	atomic.AddInt32(&m.runs, 1)
+	if m.block {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	return nil
+}
+
+func testConfig() Config {
+	return Config{
+		LeaseName: "exodus-executor",
+		Holder:    "pod-a",
+		Interval:  10 * time.Millisecond,
+		LeaseTTL:  30 * time.Millisecond,
+	}
+}
+
+// startScheduler runs s in the background and returns a stop function that
+// cancels it and waits for Start to return.
+func startScheduler(s *Scheduler) func() {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		s.Start(ctx)
+		close(done)
+	}()
+	return func() {
+		cancel()
+		<-done
+	}
+}
+
+func TestScheduler_RunsEveryTickWhileLeader(t *testing.T) {
+	leases := &mockLeaseStore{held: true}
+	runner := &mockRunner{}
+	s := New(leases, runner, testConfig())
+
+	stop := startScheduler(s)
+	time.Sleep(55 * time.Millisecond)
+	stop()
+
+	if runs := atomic.LoadInt32(&runner.runs); runs < 3 {
+		t.Errorf("Expected several runs while leader, got %d", runs)
+	}
+	if !leases.released {
+		t.Error("Expected the lease to be released on shutdown")
+	}
+}
+
+func TestScheduler_DoesNotRunWithoutLease(t *testing.T) {
+	leases := &mockLeaseStore{held: false}
+	runner := &mockRunner{}
+	s := New(leases, runner, testConfig())
+
+	stop := startScheduler(s)
+	time.Sleep(40 * time.Millisecond)
+	if s.Ready() {
+		t.Error("Expected a replica without the lease to report not ready")
+	}
+	stop()
+
+	if runs := atomic.LoadInt32(&runner.runs); runs != 0 {
+		t.Errorf("Expected no runs without the lease, got %d", runs)
+	}
+	if leases.released {
+		t.Error("Expected a non-leader not to release the lease")
+	}
+}
+
+func TestScheduler_ReadyTracksLease(t *testing.T) {
+	leases := &mockLeaseStore{held: true}
+	s := New(leases, &mockRunner{}, testConfig())
+
+	stop := startScheduler(s)
+	defer stop()
+
+	time.Sleep(5 * time.Millisecond)
+	if !s.Ready() {
+		t.Fatal("Expected leader to report ready")
+	}
+
+	leases.set(false, nil)
+	time.Sleep(30 * time.Millisecond)
+	if s.Ready() {
+		t.Error("Expected replica to report not ready after losing the lease")
+	}
+}
+
+func TestScheduler_RenewalErrorDropsLeadership(t *testing.T) {
+	leases := &mockLeaseStore{held: true}
+	s := New(leases, &mockRunner{}, testConfig())
+
+	stop := startScheduler(s)
+	defer stop()
+
+	time.Sleep(5 * time.Millisecond)
+	leases.set(true, errors.New("connection refused"))
+	time.Sleep(30 * time.Millisecond)
+
+	if s.Ready() {
+		t.Error("Expected a failed renewal to be treated as a lost lease")
+	}
+}
+
+func TestScheduler_LosingLeaseCancelsRun(t *testing.T) {
+	leases := &mockLeaseStore{held: true}
+	runner := &mockRunner{block: true}
+	s := New(leases, runner, testConfig())
+
+	stop := startScheduler(s)
+	defer stop()
+
+	time.Sleep(5 * time.Millisecond)
+	leases.set(false, nil)
+
+	// The blocked run only returns once its context is cancelled, so a second
+	// run starting is proof the first was cut short -- and the second must
+	// not start, because we are no longer leader.
+	time.Sleep(40 * time.Millisecond)
+	if runs := atomic.LoadInt32(&runner.runs); runs != 1 {
+		t.Errorf("Expected exactly one (cancelled) run, got %d", runs)
+	}
+	s.mu.Lock()
+	inFlight := s.cancelRun != nil
+	s.mu.Unlock()
+	if inFlight {
+		t.Error("Expected the in-flight run to be cancelled when the lease was lost")
+	}
+}
@@ -15,6 +15,11 @@ type BailoutEvent struct {
 	User  string       `json:"user"`
 	Page  string       `json:"page"`
 	Event *EventDetail `json:"event"`
+
+	// Platform is the conversation's messaging platform ("messenger" |
+	// "whatsapp") when the caller knows it. Replybot reads it as a hint for
+	// synthetic events; omitted, it falls back to the persisted state.
+	Platform string `json:"platform,omitempty"`
 }
 
 // EventDetail contains the event type and value
@@ -42,6 +47,11 @@ type UserTarget struct {
 	UserID          string
 	PageID          string
 	DestinationForm string // always set by caller; resolved before passing to sender
+	Platform        string // optional; only stored user lists carry it
+
+	// Metadata is this user's rendered metadata. When nil, the bail-wide
+	// metadata passed to SendBailouts is sent instead.
+	Metadata map[string]interface{}
 }
 
 // New creates a new Sender instance
@@ -56,21 +66,27 @@ func New(botserverURL string, rateLimit time.Duration, dryRun bool) *Sender {
 
 // SendBailout sends a single bailout event
 func (s *Sender) SendBailout(ctx context.Context, userID, pageID, destinationForm string, metadata map[string]interface{}) error {
+	return s.send(ctx, UserTarget{UserID: userID, PageID: pageID, DestinationForm: destinationForm}, metadata)
+}
+
+// send posts the bailout event for a single target
+func (s *Sender) send(ctx context.Context, user UserTarget, metadata map[string]interface{}) error {
 	event := &BailoutEvent{
-		User: userID,
-		Page: pageID,
+		User: user.UserID,
+		Page: user.PageID,
 		Event: &EventDetail{
 			Type: "bailout",
 			Value: &BailValue{
-				Form:     destinationForm,
+				Form:     user.DestinationForm,
 				Metadata: metadata,
 			},
 		},
+		Platform: user.Platform,
 	}
 
 	if s.dryRun {
 		log.Printf("[DRY RUN] Would bail user=%s page=%s to form=%s with metadata=%v",
-			userID, pageID, destinationForm, metadata)
+			user.UserID, user.PageID, user.DestinationForm, metadata)
 		return nil
 	}
 
@@ -99,7 +115,7 @@ func (s *Sender) SendBailout(ctx context.Context, userID, pageID, destinationFor
 		return fmt.Errorf("botserver returned non-200 status: %d", resp.StatusCode)
 	}
 
-	log.Printf("Successfully bailed user=%s page=%s to form=%s", userID, pageID, destinationForm)
+	log.Printf("Successfully bailed user=%s page=%s to form=%s", user.UserID, user.PageID, user.DestinationForm)
 	return nil
 }
 
@@ -117,8 +133,13 @@ func (s *Sender) SendBailouts(ctx context.Context, users []UserTarget, metadata
 		default:
 		}
 
+		userMetadata := metadata
+		if user.Metadata != nil {
+			userMetadata = user.Metadata
+		}
+
 		// Send bailout for this user using their destination form
-		err := s.SendBailout(ctx, user.UserID, user.PageID, user.DestinationForm, metadata)
+		err := s.send(ctx, user, userMetadata)
 		if err != nil {
 			log.Printf("Failed to bail user=%s page=%s: %v", user.UserID, user.PageID, err)
 			lastError = err
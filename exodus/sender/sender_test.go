@@ -318,3 +318,63 @@ func TestSendBailout_NilMetadata(t *testing.T) {
 		t.Errorf("Expected nil or empty metadata, got %v", receivedEvent.Event.Value.Metadata)
 	}
 }
+
+func TestSendBailouts_PerUserMetadata(t *testing.T) {
+	received := make(map[string]map[string]interface{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event BailoutEvent
+		body, _ := io.ReadAll(r.Body)
+		json.Unmarshal(body, &event)
+		received[event.User] = event.Event.Value.Metadata
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	sender := New(server.URL, 0, false)
+	users := []UserTarget{
+		{UserID: "user1", PageID: "page1", DestinationForm: "exit-form", Metadata: map[string]interface{}{"name": "Ada"}},
+		{UserID: "user2", PageID: "page1", DestinationForm: "exit-form"},
+	}
+
+	_, err := sender.SendBailouts(context.Background(), users, map[string]interface{}{"name": "shared"})
+	if err != nil {
+		t.Fatalf("SendBailouts failed: %v", err)
+	}
+
+	if received["user1"]["name"] != "Ada" {
+		t.Errorf("Expected user1 to get its own metadata, got %v", received["user1"])
+	}
+	if received["user2"]["name"] != "shared" {
+		t.Errorf("Expected user2 to fall back to the shared metadata, got %v", received["user2"])
+	}
+}
+
+func TestSendBailouts_CarriesPlatform(t *testing.T) {
+	received := make(map[string]map[string]interface{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event map[string]interface{}
+		body, _ := io.ReadAll(r.Body)
+		json.Unmarshal(body, &event)
+		received[event["user"].(string)] = event
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	sender := New(server.URL, 0, false)
+	users := []UserTarget{
+		{UserID: "user1", PageID: "page1", DestinationForm: "exit-form", Platform: "whatsapp"},
+		{UserID: "user2", PageID: "page1", DestinationForm: "exit-form"},
+	}
+
+	_, err := sender.SendBailouts(context.Background(), users, nil)
+	if err != nil {
+		t.Fatalf("SendBailouts failed: %v", err)
+	}
+
+	if received["user1"]["platform"] != "whatsapp" {
+		t.Errorf("Expected user1's platform to be sent, got %v", received["user1"]["platform"])
+	}
+	if _, ok := received["user2"]["platform"]; ok {
+		t.Errorf("Expected no platform field for user2, got %v", received["user2"]["platform"])
+	}
+}
@@ -0,0 +1,148 @@
+package types
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// metadataTemplatePattern matches a {{...}} placeholder in a metadata string
+var metadataTemplatePattern = regexp.MustCompile(`\{\{\s*([^{}]*?)\s*\}\}`)
+
+// questionRefPattern restricts response.<question_ref> (and the form in
+// response.<form>.<question_ref>) to the characters Typeform refs and
+// shortcodes use, so neither can carry SQL or template syntax
+var questionRefPattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// MetadataRef is a per-user value referenced from action metadata:
+//
+//	{{response.<question_ref>}}         the user's latest answer to that question in their current form
+//	{{response.<form>.<question_ref>}}  the user's latest answer to that question in that form
+//	{{seed}}                            the user's seed
+//	{{current_form}}                    the shortcode of the user's current form
+//
+// Question refs are only unique within a form, so a response is always
+// read from one form's answers.
+type MetadataRef struct {
+	Kind        string // "response", "seed" or "current_form"
+	Form        string // only for "response"; "" is the user's current form
+	QuestionRef string // only for "response"
+}
+
+// String returns the ref as written inside {{ }}. It doubles as the column
+// name the query stage resolves the ref into.
+func (r MetadataRef) String() string {
+	if r.Kind == "response" && r.Form != "" {
+		return "response." + r.Form + "." + r.QuestionRef
+	}
+	if r.Kind == "response" {
+		return "response." + r.QuestionRef
+	}
+	return r.Kind
+}
+
+// parseMetadataRef parses the inside of a {{ }} placeholder
+func parseMetadataRef(expr string) (MetadataRef, error) {
+	switch {
+	case expr == "seed", expr == "current_form":
+		return MetadataRef{Kind: expr}, nil
+	case strings.HasPrefix(expr, "response."):
+		form, ref := "", strings.TrimPrefix(expr, "response.")
+		if i := strings.Index(ref, "."); i >= 0 {
+			form, ref = ref[:i], ref[i+1:]
+			if !questionRefPattern.MatchString(form) {
+				return MetadataRef{}, fmt.Errorf("invalid form in {{%s}}", expr)
+			}
+		}
+		if !questionRefPattern.MatchString(ref) {
+			return MetadataRef{}, fmt.Errorf("invalid question ref in {{%s}}", expr)
+		}
+		return MetadataRef{Kind: "response", Form: form, QuestionRef: ref}, nil
+	}
+	return MetadataRef{}, fmt.Errorf("unknown metadata template {{%s}} (must be response.<question_ref>, response.<form>.<question_ref>, seed or current_form)", expr)
+}
+
+// MetadataRefs returns the distinct per-user refs used in the metadata,
+// sorted so the generated query is stable. String values are searched at any
+// depth; keys are never templated.
+func (a *Action) MetadataRefs() ([]MetadataRef, error) {
+	seen := make(map[MetadataRef]bool)
+	if err := collectMetadataRefs(a.Metadata, seen); err != nil {
+		return nil, err
+	}
+
+	refs := make([]MetadataRef, 0, len(seen))
+	for ref := range seen {
+		refs = append(refs, ref)
+	}
+	sort.Slice(refs, func(i, j int) bool {
+		return refs[i].String() < refs[j].String()
+	})
+	return refs, nil
+}
+
+func collectMetadataRefs(value interface{}, seen map[MetadataRef]bool) error {
+	switch v := value.(type) {
+	case string:
+		for _, m := range metadataTemplatePattern.FindAllStringSubmatch(v, -1) {
+			ref, err := parseMetadataRef(m[1])
+			if err != nil {
+				return err
+			}
+			seen[ref] = true
+		}
+	case map[string]interface{}:
+		for _, item := range v {
+			if err := collectMetadataRefs(item, seen); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		for _, item := range v {
+			if err := collectMetadataRefs(item, seen); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// RenderMetadata returns a copy of the metadata with every placeholder
+// replaced by the user's value, keyed by MetadataRef.String(). A ref with no
+// value (the user never answered, or has no seed) renders as "".
+func (a *Action) RenderMetadata(values map[string]string) map[string]interface{} {
+	if a.Metadata == nil {
+		return nil
+	}
+	return renderMetadataValue(a.Metadata, values).(map[string]interface{})
+}
+
+func renderMetadataValue(value interface{}, values map[string]string) interface{} {
+	switch v := value.(type) {
+	case string:
+		return metadataTemplatePattern.ReplaceAllStringFunc(v, func(placeholder string) string {
+			expr := metadataTemplatePattern.FindStringSubmatch(placeholder)[1]
+			ref, err := parseMetadataRef(expr)
+			if err != nil {
+				// Validate rejects these, so this only happens for a
+				// definition that bypassed it; leave the text alone.
+				return placeholder
+			}
+			return values[ref.String()]
+		})
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			out[k] = renderMetadataValue(item, values)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, item := range v {
+			out[i] = renderMetadataValue(item, values)
+		}
+		return out
+	}
+	return value
+}
@@ -0,0 +1,159 @@
+package types
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMetadataRefs(t *testing.T) {
+	tests := []struct {
+		name     string
+		metadata map[string]interface{}
+		want     []MetadataRef
+		wantErr  bool
+	}{
+		{
+			name:     "no templates",
+			metadata: map[string]interface{}{"reason": "timeout", "count": 3},
+			want:     []MetadataRef{},
+		},
+		{
+			name: "all kinds, sorted and deduplicated",
+			metadata: map[string]interface{}{
+				"name":   "{{response.q_name}}",
+				"greet":  "Hi {{ response.q_name }}!",
+				"seed":   "{{seed}}",
+				"origin": "{{current_form}}",
+			},
+			want: []MetadataRef{
+				{Kind: "current_form"},
+				{Kind: "response", QuestionRef: "q_name"},
+				{Kind: "seed"},
+			},
+		},
+		{
+			name: "nested values",
+			metadata: map[string]interface{}{
+				"user": map[string]interface{}{"answers": []interface{}{"{{response.age}}"}},
+			},
+			want: []MetadataRef{{Kind: "response", QuestionRef: "age"}},
+		},
+		{
+			name:     "response in a named form",
+			metadata: map[string]interface{}{"x": "{{response.intake.age}}", "y": "{{response.age}}"},
+			want: []MetadataRef{
+				{Kind: "response", QuestionRef: "age"},
+				{Kind: "response", Form: "intake", QuestionRef: "age"},
+			},
+		},
+		{
+			name:     "invalid form",
+			metadata: map[string]interface{}{"x": "{{response.a'b.age}}"},
+			wantErr:  true,
+		},
+		{
+			name:     "empty question ref after a form",
+			metadata: map[string]interface{}{"x": "{{response.intake.}}"},
+			wantErr:  true,
+		},
+		{
+			name:     "unknown template",
+			metadata: map[string]interface{}{"x": "{{email}}"},
+			wantErr:  true,
+		},
+		{
+			name:     "invalid question ref",
+			metadata: map[string]interface{}{"x": "{{response.a'b}}"},
+			wantErr:  true,
+		},
+		{
+			name:     "empty response ref",
+			metadata: map[string]interface{}{"x": "{{response.}}"},
+			wantErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &Action{DestinationForm: "exit", Metadata: tt.metadata}
+			got, err := a.MetadataRefs()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("MetadataRefs() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("MetadataRefs() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRenderMetadata(t *testing.T) {
+	a := &Action{
+		DestinationForm: "exit",
+		Metadata: map[string]interface{}{
+			"name":   "{{response.q_name}}",
+			"greet":  "Hi {{response.q_name}}, seed {{seed}}",
+			"nested": map[string]interface{}{"form": "{{current_form}}"},
+			"list":   []interface{}{"{{seed}}", 7},
+			"static": "unchanged",
+			"count":  3.0,
+		},
+	}
+
+	got := a.RenderMetadata(map[string]string{
+		"response.q_name": "Ada",
+		"seed":            "42",
+	})
+
+	want := map[string]interface{}{
+		"name":   "Ada",
+		"greet":  "Hi Ada, seed 42",
+		"nested": map[string]interface{}{"form": ""},
+		"list":   []interface{}{"42", 7},
+		"static": "unchanged",
+		"count":  3.0,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("RenderMetadata() = %v, want %v", got, want)
+	}
+
+	// The template itself must not be modified
+	if a.Metadata["name"] != "{{response.q_name}}" {
+		t.Error("Expected RenderMetadata to leave the action's metadata untouched")
+	}
+}
+
+func TestBailDefinitionValidation_MetadataTemplates(t *testing.T) {
+	cond := Condition{}
+	cond.UnmarshalJSON([]byte(`{"type":"form","value":"f"}`))
+
+	valid := BailDefinition{
+		Conditions: &cond,
+		Execution:  Execution{Timing: "immediate"},
+		Action: Action{
+			DestinationForm: "exit",
+			Metadata:        map[string]interface{}{"name": "{{response.q_name}}"},
+		},
+	}
+	if err := valid.Validate(); err != nil {
+		t.Errorf("Expected templated metadata on a conditions bail to be valid, got %v", err)
+	}
+
+	invalid := valid
+	invalid.Action.Metadata = map[string]interface{}{"name": "{{nope}}"}
+	if err := invalid.Validate(); err == nil {
+		t.Error("Expected an unknown template to be rejected")
+	}
+
+	userList := BailDefinition{
+		Type:      "user_list",
+		UserList:  &UserList{Users: []UserListEntry{{UserID: "u", PageID: "p", Shortcode: "s"}}},
+		Execution: Execution{Timing: "immediate"},
+		Action: Action{
+			Metadata: map[string]interface{}{"seed": "{{seed}}"},
+		},
+	}
+	if err := userList.Validate(); err == nil {
+		t.Error("Expected metadata templates on a user_list bail to be rejected")
+	}
+}
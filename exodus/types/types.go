@@ -52,6 +52,16 @@ func (bd *BailDefinition) Validate() error {
 		if err := bd.Action.Validate(); err != nil {
 			return fmt.Errorf("invalid action: %w", err)
 		}
+	} else {
+		// Templates are resolved in the conditions query, which user_list
+		// bails never run
+		refs, err := bd.Action.MetadataRefs()
+		if err != nil {
+			return fmt.Errorf("invalid action: %w", err)
+		}
+		if len(refs) > 0 {
+			return fmt.Errorf("invalid action: metadata templates are only supported for conditions-type bails")
+		}
 	}
 	return nil
 }
@@ -94,7 +104,9 @@ func (e *Execution) Validate() error {
 	return nil
 }
 
-// Action defines what happens when a bail is triggered
+// Action defines what happens when a bail is triggered.
+// Metadata string values may contain {{...}} templates, resolved per user
+// (see MetadataRef).
 type Action struct {
 	DestinationForm string                 `json:"destination_form"`
 	Metadata        map[string]interface{} `json:"metadata,omitempty"`
@@ -105,6 +117,9 @@ func (a *Action) Validate() error {
 	if a.DestinationForm == "" {
 		return fmt.Errorf("destination_form is required")
 	}
+	if _, err := a.MetadataRefs(); err != nil {
+		return fmt.Errorf("invalid metadata: %w", err)
+	}
 	return nil
 }
 
@@ -115,13 +130,25 @@ type UserListEntry struct {
 	Shortcode string `json:"shortcode"` // per-user destination form
 }
 
-// UserList represents a list of users for user_list-type bails
+// UserList represents a list of users for user_list-type bails.
+// The users are either embedded (Users, up to 1000) or stored separately by a
+// CSV upload and referenced by ListID -- never both.
 type UserList struct {
-	Users []UserListEntry `json:"users"`
+	ListID *uuid.UUID      `json:"list_id,omitempty"`
+	Users  []UserListEntry `json:"users,omitempty"`
 }
 
 // Validate checks if the UserList is valid
 func (ul *UserList) Validate() error {
+	if ul.ListID != nil {
+		if *ul.ListID == uuid.Nil {
+			return fmt.Errorf("list_id must be a valid UUID")
+		}
+		if len(ul.Users) > 0 {
+			return fmt.Errorf("user_list cannot have both list_id and users")
+		}
+		return nil
+	}
 	if len(ul.Users) == 0 {
 		return fmt.Errorf("user_list must contain at least one user")
 	}
@@ -142,6 +169,45 @@ func (ul *UserList) Validate() error {
 	return nil
 }
 
+// UserListIssue describes one problem row found while validating an uploaded list
+type UserListIssue struct {
+	Line      int    `json:"line,omitempty"` // 1-based CSV line, when known
+	UserID    string `json:"userid,omitempty"`
+	PageID    string `json:"pageid,omitempty"`
+	Shortcode string `json:"shortcode,omitempty"`
+	Message   string `json:"message,omitempty"`
+}
+
+// UserListIssues is a count plus a capped sample of the rows behind it
+type UserListIssues struct {
+	Count    int             `json:"count"`
+	Examples []UserListIssue `json:"examples"`
+}
+
+// UserListReport is the validation report returned when a user list is
+// uploaded. Only invalid rows are excluded from the stored list (and duplicates
+// collapsed to their first occurrence); the rest are reported so the
+// researcher can decide whether the list is fit to bail.
+type UserListReport struct {
+	TotalRows         int            `json:"total_rows"`
+	StoredRows        int            `json:"stored_rows"`
+	InvalidRows       UserListIssues `json:"invalid_rows"`
+	DuplicateRows     UserListIssues `json:"duplicate_rows"`
+	UnknownUsers      UserListIssues `json:"unknown_users"`  // userid never seen in states
+	NotInStates       UserListIssues `json:"not_in_states"`  // userid has no state on the given pageid
+	UnknownShortcodes []string       `json:"unknown_shortcodes"`
+}
+
+// StoredUserList is an uploaded user list as returned by the API
+type StoredUserList struct {
+	ID        uuid.UUID       `json:"id"`
+	UserID    uuid.UUID       `json:"user_id"`
+	Name      string          `json:"name"`
+	RowCount  int             `json:"row_count"`
+	Report    *UserListReport `json:"report,omitempty"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
 // Condition represents a union type that can be a simple condition or a logical operation
 type Condition struct {
 	simple   *SimpleCondition
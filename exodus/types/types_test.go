@@ -721,6 +721,28 @@ func TestUserListValidation(t *testing.T) {
 			wantErr: true,
 			errMsg:  "shortcode is required at index 2",
 		},
+		{
+			name:    "valid stored list reference",
+			ul:      UserList{ListID: uuidPtr(uuid.New())},
+			wantErr: false,
+		},
+		{
+			name:    "nil list_id",
+			ul:      UserList{ListID: uuidPtr(uuid.Nil)},
+			wantErr: true,
+			errMsg:  "list_id must be a valid UUID",
+		},
+		{
+			name: "list_id and embedded users together",
+			ul: UserList{
+				ListID: uuidPtr(uuid.New()),
+				Users: []UserListEntry{
+					{UserID: "user1", PageID: "page1", Shortcode: "form1"},
+				},
+			},
+			wantErr: true,
+			errMsg:  "cannot have both list_id and users",
+		},
 	}
 
 	for _, tt := range tests {
@@ -1021,3 +1043,7 @@ func TestBailValidation_UserListType(t *testing.T) {
 		})
 	}
 }
+
+func uuidPtr(id uuid.UUID) *uuid.UUID {
+	return &id
+}
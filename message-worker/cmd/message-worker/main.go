@@ -63,8 +63,17 @@ func main() {
 	defer cancel()
 
 	// Initialize TokenStore
+	var keys messageworker.KeyUnwrapper
+	if config.CredentialsKeyring != "" {
+		keyring, err := messageworker.LoadKeyring(config.CredentialsKeyring)
+		if err != nil {
+			logger.Fatal("failed to load credentials keyring", zap.Error(err))
+		}
+		keys = keyring
+	}
+
 	var tokenStore messageworker.TokenStore
-	tokenStore, err = messageworker.NewPostgresTokenStore(ctx, config.DatabaseURL, config.TokenCacheTTL)
+	tokenStore, err = messageworker.NewPostgresTokenStore(ctx, config.DatabaseURL, config.TokenCacheTTL, keys)
 	if err != nil {
 		logger.Fatal("failed to create token store", zap.Error(err))
 	}
@@ -113,12 +122,15 @@ func main() {
 	clients[types.PlatformWhatsApp] = whatsappClient
 	logger.Info("registered WhatsApp client", zap.String("url", config.WhatsAppGraphURL))
 
-	// Create stub clients for platforms not yet implemented
-	clients[types.PlatformInstagram] = messageworker.NewInstagramClient()
-	logger.Info("registered Instagram client (stub)")
+	// Create Instagram client (real Graph API HTTP client)
+	instagramClient := messageworker.NewInstagramClient(config.InstagramGraphURL, tokenStore)
+	clients[types.PlatformInstagram] = instagramClient
+	logger.Info("registered Instagram client", zap.String("url", config.InstagramGraphURL))
 
-	clients[types.PlatformTelegram] = messageworker.NewTelegramClient()
-	logger.Info("registered Telegram client (stub)")
+	// Create Telegram client (real Bot API HTTP client)
+	telegramClient := messageworker.NewTelegramClient(config.TelegramAPIURL, tokenStore)
+	clients[types.PlatformTelegram] = telegramClient
+	logger.Info("registered Telegram client", zap.String("url", config.TelegramAPIURL))
 
 	logger.Info("platform clients initialized", zap.Int("platforms", len(clients)))
 
@@ -141,6 +153,9 @@ func main() {
 		zap.Bool("media_handle_use", config.MediaHandleUse),
 		zap.Duration("media_handle_margin", config.MediaHandleMargin))
 
+	worker = worker.WithLifecycleEvents(config.LifecycleEvents)
+	logger.Info("lifecycle events configured", zap.String("mode", string(config.LifecycleEvents)))
+
 	// Create Burrow pool for concurrent processing.
 	burrowConfig := burrow.DefaultConfig(logger)
 	burrowConfig.NumWorkers = config.NumWorkers
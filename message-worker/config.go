@@ -24,9 +24,15 @@ type Config struct {
 	DatabaseURL   string
 	TokenCacheTTL time.Duration
 
+	// Key file for tokens sealed by dinersclub's seal-credentials. Unset,
+	// tokens must be plaintext.
+	CredentialsKeyring string
+
 	// Platform API base URLs
-	FacebookGraphURL string // For Messenger/Instagram (e.g., "https://graph.facebook.com/v18.0" or "http://gbv-facebot")
-	WhatsAppGraphURL string // WhatsApp Cloud API base (e.g., "https://graph.facebook.com/v18.0" or a mock)
+	FacebookGraphURL  string // For Messenger/Instagram (e.g., "https://graph.facebook.com/v18.0" or "http://gbv-facebot")
+	WhatsAppGraphURL  string // WhatsApp Cloud API base (e.g., "https://graph.facebook.com/v18.0" or a mock)
+	InstagramGraphURL string // Instagram messaging base (e.g., "https://graph.facebook.com/v18.0" or a mock)
+	TelegramAPIURL    string // Telegram Bot API base (e.g., "https://api.telegram.org" or a mock)
 
 	// Legacy config (kept for backwards compatibility but not used)
 	MessengerURL    string
@@ -48,6 +54,10 @@ type Config struct {
 	// this ships dark; see §8.5 for the staged rollout.
 	MediaHandleUse    bool
 	MediaHandleMargin time.Duration
+
+	// Lifecycle events (message_sent, message_failed, handoff_completed).
+	// Off by default; see LifecycleMode.
+	LifecycleEvents LifecycleMode
 }
 
 // LoadConfigFromEnv loads configuration from environment variables
@@ -67,10 +77,17 @@ func LoadConfigFromEnv() (*Config, error) {
 		DatabaseURL:   os.Getenv("DATABASE_URL"),
 		TokenCacheTTL: time.Duration(getEnvAsInt("TOKEN_CACHE_TTL", 300)) * time.Second,
 
+		CredentialsKeyring: os.Getenv("CREDENTIALS_KEYRING"),
+
 		// Facebook Graph API URL (for Messenger/Instagram)
 		FacebookGraphURL: getEnvOrDefault("FACEBOOK_GRAPH_URL", "https://graph.facebook.com/v18.0"),
 		// WhatsApp Cloud API URL (defaults to the Graph API; overridden to a mock in tests)
 		WhatsAppGraphURL: getEnvOrDefault("WHATSAPP_GRAPH_URL", "https://graph.facebook.com/v18.0"),
+		// Instagram messaging URL (likewise; graph.instagram.com for accounts
+		// connected with Instagram Login)
+		InstagramGraphURL: getEnvOrDefault("INSTAGRAM_GRAPH_URL", "https://graph.facebook.com/v18.0"),
+		// Telegram Bot API URL (the bot token is appended per request)
+		TelegramAPIURL: getEnvOrDefault("TELEGRAM_API_URL", "https://api.telegram.org"),
 
 		// Legacy config (kept for backwards compatibility)
 		MessengerURL:    os.Getenv("MESSENGER_URL"),
@@ -93,6 +110,14 @@ func LoadConfigFromEnv() (*Config, error) {
 		MediaHandleMargin: getEnvAsDuration("MEDIA_HANDLE_MARGIN", time.Hour),
 	}
 
+	// Lifecycle events -- DEFAULT OFF. "both" also emits the legacy
+	// UniversalEvent shape while its consumers migrate (see lifecycle.go).
+	lifecycle, err := ParseLifecycleMode(os.Getenv("LIFECYCLE_EVENTS"))
+	if err != nil {
+		return nil, err
+	}
+	config.LifecycleEvents = lifecycle
+
 	// Validate required config
 	if config.DatabaseURL == "" {
 		return nil, fmt.Errorf("DATABASE_URL is required for token lookup")
@@ -0,0 +1,174 @@
+package messageworker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/vlab-research/fly/message-worker/types"
+)
+
+// InstagramClient sends messages to Instagram professional accounts' DMs via
+// the Graph API's Instagram messaging. It mirrors MessengerClient: the send
+// is POST /me/messages with the account's token, /me resolving to the
+// account the token belongs to, and the body is the Messenger shape --
+// recipient, message with text, quick_replies or an attachment.
+//
+// The token is the instagram_account credential keyed by the Instagram
+// account id (see platformToEntity in tokenstore.go).
+type InstagramClient struct {
+	baseURL    string
+	tokenStore TokenStore
+	httpClient *http.Client
+}
+
+func NewInstagramClient(baseURL string, tokenStore TokenStore) *InstagramClient {
+	return &InstagramClient{
+		baseURL:    baseURL,
+		tokenStore: tokenStore,
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// InstagramSendRequest is the send envelope. Recipient id is the
+// Instagram-scoped user id (IGSID) from the webhook.
+type InstagramSendRequest struct {
+	Recipient FacebookRecipient      `json:"recipient"`
+	Message   types.InstagramMessage `json:"message"`
+}
+
+func (c *InstagramClient) SendMessage(ctx context.Context, platformAccountID, userID string, message interface{}, platformContext json.RawMessage) (*SendMessageResponse, error) {
+	igMsg, ok := message.(types.InstagramMessage)
+	if !ok {
+		return nil, fmt.Errorf("instagram client expected types.InstagramMessage, got %T", message)
+	}
+
+	req := InstagramSendRequest{
+		Recipient: FacebookRecipient{ID: userID},
+		Message:   igMsg,
+	}
+
+	body, err := c.post(ctx, platformAccountID, "/me/messages", req)
+	if err != nil {
+		return nil, err
+	}
+
+	var igResp FacebookSendResponse
+	if err := json.Unmarshal(body, &igResp); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+	if igResp.Error != nil {
+		return nil, parseInstagramError(igResp.Error)
+	}
+	return &SendMessageResponse{MessageID: igResp.MessageID, Success: true}, nil
+}
+
+// PassThreadControl hands the conversation to another app. Instagram
+// messaging supports the same handover protocol as Messenger.
+func (c *InstagramClient) PassThreadControl(ctx context.Context, userID, platformAccountID, targetAppID, metadata string) error {
+	req := map[string]interface{}{
+		"recipient":     map[string]string{"id": userID},
+		"target_app_id": targetAppID,
+		"metadata":      metadata,
+	}
+
+	body, err := c.post(ctx, platformAccountID, "/me/pass_thread_control", req)
+	if err != nil {
+		return err
+	}
+
+	var igResp FacebookSendResponse
+	if err := json.Unmarshal(body, &igResp); err != nil {
+		return fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+	if igResp.Error != nil {
+		return parseInstagramError(igResp.Error)
+	}
+	return nil
+}
+
+// post sends payload to path with the account's token and returns the body
+// of a successful response. Every failure is a *PlatformError except one
+// building the request.
+func (c *InstagramClient) post(ctx context.Context, platformAccountID, path string, payload interface{}) ([]byte, error) {
+	token, err := c.tokenStore.GetToken(ctx, string(types.PlatformInstagram), platformAccountID)
+	if err != nil {
+		return nil, &PlatformError{
+			StatusCode: 0,
+			Message:    fmt.Sprintf("failed to get token: %v", err),
+			Retriable:  false,
+		}
+	}
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", c.baseURL+path, bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := c.httpClient.Do(httpReq)
+	if err != nil {
+		return nil, &PlatformError{
+			StatusCode: 0,
+			Message:    fmt.Sprintf("HTTP request failed: %v", err),
+			Retriable:  true,
+		}
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, &PlatformError{
+			StatusCode: resp.StatusCode,
+			Message:    fmt.Sprintf("failed to read response body: %v", err),
+			Retriable:  true,
+		}
+	}
+
+	if resp.StatusCode >= 400 {
+		var igResp FacebookSendResponse
+		if err := json.Unmarshal(body, &igResp); err == nil && igResp.Error != nil {
+			return nil, parseInstagramError(igResp.Error)
+		}
+		return nil, &PlatformError{
+			StatusCode: resp.StatusCode,
+			Message:    string(body),
+			Retriable:  isRetriableHTTPStatus(resp.StatusCode),
+		}
+	}
+	return body, nil
+}
+
+func parseInstagramError(igErr *FacebookError) *PlatformError {
+	return &PlatformError{
+		StatusCode: igErr.Code,
+		Message:    igErr.Message,
+		Retriable:  isRetriableInstagramError(igErr.Code),
+	}
+}
+
+// isRetriableInstagramError reports whether a Graph API error code is worth
+// retrying: the temporary and throttling codes. Everything else -- an
+// invalid token (190), missing permission (10, 200), a recipient outside
+// the messaging window or unknown (100 and its subcodes) -- fails the same
+// way every time.
+func isRetriableInstagramError(code int) bool {
+	switch code {
+	case 1, 2: // unknown / temporary service error
+		return true
+	case 4, 17, 32, 613: // app, user, page and call-rate limits
+		return true
+	default:
+		return isRetriableFacebookError(code)
+	}
+}
@@ -0,0 +1,264 @@
+package messageworker
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/vlab-research/fly/message-worker/types"
+)
+
+const igOKResponse = `{"recipient_id":"IGSID_1","message_id":"mid.ig.test"}`
+
+// platformTokenStore records which platform each token was asked for.
+type platformTokenStore struct {
+	platforms []string
+	accounts  []string
+}
+
+func (s *platformTokenStore) GetToken(ctx context.Context, platform, platformAccountID string) (string, error) {
+	s.platforms = append(s.platforms, platform)
+	s.accounts = append(s.accounts, platformAccountID)
+	return "ig-token", nil
+}
+
+func (s *platformTokenStore) Close() {}
+
+func decodeIGRequest(t *testing.T, body []byte) (recipient string, message map[string]interface{}) {
+	t.Helper()
+	var out struct {
+		Recipient struct {
+			ID string `json:"id"`
+		} `json:"recipient"`
+		Message map[string]interface{} `json:"message"`
+	}
+	if err := json.Unmarshal(body, &out); err != nil {
+		t.Fatalf("bad request body: %v", err)
+	}
+	return out.Recipient.ID, out.Message
+}
+
+func TestInstagramClient_SendMessage_Text(t *testing.T) {
+	server := newCapturingWAServer(http.StatusOK, igOKResponse)
+	defer server.Close()
+
+	tokens := &platformTokenStore{}
+	client := NewInstagramClient(server.server.URL, tokens)
+	msg := types.InstagramMessage{Text: "Hello from the bot"}
+
+	resp, err := client.SendMessage(context.Background(), "IG_ACCOUNT_1", "IGSID_1", msg, nil)
+	if err != nil {
+		t.Fatalf("SendMessage failed: %v", err)
+	}
+	if !resp.Success || resp.MessageID != "mid.ig.test" {
+		t.Errorf("resp = %+v, want Success=true MessageID=mid.ig.test", resp)
+	}
+
+	if len(tokens.platforms) != 1 || tokens.platforms[0] != "instagram" || tokens.accounts[0] != "IG_ACCOUNT_1" {
+		t.Errorf("token lookups = %v %v, want instagram IG_ACCOUNT_1", tokens.platforms, tokens.accounts)
+	}
+	if len(server.paths) != 1 {
+		t.Fatalf("expected 1 request, got %d", len(server.paths))
+	}
+	if server.paths[0] != "/me/messages" {
+		t.Errorf("path = %s, want /me/messages", server.paths[0])
+	}
+	if server.auth[0] != "Bearer ig-token" {
+		t.Errorf("auth = %s, want Bearer ig-token", server.auth[0])
+	}
+
+	recipient, message := decodeIGRequest(t, server.bodies[0])
+	if recipient != "IGSID_1" {
+		t.Errorf("recipient.id = %s, want IGSID_1", recipient)
+	}
+	if message["text"] != "Hello from the bot" {
+		t.Errorf("message.text = %v, want Hello from the bot", message["text"])
+	}
+	if _, ok := message["attachment"]; ok {
+		t.Errorf("text message should carry no attachment: %v", message)
+	}
+}
+
+func TestInstagramClient_SendMessage_QuickReplies(t *testing.T) {
+	server := newCapturingWAServer(http.StatusOK, igOKResponse)
+	defer server.Close()
+
+	client := NewInstagramClient(server.server.URL, NewStaticTokenStore("test-token"))
+	msg := types.InstagramMessage{
+		Text: "Pick one",
+		QuickReplies: []types.QuickReply{
+			{ContentType: "text", Title: "Yes", Payload: "yes"},
+			{ContentType: "text", Title: "No", Payload: "no"},
+		},
+	}
+
+	if _, err := client.SendMessage(context.Background(), "IG_ACCOUNT_1", "IGSID_1", msg, nil); err != nil {
+		t.Fatalf("SendMessage failed: %v", err)
+	}
+
+	_, message := decodeIGRequest(t, server.bodies[0])
+	qrs, _ := message["quick_replies"].([]interface{})
+	if len(qrs) != 2 {
+		t.Fatalf("quick_replies = %v, want 2", message["quick_replies"])
+	}
+	first, _ := qrs[0].(map[string]interface{})
+	if first["title"] != "Yes" || first["payload"] != "yes" || first["content_type"] != "text" {
+		t.Errorf("quick_replies[0] = %v, want text Yes/yes", first)
+	}
+}
+
+func TestInstagramClient_SendMessage_Media(t *testing.T) {
+	server := newCapturingWAServer(http.StatusOK, igOKResponse)
+	defer server.Close()
+
+	client := NewInstagramClient(server.server.URL, NewStaticTokenStore("test-token"))
+	msg := types.InstagramMessage{
+		Attachment: &types.Attachment{
+			Type:    "image",
+			Payload: types.AttachmentPayload{URL: "https://example.com/cat.png"},
+		},
+	}
+
+	if _, err := client.SendMessage(context.Background(), "IG_ACCOUNT_1", "IGSID_1", msg, nil); err != nil {
+		t.Fatalf("SendMessage failed: %v", err)
+	}
+
+	_, message := decodeIGRequest(t, server.bodies[0])
+	att, _ := message["attachment"].(map[string]interface{})
+	if att == nil || att["type"] != "image" {
+		t.Fatalf("attachment = %v, want type image", message["attachment"])
+	}
+	payload, _ := att["payload"].(map[string]interface{})
+	if payload["url"] != "https://example.com/cat.png" {
+		t.Errorf("attachment.payload.url = %v, want https://example.com/cat.png", payload["url"])
+	}
+	if _, ok := message["text"]; ok {
+		t.Errorf("media message should carry no text: %v", message)
+	}
+}
+
+func TestInstagramClient_SendMessage_WrongMessageType(t *testing.T) {
+	server := newCapturingWAServer(http.StatusOK, igOKResponse)
+	defer server.Close()
+
+	client := NewInstagramClient(server.server.URL, NewStaticTokenStore("test-token"))
+	// A Messenger message is not an Instagram one, even though they look alike.
+	_, err := client.SendMessage(context.Background(), "IG_ACCOUNT_1", "IGSID_1", types.MessengerMessage{Text: "hi"}, nil)
+	if err == nil {
+		t.Fatal("expected error for wrong message type, got nil")
+	}
+	if len(server.paths) != 0 {
+		t.Errorf("should not have sent a request, got %d", len(server.paths))
+	}
+}
+
+func TestInstagramClient_SendMessage_MissingToken(t *testing.T) {
+	server := newCapturingWAServer(http.StatusOK, igOKResponse)
+	defer server.Close()
+
+	client := NewInstagramClient(server.server.URL, NewStaticTokenStore(""))
+	_, err := client.SendMessage(context.Background(), "IG_ACCOUNT_1", "IGSID_1", types.InstagramMessage{Text: "hi"}, nil)
+
+	pe, ok := err.(*PlatformError)
+	if !ok {
+		t.Fatalf("expected *PlatformError, got %T (%v)", err, err)
+	}
+	if pe.Retriable {
+		t.Error("a missing token should not be retriable")
+	}
+	if len(server.paths) != 0 {
+		t.Errorf("should not have sent a request, got %d", len(server.paths))
+	}
+}
+
+func TestInstagramClient_SendMessage_Errors(t *testing.T) {
+	tests := []struct {
+		name          string
+		status        int
+		body          string
+		wantCode      int
+		wantRetriable bool
+	}{
+		{"invalid token", http.StatusUnauthorized, `{"error":{"message":"Invalid OAuth access token","type":"OAuthException","code":190}}`, 190, false},
+		{"missing permission", http.StatusForbidden, `{"error":{"message":"Permissions error","code":10}}`, 10, false},
+		{"outside messaging window", http.StatusBadRequest, `{"error":{"message":"This message is sent outside of allowed window","code":10,"error_subcode":2534022}}`, 10, false},
+		{"invalid recipient", http.StatusBadRequest, `{"error":{"message":"No matching user found","code":100,"error_subcode":2534014}}`, 100, false},
+		{"rate limited", http.StatusBadRequest, `{"error":{"message":"Calls to this api have exceeded the rate limit","code":613}}`, 613, true},
+		{"app throttled", http.StatusForbidden, `{"error":{"message":"Application request limit reached","code":4}}`, 4, true},
+		{"temporary", http.StatusInternalServerError, `{"error":{"message":"An unexpected error has occurred","code":2}}`, 2, true},
+		{"gateway without graph body", http.StatusBadGateway, `<html>bad gateway</html>`, http.StatusBadGateway, true},
+		{"too many requests without graph body", http.StatusTooManyRequests, `slow down`, http.StatusTooManyRequests, true},
+		{"bad request without graph body", http.StatusBadRequest, `nope`, http.StatusBadRequest, false},
+		{"error in a 200 body", http.StatusOK, `{"error":{"message":"Temporary send failure","code":1200}}`, 1200, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newCapturingWAServer(tt.status, tt.body)
+			defer server.Close()
+
+			client := NewInstagramClient(server.server.URL, NewStaticTokenStore("test-token"))
+			_, err := client.SendMessage(context.Background(), "IG_ACCOUNT_1", "IGSID_1", types.InstagramMessage{Text: "hi"}, nil)
+
+			pe, ok := err.(*PlatformError)
+			if !ok {
+				t.Fatalf("expected *PlatformError, got %T (%v)", err, err)
+			}
+			if pe.StatusCode != tt.wantCode {
+				t.Errorf("StatusCode = %d, want %d", pe.StatusCode, tt.wantCode)
+			}
+			if pe.Retriable != tt.wantRetriable {
+				t.Errorf("Retriable = %v, want %v", pe.Retriable, tt.wantRetriable)
+			}
+		})
+	}
+}
+
+func TestInstagramClient_SendMessage_Unreachable(t *testing.T) {
+	server := newCapturingWAServer(http.StatusOK, igOKResponse)
+	url := server.server.URL
+	server.Close()
+
+	client := NewInstagramClient(url, NewStaticTokenStore("test-token"))
+	_, err := client.SendMessage(context.Background(), "IG_ACCOUNT_1", "IGSID_1", types.InstagramMessage{Text: "hi"}, nil)
+
+	pe, ok := err.(*PlatformError)
+	if !ok {
+		t.Fatalf("expected *PlatformError, got %T (%v)", err, err)
+	}
+	if !pe.Retriable {
+		t.Error("a connection failure should be retriable")
+	}
+}
+
+func TestInstagramClient_PassThreadControl(t *testing.T) {
+	server := newCapturingWAServer(http.StatusOK, `{"success":true}`)
+	defer server.Close()
+
+	client := NewInstagramClient(server.server.URL, NewStaticTokenStore("test-token"))
+	if err := client.PassThreadControl(context.Background(), "IGSID_1", "IG_ACCOUNT_1", "263902037430900", `{"reason":"handoff"}`); err != nil {
+		t.Fatalf("PassThreadControl failed: %v", err)
+	}
+
+	if len(server.paths) != 1 || server.paths[0] != "/me/pass_thread_control" {
+		t.Fatalf("paths = %v, want [/me/pass_thread_control]", server.paths)
+	}
+	var out map[string]interface{}
+	if err := json.Unmarshal(server.bodies[0], &out); err != nil {
+		t.Fatalf("bad request body: %v", err)
+	}
+	recipient, _ := out["recipient"].(map[string]interface{})
+	if recipient["id"] != "IGSID_1" {
+		t.Errorf("recipient = %v, want IGSID_1", out["recipient"])
+	}
+	if out["target_app_id"] != "263902037430900" || out["metadata"] != `{"reason":"handoff"}` {
+		t.Errorf("body = %v", out)
+	}
+}
+
+func TestPlatformToEntity_Instagram(t *testing.T) {
+	if got := platformToEntity[string(types.PlatformInstagram)]; got != "instagram_account" {
+		t.Errorf("platformToEntity[instagram] = %q, want instagram_account", got)
+	}
+}
@@ -0,0 +1,183 @@
+package messageworker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/vlab-research/fly/message-worker/types"
+	"go.uber.org/zap"
+)
+
+// LifecycleMode selects which lifecycle events (message_sent, message_failed,
+// handoff_completed) the worker publishes, and in which shape.
+//
+//	off  -> none (the default, and the behaviour before these events existed)
+//	v1   -> types.LifecycleEvent only
+//	both -> types.LifecycleEvent and the legacy types.UniversalEvent, for
+//	        consumers still reading the old shape during the migration
+//
+// A handoff has no legacy event of its own; in the legacy shape it is a
+// message_sent with no platform message id, as it always would have been.
+type LifecycleMode string
+
+const (
+	LifecycleOff  LifecycleMode = "off"
+	LifecycleV1   LifecycleMode = "v1"
+	LifecycleBoth LifecycleMode = "both"
+)
+
+// ParseLifecycleMode reads LIFECYCLE_EVENTS. "" is off.
+func ParseLifecycleMode(s string) (LifecycleMode, error) {
+	switch LifecycleMode(s) {
+	case "", LifecycleOff:
+		return LifecycleOff, nil
+	case LifecycleV1, LifecycleBoth:
+		return LifecycleMode(s), nil
+	default:
+		return "", fmt.Errorf("LIFECYCLE_EVENTS must be off, v1 or both, got %q", s)
+	}
+}
+
+func (m LifecycleMode) v1() bool     { return m == LifecycleV1 || m == LifecycleBoth }
+func (m LifecycleMode) legacy() bool { return m == LifecycleBoth }
+
+// WithLifecycleEvents turns on lifecycle event publishing. A Worker without it
+// publishes none.
+func (w *Worker) WithLifecycleEvents(mode LifecycleMode) *Worker {
+	w.lifecycle = mode
+	return w
+}
+
+// Lifecycle events are published after the outcome they describe is settled,
+// and a failure to publish one changes nothing about that outcome: like a
+// failed echo, it is logged and swallowed. The send has already happened (or
+// failed and been reported), so neither replaying the command nor reporting
+// a delivery failure would be true.
+
+func (w *Worker) messageSent(ctx context.Context, cmd types.SendMessageCommand, messageID string, attempts int) {
+	if w.lifecycle.legacy() {
+		if err := w.emitMessageSent(ctx, cmd, messageID, attempts); err != nil {
+			w.logger.Warn("failed to emit legacy message_sent", zap.String("command_id", cmd.CommandID), zap.Error(err))
+		}
+	}
+	if !w.lifecycle.v1() {
+		return
+	}
+
+	payload := types.MessageSentPayload{
+		Type:           "message_sent",
+		CommandID:      cmd.CommandID,
+		ConversationID: cmd.ConversationID,
+		UserID:         cmd.UserID,
+		Attempts:       attempts,
+		MessageType:    cmd.Message.Type,
+		Content:        messageContent(cmd.Message),
+		Metadata:       cmd.Message.Metadata,
+	}
+	if messageID != "" {
+		payload.PlatformMessageID = &messageID
+	}
+	w.publishLifecycle(ctx, cmd, payload.Type, payload)
+}
+
+// messageContent is the human-readable part of a message: its text, a
+// question's text, a caption, or failing those the media URL.
+func messageContent(m types.MessageContent) string {
+	for _, s := range []*string{m.Text, m.QuestionText, m.Caption, m.MediaURL} {
+		if !types.Blank(s) {
+			return *s
+		}
+	}
+	return ""
+}
+
+func (w *Worker) messageFailed(ctx context.Context, cmd types.SendMessageCommand, err error, attempts int) {
+	retriable := IsRetriable(err)
+	if w.lifecycle.legacy() {
+		if emitErr := w.emitMessageFailed(ctx, cmd, err, attempts, retriable); emitErr != nil {
+			w.logger.Warn("failed to emit legacy message_failed", zap.String("command_id", cmd.CommandID), zap.Error(emitErr))
+		}
+	}
+	if !w.lifecycle.v1() {
+		return
+	}
+
+	errorCode := GetErrorCode(err)
+	payload := types.MessageFailedPayload{
+		Type:           "message_failed",
+		CommandID:      cmd.CommandID,
+		ConversationID: cmd.ConversationID,
+		UserID:         cmd.UserID,
+		Error:          err.Error(),
+		ErrorCode:      &errorCode,
+		Attempts:       attempts,
+		Retriable:      retriable,
+	}
+	var platformErr *PlatformError
+	if errors.As(err, &platformErr) {
+		payload.PlatformCode = platformErr.StatusCode
+	}
+	w.publishLifecycle(ctx, cmd, payload.Type, payload)
+}
+
+func (w *Worker) handoffCompleted(ctx context.Context, cmd types.HandoffCommand, attempts int) {
+	sendCmd := w.handoffToCmd(cmd)
+	if w.lifecycle.legacy() {
+		if err := w.emitMessageSent(ctx, sendCmd, "", attempts); err != nil {
+			w.logger.Warn("failed to emit legacy message_sent for handoff", zap.String("command_id", cmd.CommandID), zap.Error(err))
+		}
+	}
+	if !w.lifecycle.v1() {
+		return
+	}
+
+	payload := types.HandoffCompletedPayload{
+		Type:           "handoff_completed",
+		CommandID:      cmd.CommandID,
+		ConversationID: cmd.ConversationID,
+		UserID:         cmd.UserID,
+		TargetAppID:    cmd.TargetAppID,
+		Attempts:       attempts,
+	}
+	w.publishLifecycle(ctx, sendCmd, payload.Type, payload)
+}
+
+// publishLifecycle wraps payload in a v1 LifecycleEvent and publishes it
+// keyed by user id.
+func (w *Worker) publishLifecycle(ctx context.Context, cmd types.SendMessageCommand, eventType string, payload interface{}) {
+	payloadJSON, err := json.Marshal(payload)
+	if err != nil {
+		w.logger.Warn("failed to marshal lifecycle payload", zap.String("event_type", eventType), zap.Error(err))
+		return
+	}
+
+	event := types.LifecycleEvent{
+		SchemaVersion:  types.LifecycleSchemaVersion,
+		EventID:        generateEventID(),
+		EventType:      eventType,
+		UserID:         cmd.UserID,
+		ConversationID: cmd.ConversationID,
+		Timestamp:      time.Now().UnixMilli(),
+		Source: types.LifecycleSource{
+			Type:      cmd.Platform,
+			AccountID: cmd.PlatformAccountID,
+		},
+		Origin:  types.EventSourceMessageWorker,
+		Payload: payloadJSON,
+	}
+	data, err := json.Marshal(event)
+	if err != nil {
+		w.logger.Warn("failed to marshal lifecycle event", zap.String("event_type", eventType), zap.Error(err))
+		return
+	}
+
+	if err := w.producer.PublishRawEvent(ctx, cmd.UserID, data); err != nil {
+		w.logger.Warn("failed to emit lifecycle event",
+			zap.String("event_type", eventType),
+			zap.String("command_id", cmd.CommandID),
+			zap.Error(err))
+	}
+}
@@ -0,0 +1,244 @@
+package messageworker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/vlab-research/fly/message-worker/types"
+	"go.uber.org/zap"
+)
+
+func TestParseLifecycleMode(t *testing.T) {
+	for in, want := range map[string]LifecycleMode{"": LifecycleOff, "off": LifecycleOff, "v1": LifecycleV1, "both": LifecycleBoth} {
+		got, err := ParseLifecycleMode(in)
+		if err != nil || got != want {
+			t.Errorf("ParseLifecycleMode(%q) = %q, %v, want %q", in, got, err, want)
+		}
+	}
+	if _, err := ParseLifecycleMode("true"); err == nil {
+		t.Error("expected an error for an unknown mode")
+	}
+}
+
+func lifecycleWorker(t *testing.T, mode LifecycleMode, sender MessageSender, producer *mockEventProducer) *Worker {
+	t.Helper()
+	bot := newMockBotserver()
+	t.Cleanup(bot.Close)
+
+	clients := map[types.PlatformType]MessageSender{types.PlatformMessenger: sender}
+	worker := NewWorker(clients, producer, bot.URL(), zap.NewNop()).WithLifecycleEvents(mode)
+	worker.config = RetryConfig{MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond}
+	return worker
+}
+
+func lifecycleSendCommand() []byte {
+	text := "Hello"
+	cmd := types.SendMessageCommand{
+		Type:              "send_message",
+		CommandID:         "cmd_1",
+		ConversationID:    "user_789",
+		UserID:            "user_789",
+		Platform:          types.PlatformMessenger,
+		PlatformAccountID: "page_123",
+		Message: types.MessageContent{
+			Type:     types.MessageTypeText,
+			Text:     &text,
+			Metadata: json.RawMessage(`{"ref":"intro"}`),
+		},
+	}
+	data, _ := json.Marshal(cmd)
+	return data
+}
+
+func decodeLifecycle(t *testing.T, raw []byte, payload interface{}) types.LifecycleEvent {
+	t.Helper()
+	var event types.LifecycleEvent
+	if err := json.Unmarshal(raw, &event); err != nil {
+		t.Fatalf("bad lifecycle event: %v", err)
+	}
+	if err := json.Unmarshal(event.Payload, payload); err != nil {
+		t.Fatalf("bad lifecycle payload: %v", err)
+	}
+	return event
+}
+
+func TestLifecycle_OffPublishesNothing(t *testing.T) {
+	producer := &mockEventProducer{}
+	sender := &mockMessageSender{response: &SendMessageResponse{MessageID: "mid.1", Success: true}}
+	worker := lifecycleWorker(t, LifecycleOff, sender, producer)
+
+	if err := worker.ProcessCommand(context.Background(), lifecycleSendCommand()); err != nil {
+		t.Fatalf("ProcessCommand failed: %v", err)
+	}
+	if len(producer.rawEvents) != 0 || len(producer.events) != 0 {
+		t.Errorf("expected no events, got %d raw and %d legacy", len(producer.rawEvents), len(producer.events))
+	}
+}
+
+func TestLifecycle_V1MessageSent(t *testing.T) {
+	producer := &mockEventProducer{}
+	sender := &mockMessageSender{response: &SendMessageResponse{MessageID: "mid.1", Success: true}}
+	worker := lifecycleWorker(t, LifecycleV1, sender, producer)
+
+	if err := worker.ProcessCommand(context.Background(), lifecycleSendCommand()); err != nil {
+		t.Fatalf("ProcessCommand failed: %v", err)
+	}
+	if len(producer.events) != 0 {
+		t.Errorf("v1 should not emit the legacy shape, got %d", len(producer.events))
+	}
+	if len(producer.rawEvents) != 1 {
+		t.Fatalf("expected 1 lifecycle event, got %d", len(producer.rawEvents))
+	}
+
+	var payload types.MessageSentPayload
+	event := decodeLifecycle(t, producer.rawEvents[0], &payload)
+	if event.SchemaVersion != types.LifecycleSchemaVersion || event.EventType != "message_sent" {
+		t.Errorf("event = %+v", event)
+	}
+	if event.UserID != "user_789" || event.Source.Type != types.PlatformMessenger || event.Source.AccountID != "page_123" {
+		t.Errorf("event addressing = %+v", event)
+	}
+	if event.Origin != types.EventSourceMessageWorker || event.Timestamp == 0 {
+		t.Errorf("event origin/timestamp = %q/%d", event.Origin, event.Timestamp)
+	}
+	if payload.PlatformMessageID == nil || *payload.PlatformMessageID != "mid.1" || payload.Attempts != 1 {
+		t.Errorf("payload = %+v", payload)
+	}
+	if string(payload.Metadata) != `{"ref":"intro"}` {
+		t.Errorf("payload metadata = %s", payload.Metadata)
+	}
+	if payload.MessageType != types.MessageTypeText || payload.Content != "Hello" {
+		t.Errorf("payload content = %q/%q", payload.MessageType, payload.Content)
+	}
+}
+
+func TestMessageContent(t *testing.T) {
+	text, question, caption, url := "hi", "Which?", "look", "https://example.com/a.png"
+	cases := []struct {
+		msg  types.MessageContent
+		want string
+	}{
+		{types.MessageContent{Text: &text}, "hi"},
+		{types.MessageContent{QuestionText: &question}, "Which?"},
+		{types.MessageContent{Caption: &caption, MediaURL: &url}, "look"},
+		{types.MessageContent{MediaURL: &url}, url},
+		{types.MessageContent{}, ""},
+	}
+	for _, c := range cases {
+		if got := messageContent(c.msg); got != c.want {
+			t.Errorf("messageContent(%+v) = %q, want %q", c.msg, got, c.want)
+		}
+	}
+}
+
+func TestLifecycle_BothEmitsLegacyShapeToo(t *testing.T) {
+	producer := &mockEventProducer{}
+	sender := &mockMessageSender{response: &SendMessageResponse{MessageID: "mid.1", Success: true}}
+	worker := lifecycleWorker(t, LifecycleBoth, sender, producer)
+
+	if err := worker.ProcessCommand(context.Background(), lifecycleSendCommand()); err != nil {
+		t.Fatalf("ProcessCommand failed: %v", err)
+	}
+	if len(producer.rawEvents) != 1 || len(producer.events) != 1 {
+		t.Fatalf("expected 1 v1 and 1 legacy event, got %d and %d", len(producer.rawEvents), len(producer.events))
+	}
+	if producer.events[0].EventType != "message_sent" || producer.events[0].Source != types.EventSourceMessageWorker {
+		t.Errorf("legacy event = %+v", producer.events[0])
+	}
+}
+
+func TestLifecycle_MessageFailedCountsAttempts(t *testing.T) {
+	producer := &mockEventProducer{}
+	sender := &mockMessageSender{err: &PlatformError{StatusCode: 613, Message: "rate limited", Retriable: true}}
+	worker := lifecycleWorker(t, LifecycleV1, sender, producer)
+
+	err := worker.ProcessCommand(context.Background(), lifecycleSendCommand())
+	var handled *HandledError
+	if !errors.As(err, &handled) {
+		t.Fatalf("expected a HandledError, got %v", err)
+	}
+	if len(producer.rawEvents) != 1 {
+		t.Fatalf("expected 1 lifecycle event, got %d", len(producer.rawEvents))
+	}
+
+	var payload types.MessageFailedPayload
+	event := decodeLifecycle(t, producer.rawEvents[0], &payload)
+	if event.EventType != "message_failed" {
+		t.Errorf("event_type = %q, want message_failed", event.EventType)
+	}
+	if payload.Attempts != 3 || !payload.Retriable || payload.PlatformCode != 613 {
+		t.Errorf("payload = %+v, want 3 retriable attempts with code 613", payload)
+	}
+}
+
+func TestLifecycle_TranslationFailureIsZeroAttempts(t *testing.T) {
+	producer := &mockEventProducer{}
+	sender := &mockMessageSender{}
+	worker := lifecycleWorker(t, LifecycleV1, sender, producer)
+
+	cmd := types.SendMessageCommand{
+		Type:     "send_message",
+		UserID:   "user_789",
+		Platform: types.PlatformMessenger,
+		Message:  types.MessageContent{Type: types.MessageTypeText},
+	}
+	data, _ := json.Marshal(cmd)
+	_ = worker.ProcessCommand(context.Background(), data)
+
+	if sender.calls != 0 {
+		t.Errorf("nothing should have been sent, got %d calls", sender.calls)
+	}
+	var payload types.MessageFailedPayload
+	decodeLifecycle(t, producer.rawEvents[0], &payload)
+	if payload.Attempts != 0 || payload.Retriable || payload.PlatformCode != 0 {
+		t.Errorf("payload = %+v, want 0 attempts, not retriable, no platform code", payload)
+	}
+}
+
+func TestLifecycle_HandoffCompleted(t *testing.T) {
+	producer := &mockEventProducer{}
+	sender := &mockMessageSender{}
+	worker := lifecycleWorker(t, LifecycleBoth, sender, producer)
+
+	cmd := types.HandoffCommand{
+		Type:              "handoff",
+		CommandID:         "cmd_h",
+		ConversationID:    "user_789",
+		UserID:            "user_789",
+		Platform:          types.PlatformMessenger,
+		PlatformAccountID: "page_123",
+		TargetAppID:       "263902037430900",
+	}
+	data, _ := json.Marshal(cmd)
+	if err := worker.ProcessCommand(context.Background(), data); err != nil {
+		t.Fatalf("ProcessCommand failed: %v", err)
+	}
+
+	var payload types.HandoffCompletedPayload
+	event := decodeLifecycle(t, producer.rawEvents[0], &payload)
+	if event.EventType != "handoff_completed" || payload.TargetAppID != "263902037430900" || payload.Attempts != 1 {
+		t.Errorf("event = %+v, payload = %+v", event, payload)
+	}
+	// The legacy shape has no handoff event: it was a message_sent.
+	if len(producer.events) != 1 || producer.events[0].EventType != "message_sent" {
+		t.Errorf("legacy events = %+v", producer.events)
+	}
+}
+
+// A lifecycle event that cannot be published does not turn a send into a
+// failure.
+func TestLifecycle_PublishFailureIsNotASendFailure(t *testing.T) {
+	producer := &mockEventProducer{err: errors.New("kafka unavailable")}
+	sender := &mockMessageSender{response: &SendMessageResponse{MessageID: "mid.1", Success: true}}
+	worker := lifecycleWorker(t, LifecycleBoth, sender, producer)
+
+	if err := worker.ProcessCommand(context.Background(), lifecycleSendCommand()); err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	if sender.calls != 1 {
+		t.Errorf("expected 1 send, got %d", sender.calls)
+	}
+}
@@ -0,0 +1,120 @@
+package messageworker
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Sealed credentials. A token in credentials.details may be sealed in place
+// with envelope encryption:
+//
+//	"access_token": "sealed:v1:<key id>:<wrapped data key>:<ciphertext>"
+//
+// The data key is AES-256-GCM, wrapped by the key-encryption key named by
+// <key id>. This is the format dinersclub seals with (dinersclub/sealed.go,
+// which also documents rotation); message-worker only ever opens it. Change
+// one, change both.
+
+const sealedPrefix = "sealed:v1:"
+
+// KeyUnwrapper unwraps data keys under named key-encryption keys. A KMS
+// client fits it as well as a key file does.
+type KeyUnwrapper interface {
+	UnwrapKey(kid string, wrapped []byte) ([]byte, error)
+}
+
+// FileKeyring is a KeyUnwrapper over the key file dinersclub uses:
+//
+//	{"current": "2026-10", "keys": {"2026-10": "<base64 32 bytes>", ...}}
+type FileKeyring struct {
+	keys map[string][]byte
+}
+
+// LoadKeyring reads a key file.
+func LoadKeyring(path string) (*FileKeyring, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	file := struct {
+		Keys map[string]string `json:"keys"`
+	}{}
+	if err := json.Unmarshal(b, &file); err != nil {
+		return nil, fmt.Errorf("keyring %s: %w", path, err)
+	}
+
+	k := &FileKeyring{keys: map[string][]byte{}}
+	for kid, enc := range file.Keys {
+		key, err := base64.StdEncoding.DecodeString(enc)
+		if err != nil || len(key) != 32 {
+			return nil, fmt.Errorf("keyring %s: key %q must be 32 bytes, base64", path, kid)
+		}
+		k.keys[kid] = key
+	}
+	if len(k.keys) == 0 {
+		return nil, fmt.Errorf("keyring %s has no keys", path)
+	}
+	return k, nil
+}
+
+// UnwrapKey opens a data key wrapped under kid.
+func (k *FileKeyring) UnwrapKey(kid string, wrapped []byte) ([]byte, error) {
+	kek, ok := k.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("no key %q in the keyring", kid)
+	}
+	return gcmOpen(kek, wrapped)
+}
+
+func gcmOpen(key, sealed []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	n := gcm.NonceSize()
+	if len(sealed) < n {
+		return nil, errors.New("sealed value is truncated")
+	}
+	return gcm.Open(nil, sealed[:n], sealed[n:], nil)
+}
+
+// OpenValue returns a sealed value's plaintext. Anything not sealed is
+// returned as it is, so plaintext credentials keep working.
+func OpenValue(keys KeyUnwrapper, v string) (string, error) {
+	if !strings.HasPrefix(v, sealedPrefix) {
+		return v, nil
+	}
+	if keys == nil {
+		return "", errors.New("credential is sealed but CREDENTIALS_KEYRING is not set")
+	}
+
+	parts := strings.Split(strings.TrimPrefix(v, sealedPrefix), ":")
+	if len(parts) != 3 {
+		return "", errors.New("malformed sealed value")
+	}
+	wrapped, err1 := base64.RawStdEncoding.DecodeString(parts[1])
+	ct, err2 := base64.RawStdEncoding.DecodeString(parts[2])
+	if err1 != nil || err2 != nil {
+		return "", errors.New("malformed sealed value")
+	}
+
+	dek, err := keys.UnwrapKey(parts[0], wrapped)
+	if err != nil {
+		return "", fmt.Errorf("unwrapping credential key under %q: %w", parts[0], err)
+	}
+	pt, err := gcmOpen(dek, ct)
+	if err != nil {
+		return "", fmt.Errorf("opening credential: %w", err)
+	}
+	return string(pt), nil
+}
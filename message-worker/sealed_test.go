@@ -0,0 +1,75 @@
+package messageworker
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// sealedVector was sealed by dinersclub's sealValue under the key in
+// vectorKeyring. If this stops opening, the two services disagree on the
+// format.
+const sealedVector = "sealed:v1:2026-10:N2aHvcoIgdsznrHGSu4dtBWHfDCvfyW3EA9PfZ21QCJCfBahJW8GvwHWXUFFmdSOljMmYG6jNoJrXXHW:qD9nIzZQq/enqBPw4766XBQ7HpupbTLRJt3YABpu+MpzxS5EBkWluSQAtQ"
+
+const vectorKeyring = `{"current": "2026-10", "keys": {"2026-10": "AAECAwQFBgcICQoLDA0ODxAREhMUFRYXGBkaGxwdHh8="}}`
+
+func writeTestKeyring(t *testing.T, contents string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "keyring.json")
+	if err := os.WriteFile(path, []byte(contents), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestOpenValue_OpensDinersclubSealedToken(t *testing.T) {
+	keys, err := LoadKeyring(writeTestKeyring(t, vectorKeyring))
+	if err != nil {
+		t.Fatalf("LoadKeyring: %v", err)
+	}
+
+	token, err := OpenValue(keys, sealedVector)
+	if err != nil {
+		t.Fatalf("OpenValue: %v", err)
+	}
+	if token != "EAAB-page-token" {
+		t.Errorf("Expected EAAB-page-token, got %q", token)
+	}
+}
+
+func TestOpenValue_PlaintextPassesThrough(t *testing.T) {
+	token, err := OpenValue(nil, "EAAB-plain")
+	if err != nil || token != "EAAB-plain" {
+		t.Errorf("Expected plaintext unchanged, got %q, %v", token, err)
+	}
+}
+
+func TestOpenValue_SealedWithoutKeyringFails(t *testing.T) {
+	_, err := OpenValue(nil, sealedVector)
+	if err == nil || !strings.Contains(err.Error(), "CREDENTIALS_KEYRING") {
+		t.Errorf("Expected an error naming CREDENTIALS_KEYRING, got %v", err)
+	}
+}
+
+func TestOpenValue_WrongKeyFails(t *testing.T) {
+	keys, err := LoadKeyring(writeTestKeyring(t,
+		`{"keys": {"2026-10": "HwAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA="}}`))
+	if err != nil {
+		t.Fatalf("LoadKeyring: %v", err)
+	}
+	if _, err := OpenValue(keys, sealedVector); err == nil {
+		t.Error("Expected opening under the wrong key to fail")
+	}
+	if _, err := OpenValue(keys, "sealed:v1:2026-10:garbage"); err == nil {
+		t.Error("Expected a malformed sealed value to fail")
+	}
+}
+
+func TestLoadKeyring_RejectsBadKeys(t *testing.T) {
+	for _, contents := range []string{`{"keys": {}}`, `{"keys": {"k": "c2hvcnQ="}}`, `nope`} {
+		if _, err := LoadKeyring(writeTestKeyring(t, contents)); err == nil {
+			t.Errorf("Expected %s to be rejected", contents)
+		}
+	}
+}
@@ -30,24 +30,5 @@ func (c *StubClient) PassThreadControl(ctx context.Context, userID, platformAcco
 	}
 }
 
-// WhatsAppClient is a real HTTP client — see whatsapp_client.go.
-
-type InstagramClient struct {
-	StubClient
-}
-
-func NewInstagramClient() *InstagramClient {
-	return &InstagramClient{
-		StubClient: StubClient{platformName: "Instagram"},
-	}
-}
-
-type TelegramClient struct {
-	StubClient
-}
-
-func NewTelegramClient() *TelegramClient {
-	return &TelegramClient{
-		StubClient: StubClient{platformName: "Telegram"},
-	}
-}
+// WhatsAppClient, InstagramClient and TelegramClient are real HTTP clients —
+// see whatsapp_client.go, instagram_client.go and telegram_client.go.
@@ -0,0 +1,157 @@
+package messageworker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/vlab-research/fly/message-worker/types"
+)
+
+// TelegramClient sends messages via the Telegram Bot API. It mirrors
+// WhatsAppClient: a configurable baseURL (pointed at a mock in tests), a
+// TokenStore for the bot token, and a 30s HTTP client.
+//
+// The platform account id is the bot's namespaced id, "tg:<bot id>" (see the
+// account-id namespace policy in documentation/platform-abstraction.md), and
+// is used only to look up the telegram_bot credential. The Bot API itself
+// identifies the bot by its token, which goes in the URL path:
+// POST {baseURL}/bot<token>/<method>. Because of that, nothing that may carry
+// the URL is allowed into an error message -- those messages end up in
+// machine_reports.
+type TelegramClient struct {
+	baseURL    string
+	tokenStore TokenStore
+	httpClient *http.Client
+}
+
+func NewTelegramClient(baseURL string, tokenStore TokenStore) *TelegramClient {
+	return &TelegramClient{
+		baseURL:    baseURL,
+		tokenStore: tokenStore,
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// TelegramSendRequest is a Bot API send body. It embeds the translated
+// TelegramMessage, flattening its fields (text, photo, reply_markup, ...)
+// next to chat_id.
+type TelegramSendRequest struct {
+	ChatID string `json:"chat_id"`
+	types.TelegramMessage
+}
+
+// TelegramResponse is the Bot API response envelope. On failure ok is false
+// and error_code mirrors the HTTP status.
+type TelegramResponse struct {
+	OK          bool   `json:"ok"`
+	ErrorCode   int    `json:"error_code,omitempty"`
+	Description string `json:"description,omitempty"`
+	Result      struct {
+		MessageID int64 `json:"message_id"`
+	} `json:"result"`
+}
+
+func (c *TelegramClient) SendMessage(ctx context.Context, platformAccountID, userID string, message interface{}, platformContext json.RawMessage) (*SendMessageResponse, error) {
+	token, err := c.tokenStore.GetToken(ctx, string(types.PlatformTelegram), platformAccountID)
+	if err != nil {
+		return nil, &PlatformError{
+			StatusCode: 0,
+			Message:    fmt.Sprintf("failed to get token: %v", err),
+			Retriable:  false,
+		}
+	}
+
+	tgMsg, ok := message.(types.TelegramMessage)
+	if !ok {
+		return nil, fmt.Errorf("telegram client expected types.TelegramMessage, got %T", message)
+	}
+	if tgMsg.Method == "" {
+		return nil, fmt.Errorf("telegram message has no Bot API method")
+	}
+
+	req := TelegramSendRequest{
+		ChatID:          userID,
+		TelegramMessage: tgMsg,
+	}
+
+	data, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", c.baseURL+"/bot"+token+"/"+tgMsg.Method, bytes.NewReader(data))
+	if err != nil {
+		// url.Parse errors quote the URL, token included.
+		return nil, fmt.Errorf("failed to create request for %s", tgMsg.Method)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.httpClient.Do(httpReq)
+	if err != nil {
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return nil, &PlatformError{
+			StatusCode: 0,
+			Message:    fmt.Sprintf("HTTP request failed: %v", err),
+			Retriable:  true,
+		}
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %w", err)
+	}
+
+	var tgResp TelegramResponse
+	if err := json.Unmarshal(body, &tgResp); err != nil {
+		if resp.StatusCode >= 400 {
+			return nil, &PlatformError{
+				StatusCode: resp.StatusCode,
+				Message:    string(body),
+				Retriable:  isRetriableHTTPStatus(resp.StatusCode),
+			}
+		}
+		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+
+	if !tgResp.OK || resp.StatusCode >= 400 {
+		return nil, parseTelegramError(resp.StatusCode, tgResp)
+	}
+
+	return &SendMessageResponse{MessageID: strconv.FormatInt(tgResp.Result.MessageID, 10), Success: true}, nil
+}
+
+// parseTelegramError classifies a Bot API failure by its error_code, which
+// is an HTTP status: 429 (flood control) and 5xx are retried; 400 (chat not
+// found, bad markup), 401/404 (bad token) and 403 (bot blocked by the user)
+// fail the same way every time. 429 carries a retry_after that the generic
+// backoff does not honour -- if it is longer than the backoff, the command
+// fails and is reported like any other exhausted retry.
+func parseTelegramError(statusCode int, tgResp TelegramResponse) *PlatformError {
+	code := tgResp.ErrorCode
+	if code == 0 {
+		code = statusCode
+	}
+	return &PlatformError{
+		StatusCode: code,
+		Message:    tgResp.Description,
+		Retriable:  isRetriableHTTPStatus(code),
+	}
+}
+
+// PassThreadControl is a no-op for Telegram, as it is for WhatsApp: a bot
+// has no handover protocol to pass the chat to another app.
+func (c *TelegramClient) PassThreadControl(ctx context.Context, userID, platformAccountID, targetAppID, metadata string) error {
+	return nil
+}
@@ -0,0 +1,179 @@
+package messageworker
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/vlab-research/fly/message-worker/types"
+)
+
+const tgOKResponse = `{"ok":true,"result":{"message_id":42,"chat":{"id":555001}}}`
+
+func TestTelegramClient_SendMessage_Text(t *testing.T) {
+	server := newCapturingWAServer(http.StatusOK, tgOKResponse)
+	defer server.Close()
+
+	client := NewTelegramClient(server.server.URL, NewStaticTokenStore("7123456789:AAbot-token"))
+	msg := types.TelegramMessage{Method: "sendMessage", Text: "Hello from the bot"}
+
+	resp, err := client.SendMessage(context.Background(), "tg:7123456789", "555001", msg, nil)
+	if err != nil {
+		t.Fatalf("SendMessage failed: %v", err)
+	}
+	if !resp.Success || resp.MessageID != "42" {
+		t.Errorf("resp = %+v, want Success=true MessageID=42", resp)
+	}
+
+	if len(server.paths) != 1 {
+		t.Fatalf("expected 1 request, got %d", len(server.paths))
+	}
+	// POST /bot<token>/<method>
+	if server.paths[0] != "/bot7123456789:AAbot-token/sendMessage" {
+		t.Errorf("path = %s, want /bot7123456789:AAbot-token/sendMessage", server.paths[0])
+	}
+
+	var out map[string]interface{}
+	if err := json.Unmarshal(server.bodies[0], &out); err != nil {
+		t.Fatalf("bad request body: %v", err)
+	}
+	if out["chat_id"] != "555001" || out["text"] != "Hello from the bot" {
+		t.Errorf("body = %v, want chat_id 555001 and the text", out)
+	}
+	if _, ok := out["Method"]; ok {
+		t.Errorf("the method must not be sent in the body: %v", out)
+	}
+}
+
+func TestTelegramClient_SendMessage_InlineKeyboardAndMedia(t *testing.T) {
+	server := newCapturingWAServer(http.StatusOK, tgOKResponse)
+	defer server.Close()
+
+	client := NewTelegramClient(server.server.URL, NewStaticTokenStore("test-token"))
+	msg := types.TelegramMessage{
+		Method:  "sendPhoto",
+		Photo:   "https://example.com/cat.png",
+		Caption: "Which?",
+		ReplyMarkup: &types.TelegramReplyMarkup{
+			InlineKeyboard: [][]types.TelegramInlineButton{{{Text: "Cat", CallbackData: "cat"}}},
+		},
+	}
+
+	if _, err := client.SendMessage(context.Background(), "tg:7123456789", "555001", msg, nil); err != nil {
+		t.Fatalf("SendMessage failed: %v", err)
+	}
+	if server.paths[0] != "/bottest-token/sendPhoto" {
+		t.Errorf("path = %s, want /bottest-token/sendPhoto", server.paths[0])
+	}
+
+	var out struct {
+		Photo       string                    `json:"photo"`
+		Caption     string                    `json:"caption"`
+		ReplyMarkup types.TelegramReplyMarkup `json:"reply_markup"`
+	}
+	if err := json.Unmarshal(server.bodies[0], &out); err != nil {
+		t.Fatalf("bad request body: %v", err)
+	}
+	if out.Photo != "https://example.com/cat.png" || out.Caption != "Which?" {
+		t.Errorf("body = %+v", out)
+	}
+	if len(out.ReplyMarkup.InlineKeyboard) != 1 || out.ReplyMarkup.InlineKeyboard[0][0].CallbackData != "cat" {
+		t.Errorf("reply_markup = %+v", out.ReplyMarkup)
+	}
+}
+
+func TestTelegramClient_SendMessage_WrongMessageType(t *testing.T) {
+	server := newCapturingWAServer(http.StatusOK, tgOKResponse)
+	defer server.Close()
+
+	client := NewTelegramClient(server.server.URL, NewStaticTokenStore("test-token"))
+	_, err := client.SendMessage(context.Background(), "tg:7123456789", "555001", types.WhatsAppMessage{Type: "text"}, nil)
+	if err == nil {
+		t.Fatal("expected error for wrong message type, got nil")
+	}
+	if len(server.paths) != 0 {
+		t.Errorf("should not have sent a request, got %d", len(server.paths))
+	}
+}
+
+func TestTelegramClient_SendMessage_Errors(t *testing.T) {
+	tests := []struct {
+		name          string
+		status        int
+		body          string
+		wantCode      int
+		wantRetriable bool
+	}{
+		{"blocked by the user", http.StatusForbidden, `{"ok":false,"error_code":403,"description":"Forbidden: bot was blocked by the user"}`, 403, false},
+		{"chat not found", http.StatusBadRequest, `{"ok":false,"error_code":400,"description":"Bad Request: chat not found"}`, 400, false},
+		{"bad token", http.StatusUnauthorized, `{"ok":false,"error_code":401,"description":"Unauthorized"}`, 401, false},
+		{"flood control", http.StatusTooManyRequests, `{"ok":false,"error_code":429,"description":"Too Many Requests: retry after 3","parameters":{"retry_after":3}}`, 429, true},
+		{"server error", http.StatusInternalServerError, `{"ok":false,"error_code":500,"description":"Internal Server Error"}`, 500, true},
+		{"gateway without a bot api body", http.StatusBadGateway, `<html>bad gateway</html>`, http.StatusBadGateway, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newCapturingWAServer(tt.status, tt.body)
+			defer server.Close()
+
+			client := NewTelegramClient(server.server.URL, NewStaticTokenStore("test-token"))
+			_, err := client.SendMessage(context.Background(), "tg:7123456789", "555001", types.TelegramMessage{Method: "sendMessage", Text: "hi"}, nil)
+
+			pe, ok := err.(*PlatformError)
+			if !ok {
+				t.Fatalf("expected *PlatformError, got %T (%v)", err, err)
+			}
+			if pe.StatusCode != tt.wantCode {
+				t.Errorf("StatusCode = %d, want %d", pe.StatusCode, tt.wantCode)
+			}
+			if pe.Retriable != tt.wantRetriable {
+				t.Errorf("Retriable = %v, want %v", pe.Retriable, tt.wantRetriable)
+			}
+		})
+	}
+}
+
+func TestTelegramClient_SendMessage_MissingToken(t *testing.T) {
+	client := NewTelegramClient("http://unused", NewStaticTokenStore(""))
+	_, err := client.SendMessage(context.Background(), "tg:7123456789", "555001", types.TelegramMessage{Method: "sendMessage", Text: "hi"}, nil)
+
+	pe, ok := err.(*PlatformError)
+	if !ok {
+		t.Fatalf("expected *PlatformError, got %T (%v)", err, err)
+	}
+	if pe.Retriable {
+		t.Error("a missing token should not be retriable")
+	}
+}
+
+// The token is in the request URL; a transport error must not carry it into
+// the machine_report.
+func TestTelegramClient_SendMessage_UnreachableDoesNotLeakToken(t *testing.T) {
+	server := newCapturingWAServer(http.StatusOK, tgOKResponse)
+	url := server.server.URL
+	server.Close()
+
+	client := NewTelegramClient(url, NewStaticTokenStore("7123456789:SECRET"))
+	_, err := client.SendMessage(context.Background(), "tg:7123456789", "555001", types.TelegramMessage{Method: "sendMessage", Text: "hi"}, nil)
+
+	pe, ok := err.(*PlatformError)
+	if !ok {
+		t.Fatalf("expected *PlatformError, got %T (%v)", err, err)
+	}
+	if !pe.Retriable {
+		t.Error("a connection failure should be retriable")
+	}
+	if strings.Contains(pe.Message, "SECRET") {
+		t.Errorf("error message leaks the bot token: %s", pe.Message)
+	}
+}
+
+func TestTelegramClient_PassThreadControl_NoOp(t *testing.T) {
+	client := NewTelegramClient("http://unused", NewStaticTokenStore("test-token"))
+	if err := client.PassThreadControl(context.Background(), "555001", "tg:7123456789", "app", "{}"); err != nil {
+		t.Errorf("PassThreadControl should be a no-op returning nil, got %v", err)
+	}
+}
@@ -26,19 +26,31 @@ type TokenStore interface {
 // platformToEntity maps a messaging platform name to its credentials entity
 // type. The platform values match types.PlatformType; entity values are the
 // credentials.entity discriminator.
+//
+// instagram_account (key: the Instagram professional account id) is outside
+// unique_messaging_account, so it is only ever found by this natural key,
+// never by the key-only fallback. An Instagram send always knows its
+// platform, so that is no loss. The same holds for telegram_bot, whose key is
+// the namespaced bot id "tg:<bot id>" and whose details carry the bot token
+// as "token".
 var platformToEntity = map[string]string{
 	"messenger": "facebook_page",
 	"whatsapp":  "whatsapp_business",
+	"instagram": "instagram_account",
+	"telegram":  "telegram_bot",
 }
 
 // ErrTokenNotFound is returned when no token is found for a platform account
 var ErrTokenNotFound = fmt.Errorf("token not found for platform account")
 
-// PostgresTokenStore implements TokenStore using database lookup with caching
+// PostgresTokenStore implements TokenStore using database lookup with caching.
+// Tokens sealed by dinersclub's seal-credentials are opened with keys (see
+// sealed.go); nil keys means every token must be plaintext.
 type PostgresTokenStore struct {
 	pool  *pgxpool.Pool
 	cache *tokenCache
 	ttl   time.Duration
+	keys  KeyUnwrapper
 }
 
 // tokenCache is a simple TTL cache for tokens
@@ -52,8 +64,8 @@ type cacheEntry struct {
 	expiresAt time.Time
 }
 
-// NewPostgresTokenStore creates a new PostgresTokenStore
-func NewPostgresTokenStore(ctx context.Context, databaseURL string, cacheTTL time.Duration) (*PostgresTokenStore, error) {
+// NewPostgresTokenStore creates a new PostgresTokenStore. keys may be nil.
+func NewPostgresTokenStore(ctx context.Context, databaseURL string, cacheTTL time.Duration, keys KeyUnwrapper) (*PostgresTokenStore, error) {
 	pool, err := pgxpool.New(ctx, databaseURL)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create database pool: %w", err)
@@ -70,7 +82,8 @@ func NewPostgresTokenStore(ctx context.Context, databaseURL string, cacheTTL tim
 		cache: &tokenCache{
 			entries: make(map[string]*cacheEntry),
 		},
-		ttl: cacheTTL,
+		ttl:  cacheTTL,
+		keys: keys,
 	}, nil
 }
 
@@ -119,6 +132,11 @@ func (s *PostgresTokenStore) GetToken(ctx context.Context, platform, platformAcc
 		return "", fmt.Errorf("%w: %s (empty token)", ErrTokenNotFound, platformAccountID)
 	}
 
+	token, err = OpenValue(s.keys, token)
+	if err != nil {
+		return "", fmt.Errorf("token for %s: %w", platformAccountID, err)
+	}
+
 	// Cache the token
 	s.cache.set(cacheKey, token, s.ttl)
 
@@ -0,0 +1,150 @@
+package messageworker
+
+import (
+	"fmt"
+
+	"github.com/vlab-research/fly/message-worker/types"
+)
+
+// TranslateToTelegram translates a platform-agnostic message to Telegram Bot
+// API format.
+//
+// utility_message has no Telegram counterpart and needs none: a bot may
+// message anyone who has started it, with no 24h window, so the re-contact
+// is sent as the plain text or question it is underneath. The Meta-specific
+// template metadata is ignored.
+func TranslateToTelegram(cmd types.SendMessageCommand) (types.TelegramMessage, error) {
+	// Validate message content
+	if err := cmd.Message.Validate(); err != nil {
+		return types.TelegramMessage{}, err
+	}
+
+	switch cmd.Message.Type {
+	case types.MessageTypeText:
+		// Same dispatch as TranslateToWhatsApp: a webview arrives as "text"
+		// with its link in metadata.url.
+		if cmd.Message.GetTypeFromMetadata() == "webview" {
+			return translateTelegramWebview(cmd.Message)
+		}
+		return translateTelegramText(cmd.Message)
+	case types.MessageTypeQuestion:
+		return translateTelegramQuestion(cmd.Message)
+	case types.MessageTypeMedia:
+		return translateTelegramMedia(cmd.Message, cmd.ResolvedMedia)
+	default:
+		return types.TelegramMessage{}, fmt.Errorf("%w: %s", types.ErrUnsupportedMessageType, cmd.Message.Type)
+	}
+}
+
+func translateTelegramText(msg types.MessageContent) (types.TelegramMessage, error) {
+	return types.TelegramMessage{
+		Method: "sendMessage",
+		Text:   *msg.Text,
+	}, nil
+}
+
+// translateTelegramWebview renders a webview field as text with a single URL
+// button, the counterpart of the Messenger web_url button and the WhatsApp
+// cta_url message. Telegram puts no short cap on button text, so unlike
+// WhatsApp a long buttonText is sent as it is.
+func translateTelegramWebview(msg types.MessageContent) (types.TelegramMessage, error) {
+	md := metadataMap(msg.Metadata)
+	ref := getRefFromMetadata(msg.Metadata)
+
+	rawURL := metadataString(md, "url")
+	if rawURL == "" {
+		return types.TelegramMessage{}, fmt.Errorf("%w (field %q)", types.ErrMissingWebviewURL, ref)
+	}
+
+	url, err := normalizeWebviewURL(rawURL, ref)
+	if err != nil {
+		return types.TelegramMessage{}, err
+	}
+
+	buttonText := metadataString(md, "buttonText")
+	if buttonText == "" {
+		buttonText = "View website"
+	}
+
+	return types.TelegramMessage{
+		Method: "sendMessage",
+		Text:   *msg.Text,
+		ReplyMarkup: &types.TelegramReplyMarkup{
+			InlineKeyboard: [][]types.TelegramInlineButton{
+				{{Text: buttonText, URL: url}},
+			},
+		},
+	}, nil
+}
+
+// translateTelegramQuestion renders the options as an inline keyboard, one
+// button per row so long labels are not squeezed side by side. A button's
+// callback_data is the option value, as the WhatsApp reply id is; the label
+// is what the respondent sees and what the answer is validated against.
+//
+// Telegram rejects the whole message when any callback_data is over 64
+// bytes, so an over-long value fails here, naming the field, rather than as
+// an opaque BUTTON_DATA_INVALID from the Bot API.
+func translateTelegramQuestion(msg types.MessageContent) (types.TelegramMessage, error) {
+	const maxButtons = 100
+
+	if len(msg.Options) > maxButtons {
+		return types.TelegramMessage{}, fmt.Errorf("%w: Telegram supports max %d inline keyboard buttons, got %d",
+			types.ErrTooManyOptions, maxButtons, len(msg.Options))
+	}
+
+	rows := make([][]types.TelegramInlineButton, len(msg.Options))
+	for i, opt := range msg.Options {
+		data := opt.ValueAsString()
+		if len(data) > types.TelegramCallbackDataMaxBytes {
+			return types.TelegramMessage{}, fmt.Errorf(
+				"%w (field %q): option %q value is %d bytes, max %d",
+				types.ErrCallbackDataTooLong, getRefFromMetadata(msg.Metadata), opt.Label, len(data), types.TelegramCallbackDataMaxBytes)
+		}
+		rows[i] = []types.TelegramInlineButton{{Text: opt.Label, CallbackData: data}}
+	}
+
+	return types.TelegramMessage{
+		Method:      "sendMessage",
+		Text:        *msg.QuestionText,
+		ReplyMarkup: &types.TelegramReplyMarkup{InlineKeyboard: rows},
+	}, nil
+}
+
+// translateTelegramMedia picks the Bot API method for the media type. A
+// by-id resolution is a Telegram file_id, which goes in the same field as a
+// URL would.
+func translateTelegramMedia(msg types.MessageContent, resolved *types.MediaSendable) (types.TelegramMessage, error) {
+	if types.Blank(msg.MediaURL) {
+		return types.TelegramMessage{}, types.ErrAttachmentIDUnsupported
+	}
+
+	media := *msg.MediaURL
+	if resolved != nil && resolved.Kind == types.MediaByID {
+		media = resolved.ID
+	}
+
+	telegramMsg := types.TelegramMessage{}
+	if msg.Caption != nil {
+		telegramMsg.Caption = *msg.Caption
+	}
+
+	switch *msg.MediaType {
+	case types.MediaTypeImage:
+		telegramMsg.Method = "sendPhoto"
+		telegramMsg.Photo = media
+	case types.MediaTypeVideo:
+		telegramMsg.Method = "sendVideo"
+		telegramMsg.Video = media
+	case types.MediaTypeAudio:
+		telegramMsg.Method = "sendAudio"
+		telegramMsg.Audio = media
+	case types.MediaTypeFile:
+		telegramMsg.Method = "sendDocument"
+		telegramMsg.Document = media
+	default:
+		return types.TelegramMessage{}, fmt.Errorf("%w: %s", types.ErrUnsupportedMediaType, *msg.MediaType)
+	}
+
+	return telegramMsg, nil
+}
@@ -0,0 +1,226 @@
+package messageworker
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/vlab-research/fly/message-worker/types"
+)
+
+func telegramCmd(msg types.MessageContent) types.SendMessageCommand {
+	return types.SendMessageCommand{
+		CommandID:         "cmd_tg",
+		ConversationID:    "conv_1",
+		UserID:            "555001",
+		Platform:          types.PlatformTelegram,
+		PlatformAccountID: "tg:7123456789",
+		Message:           msg,
+	}
+}
+
+func TestTranslateToTelegram(t *testing.T) {
+	tests := []struct {
+		name    string
+		cmd     types.SendMessageCommand
+		want    types.TelegramMessage
+		wantErr error
+	}{
+		{
+			name: "text message",
+			cmd: telegramCmd(types.MessageContent{
+				Type: types.MessageTypeText,
+				Text: stringPtr("Hello from Telegram!"),
+			}),
+			want: types.TelegramMessage{Method: "sendMessage", Text: "Hello from Telegram!"},
+		},
+		{
+			name: "question renders an inline keyboard, one button per row",
+			cmd: telegramCmd(types.MessageContent{
+				Type:         types.MessageTypeQuestion,
+				QuestionText: stringPtr("Do you agree?"),
+				Options: []types.Option{
+					{Value: json.RawMessage(`"yes"`), Label: "Yes"},
+					{Value: json.RawMessage(`2`), Label: "Not sure"},
+				},
+			}),
+			want: types.TelegramMessage{
+				Method: "sendMessage",
+				Text:   "Do you agree?",
+				ReplyMarkup: &types.TelegramReplyMarkup{
+					InlineKeyboard: [][]types.TelegramInlineButton{
+						{{Text: "Yes", CallbackData: "yes"}},
+						{{Text: "Not sure", CallbackData: "2"}},
+					},
+				},
+			},
+		},
+		{
+			// No 24h window on Telegram: the re-contact is sent as the
+			// question it is, template metadata and all ignored.
+			name: "utility_message is sent as its base type",
+			cmd: telegramCmd(types.MessageContent{
+				Type:         types.MessageTypeQuestion,
+				QuestionText: stringPtr("Still with us?"),
+				Options:      []types.Option{{Value: json.RawMessage(`"y"`), Label: "Yes"}},
+				Metadata:     json.RawMessage(`{"type":"utility_message","template":"followup","language":"en_US","ref":"u_1"}`),
+			}),
+			want: types.TelegramMessage{
+				Method: "sendMessage",
+				Text:   "Still with us?",
+				ReplyMarkup: &types.TelegramReplyMarkup{
+					InlineKeyboard: [][]types.TelegramInlineButton{{{Text: "Yes", CallbackData: "y"}}},
+				},
+			},
+		},
+		{
+			name: "webview renders a url button",
+			cmd: telegramCmd(types.MessageContent{
+				Type:     types.MessageTypeText,
+				Text:     stringPtr("Take a look!"),
+				Metadata: json.RawMessage(`{"type":"webview","url":"bit.ly/wazzii","buttonText":"A label well over twenty characters","ref":"wv_1"}`),
+			}),
+			want: types.TelegramMessage{
+				Method: "sendMessage",
+				Text:   "Take a look!",
+				ReplyMarkup: &types.TelegramReplyMarkup{
+					InlineKeyboard: [][]types.TelegramInlineButton{
+						{{Text: "A label well over twenty characters", URL: "https://bit.ly/wazzii"}},
+					},
+				},
+			},
+		},
+		{
+			name: "webview rejects a non-http scheme",
+			cmd: telegramCmd(types.MessageContent{
+				Type:     types.MessageTypeText,
+				Text:     stringPtr("Call us"),
+				Metadata: json.RawMessage(`{"type":"webview","url":"tel:+2340700","ref":"wv_2"}`),
+			}),
+			wantErr: types.ErrWebviewURLScheme,
+		},
+		{
+			name: "image with caption",
+			cmd: telegramCmd(types.MessageContent{
+				Type:      types.MessageTypeMedia,
+				MediaType: mediaTypePtr(types.MediaTypeImage),
+				MediaURL:  stringPtr("https://example.com/cat.png"),
+				Caption:   stringPtr("A cat"),
+			}),
+			want: types.TelegramMessage{Method: "sendPhoto", Photo: "https://example.com/cat.png", Caption: "A cat"},
+		},
+		{
+			name: "video",
+			cmd: telegramCmd(types.MessageContent{
+				Type:      types.MessageTypeMedia,
+				MediaType: mediaTypePtr(types.MediaTypeVideo),
+				MediaURL:  stringPtr("https://example.com/v.mp4"),
+			}),
+			want: types.TelegramMessage{Method: "sendVideo", Video: "https://example.com/v.mp4"},
+		},
+		{
+			name: "audio",
+			cmd: telegramCmd(types.MessageContent{
+				Type:      types.MessageTypeMedia,
+				MediaType: mediaTypePtr(types.MediaTypeAudio),
+				MediaURL:  stringPtr("https://example.com/a.mp3"),
+			}),
+			want: types.TelegramMessage{Method: "sendAudio", Audio: "https://example.com/a.mp3"},
+		},
+		{
+			name: "file",
+			cmd: telegramCmd(types.MessageContent{
+				Type:      types.MessageTypeMedia,
+				MediaType: mediaTypePtr(types.MediaTypeFile),
+				MediaURL:  stringPtr("https://example.com/doc.pdf"),
+			}),
+			want: types.TelegramMessage{Method: "sendDocument", Document: "https://example.com/doc.pdf"},
+		},
+		{
+			name: "attachment id is messenger-only",
+			cmd: telegramCmd(types.MessageContent{
+				Type:              types.MessageTypeMedia,
+				MediaType:         mediaTypePtr(types.MediaTypeImage),
+				MediaAttachmentID: stringPtr("123456"),
+			}),
+			wantErr: types.ErrAttachmentIDUnsupported,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := TranslateToTelegram(tt.cmd)
+
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("TranslateToTelegram() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("TranslateToTelegram() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("TranslateToTelegram() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTranslateToTelegram_MediaByID(t *testing.T) {
+	cmd := telegramCmd(types.MessageContent{
+		Type:      types.MessageTypeMedia,
+		MediaType: mediaTypePtr(types.MediaTypeImage),
+		MediaURL:  stringPtr("https://api.vlab.digital/a/8f14e45f-ceea-467f-a0e6-6f6a2b3c4d5e"),
+	})
+	cmd.ResolvedMedia = &types.MediaSendable{Kind: types.MediaByID, ID: "AgACAgQAAxkBAAIB"}
+
+	got, err := TranslateToTelegram(cmd)
+	if err != nil {
+		t.Fatalf("TranslateToTelegram() error = %v", err)
+	}
+	if got.Photo != "AgACAgQAAxkBAAIB" {
+		t.Errorf("photo = %q, want the file_id", got.Photo)
+	}
+}
+
+func TestTranslateToTelegram_CallbackDataLimit(t *testing.T) {
+	question := func(value string) types.SendMessageCommand {
+		return telegramCmd(types.MessageContent{
+			Type:         types.MessageTypeQuestion,
+			QuestionText: stringPtr("Pick"),
+			Options:      []types.Option{{Value: json.RawMessage(fmt.Sprintf("%q", value)), Label: "Long"}},
+			Metadata:     json.RawMessage(`{"ref":"q_long"}`),
+		})
+	}
+
+	if _, err := TranslateToTelegram(question(strings.Repeat("a", types.TelegramCallbackDataMaxBytes))); err != nil {
+		t.Errorf("a value at the limit should translate, got %v", err)
+	}
+
+	_, err := TranslateToTelegram(question(strings.Repeat("a", types.TelegramCallbackDataMaxBytes+1)))
+	if !errors.Is(err, types.ErrCallbackDataTooLong) {
+		t.Fatalf("error = %v, want ErrCallbackDataTooLong", err)
+	}
+	if !strings.Contains(err.Error(), "q_long") {
+		t.Errorf("error should name the field: %v", err)
+	}
+}
+
+func TestTranslateToTelegram_TooManyOptions(t *testing.T) {
+	options := make([]types.Option, 101)
+	for i := range options {
+		options[i] = types.Option{Value: json.RawMessage(fmt.Sprintf("%d", i)), Label: fmt.Sprintf("Option %d", i)}
+	}
+	_, err := TranslateToTelegram(telegramCmd(types.MessageContent{
+		Type:         types.MessageTypeQuestion,
+		QuestionText: stringPtr("Pick"),
+		Options:      options,
+	}))
+	if !errors.Is(err, types.ErrTooManyOptions) {
+		t.Fatalf("error = %v, want ErrTooManyOptions", err)
+	}
+}
@@ -26,7 +26,8 @@ var (
 	ErrMissingUtilityLanguage   = errors.New(`utility_message field missing required "language" in metadata`)
 	ErrMissingWebviewURL        = errors.New(`webview field missing required "url" in metadata`)
 	ErrWebviewButtonTextTooLong = errors.New("webview buttonText is too long for WhatsApp")
-	ErrWebviewURLScheme         = errors.New("webview url scheme is not supported by WhatsApp")
+	ErrWebviewURLScheme         = errors.New("webview url scheme is not supported; only http(s) links can be sent")
+	ErrCallbackDataTooLong      = errors.New("option value is too long for a Telegram button")
 )
 
 // WhatsAppCTAButtonTextMaxChars is the Cloud API's documented ceiling on a
@@ -35,3 +36,7 @@ var (
 // STATE_ACTIONS error the researcher can see and fix, rather than an opaque
 // delivery failure.
 const WhatsAppCTAButtonTextMaxChars = 20
+
+// TelegramCallbackDataMaxBytes is the Bot API's limit on an inline keyboard
+// button's callback_data. Telegram rejects the whole message over it.
+const TelegramCallbackDataMaxBytes = 64
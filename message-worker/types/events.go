@@ -29,14 +29,62 @@ const (
 	EventSourceSystem        EventSource = "system"         // System/internal events
 )
 
-// MessageSentPayload represents successful message delivery
+// LifecycleSchemaVersion is the LifecycleEvent schema_version this worker
+// writes. Consumers must ignore events with a version they do not know.
+const LifecycleSchemaVersion = 1
+
+// LifecycleEvent is the versioned envelope for message-worker's lifecycle
+// events (message_sent, message_failed, handoff_completed). It is replybot's
+// normalized event shape -- event_type, user_id, timestamp and a source
+// object carrying the platform and account id -- so replybot's
+// event-normalizer passes it through as it is, and it is published keyed by
+// user_id like every other chat event. UniversalEvent, the shape it
+// replaces, is keyed by conversation_id and has a string source, which is
+// what replybot could not parse.
+type LifecycleEvent struct {
+	SchemaVersion  int             `json:"schema_version"`
+	EventID        string          `json:"event_id"`
+	EventType      string          `json:"event_type"`
+	UserID         string          `json:"user_id"`
+	ConversationID string          `json:"conversation_id"`
+	Timestamp      int64           `json:"timestamp"`
+	Source         LifecycleSource `json:"source"`
+	Origin         EventSource     `json:"origin"`
+	Payload        json.RawMessage `json:"payload"`
+}
+
+// LifecycleSource names the platform and account a lifecycle event concerns,
+// in replybot's source shape.
+type LifecycleSource struct {
+	Type      PlatformType `json:"type"`
+	AccountID string       `json:"account_id"`
+}
+
+// MessageSentPayload represents successful message delivery. Metadata is the
+// sent message's metadata (ref, type, ...), so a consumer can tell which
+// question went out without the echo. MessageType and Content are what was
+// sent, for scribble's chat log: Content is the text the respondent sees, or
+// the media URL when there is none.
 type MessageSentPayload struct {
-	Type              string  `json:"type"`
-	CommandID         string  `json:"command_id"`
-	ConversationID    string  `json:"conversation_id"`
-	UserID            string  `json:"user_id"`
-	PlatformMessageID *string `json:"platform_message_id,omitempty"`
-	Attempts          int     `json:"attempts"`
+	Type              string          `json:"type"`
+	CommandID         string          `json:"command_id"`
+	ConversationID    string          `json:"conversation_id"`
+	UserID            string          `json:"user_id"`
+	PlatformMessageID *string         `json:"platform_message_id,omitempty"`
+	Attempts          int             `json:"attempts"`
+	MessageType       MessageType     `json:"message_type,omitempty"`
+	Content           string          `json:"content,omitempty"`
+	Metadata          json.RawMessage `json:"metadata,omitempty"`
+}
+
+// HandoffCompletedPayload represents a thread handed to another app.
+type HandoffCompletedPayload struct {
+	Type           string `json:"type"`
+	CommandID      string `json:"command_id"`
+	ConversationID string `json:"conversation_id"`
+	UserID         string `json:"user_id"`
+	TargetAppID    string `json:"target_app_id"`
+	Attempts       int    `json:"attempts"`
 }
 
 // MessageFailedPayload represents failed message delivery
@@ -47,6 +95,9 @@ type MessageFailedPayload struct {
 	UserID         string  `json:"user_id"`
 	Error          string  `json:"error"`
 	ErrorCode      *string `json:"error_code,omitempty"`
-	Attempts       int     `json:"attempts"`
-	Retriable      bool    `json:"retriable"`
+	// PlatformCode is the PlatformError status code (an HTTP status or a
+	// Graph/Bot API error code); 0 when the failure was not the platform's.
+	PlatformCode int  `json:"platform_code,omitempty"`
+	Attempts     int  `json:"attempts"`
+	Retriable    bool `json:"retriable"`
 }
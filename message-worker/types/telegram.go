@@ -0,0 +1,35 @@
+package types
+
+// TelegramMessage is a message in Telegram Bot API format. Unlike the Meta
+// platforms, each kind of message is its own Bot API method (sendMessage,
+// sendPhoto, ...), so Method travels with the message and is not part of the
+// body. chat_id is added by the client.
+type TelegramMessage struct {
+	Method      string               `json:"-"` // "sendMessage", "sendPhoto", "sendVideo", "sendAudio", "sendDocument"
+	Text        string               `json:"text,omitempty"`
+	Photo       string               `json:"photo,omitempty"`
+	Video       string               `json:"video,omitempty"`
+	Audio       string               `json:"audio,omitempty"`
+	Document    string               `json:"document,omitempty"`
+	Caption     string               `json:"caption,omitempty"`
+	ReplyMarkup *TelegramReplyMarkup `json:"reply_markup,omitempty"`
+}
+
+// TelegramReplyMarkup is an inline keyboard: rows of buttons attached to the
+// message.
+type TelegramReplyMarkup struct {
+	InlineKeyboard [][]TelegramInlineButton `json:"inline_keyboard"`
+}
+
+// TelegramInlineButton is one inline keyboard button. Exactly one of
+// CallbackData (a reply, delivered to the bot as a callback_query) and URL (a
+// link opened by the client) is set.
+type TelegramInlineButton struct {
+	Text         string `json:"text"`
+	CallbackData string `json:"callback_data,omitempty"`
+	URL          string `json:"url,omitempty"`
+}
+
+// Media inputs (Photo, Video, Audio, Document) are either an http(s) URL the
+// Bot API downloads, or the file_id of a file already sent by the bot --
+// the same field takes both.
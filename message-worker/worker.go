@@ -65,6 +65,8 @@ type Worker struct {
 	mediaStore     MediaStore
 	mediaHandleUse bool
 	mediaMargin    time.Duration
+
+	lifecycle LifecycleMode
 }
 
 func NewWorker(clients map[types.PlatformType]MessageSender, producer EventProducer, botserverURL string, logger *zap.Logger) *Worker {
@@ -144,48 +146,63 @@ func (w *Worker) processSendMessage(ctx context.Context, cmd types.SendMessageCo
 		platformMsg, err = TranslateToWhatsApp(cmd)
 	case types.PlatformInstagram:
 		platformMsg, err = TranslateToInstagram(cmd)
+	case types.PlatformTelegram:
+		platformMsg, err = TranslateToTelegram(cmd)
 	default:
 		err = fmt.Errorf("unsupported platform: %s", cmd.Platform)
 	}
 
 	if err != nil {
+		w.messageFailed(ctx, cmd, err, 0)
 		return w.reportError(cmd, err)
 	}
 
 	client, ok := w.clients[cmd.Platform]
 	if !ok {
 		err := fmt.Errorf("no client configured for platform: %s", cmd.Platform)
+		w.messageFailed(ctx, cmd, err, 0)
 		return w.reportError(cmd, err)
 	}
 
-	_, sendErr := RetryWithBackoff(ctx, w.config, func() error {
-		_, retryErr := client.SendMessage(ctx, cmd.PlatformAccountID, cmd.UserID, platformMsg, cmd.PlatformContext)
+	var resp *SendMessageResponse
+	attempts, sendErr := RetryWithBackoff(ctx, w.config, func() error {
+		var retryErr error
+		resp, retryErr = client.SendMessage(ctx, cmd.PlatformAccountID, cmd.UserID, platformMsg, cmd.PlatformContext)
 		return retryErr
 	})
 
 	if sendErr != nil {
+		w.messageFailed(ctx, cmd, sendErr, attempts)
 		return w.reportError(cmd, sendErr)
 	}
 
-	// WhatsApp has no native message echo (unlike Messenger's is_echo webhook),
-	// yet the replybot state machine advances RESPONDING -> QOUT off a
-	// bot_message_sent echo carrying the outbound message's metadata. Emit that
-	// echo here so the survey progresses. Scoped to WhatsApp so platforms that
-	// already echo natively don't get a duplicate.
+	// WhatsApp and Telegram have no native message echo (unlike Messenger's
+	// is_echo webhook), yet the replybot state machine advances RESPONDING ->
+	// QOUT off a bot_message_sent echo carrying the outbound message's
+	// metadata. Emit that echo here so the survey progresses. Scoped to those
+	// two so platforms that already echo natively don't get a duplicate.
 	//
 	// A failed echo is deliberately not a HandledError: the message itself was
 	// sent, so reporting it as a send failure would be just as untruthful as
 	// the reverse. It is not a hard error either — returning one would replay
 	// the command and re-send a message the user already got. The warning above
 	// is the signal; it stalls the state machine, not the delivery.
-	if cmd.Platform == types.PlatformWhatsApp {
+	switch cmd.Platform {
+	case types.PlatformWhatsApp:
 		if err := w.emitWhatsAppEcho(ctx, cmd); err != nil {
 			w.logger.Warn("failed to emit whatsapp echo", zap.Error(err))
 		}
+	case types.PlatformTelegram:
+		if err := w.emitTelegramEcho(ctx, cmd); err != nil {
+			w.logger.Warn("failed to emit telegram echo", zap.Error(err))
+		}
 	}
 
-	// Event emission is temporarily disabled - replybot can't parse message_worker event shape
-	// return w.emitMessageSent(ctx, cmd, resp.MessageID, 1)
+	messageID := ""
+	if resp != nil {
+		messageID = resp.MessageID
+	}
+	w.messageSent(ctx, cmd, messageID, attempts)
 	return nil
 }
 
@@ -282,24 +299,55 @@ func (w *Worker) emitWhatsAppEcho(ctx context.Context, cmd types.SendMessageComm
 	return w.producer.PublishRawEvent(ctx, cmd.UserID, data)
 }
 
+// emitTelegramEcho is emitWhatsAppEcho for Telegram: a replybot-shaped event
+// with source "telegram", keyed by the bot's account id ("tg:<bot id>") and
+// the chat id, which replybot's normalizer maps to bot_message_sent.
+func (w *Worker) emitTelegramEcho(ctx context.Context, cmd types.SendMessageCommand) error {
+	metadata := cmd.Message.Metadata
+	if len(metadata) == 0 {
+		metadata = json.RawMessage("null")
+	}
+	echo := struct {
+		Source    string          `json:"source"`
+		BotID     string          `json:"bot_id"`
+		From      string          `json:"from"`
+		Type      string          `json:"type"`
+		Metadata  json.RawMessage `json:"metadata"`
+		Timestamp int64           `json:"timestamp"`
+	}{
+		Source:    "telegram",
+		BotID:     cmd.PlatformAccountID,
+		From:      cmd.UserID,
+		Type:      "bot_echo",
+		Metadata:  metadata,
+		Timestamp: time.Now().UnixMilli(),
+	}
+	data, err := json.Marshal(echo)
+	if err != nil {
+		return err
+	}
+	return w.producer.PublishRawEvent(ctx, cmd.UserID, data)
+}
+
 func (w *Worker) processHandoff(ctx context.Context, cmd types.HandoffCommand) error {
 	client, ok := w.clients[cmd.Platform]
 	if !ok {
 		err := fmt.Errorf("no client configured for platform: %s", cmd.Platform)
+		w.messageFailed(ctx, w.handoffToCmd(cmd), err, 0)
 		return w.reportError(w.handoffToCmd(cmd), err)
 	}
 
 	metadata := string(cmd.Metadata)
-	_, handoffErr := RetryWithBackoff(ctx, w.config, func() error {
+	attempts, handoffErr := RetryWithBackoff(ctx, w.config, func() error {
 		return client.PassThreadControl(ctx, cmd.UserID, cmd.PlatformAccountID, cmd.TargetAppID, metadata)
 	})
 
 	if handoffErr != nil {
+		w.messageFailed(ctx, w.handoffToCmd(cmd), handoffErr, attempts)
 		return w.reportError(w.handoffToCmd(cmd), handoffErr)
 	}
 
-	// Event emission is temporarily disabled - replybot can't parse message_worker event shape
-	// return w.emitMessageSent(ctx, w.handoffToCmd(cmd), "", 1)
+	w.handoffCompleted(ctx, cmd, attempts)
 	return nil
 }
 
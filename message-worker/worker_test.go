@@ -524,6 +524,60 @@ func TestWorker_ProcessCommand_WhatsAppEchoFailure_IsNotHandledError(t *testing.
 	}
 }
 
+// A Telegram send is echoed like a WhatsApp one: Telegram has no native echo
+// either, and replybot only advances on bot_message_sent.
+func TestWorker_ProcessCommand_TelegramEcho(t *testing.T) {
+	mockProducer := &mockEventProducer{}
+	mockSender := &mockMessageSender{response: &SendMessageResponse{MessageID: "42", Success: true}}
+	mockBot := newMockBotserver()
+	defer mockBot.Close()
+
+	clients := map[types.PlatformType]MessageSender{
+		types.PlatformTelegram: mockSender,
+	}
+	worker := NewWorker(clients, mockProducer, mockBot.URL(), zap.NewNop())
+
+	text := "Hello"
+	cmd := types.SendMessageCommand{
+		Type:              "send_message",
+		CommandID:         "cmd_tg_1",
+		ConversationID:    "conv_456",
+		UserID:            "555001",
+		Platform:          types.PlatformTelegram,
+		PlatformAccountID: "tg:7123456789",
+		Message: types.MessageContent{
+			Type:     types.MessageTypeText,
+			Text:     &text,
+			Metadata: json.RawMessage(`{"ref":"intro","type":"statement"}`),
+		},
+	}
+
+	cmdJSON, _ := json.Marshal(cmd)
+	if err := worker.ProcessCommand(context.Background(), cmdJSON); err != nil {
+		t.Fatalf("ProcessCommand failed: %v", err)
+	}
+
+	if len(mockProducer.rawEvents) != 1 {
+		t.Fatalf("Expected 1 echo, got %d", len(mockProducer.rawEvents))
+	}
+	var echo struct {
+		Source   string          `json:"source"`
+		BotID    string          `json:"bot_id"`
+		From     string          `json:"from"`
+		Type     string          `json:"type"`
+		Metadata json.RawMessage `json:"metadata"`
+	}
+	if err := json.Unmarshal(mockProducer.rawEvents[0], &echo); err != nil {
+		t.Fatalf("bad echo: %v", err)
+	}
+	if echo.Source != "telegram" || echo.BotID != "tg:7123456789" || echo.From != "555001" || echo.Type != "bot_echo" {
+		t.Errorf("echo = %+v", echo)
+	}
+	if string(echo.Metadata) != `{"ref":"intro","type":"statement"}` {
+		t.Errorf("echo metadata = %s, want the message's metadata", echo.Metadata)
+	}
+}
+
 func TestGenerateEventID(t *testing.T) {
 	id1 := generateEventID()
 	id2 := generateEventID()
@@ -521,6 +521,54 @@ function parseWhatsAppEvent(data, timestamp) {
   }
 }
 
+// Telegram. Only the message-worker's synthetic send echo arrives today: a
+// bot has no native echo, so, as for WhatsApp, the worker publishes
+// { source: 'telegram', bot_id, from, type: 'bot_echo', metadata } after each
+// send to let the ECHO handler advance the conversation. bot_id is the
+// namespaced account id ('tg:<bot id>'). Inbound Telegram updates are not
+// ingested yet and come out as unknown.
+function parseTelegramEvent(data, timestamp) {
+  let event_type = 'unknown'
+  let payload = { type: 'unknown' }
+  if (data.type === 'bot_echo') {
+    event_type = 'bot_message_sent'
+    payload = { type: 'bot_message_sent', metadata: data.metadata }
+  }
+
+  return {
+    event_id: newEventId(),
+    user_id: data.from || '',
+    timestamp,
+    source: { type: 'telegram', account_id: data.bot_id },
+    event_type,
+    payload,
+    raw: data
+  }
+}
+
+// message-worker lifecycle events (message_sent / message_failed /
+// handoff_completed). The current, versioned envelope (schema_version 1) is
+// already normalized and passes straight through parseEvent; any other
+// schema_version is one we cannot read, and becomes 'unknown'. This upgrades
+// the legacy UniversalEvent shape that message-worker also emits while
+// LIFECYCLE_EVENTS=both -- string source 'message_worker', the event type
+// under `type`, the platform under `platform` -- to the same shape.
+function parseLegacyWorkerEvent(data, timestamp) {
+  const platform = data.platform || {}
+  return {
+    event_id: data.event_id || newEventId(),
+    user_id: data.user_id || '',
+    timestamp,
+    source: { type: platform.type, account_id: platform.account_id },
+    event_type: data.type || 'unknown',
+    payload: data.payload || {},
+    raw: data
+  }
+}
+
+// The lifecycle schema_versions this normalizer understands.
+const LIFECYCLE_SCHEMA_VERSIONS = [1]
+
 function parseEvent(rawKafkaEvent) {
   let parsed
   if (typeof rawKafkaEvent === 'string') {
@@ -543,13 +591,25 @@ function parseEvent(rawKafkaEvent) {
     throw new Error('Invalid raw Kafka event: expected string or object')
   }
 
+  const source = parsed.source
+  const timestamp = parsed.timestamp || Date.now()
+
+  if (parsed.schema_version !== undefined && !LIFECYCLE_SCHEMA_VERSIONS.includes(parsed.schema_version)) {
+    return {
+      event_id: parsed.event_id || newEventId(),
+      user_id: parsed.user_id || '',
+      timestamp,
+      source: { type: 'unknown' },
+      event_type: 'unknown',
+      payload: {},
+      raw: parsed
+    }
+  }
+
   if (parsed.event_type) {
     return parsed
   }
 
-  const source = parsed.source
-  const timestamp = parsed.timestamp || Date.now()
-
   switch (source) {
     case 'messenger':
       return parseMessengerEvent(parsed, timestamp)
@@ -557,6 +617,10 @@ function parseEvent(rawKafkaEvent) {
       return parseSyntheticEvent(parsed, timestamp)
     case 'whatsapp':
       return parseWhatsAppEvent(parsed, timestamp)
+    case 'telegram':
+      return parseTelegramEvent(parsed, timestamp)
+    case 'message_worker':
+      return parseLegacyWorkerEvent(parsed, timestamp)
     default:
       return {
         event_id: newEventId(),
@@ -575,6 +639,8 @@ module.exports = {
   parseMessengerEvent,
   parseSyntheticEvent,
   parseWhatsAppEvent,
+  parseTelegramEvent,
+  parseLegacyWorkerEvent,
   categorizeMessengerEvent,
   categorizeWhatsAppEvent,
   parsePayload,
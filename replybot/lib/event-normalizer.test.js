@@ -957,6 +957,82 @@ describe('parseWhatsAppEvent', () => {
   })
 })
 
+describe('parseEvent - message-worker lifecycle events', () => {
+  it('passes a schema_version 1 envelope through unchanged', () => {
+    const event = {
+      schema_version: 1,
+      event_id: 'evt_1',
+      event_type: 'message_sent',
+      user_id: 'user_789',
+      conversation_id: 'user_789',
+      timestamp: 1640995200000,
+      source: { type: 'whatsapp', account_id: 'PHONE_1' },
+      origin: 'message_worker',
+      payload: { type: 'message_sent', platform_message_id: 'wamid.1', attempts: 1 }
+    }
+    parseEvent(JSON.stringify(event)).should.deep.equal(event)
+  })
+
+  it('ignores a schema_version it does not know', () => {
+    const event = {
+      schema_version: 2,
+      event_id: 'evt_3',
+      event_type: 'message_sent',
+      user_id: 'user_789',
+      timestamp: 1640995200000,
+      source: { type: 'whatsapp', account_id: 'PHONE_1' },
+      payload: { type: 'message_sent', attempts: 1 }
+    }
+    const result = parseEvent(JSON.stringify(event))
+    result.event_type.should.equal('unknown')
+    result.user_id.should.equal('user_789')
+    result.payload.should.deep.equal({})
+    result.raw.should.deep.equal(event)
+  })
+
+  it('upgrades the legacy UniversalEvent shape', () => {
+    const result = parseEvent(JSON.stringify({
+      event_id: 'evt_2',
+      conversation_id: 'user_789',
+      user_id: 'user_789',
+      timestamp: 1640995200000,
+      platform: { type: 'whatsapp', account_id: 'PHONE_1' },
+      source: 'message_worker',
+      type: 'message_failed',
+      payload: { type: 'message_failed', error: 'boom', attempts: 3, retriable: true }
+    }))
+    result.event_id.should.equal('evt_2')
+    result.user_id.should.equal('user_789')
+    result.source.should.deep.equal({ type: 'whatsapp', account_id: 'PHONE_1' })
+    result.event_type.should.equal('message_failed')
+    result.payload.attempts.should.equal(3)
+  })
+})
+
+describe('parseEvent - telegram source', () => {
+  it('maps a worker bot_echo to bot_message_sent carrying the metadata', () => {
+    const kafkaEvent = JSON.stringify({
+      source: 'telegram',
+      bot_id: 'tg:7123456789',
+      from: '555001',
+      type: 'bot_echo',
+      metadata: { ref: 'q1', type: 'multiple_choice' },
+      timestamp: 1640995200000
+    })
+    const result = parseEvent(kafkaEvent)
+    result.user_id.should.equal('555001')
+    result.source.type.should.equal('telegram')
+    result.source.account_id.should.equal('tg:7123456789')
+    result.event_type.should.equal('bot_message_sent')
+    result.payload.metadata.ref.should.equal('q1')
+  })
+
+  it('returns unknown for anything but an echo', () => {
+    const result = parseEvent({ source: 'telegram', bot_id: 'tg:1', from: '555001', type: 'text' })
+    result.event_type.should.equal('unknown')
+  })
+})
+
 describe('parseEvent - whatsapp source', () => {
   it('dispatches source:whatsapp through parseWhatsAppEvent', () => {
     const kafkaEvent = JSON.stringify({
@@ -173,6 +173,7 @@ function categorizeEvent(nxt) {
   if (et === 'user_text') return 'TEXT'
   if (et === 'user_media') return 'MEDIA'
   if (et === 'user_reaction') return 'REACTION'
+  if (et === 'message_sent' || et === 'message_failed' || et === 'handoff_completed') return 'LIFECYCLE'
 
   console.log(`Machine could not categorize event!
         	       \nEvent: ${util.inspect(nxt, null, 8)}`)
@@ -591,6 +592,13 @@ function exec(state, nxt) {
 
     }
 
+    // message-worker's record of what it sent. The conversation still
+    // advances on bot_message_sent and errors on machine_report; these are
+    // for the event log, not the state.
+    case 'LIFECYCLE': {
+      return _noop()
+    }
+
     case 'UNKNOWN': {
 
       return _noop()
@@ -830,12 +838,18 @@ function _wrapSideEffect(ctx, data) {
 // can route/report by platform. ctx.platform is threaded from
 // actionsResponses (transition.js), which reads the persisted md.platform;
 // 'messenger' is exact for anything predating that persistence.
+//
+// ctx.shortcode is the survey the payment was made from, which dinersclub
+// uses for per-survey budgets. It is only set on the transition path, so it
+// is left off rather than sent empty when absent.
 function _wrapPayment(ctx, payment) {
   if (!payment) return
-  return {
+  const wrapped = {
     ..._wrapSideEffect(ctx, payment),
     platform: ctx.platform || 'messenger'
   }
+  if (ctx.shortcode) wrapped.shortcode = ctx.shortcode
+  return wrapped
 }
 
 function getPaymentFromMessage(ctx, message) {
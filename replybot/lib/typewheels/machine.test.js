@@ -3800,3 +3800,43 @@ describe('md.ad_id — ad attribution identity captured from the referral', () =
     state.md.ad_id.should.equal('120226305854810726')
   })
 })
+
+describe('message-worker lifecycle events', () => {
+  const lifecycle = (event_type, payload = {}) => ({
+    schema_version: 1,
+    event_id: `evt_${event_type}`,
+    event_type,
+    user_id: USER_ID,
+    conversation_id: USER_ID,
+    timestamp: 1542123900000,
+    source: { type: 'messenger', account_id: PAGE_ID },
+    origin: 'message_worker',
+    payload: { type: event_type, ...payload }
+  })
+
+  it('leaves the state alone for every lifecycle event, in either shape', () => {
+    const before = getState([referral, echo])
+    const legacy = parseEvent({
+      event_id: 'evt_legacy',
+      conversation_id: USER_ID,
+      user_id: USER_ID,
+      timestamp: 1542123900000,
+      platform: { type: 'messenger', account_id: PAGE_ID },
+      source: 'message_worker',
+      type: 'message_sent',
+      payload: { type: 'message_sent', attempts: 1 }
+    })
+
+    const events = [
+      lifecycle('message_sent', { platform_message_id: 'mid.1', attempts: 1 }),
+      lifecycle('message_failed', { error: 'rate limited', attempts: 3, retriable: true }),
+      lifecycle('handoff_completed', { target_app_id: '263902037430900', attempts: 1 }),
+      legacy
+    ]
+
+    for (const event of events) {
+      exec(before, parseEvent(event)).action.should.equal('NONE')
+    }
+    getState([referral, echo, ...events].map(parseEvent)).should.deep.equal(before)
+  })
+})
@@ -60,7 +60,7 @@ class Machine {
     // conversation start; 'messenger' is exact for states predating that.
     const platform = (newState.md && newState.md.platform) || 'messenger'
 
-    const { messages, payment, handoff } = act({ form, user, page: { id: pageId }, timestamp, platform }, state, output)
+    const { messages, payment, handoff } = act({ form, user, page: { id: pageId }, timestamp, platform, shortcode }, state, output)
 
     const responses = responseVals(newState, upd, form, surveyId, pageId, user, timestamp)
 
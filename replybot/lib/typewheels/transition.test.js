@@ -235,7 +235,8 @@ describe('Machine integrated', () => {
       timestamp: event.timestamp,
       provider: 'reloadly',
       details: { foo: 'bar' },
-      platform: 'messenger'
+      platform: 'messenger',
+      shortcode: 'someform'
     })
   })
 
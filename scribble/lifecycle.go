@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// lifecycleSchemaVersion is the message-worker LifecycleEvent schema_version
+// this scribbler reads. Events of any other version are skipped, as the
+// envelope asks of its consumers.
+const lifecycleSchemaVersion = 1
+
+// LifecycleEvent is the part of message-worker's lifecycle envelope the chat
+// log needs. Source is only read once the version is known: the legacy
+// shape has a string there.
+type LifecycleEvent struct {
+	SchemaVersion int             `json:"schema_version"`
+	EventType     string          `json:"event_type"`
+	UserID        string          `json:"user_id"`
+	Timestamp     *JSTimestamp    `json:"timestamp"`
+	Source        json.RawMessage `json:"source"`
+	Payload       json.RawMessage `json:"payload"`
+}
+
+type lifecycleSource struct {
+	AccountID string `json:"account_id"`
+}
+
+type messageSentPayload struct {
+	MessageType string          `json:"message_type"`
+	Content     string          `json:"content"`
+	Metadata    json.RawMessage `json:"metadata"`
+}
+
+// LifecycleScribbler writes the messages message-worker sent to chat_log, as
+// the bot's side of the conversation. Only message_sent events are a message;
+// message_failed and handoff_completed are skipped.
+type LifecycleScribbler struct {
+	ChatLogScribbler
+}
+
+func NewLifecycleScribbler(pool *pgxpool.Pool) Scribbler {
+	return &LifecycleScribbler{ChatLogScribbler{pool}}
+}
+
+func (s *LifecycleScribbler) Marshal(msg *kafka.Message) (Writeable, error) {
+	e := new(LifecycleEvent)
+	if err := json.Unmarshal(msg.Value, e); err != nil {
+		return nil, err
+	}
+	if e.SchemaVersion != lifecycleSchemaVersion || e.EventType != "message_sent" {
+		return nil, nil
+	}
+
+	source := new(lifecycleSource)
+	if err := json.Unmarshal(e.Source, source); err != nil {
+		return nil, err
+	}
+	p := new(messageSentPayload)
+	if err := json.Unmarshal(e.Payload, p); err != nil {
+		return nil, err
+	}
+
+	entry := &ChatLogEntry{
+		Userid:     e.UserID,
+		Timestamp:  e.Timestamp,
+		Direction:  "bot",
+		Content:    p.Content,
+		RawPayload: e.Payload,
+		Metadata:   p.Metadata,
+	}
+	if entry.Content == "" {
+		entry.Content = fmt.Sprintf("[%s]", p.MessageType)
+	}
+	if source.AccountID != "" {
+		entry.Pageid = &source.AccountID
+	}
+	if p.MessageType != "" {
+		entry.MessageType = &p.MessageType
+	}
+
+	meta := struct {
+		Ref string `json:"ref"`
+	}{}
+	if len(p.Metadata) > 0 && json.Unmarshal(p.Metadata, &meta) == nil && meta.Ref != "" {
+		entry.QuestionRef = &meta.Ref
+	}
+	return entry, nil
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+	"github.com/stretchr/testify/assert"
+)
+
+func lifecycleMessage(value string) *kafka.Message {
+	return &kafka.Message{Value: []byte(value)}
+}
+
+func TestLifecycleMarshalMessageSent(t *testing.T) {
+	scribbler := &LifecycleScribbler{}
+	w, err := scribbler.Marshal(lifecycleMessage(`{
+		"schema_version": 1,
+		"event_id": "evt_1",
+		"event_type": "message_sent",
+		"user_id": "user_789",
+		"conversation_id": "user_789",
+		"timestamp": 1598706047838,
+		"source": {"type": "messenger", "account_id": "page_123"},
+		"origin": "message_worker",
+		"payload": {"type": "message_sent", "platform_message_id": "mid.1", "attempts": 1,
+			"message_type": "question", "content": "How old are you?", "metadata": {"ref": "age"}}
+	}`))
+	assert.Nil(t, err)
+
+	entry := w.(*ChatLogEntry)
+	assert.Equal(t, "user_789", entry.Userid)
+	assert.Equal(t, "page_123", *entry.Pageid)
+	assert.Equal(t, time.Unix(0, 1598706047838*1000000).UTC(), entry.Timestamp.Time)
+	assert.Equal(t, "bot", entry.Direction)
+	assert.Equal(t, "How old are you?", entry.Content)
+	assert.Equal(t, "age", *entry.QuestionRef)
+	assert.Equal(t, "question", *entry.MessageType)
+	assert.JSONEq(t, `{"ref": "age"}`, string(entry.Metadata))
+	assert.Contains(t, string(entry.RawPayload), "mid.1")
+	assert.Nil(t, entry.Shortcode)
+}
+
+func TestLifecycleMarshalWithoutContentNamesTheType(t *testing.T) {
+	scribbler := &LifecycleScribbler{}
+	w, err := scribbler.Marshal(lifecycleMessage(`{
+		"schema_version": 1, "event_type": "message_sent", "user_id": "u", "timestamp": 1598706047838,
+		"source": {"type": "messenger", "account_id": "p"},
+		"payload": {"type": "message_sent", "message_type": "media"}
+	}`))
+	assert.Nil(t, err)
+	assert.Equal(t, "[media]", w.(*ChatLogEntry).Content)
+	assert.Nil(t, w.(*ChatLogEntry).QuestionRef)
+}
+
+func TestLifecycleMarshalSkipsOtherEventsAndVersions(t *testing.T) {
+	scribbler := &LifecycleScribbler{}
+	for _, value := range []string{
+		`{"schema_version": 1, "event_type": "message_failed", "user_id": "u", "timestamp": 1, "payload": {"error": "boom"}}`,
+		`{"schema_version": 1, "event_type": "handoff_completed", "user_id": "u", "timestamp": 1, "payload": {}}`,
+		`{"schema_version": 2, "event_type": "message_sent", "user_id": "u", "timestamp": 1, "payload": {"content": "hi"}}`,
+		`{"source": "message_worker", "type": "message_sent", "user_id": "u", "timestamp": 1, "payload": {}}`,
+	} {
+		w, err := scribbler.Marshal(lifecycleMessage(value))
+		assert.Nil(t, err, value)
+		assert.Nil(t, w, value)
+	}
+}
+
+func TestLifecycleMarshalInvalidJSON(t *testing.T) {
+	scribbler := &LifecycleScribbler{}
+	_, err := scribbler.Marshal(lifecycleMessage(`{not json`))
+	assert.NotNil(t, err)
+}
@@ -66,6 +66,7 @@ func getMarshaller(cfg *Config, pool *pgxpool.Pool) Scribbler {
 		"responses": NewResponseScribbler,
 		"messages":  NewMessageScribbler,
 		"chat-log":  NewChatLogScribbler,
+		"lifecycle": NewLifecycleScribbler,
 	}
 
 	fn, ok := marshallers[name]
@@ -22,6 +22,8 @@ func BatchValues(data []Writeable) []interface{} {
 	return values
 }
 
+// Prep marshals messages. A marshaller that returns neither a Writeable nor
+// an error is saying the message is not for its table, and it is skipped.
 func Prep(fn func(*kafka.Message) (Writeable, error), messages []*kafka.Message) ([]Writeable, error) {
 	data := []Writeable{}
 	for _, msg := range messages {
@@ -33,6 +35,9 @@ func Prep(fn func(*kafka.Message) (Writeable, error), messages []*kafka.Message)
 			return nil, err
 
 		}
+		if w == nil {
+			continue
+		}
 		data = append(data, w)
 	}
 
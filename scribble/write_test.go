@@ -67,6 +67,18 @@ func TestPrepWhenOneBadYouGetAnError(t *testing.T) {
 	assert.NotNil(t, err)
 }
 
+func TestPrepSkipsMessagesWithNothingToWrite(t *testing.T) {
+	skipBar := func(msg *kafka.Message) (Writeable, error) {
+		if string(msg.Value) == "bar" {
+			return nil, nil
+		}
+		return &StringData{string(msg.Value)}, nil
+	}
+	data, err := Prep(skipBar, makeMessages([]string{"foo", "bar"}))
+	assert.Nil(t, err)
+	assert.Equal(t, []Writeable{&StringData{"foo"}}, data)
+}
+
 func TestWriteBatchSucceeds(t *testing.T) {
 
 	pool := testPool()